@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// How long an acknowledgement lasts when no duration is given
+const defaultAckDuration = 4 * time.Hour
+
+// checkAck is the acknowledgement of a failing check: someone is on it, so
+// its failures aren't notified until it recovers or the ack expires
+type checkAck struct {
+	// Where it was acked: tui, ctl or grpc
+	by      string
+	comment string
+	until   time.Time
+}
+
+// acknowledge acks the failing rows of the named check, at all sites
+// reporting it
+func (m *Monitor) acknowledge(name string, duration time.Duration, by string, comment string) error {
+	if duration <= 0 {
+		duration = defaultAckDuration
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	acked := 0
+	for _, checkResult := range m.results {
+		if checkResult.check.Name != name {
+			continue
+		}
+		found = true
+		if checkResult.execCount > 0 && !checkResult.status {
+			m.acks[incidentKey(checkResult.check)] = checkAck{by: by, comment: comment, until: time.Now().Add(duration)}
+			acked++
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown check %q", name)
+	}
+	if acked == 0 {
+		return fmt.Errorf("check %s isn't failing", name)
+	}
+	m.sharedChanged = time.Now()
+	logMessage(logInfo, "Check", name, "acknowledged by", by, "until", time.Now().Add(duration).Format(time.TimeOnly))
+	return nil
+}
+
+// unacknowledge removes the acks of the named check
+func (m *Monitor) unacknowledge(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	for _, checkResult := range m.results {
+		if checkResult.check.Name == name {
+			found = true
+			delete(m.acks, incidentKey(checkResult.check))
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown check %q", name)
+	}
+	m.sharedChanged = time.Now()
+	return nil
+}
+
+// ackOf returns the ack of the check if it's acked and the ack didn't
+// expire. Callers hold m.mu.
+func (m *Monitor) ackOf(check Check) (checkAck, bool) {
+	key := incidentKey(check)
+	ack, ok := m.acks[key]
+	if ok && time.Now().After(ack.until) {
+		logMessage(logInfo, "Acknowledgement of check", check.Name, "expired")
+		delete(m.acks, key)
+		return ack, false
+	}
+	return ack, ok
+}
+
+// isAcked reports whether the check is acked
+func (m *Monitor) isAcked(check Check) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.ackOf(check)
+	return ok
+}
+
+// clearAck ends the ack of a check that recovered. Callers hold m.mu.
+func (m *Monitor) clearAck(check Check) {
+	if _, ok := m.acks[incidentKey(check)]; ok {
+		logMessage(logInfo, "Check", check.Name, "recovered, acknowledgement cleared")
+		delete(m.acks, incidentKey(check))
+	}
+}
+
+// toggleAck acks the selected row of the interactive UI when it fails, or
+// removes its ack. Callers hold m.mu.
+func (m *Monitor) toggleAck(id int) {
+	if id >= len(m.results) {
+		return
+	}
+	checkResult := m.results[id]
+	key := incidentKey(checkResult.check)
+	if _, ok := m.acks[key]; ok {
+		delete(m.acks, key)
+		m.sharedChanged = time.Now()
+		return
+	}
+	if checkResult.execCount > 0 && !checkResult.status {
+		m.acks[key] = checkAck{by: "tui", until: time.Now().Add(defaultAckDuration)}
+		m.sharedChanged = time.Now()
+	}
+}
+
+// unlessMuted wraps an alerting consumer so that nothing is notified of
+// silenced checks, and failures of acked checks aren't either. Recoveries of
+// acked checks are, as they end the ack. It goes before onStateChange, so
+// that a failure muted when it started is notified once the mute ends.
+func (m *Monitor) unlessMuted(handle func(CheckResult)) func(CheckResult) {
+	return func(checkResult CheckResult) {
+		if m.isSilenced(checkResult.check) || (!checkResult.status && m.isAcked(checkResult.check)) {
+			return
+		}
+		handle(checkResult)
+	}
+}
+
+// describe renders the ack for the status of a check
+func (ack checkAck) describe() string {
+	text := fmt.Sprintf("acked by %s until %s", ack.by, ack.until.Format(time.TimeOnly))
+	if ack.comment != "" {
+		text += ": " + ack.comment
+	}
+	return text
+}
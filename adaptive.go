@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// An adaptive check probes twice as seldom after adaptiveStableRuns stable
+// runs in a row. A run is unstable when it fails, is degraded or its latency
+// differs from the short window average by more than half of it and at
+// least adaptiveMinChange.
+const (
+	adaptiveStableRuns = 5
+	adaptiveMinChange  = 10 * time.Millisecond
+)
+
+// adaptive reports whether the interval of the check adapts to its
+// stability
+func (c Check) adaptive() bool {
+	return c.RepeatMin > 0 || c.RepeatMax > 0
+}
+
+// tick returns the interval the check is scheduled at: its shortest one
+func (c Check) tick() time.Duration {
+	if c.RepeatMin > 0 {
+		return c.RepeatMin
+	}
+	return c.Repeat
+}
+
+// validateAdaptive checks the bounds of adaptive checks, defaulting the
+// missing one to repeat
+func validateAdaptive(checks []Check) error {
+	for i := range checks {
+		check := &checks[i]
+		if !check.adaptive() {
+			continue
+		}
+		if check.RepeatMin == 0 {
+			check.RepeatMin = check.Repeat
+		}
+		if check.RepeatMax == 0 {
+			check.RepeatMax = check.Repeat
+		}
+		switch {
+		case check.RepeatMin < minRepeat:
+			return fmt.Errorf("check %s: repeat_min %v is shorter than %v", check.Name, check.RepeatMin, minRepeat)
+		case check.RepeatMin > check.Repeat || check.RepeatMax < check.Repeat:
+			return fmt.Errorf("check %s: repeat %v is not between repeat_min %v and repeat_max %v",
+				check.Name, check.Repeat, check.RepeatMin, check.RepeatMax)
+		}
+	}
+	return nil
+}
+
+// updateAdaptive tightens the interval of an adaptive check to repeat_min
+// at the first unstable run and loosens it up to repeat_max while the check
+// is stable. Callers update shortDurations first.
+func (s *CheckResultStat) updateAdaptive(check Check, checkResult CheckResult) {
+	if !check.adaptive() {
+		s.adaptiveInterval = 0
+		return
+	}
+	if s.adaptiveInterval == 0 {
+		s.adaptiveInterval = check.Repeat
+	}
+	unstable := !checkResult.status || checkResult.degraded
+	if len(s.shortDurations) > 1 {
+		average := averageDuration(s.shortDurations[1:])
+		change := checkResult.duration - average
+		if change < 0 {
+			change = -change
+		}
+		unstable = unstable || (change > average/2 && change >= adaptiveMinChange)
+	}
+	switch {
+	case unstable:
+		if s.adaptiveInterval > check.RepeatMin {
+			logMessage(logInfo, "Check", check.Name, "is unstable, probing every", check.RepeatMin)
+		}
+		s.adaptiveInterval = check.RepeatMin
+		s.stableRuns = 0
+	case s.stableRuns+1 >= adaptiveStableRuns:
+		s.adaptiveInterval = min(2*s.adaptiveInterval, check.RepeatMax)
+		s.stableRuns = 0
+	default:
+		s.stableRuns++
+	}
+}
+
+// interval returns how often the check currently runs: its adaptive
+// interval or repeat, or the longer one it backs off to
+func (s *CheckResultStat) interval(check Check) time.Duration {
+	interval := check.Repeat
+	if s.adaptiveInterval > 0 {
+		interval = s.adaptiveInterval
+	}
+	return max(interval, s.backoff)
+}
+
+// skipped reports whether a scheduled run of the check is skipped because
+// it currently runs less often than it's scheduled. Runs are aligned to
+// multiples of the tick, so the interval is measured from the one the last
+// run belongs to.
+func (s *CheckResultStat) skipped(check Check, lastRun time.Time, now time.Time) bool {
+	interval := s.interval(check)
+	return interval > check.tick() && now.Sub(lastRun.Truncate(check.tick())) < interval
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Repeat of a check given on the command line, as often as ping
+const defaultAdHocRepeat = time.Second
+
+// settingsFlag collects repeated -set key=value flags
+type settingsFlag []string
+
+func (s *settingsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *settingsFlag) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key=value")
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+// splitAdHocArgs recognizes a check given on the command line, e.g.
+// "http https://example.com -repeat 2s", and returns its type, destination
+// and the remaining arguments
+func splitAdHocArgs(args []string) (checkType string, dest string, rest []string, ok bool) {
+	if len(args) < 2 || checkProbe(args[0]) == nil || strings.HasPrefix(args[1], "-") {
+		return "", "", args, false
+	}
+	return args[0], args[1], args[2:], true
+}
+
+// adHocChecks creates the configuration of a single check given on the
+// command line. Settings of the configuration file (key=value, e.g.
+// expect_status=[200,204]) apply to it as well.
+func adHocChecks(checkType, dest string, repeat, timeout time.Duration, settings []string) (Checks, error) {
+	var document strings.Builder
+	for _, setting := range settings {
+		key, value, _ := strings.Cut(setting, "=")
+		fmt.Fprintf(&document, "%s: %s\n", strings.TrimSpace(key), value)
+	}
+	var check Check
+	if err := yaml.UnmarshalStrict([]byte(document.String()), &check); err != nil {
+		return Checks{}, fmt.Errorf("invalid setting: %v", explainConfigError(err, reflect.TypeOf(check)))
+	}
+	check.CheckType = checkType
+	check.Dest = dest
+	check.Repeat = repeat
+	check.Timeout = timeout
+	if check.Name == "" {
+		check.Name = destHost(dest)
+	}
+	return Checks{Checks: []Check{check}}, nil
+}
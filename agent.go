@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const agentResultsPath = "/api/v1/results"
+
+// AgentResult is the wire format used by agents to report results to a
+// central instance.
+type AgentResult struct {
+	Site     string            `json:"site"`
+	ID       string            `json:"id,omitempty"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Dest     string            `json:"dest"`
+	Status   bool              `json:"status"`
+	RunAt    time.Time         `json:"run_at"`
+	Duration time.Duration     `json:"duration"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Owner    string            `json:"owner,omitempty"`
+	Contact  string            `json:"contact,omitempty"`
+	Runbook  string            `json:"runbook,omitempty"`
+	Severity string            `json:"severity,omitempty"`
+	// Cause of a failure, e.g. dns_error
+	Cause string `json:"cause,omitempty"`
+	// Working, but degraded, e.g. slower than degraded_above
+	Degraded      bool          `json:"degraded,omitempty"`
+	DegradedAbove time.Duration `json:"degraded_above,omitempty"`
+	// Set on aggregates of a recording downsampled by the retention: the
+	// number of runs starting in the period from RunAt and how many failed.
+	// Duration is the average of the successful runs.
+	Samples  int           `json:"samples,omitempty"`
+	Failures int           `json:"failures,omitempty"`
+	Period   time.Duration `json:"period,omitempty"`
+	// Set on recordings made with -sign-key, chaining each result to the
+	// previous one
+	Signature []byte `json:"sig,omitempty"`
+}
+
+// newAgentResult converts a result for sending or storing, with the time in
+// UTC independent of the local time zone and DST
+func newAgentResult(checkResult CheckResult) AgentResult {
+	return AgentResult{
+		Site:          checkResult.check.site,
+		ID:            checkResult.check.ID,
+		Name:          checkResult.check.Name,
+		Type:          checkResult.check.CheckType,
+		Dest:          checkResult.check.Dest,
+		Status:        checkResult.status,
+		RunAt:         checkResult.runAt.UTC(),
+		Duration:      checkResult.duration,
+		Labels:        checkResult.check.exportedLabels(),
+		Owner:         checkResult.check.Owner,
+		Contact:       checkResult.check.Contact,
+		Runbook:       checkResult.check.Runbook,
+		Severity:      checkResult.check.Severity,
+		Cause:         checkResult.cause,
+		Degraded:      checkResult.degraded,
+		DegradedAbove: checkResult.check.DegradedAbove,
+	}
+}
+
+// identity returns the identity of the check the result is of
+func (result AgentResult) identity() string {
+	if result.ID != "" {
+		return result.ID
+	}
+	return result.Name
+}
+
+// checkResult converts a reported result into a result of a remote check
+// with the given row id
+func (result AgentResult) checkResult(id int) CheckResult {
+	return CheckResult{
+		check: Check{
+			ID:            result.ID,
+			Name:          result.Name,
+			CheckType:     result.Type,
+			Dest:          result.Dest,
+			Labels:        result.Labels,
+			Owner:         result.Owner,
+			Contact:       result.Contact,
+			Runbook:       result.Runbook,
+			Severity:      result.Severity,
+			DegradedAbove: result.DegradedAbove,
+			id:            id,
+			site:          result.Site,
+			remote:        true,
+		},
+		status:   result.Status,
+		runAt:    result.RunAt,
+		duration: max(result.Duration, 0),
+		cause:    result.Cause,
+		degraded: result.Degraded,
+	}
+}
+
+// agentForwarder returns a consumer delivering local check results to the
+// central instance.
+func agentForwarder(centralUrl string, token string) func(CheckResult) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	endpoint := strings.TrimSuffix(centralUrl, "/") + agentResultsPath
+
+	return func(checkResult CheckResult) {
+		if checkResult.check.remote {
+			return
+		}
+		body, err := json.Marshal(newAgentResult(checkResult))
+		if err != nil {
+			return
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// agentResultsHandler accepts results from agents and feeds them into the
+// same channel as local results.
+func agentResultsHandler(token string, monitor *Monitor, c chan CheckResult) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var result AgentResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, fmt.Sprintf("invalid result: %v", err), http.StatusBadRequest)
+			return
+		}
+		if result.Site == "" || result.Name == "" {
+			http.Error(w, "site and name are required", http.StatusBadRequest)
+			return
+		}
+		checkResult := result.checkResult(monitor.remoteId(result.Site, result.identity()))
+		// Don't let agents block the API when the results queue is full
+		select {
+		case c <- checkResult:
+		default:
+			monitor.queueOverflow()
+			http.Error(w, "results queue full", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
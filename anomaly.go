@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+type AnomalyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// How many standard deviations above the moving average a sample has to be
+	Threshold float64 `yaml:"threshold"`
+	// How many consecutive unusual samples make an anomaly
+	Samples int `yaml:"samples"`
+}
+
+const (
+	anomalyAlpha            = 0.05
+	anomalyWarmup           = 20
+	defaultAnomalyThreshold = 3.5
+	defaultAnomalySamples   = 3
+)
+
+// anomalyDetector tracks an exponentially weighted moving average and
+// variance of a check's latency and flags runs of samples far above it. Single
+// spikes are ignored, sustained shifts are flagged until they become the new
+// normal.
+type anomalyDetector struct {
+	mean     float64
+	variance float64
+	samples  int
+	streak   int
+}
+
+// observe adds a latency sample and reports whether the check currently
+// behaves anomalously.
+func (d *anomalyDetector) observe(duration time.Duration, config AnomalyConfig) bool {
+	threshold := config.Threshold
+	if threshold <= 0 {
+		threshold = defaultAnomalyThreshold
+	}
+	run := config.Samples
+	if run <= 0 {
+		run = defaultAnomalySamples
+	}
+
+	x := float64(duration)
+	if d.samples == 0 {
+		d.mean = x
+	}
+	d.samples++
+
+	unusual := false
+	if d.samples > anomalyWarmup {
+		// Don't flag jitter of checks with almost constant latency
+		std := math.Max(math.Sqrt(d.variance), math.Max(0.1*d.mean, float64(time.Millisecond)))
+		unusual = (x-d.mean)/std > threshold
+	}
+	if unusual {
+		d.streak++
+	} else {
+		d.streak = 0
+	}
+
+	diff := x - d.mean
+	d.mean += anomalyAlpha * diff
+	d.variance = (1 - anomalyAlpha) * (d.variance + anomalyAlpha*diff*diff)
+
+	return d.streak >= run
+}
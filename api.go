@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// startApi serves the HTTP API: results reported by agents, the health
+// endpoint for external supervisors and internal metrics. Profiling endpoints
+// are only exposed when enablePprof is set. The returned mux allows adding
+// optional endpoints.
+func startApi(listen string, certFile string, keyFile string, token string, enablePprof bool, monitor *Monitor, c chan CheckResult) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(agentResultsPath, agentResultsHandler(token, monitor, c))
+	mux.HandleFunc("/healthz", healthzHandler(monitor))
+	mux.HandleFunc("/readyz", readyzHandler(monitor))
+	mux.HandleFunc("/metrics", metricsHandler(monitor))
+	mux.HandleFunc("/api/v1/health", healthHandler(monitor))
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprofGuard(token, pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", pprofGuard(token, pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", pprofGuard(token, pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", pprofGuard(token, pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", pprofGuard(token, pprof.Trace))
+	}
+
+	go func() {
+		var err error
+		if certFile != "" {
+			err = http.ListenAndServeTLS(listen, certFile, keyFile, mux)
+		} else {
+			err = http.ListenAndServe(listen, mux)
+		}
+		logMessage(logErr, "API stopped:", err)
+	}()
+	return mux
+}
+
+// pprofGuard protects a profiling endpoint, which reveals the command line
+// including the -token: with a token it's required, without one only
+// requests from localhost are served
+func pprofGuard(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); token == "" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "profiling is only served to localhost without a -token", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func healthzHandler(monitor *Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := monitor.healthy(5 * time.Second); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// readyzHandler reports ready while the checker is healthy and all critical
+// checks pass, e.g. for a Kubernetes readiness probe
+func readyzHandler(monitor *Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := monitor.healthy(5 * time.Second); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if !monitor.warmedUp() {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		if failing := monitor.failingCriticalChecks(); len(failing) > 0 {
+			http.Error(w, "critical checks failing: "+strings.Join(failing, ", "), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// checkLabels identifies a check in metrics, followed by its own labels
+func checkLabels(check Check) []string {
+	return append([]string{"name", check.Name, "type", check.CheckType, "site", check.site}, check.labelPairs()...)
+}
+
+// promLabels formats label name/value pairs for the Prometheus text format
+func promLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], value))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// metricsHandler exposes the results of the checks and metrics about the
+// checker itself in the Prometheus text format. When running in Kubernetes,
+// all metrics are labeled with the pod and node.
+func metricsHandler(monitor *Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		monitor.mu.Lock()
+		schedulerLag := monitor.schedulerLag
+		queueOverflows := monitor.queueOverflows
+		queueLength := len(monitor.c)
+		var results int
+		for _, checkResult := range monitor.results {
+			results += checkResult.execCount
+		}
+		lastResult := monitor.lastResult
+		checkResults := append([]CheckResult(nil), monitor.results...)
+		health, healthOk := monitor.health()
+		var traffic []int64
+		var setups []time.Duration
+		var dnsStats []CheckResultStat
+		var acked, silenced []bool
+		var causes []map[string]int
+		var since, lastFailures []time.Time
+		for _, checkResult := range checkResults {
+			_, ok := monitor.ackOf(checkResult.check)
+			acked = append(acked, ok)
+			_, ok = monitor.silenceOf(checkResult.check)
+			silenced = append(silenced, ok)
+		}
+		for _, stat := range monitor.stats {
+			traffic = append(traffic, stat.bytes)
+			setups = append(setups, stat.lastSetup)
+			dnsStats = append(dnsStats, CheckResultStat{dnsHits: stat.dnsHits, dnsMisses: stat.dnsMisses, lastDns: stat.lastDns})
+			causes = append(causes, maps.Clone(stat.causes))
+			since = append(since, stat.stateSince)
+			lastFailures = append(lastFailures, stat.lastFailure)
+		}
+		monitor.mu.Unlock()
+
+		instance := kubernetesLabels()
+		withInstance := func(pairs ...string) string {
+			return promLabels(append(pairs, instance...)...)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP network_checks_goroutines Number of running goroutines.")
+		fmt.Fprintln(w, "# TYPE network_checks_goroutines gauge")
+		fmt.Fprintf(w, "network_checks_goroutines%s %d\n", withInstance(), runtime.NumGoroutine())
+		fmt.Fprintln(w, "# HELP network_checks_dns_cache_hits_total Resolutions of check destinations served from the DNS cache.")
+		fmt.Fprintln(w, "# TYPE network_checks_dns_cache_hits_total counter")
+		fmt.Fprintf(w, "network_checks_dns_cache_hits_total%s %d\n", withInstance(), dnsCache.hits.Load())
+		fmt.Fprintln(w, "# HELP network_checks_dns_cache_misses_total Resolutions of check destinations that queried the resolver.")
+		fmt.Fprintln(w, "# TYPE network_checks_dns_cache_misses_total counter")
+		fmt.Fprintf(w, "network_checks_dns_cache_misses_total%s %d\n", withInstance(), dnsCache.misses.Load())
+		if healthOk {
+			fmt.Fprintln(w, "# HELP network_checks_health_score Weighted share of the checks working, 0 to 100.")
+			fmt.Fprintln(w, "# TYPE network_checks_health_score gauge")
+			fmt.Fprintf(w, "network_checks_health_score%s %d\n", withInstance(), health.Score)
+			if len(health.Sites) > 0 {
+				fmt.Fprintln(w, "# HELP network_checks_site_health_score Weighted share of the checks of the site working, 0 to 100.")
+				fmt.Fprintln(w, "# TYPE network_checks_site_health_score gauge")
+				for site, score := range health.Sites {
+					fmt.Fprintf(w, "network_checks_site_health_score%s %d\n", withInstance("site", site), score)
+				}
+			}
+		}
+		if monitor.cluster != nil {
+			leader := 0
+			if monitor.cluster.isLeader() {
+				leader = 1
+			}
+			fmt.Fprintln(w, "# HELP network_checks_cluster_leader Whether this instance leads the cluster and sends the alerts.")
+			fmt.Fprintln(w, "# TYPE network_checks_cluster_leader gauge")
+			fmt.Fprintf(w, "network_checks_cluster_leader%s %d\n", withInstance(), leader)
+		}
+		fmt.Fprintln(w, "# HELP network_checks_scheduler_lag_seconds Delay of the latest check run behind its schedule.")
+		fmt.Fprintln(w, "# TYPE network_checks_scheduler_lag_seconds gauge")
+		fmt.Fprintf(w, "network_checks_scheduler_lag_seconds%s %f\n", withInstance(), schedulerLag.Seconds())
+		fmt.Fprintln(w, "# HELP network_checks_results_queue_length Results waiting to be recorded.")
+		fmt.Fprintln(w, "# TYPE network_checks_results_queue_length gauge")
+		fmt.Fprintf(w, "network_checks_results_queue_length%s %d\n", withInstance(), queueLength)
+		fmt.Fprintln(w, "# HELP network_checks_results_queue_overflows_total Results rejected because the results queue was full.")
+		fmt.Fprintln(w, "# TYPE network_checks_results_queue_overflows_total counter")
+		fmt.Fprintf(w, "network_checks_results_queue_overflows_total%s %d\n", withInstance(), queueOverflows)
+		fmt.Fprintln(w, "# HELP network_checks_dropped_results_total Results dropped because a consumer was too slow.")
+		fmt.Fprintln(w, "# TYPE network_checks_dropped_results_total counter")
+		for _, consumer := range monitor.consumers {
+			fmt.Fprintf(w, "network_checks_dropped_results_total%s %d\n", withInstance("consumer", consumer.name), consumer.dropped.Load())
+		}
+		fmt.Fprintln(w, "# HELP network_checks_results_total Results recorded since the last (re)load.")
+		fmt.Fprintln(w, "# TYPE network_checks_results_total counter")
+		fmt.Fprintf(w, "network_checks_results_total%s %d\n", withInstance(), results)
+		if !lastResult.IsZero() {
+			fmt.Fprintln(w, "# HELP network_checks_last_result_timestamp_seconds Time the latest result was recorded.")
+			fmt.Fprintln(w, "# TYPE network_checks_last_result_timestamp_seconds gauge")
+			fmt.Fprintf(w, "network_checks_last_result_timestamp_seconds%s %d\n", withInstance(), lastResult.Unix())
+		}
+
+		fmt.Fprintln(w, "# HELP network_checks_check_up Whether the latest run of the check succeeded.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_up gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.execCount == 0 {
+				continue
+			}
+			up := 0
+			if checkResult.status {
+				up = 1
+			}
+			fmt.Fprintf(w, "network_checks_check_up%s %d\n", withInstance(checkLabels(checkResult.check)...), up)
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_status State of the latest run of the check: 0 down, 1 degraded, 2 ok.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_status gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.execCount > 0 {
+				fmt.Fprintf(w, "network_checks_check_status%s %d\n", withInstance(checkLabels(checkResult.check)...), checkResult.stateValue())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_state_since_timestamp_seconds Time the check entered the state of its latest run.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_state_since_timestamp_seconds gauge")
+		for i, checkResult := range checkResults {
+			if checkResult.execCount > 0 && !since[i].IsZero() {
+				fmt.Fprintf(w, "network_checks_check_state_since_timestamp_seconds%s %d\n", withInstance(checkLabels(checkResult.check)...), since[i].Unix())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_last_failure_timestamp_seconds Time of the latest failed run of the check.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_last_failure_timestamp_seconds gauge")
+		for i, checkResult := range checkResults {
+			if !lastFailures[i].IsZero() {
+				fmt.Fprintf(w, "network_checks_check_last_failure_timestamp_seconds%s %d\n", withInstance(checkLabels(checkResult.check)...), lastFailures[i].Unix())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_degraded_threshold_seconds Latency above which a successful run of the check is degraded.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_degraded_threshold_seconds gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.check.DegradedAbove > 0 {
+				fmt.Fprintf(w, "network_checks_check_degraded_threshold_seconds%s %f\n", withInstance(checkLabels(checkResult.check)...), checkResult.check.DegradedAbove.Seconds())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_acknowledged Whether the failing check is acknowledged and its failures not notified.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_acknowledged gauge")
+		for i, checkResult := range checkResults {
+			if checkResult.execCount == 0 || checkResult.status {
+				continue
+			}
+			ack := 0
+			if acked[i] {
+				ack = 1
+			}
+			fmt.Fprintf(w, "network_checks_check_acknowledged%s %d\n", withInstance(checkLabels(checkResult.check)...), ack)
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_silenced Whether the alerts of the check are silenced.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_silenced gauge")
+		for i, checkResult := range checkResults {
+			if checkResult.execCount == 0 {
+				continue
+			}
+			silence := 0
+			if silenced[i] {
+				silence = 1
+			}
+			fmt.Fprintf(w, "network_checks_check_silenced%s %d\n", withInstance(checkLabels(checkResult.check)...), silence)
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_failures_total Failed runs of the check since the start by cause, e.g. dns_error or timeout.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_failures_total counter")
+		for i, checkResult := range checkResults {
+			for cause, failures := range causes[i] {
+				fmt.Fprintf(w, "network_checks_check_failures_total%s %d\n", withInstance(append(checkLabels(checkResult.check), "cause", cause)...), failures)
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_duration_seconds Latency of the latest run of the check.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_duration_seconds gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.execCount == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "network_checks_check_duration_seconds%s %f\n", withInstance(checkLabels(checkResult.check)...), checkResult.duration.Seconds())
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_mode_info How the latest run of the check probed, e.g. the ICMP mode.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_mode_info gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.mode != "" {
+				fmt.Fprintf(w, "network_checks_check_mode_info%s 1\n", withInstance(append(checkLabels(checkResult.check), "mode", checkResult.mode)...))
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_owner_info Owner, contact and runbook of the check.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_owner_info gauge")
+		for _, checkResult := range checkResults {
+			if ownership := checkResult.check.ownershipPairs(); len(ownership) > 0 {
+				fmt.Fprintf(w, "network_checks_check_owner_info%s 1\n", withInstance(append(checkLabels(checkResult.check), ownership...)...))
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_loss_ratio Share of the pings of the latest icmp burst lost.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_loss_ratio gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.burst != nil {
+				fmt.Fprintf(w, "network_checks_check_loss_ratio%s %f\n", withInstance(checkLabels(checkResult.check)...), checkResult.burst.loss())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_p95_seconds 95th percentile round-trip time of the latest icmp burst.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_p95_seconds gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.burst != nil {
+				fmt.Fprintf(w, "network_checks_check_p95_seconds%s %f\n", withInstance(checkLabels(checkResult.check)...), checkResult.burst.p95.Seconds())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_jitter_seconds Mean difference of consecutive round-trip times of the latest icmp burst.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_jitter_seconds gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.burst != nil {
+				fmt.Fprintf(w, "network_checks_check_jitter_seconds%s %f\n", withInstance(checkLabels(checkResult.check)...), checkResult.burst.jitter.Seconds())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_response_bytes Size of the body the latest run of the http check received.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_response_bytes gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.execCount == 0 || checkResult.check.CheckType != "http" || checkResult.check.remote || !checkResult.check.readsBody() {
+				continue
+			}
+			fmt.Fprintf(w, "network_checks_check_response_bytes%s %d\n", withInstance(checkLabels(checkResult.check)...), checkResult.size)
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_throughput_bytes_per_second Download throughput of the body of the latest run of the http check.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_throughput_bytes_per_second gauge")
+		for _, checkResult := range checkResults {
+			if checkResult.execCount == 0 || checkResult.check.CheckType != "http" || checkResult.check.remote || !checkResult.check.readsBody() {
+				continue
+			}
+			fmt.Fprintf(w, "network_checks_check_throughput_bytes_per_second%s %f\n", withInstance(checkLabels(checkResult.check)...), checkResult.throughput)
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_connection_setup_seconds DNS, TCP and TLS setup of the latest new connection of the check.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_connection_setup_seconds gauge")
+		for i, checkResult := range checkResults {
+			if setups[i] == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "network_checks_check_connection_setup_seconds%s %f\n", withInstance(checkLabels(checkResult.check)...), setups[i].Seconds())
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_dns_cache_hits_total Resolutions of the dest of the check served from the DNS cache.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_dns_cache_hits_total counter")
+		for i, checkResult := range checkResults {
+			if dnsStats[i].lastDns != nil {
+				fmt.Fprintf(w, "network_checks_check_dns_cache_hits_total%s %d\n", withInstance(checkLabels(checkResult.check)...), dnsStats[i].dnsHits)
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_dns_cache_misses_total Resolutions of the dest of the check that queried the resolver.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_dns_cache_misses_total counter")
+		for i, checkResult := range checkResults {
+			if dnsStats[i].lastDns != nil {
+				fmt.Fprintf(w, "network_checks_check_dns_cache_misses_total%s %d\n", withInstance(checkLabels(checkResult.check)...), dnsStats[i].dnsMisses)
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_dns_age_seconds Age of the addresses the latest resolution of the check used, 0 when freshly resolved.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_dns_age_seconds gauge")
+		for i, checkResult := range checkResults {
+			if dnsStats[i].lastDns != nil {
+				fmt.Fprintf(w, "network_checks_check_dns_age_seconds%s %f\n", withInstance(checkLabels(checkResult.check)...), dnsStats[i].lastDns.age.Seconds())
+			}
+		}
+		fmt.Fprintln(w, "# HELP network_checks_check_traffic_bytes_total Traffic generated by the check on the local network.")
+		fmt.Fprintln(w, "# TYPE network_checks_check_traffic_bytes_total counter")
+		for i, checkResult := range checkResults {
+			if checkResult.execCount == 0 || checkResult.check.remote {
+				continue
+			}
+			fmt.Fprintf(w, "network_checks_check_traffic_bytes_total%s %d\n", withInstance(checkLabels(checkResult.check)...), traffic[i])
+		}
+	}
+}
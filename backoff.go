@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Longest interval a check down for a long time backs off to, unless
+// configured
+const defaultBackoffMax = time.Hour
+
+// validateBackoffs checks the backoff of the checks and defaults its cap
+func validateBackoffs(checks []Check) error {
+	for i := range checks {
+		check := &checks[i]
+		switch {
+		case check.BackoffAfter < 0:
+			return fmt.Errorf("check %s: backoff_after %v is negative", check.Name, check.BackoffAfter)
+		case check.BackoffAfter == 0 && check.BackoffMax != 0:
+			return fmt.Errorf("check %s: backoff_max needs backoff_after", check.Name)
+		case check.BackoffAfter == 0:
+			continue
+		case check.BackoffMax == 0:
+			check.BackoffMax = max(defaultBackoffMax, check.Repeat)
+		case check.BackoffMax < check.Repeat:
+			return fmt.Errorf("check %s: backoff_max %v is shorter than repeat %v", check.Name, check.BackoffMax, check.Repeat)
+		}
+	}
+	return nil
+}
+
+// updateBackoff tracks how long the check is down and backs off its
+// interval: once it's down for backoff_after, the interval doubles with
+// every failed run up to backoff_max. A successful run returns to repeat.
+func (s *CheckResultStat) updateBackoff(check Check, checkResult CheckResult) {
+	if checkResult.status {
+		if s.backoff > 0 {
+			logMessage(logInfo, "Check", check.Name, "recovered, probing every", check.Repeat, "again")
+		}
+		s.downSince = time.Time{}
+		s.backoff = 0
+		return
+	}
+	if s.downSince.IsZero() {
+		s.downSince = checkResult.runAt
+	}
+	down := checkResult.runAt.Sub(s.downSince)
+	switch {
+	case check.BackoffAfter == 0 || down < check.BackoffAfter:
+		s.backoff = 0
+	case s.backoff == 0:
+		s.backoff = min(2*check.Repeat, check.BackoffMax)
+		logMessage(logWarning, "Check", check.Name, "down for", down.Round(time.Second), "backing off, probing every", s.backoff)
+	default:
+		s.backoff = min(2*s.backoff, check.BackoffMax)
+	}
+}
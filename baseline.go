@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// BaselineEntry is the typical behaviour of a single check
+type BaselineEntry struct {
+	Site    string        `json:"site"`
+	Name    string        `json:"name"`
+	P50     time.Duration `json:"p50"`
+	P95     time.Duration `json:"p95"`
+	Loss    float64       `json:"loss"`
+	Samples int           `json:"samples"`
+}
+
+type Baseline struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Checks    []BaselineEntry `json:"checks"`
+}
+
+// Minimum number of recent samples before a check is compared to its baseline
+const baselineMinSamples = 10
+
+// Runs the loss compared to the baseline is computed over, independent of
+// the display
+const baselineLossWindow = 50
+
+func loadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, err
+	}
+	return &baseline, nil
+}
+
+func writeBaseline(path string, baseline Baseline) error {
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (b *Baseline) entry(site string, name string) *BaselineEntry {
+	for i, entry := range b.Checks {
+		if entry.Site == site && entry.Name == name {
+			return &b.Checks[i]
+		}
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile (0-100) of the durations using the
+// nearest-rank method
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func lossRatio(statuses []bool) float64 {
+	if len(statuses) == 0 {
+		return 0
+	}
+	var failed int
+	for _, status := range statuses {
+		if !status {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(statuses))
+}
+
+// deviation compares recent statistics of a check to its baseline and returns
+// a description of how it got worse, or an empty string if it didn't.
+// Latency deviates when p95 exceeds factor times the baseline p95, loss when
+// it exceeds factor times the baseline loss by at least 5 percentage points.
+func (b *Baseline) deviation(site string, name string, stat CheckResultStat, factor float64) string {
+	entry := b.entry(site, name)
+	if entry == nil || len(stat.longDurations) < baselineMinSamples {
+		return ""
+	}
+
+	p95 := percentile(stat.longDurations, 95)
+	if entry.P95 > 0 && float64(p95) > factor*float64(entry.P95) {
+		return fmt.Sprintf("p95 %v is %.1fx baseline %v", p95.Round(time.Millisecond),
+			float64(p95)/float64(entry.P95), entry.P95.Round(time.Millisecond))
+	}
+	loss := lossRatio(stat.recentStatuses)
+	if loss > factor*entry.Loss && loss > entry.Loss+0.05 {
+		return fmt.Sprintf("loss %.0f%% above baseline %.0f%%", loss*100, entry.Loss*100)
+	}
+	return ""
+}
+
+// baselineSamples collects the results of checks in the order they are first
+// seen
+type baselineSamples struct {
+	keys      []BaselineEntry
+	durations map[string][]time.Duration
+	statuses  map[string][]bool
+}
+
+func newBaselineSamples() *baselineSamples {
+	return &baselineSamples{
+		durations: make(map[string][]time.Duration),
+		statuses:  make(map[string][]bool),
+	}
+}
+
+func (s *baselineSamples) add(site string, name string, duration time.Duration, status bool) {
+	key := site + "/" + name
+	if _, ok := s.statuses[key]; !ok {
+		s.keys = append(s.keys, BaselineEntry{Site: site, Name: name})
+	}
+	s.durations[key] = append(s.durations[key], duration)
+	s.statuses[key] = append(s.statuses[key], status)
+}
+
+func (s *baselineSamples) baseline() Baseline {
+	baseline := Baseline{CreatedAt: time.Now().UTC()}
+	for _, entry := range s.keys {
+		key := entry.Site + "/" + entry.Name
+		entry.P50 = percentile(s.durations[key], 50)
+		entry.P95 = percentile(s.durations[key], 95)
+		entry.Loss = lossRatio(s.statuses[key])
+		entry.Samples = len(s.durations[key])
+		baseline.Checks = append(baseline.Checks, entry)
+	}
+	return baseline
+}
+
+// runBaseline implements the baseline subcommand, computing a baseline from a
+// recording made with -record. It returns the process exit code.
+func runBaseline(args []string) int {
+	flags := flag.NewFlagSet("baseline", flag.ExitOnError)
+	from := flags.String("from", "", "recording made with -record")
+	output := flags.String("o", "baseline.json", "file to write the baseline to")
+	flags.Parse(args)
+	if *from == "" {
+		fmt.Println("Usage: network-checks baseline -from results.jsonl [-o baseline.json]")
+		return 2
+	}
+
+	samples := newBaselineSamples()
+	err := readRecording(*from, func(result AgentResult) error {
+		// Downsampled history has no distribution to take percentiles of
+		if result.Samples > 0 {
+			return nil
+		}
+		samples.add(result.Site, result.Name, result.Duration, result.Status)
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error reading recording:", err)
+		return 1
+	}
+
+	baseline := samples.baseline()
+	if err := writeBaseline(*output, baseline); err != nil {
+		fmt.Println("Error writing baseline:", err)
+		return 1
+	}
+	fmt.Printf("Baseline of %d checks written to %s\n", len(baseline.Checks), *output)
+	return 0
+}
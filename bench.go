@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// Width of the longest bar of the bench histogram
+const benchBarWidth = 40
+
+// benchBuckets returns upper bounds in a 1-2-5 series covering the durations
+// from min to max
+func benchBuckets(min, max time.Duration) []time.Duration {
+	var buckets []time.Duration
+	for decade := 10 * time.Microsecond; decade <= time.Hour; decade *= 10 {
+		for _, step := range []time.Duration{1, 2, 5} {
+			bound := decade * step
+			if bound >= min && (len(buckets) == 0 || buckets[len(buckets)-1] < max) {
+				buckets = append(buckets, bound)
+			}
+		}
+	}
+	return buckets
+}
+
+// benchReport formats the latency distribution of the successful runs
+func benchReport(durations []time.Duration, failures int, elapsed time.Duration) string {
+	var b strings.Builder
+	runs := len(durations) + failures
+	fmt.Fprintf(&b, "%d runs in %v, %d failed (%.1f%% loss)\n", runs, elapsed.Round(time.Millisecond),
+		failures, float64(failures)*100/math.Max(float64(runs), 1))
+	if len(durations) == 0 {
+		return b.String()
+	}
+
+	var total time.Duration
+	min, max := durations[0], durations[0]
+	for _, d := range durations {
+		total += d
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	mean := total / time.Duration(len(durations))
+	var variance float64
+	for _, d := range durations {
+		variance += math.Pow(float64(d-mean), 2)
+	}
+	stddev := time.Duration(math.Sqrt(variance / float64(len(durations))))
+	fmt.Fprintf(&b, "min %v, mean %v, max %v, stddev %v\n", min.Round(time.Microsecond), mean.Round(time.Microsecond),
+		max.Round(time.Microsecond), stddev.Round(time.Microsecond))
+	var percentiles []string
+	for _, p := range []float64{50, 90, 95, 99, 99.9} {
+		percentiles = append(percentiles, fmt.Sprintf("p%g %v", p, percentile(durations, p).Round(time.Microsecond)))
+	}
+	fmt.Fprintln(&b, strings.Join(percentiles, ", "))
+
+	buckets := benchBuckets(min, max)
+	counts := make([]int, len(buckets))
+	most := 0
+	for _, d := range durations {
+		i := 0
+		for i < len(buckets)-1 && d > buckets[i] {
+			i++
+		}
+		counts[i]++
+		if counts[i] > most {
+			most = counts[i]
+		}
+	}
+	fmt.Fprintln(&b)
+	for i, bound := range buckets {
+		bar := strings.Repeat("█", (counts[i]*benchBarWidth+most-1)/most)
+		fmt.Fprintf(&b, "<= %8v %6d %s\n", bound, counts[i], bar)
+	}
+	return b.String()
+}
+
+// runBench implements the bench subcommand, running a single check a number
+// of times back to back (or at -interval) and printing the latency
+// distribution. Interrupting it prints the distribution so far.
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	count := flags.Int("n", 100, "number of runs")
+	interval := flags.Duration("interval", 0, "pause between runs")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of every run")
+	var settings settingsFlag
+	flags.Var(&settings, "set", "setting (key=value) of the check, repeatable")
+	checkType, dest, rest, ok := splitAdHocArgs(args)
+	flags.Parse(rest)
+	if !ok {
+		fmt.Println("Usage: network-checks bench <type> <dest> [-n 100] [-interval 0s] [-timeout 10s] [-set key=value]")
+		return 2
+	}
+	checks, err := adHocChecks(checkType, dest, 0, *timeout, settings)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+	check := checks.Checks[0]
+	run := checkProbe(check.CheckType)
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	var durations []time.Duration
+	failures := 0
+	start := time.Now()
+	c := make(chan CheckResult, 1)
+bench:
+	for i := 0; i < *count; i++ {
+		if i > 0 && *interval > 0 {
+			select {
+			case <-interrupted:
+				break bench
+			case <-time.After(*interval):
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), check.deadline())
+		go run(ctx, check, c)
+		select {
+		case checkResult := <-c:
+			cancel()
+			if checkResult.status {
+				durations = append(durations, checkResult.duration)
+			} else {
+				failures++
+				if failures == 1 && checkResult.detail != "" {
+					fmt.Fprintln(os.Stderr, "First failure:", checkResult.detail)
+				}
+			}
+		case <-interrupted:
+			cancel()
+			break bench
+		}
+	}
+
+	fmt.Print(benchReport(durations, failures, time.Since(start)))
+	if len(durations) == 0 {
+		return 1
+	}
+	return 0
+}
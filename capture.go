@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CaptureConfig configures capturing packets to the destination of a check
+// with tcpdump when the check starts failing
+type CaptureConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Dir       string        `yaml:"dir"`
+	Interface string        `yaml:"interface"`
+	Duration  time.Duration `yaml:"duration"`
+	// Captures kept per check, older ones are removed
+	Keep int `yaml:"keep"`
+}
+
+const (
+	defaultCaptureInterface = "any"
+	defaultCaptureDuration  = 30 * time.Second
+	defaultCaptureKeep      = 10
+)
+
+// packetCapture runs at most one capture per check at a time
+type packetCapture struct {
+	config  CaptureConfig
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// newPacketCapture stores the captures in the configured directory or next
+// to the recording, if any
+func newPacketCapture(config CaptureConfig, recordPath string) *packetCapture {
+	if config.Dir == "" {
+		config.Dir = "."
+		if recordPath != "" {
+			config.Dir = filepath.Dir(recordPath)
+		}
+	}
+	if config.Interface == "" {
+		config.Interface = defaultCaptureInterface
+	}
+	if config.Duration <= 0 {
+		config.Duration = defaultCaptureDuration
+	}
+	if config.Keep <= 0 {
+		config.Keep = defaultCaptureKeep
+	}
+	return &packetCapture{config: config, running: make(map[string]bool)}
+}
+
+// start is a consumer capturing the traffic of a check after it started
+// failing, i.e. of its following runs, see onStateChange
+func (p *packetCapture) start(checkResult CheckResult) {
+	check := checkResult.check
+	if checkResult.status || check.remote || check.Via != "" {
+		return
+	}
+	p.mu.Lock()
+	if p.running[check.Name] {
+		p.mu.Unlock()
+		return
+	}
+	p.running[check.Name] = true
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.running, check.Name)
+			p.mu.Unlock()
+		}()
+		if err := p.capture(check, checkResult.runAt); err != nil {
+			logMessage(logWarning, "Error capturing packets of", check.Name+":", err)
+		}
+	}()
+}
+
+func (p *packetCapture) capture(check Check, failedAt time.Time) error {
+	dir := filepath.Join(p.config.Dir, rrdNameReplacer.ReplaceAllString(check.Name, "_"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, failedAt.UTC().Format("20060102T150405Z")+".pcap")
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Duration)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "tcpdump", "-i", p.config.Interface, "-n", "-U", "-w", path, "host", destHost(check.Dest))
+	output, err := cmd.CombinedOutput()
+	// tcpdump only stops when killed at the end of the capture
+	if ctx.Err() == nil && err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	logMessage(logInfo, "Captured packets of", check.Name, "to", path)
+	return p.rotate(dir)
+}
+
+// rotate removes all but the newest captures of a check
+func (p *packetCapture) rotate(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pcap"))
+	if err != nil {
+		return err
+	}
+	// The names sort by time
+	sort.Strings(paths)
+	for len(paths) > p.config.Keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
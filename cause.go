@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Causes failures are classified into, counted per check
+const (
+	causeDNS         = "dns_error"
+	causeTimeout     = "timeout"
+	causeRefused     = "conn_refused"
+	causeReset       = "conn_reset"
+	causeUnreachable = "unreachable"
+	causeTLS         = "tls_error"
+	causeHTTP4xx     = "http_4xx"
+	causeHTTP5xx     = "http_5xx"
+	causeParse       = "parse_error"
+	// The target answered, but not what the check expects, e.g. a wrong
+	// body hash or register value
+	causeUnexpected = "unexpected"
+	causeOther      = "other"
+)
+
+// unexpectedAnswer is an answer of the target the check doesn't accept,
+// e.g. a wrong status
+type unexpectedAnswer struct {
+	message string
+}
+
+func (e unexpectedAnswer) Error() string {
+	return e.message
+}
+
+// unexpectedf formats an unexpected answer of the target
+func unexpectedf(format string, a ...interface{}) error {
+	return unexpectedAnswer{fmt.Sprintf(format, a...)}
+}
+
+// errorCause classifies an error of a probe by its type. Errors of other
+// types only count by what they say when it's unambiguous.
+func errorCause(err error) string {
+	var unexpected unexpectedAnswer
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unexpected):
+		return causeUnexpected
+	case errors.As(err, &dnsErr):
+		return causeDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return causeTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return causeRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return causeReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return causeUnreachable
+	case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &invalidCert):
+		return causeTLS
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.As(err, &numErr):
+		return causeParse
+	}
+	return detailCause(err.Error())
+}
+
+// argumentCause classifies a failure by the error among the arguments of its
+// detail, if any
+func argumentCause(a []interface{}) string {
+	for _, arg := range a {
+		if err, ok := arg.(error); ok {
+			return errorCause(err)
+		}
+	}
+	return ""
+}
+
+// httpStatusCause classifies an unexpected HTTP response status
+func httpStatusCause(code int) string {
+	switch {
+	case code >= 500:
+		return causeHTTP5xx
+	case code >= 400:
+		return causeHTTP4xx
+	}
+	return causeUnexpected
+}
+
+// detailCauses map the messages of the system and the standard library a
+// failure detail may quote, e.g. from a command run via SSH, to their
+// cause, the first matching one wins. Probes set the cause of anything else.
+var detailCauses = []struct {
+	cause    string
+	keywords []string
+}{
+	{causeDNS, []string{"no such host", "name or service not known", "could not resolve"}},
+	{causeTimeout, []string{"i/o timeout", "timed out", "deadline exceeded"}},
+	{causeRefused, []string{"connection refused", "actively refused"}},
+	{causeReset, []string{"connection reset", "broken pipe"}},
+	{causeUnreachable, []string{"network is unreachable", "host is unreachable", "no route to host"}},
+	{causeTLS, []string{"x509: ", "tls: "}},
+}
+
+// detailCause classifies a failure by its detail
+func detailCause(detail string) string {
+	detail = strings.ToLower(detail)
+	for _, entry := range detailCauses {
+		for _, keyword := range entry.keywords {
+			if strings.Contains(detail, keyword) {
+				return entry.cause
+			}
+		}
+	}
+	return causeOther
+}
+
+// failureCause returns the cause of a failed result: the one the probe
+// determined, else the one its detail mentions. A run without a detail
+// taking the whole timeout timed out.
+func failureCause(checkResult CheckResult) string {
+	switch {
+	case checkResult.status:
+		return ""
+	case checkResult.cause != "":
+		return checkResult.cause
+	case checkResult.detail != "":
+		return detailCause(checkResult.detail)
+	case !checkResult.check.remote && checkResult.duration >= checkResult.check.deadline():
+		return causeTimeout
+	}
+	return causeOther
+}
+
+// formatCauses renders the failures of a check by cause, the most frequent
+// first, like timeout 3, dns_error 1
+func formatCauses(causes map[string]int) string {
+	names := make([]string, 0, len(causes))
+	for cause := range causes {
+		names = append(names, cause)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if causes[names[i]] != causes[names[j]] {
+			return causes[names[i]] > causes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, cause := range names {
+		parts[i] = fmt.Sprintf("%s %d", cause, causes[cause])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// historySample is a single result kept for the chart view
+type historySample struct {
+	runAt    time.Time
+	duration time.Duration
+	status   bool
+	// Whether the run was slow and why it failed, for the history strip
+	degraded bool
+	cause    string
+}
+
+// Number of results per check kept for the chart view
+const chartHistory = 1000
+
+// displayChart draws a full-screen latency chart of the latest window
+// samples. When there are more samples than columns, each column shows the
+// average of several samples and is marked as failed if any of them failed.
+func displayChart(name string, ownership string, samples []historySample, window int) {
+	fmt.Print("\033[H\033[2J") // Clear terminal screen
+	width, height := terminalSize()
+
+	if len(samples) > window {
+		samples = samples[len(samples)-window:]
+	}
+	fmt.Printf("%s - last %d results (+/- zoom, q back)\n", name, len(samples))
+	if ownership != "" {
+		fmt.Println(ownership)
+	}
+	fmt.Println()
+	if len(samples) == 0 {
+		fmt.Println("No results yet")
+		return
+	}
+
+	const labelWidth = 9
+	columns := width - labelWidth - 1
+	if columns > len(samples) {
+		columns = len(samples)
+	}
+	rows := height - 5
+	if ownership != "" {
+		rows--
+	}
+	if rows < 4 {
+		rows = 4
+	}
+
+	// Aggregate samples into columns
+	values := make([]time.Duration, columns)
+	failed := make([]bool, columns)
+	for col := 0; col < columns; col++ {
+		from := col * len(samples) / columns
+		to := (col + 1) * len(samples) / columns
+		var total time.Duration
+		for _, sample := range samples[from:to] {
+			total += sample.duration
+			if !sample.status {
+				failed[col] = true
+			}
+		}
+		values[col] = total / time.Duration(to-from)
+	}
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max == min {
+		max = min + time.Millisecond
+	}
+
+	failColor := color.New(color.FgRed)
+	okColor := color.New(color.FgGreen)
+	for row := rows - 1; row >= 0; row-- {
+		lower := min + time.Duration(int64(max-min)*int64(row)/int64(rows))
+		upper := min + time.Duration(int64(max-min)*int64(row+1)/int64(rows))
+		if row == rows-1 || row == 0 || row == rows/2 {
+			fmt.Printf("%*s ┤", labelWidth-2, formatDuration(lower))
+		} else {
+			fmt.Printf("%*s │", labelWidth-2, "")
+		}
+		for col, v := range values {
+			inRow := v >= lower && (v < upper || row == rows-1 && v <= upper)
+			switch {
+			case inRow && failed[col]:
+				failColor.Print("x")
+			case inRow:
+				okColor.Print("•")
+			default:
+				fmt.Print(" ")
+			}
+		}
+		fmt.Println()
+	}
+
+	first := samples[0].runAt.Format(time.TimeOnly)
+	last := samples[len(samples)-1].runAt.Format(time.TimeOnly)
+	axis := first
+	if columns > len(first)+len(last) {
+		axis = fmt.Sprintf("%-*s%s", columns-len(last), first, last)
+	}
+	fmt.Printf("%*s └%s\n", labelWidth-2, "", axis)
+}
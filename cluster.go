@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const clusterPath = "/api/v1/cluster"
+
+const defaultClusterInterval = 5 * time.Second
+
+// Heartbeats a peer may miss before it's considered down
+const clusterMissedHeartbeats = 3
+
+// ClusterConfig lets instances running the same checks for redundancy
+// elect one leader sending the alerts. Every instance heartbeats its peers
+// through their API (-listen); the healthy instance with the lowest node
+// name leads, so when the leader dies or gets stuck another one takes over.
+type ClusterConfig struct {
+	// Name of this instance, the hostname by default
+	Node string `yaml:"node"`
+	// API URLs of the other instances
+	Peers    []string      `yaml:"peers"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// clusterHeartbeat is exchanged between the instances
+type clusterHeartbeat struct {
+	Node      string    `json:"node"`
+	StartedAt time.Time `json:"started_at"`
+	Healthy   bool      `json:"healthy"`
+	// The acks and runtime silences of the instance
+	State *clusterState `json:"state,omitempty"`
+}
+
+// clusterState is the alerting state set at runtime on any instance, so
+// that a check acked or silenced on one stays muted on the others after a
+// failover. The most recently changed state wins.
+type clusterState struct {
+	Changed  time.Time        `json:"changed"`
+	Acks     []clusterAck     `json:"acks,omitempty"`
+	Silences []clusterSilence `json:"silences,omitempty"`
+}
+
+type clusterAck struct {
+	// incidentKey of the check
+	Key     string    `json:"key"`
+	By      string    `json:"by"`
+	Comment string    `json:"comment,omitempty"`
+	Until   time.Time `json:"until"`
+}
+
+type clusterSilence struct {
+	ID      int       `json:"id"`
+	Match   []string  `json:"match"`
+	From    time.Time `json:"from,omitempty"`
+	Until   time.Time `json:"until"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// sharedState returns the acks and runtime silences to share with the
+// peers, nil when they were never changed
+func (m *Monitor) sharedState() *clusterState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sharedChanged.IsZero() {
+		return nil
+	}
+	state := &clusterState{Changed: m.sharedChanged}
+	for key, ack := range m.acks {
+		state.Acks = append(state.Acks, clusterAck{Key: key, By: ack.by, Comment: ack.comment, Until: ack.until})
+	}
+	for _, silence := range m.silences {
+		state.Silences = append(state.Silences, clusterSilence{ID: silence.id, Match: silence.Match, From: silence.from, Until: silence.until, Comment: silence.Comment})
+	}
+	return state
+}
+
+// adoptState replaces the acks and runtime silences with those of a peer
+// which changed them more recently. Acks of checks which don't fail here
+// are left out, they would mute their next failure.
+func (m *Monitor) adoptState(state *clusterState) {
+	if state == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !state.Changed.After(m.sharedChanged) {
+		return
+	}
+	failing := make(map[string]bool)
+	for _, checkResult := range m.results {
+		if checkResult.execCount > 0 && !checkResult.status {
+			failing[incidentKey(checkResult.check)] = true
+		}
+	}
+	m.acks = make(map[string]checkAck)
+	for _, ack := range state.Acks {
+		if failing[ack.Key] {
+			m.acks[ack.Key] = checkAck{by: ack.By, comment: ack.Comment, until: ack.Until}
+		}
+	}
+	m.silences = nil
+	for _, shared := range state.Silences {
+		silence := Silence{Match: shared.Match, End: shared.Until.Format(time.RFC3339), Comment: shared.Comment, id: shared.ID}
+		if !shared.From.IsZero() {
+			silence.Start = shared.From.Format(time.RFC3339)
+		}
+		if err := silence.prepare(); err != nil {
+			logMessage(logWarning, "Ignoring silence shared by the cluster:", err)
+			continue
+		}
+		m.silences = append(m.silences, silence)
+		m.silenceIds = max(m.silenceIds, silence.id)
+	}
+	m.sharedChanged = state.Changed
+	logMessage(logInfo, "Adopted", len(m.acks), "acks and", len(m.silences), "silences from the cluster")
+}
+
+// clusterPeer is the latest heartbeat of a peer, from either direction
+type clusterPeer struct {
+	heartbeat clusterHeartbeat
+	seen      time.Time
+	up        bool
+}
+
+// cluster tracks the peers of this instance and who leads
+type cluster struct {
+	config    ClusterConfig
+	token     string
+	monitor   *Monitor
+	startedAt time.Time
+
+	mu     sync.Mutex
+	peers  map[string]*clusterPeer
+	leader string
+}
+
+func newCluster(config ClusterConfig, token string, monitor *Monitor) *cluster {
+	if config.Node == "" {
+		config.Node, _ = os.Hostname()
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultClusterInterval
+	}
+	return &cluster{config: config, token: token, monitor: monitor, startedAt: time.Now().UTC(), peers: make(map[string]*clusterPeer)}
+}
+
+func (c *cluster) heartbeat() clusterHeartbeat {
+	return clusterHeartbeat{
+		Node:      c.config.Node,
+		StartedAt: c.startedAt,
+		Healthy:   c.monitor.healthy(c.config.Interval) == nil,
+		State:     c.monitor.sharedState(),
+	}
+}
+
+// seen records a heartbeat of a peer and adopts its state if it's newer
+func (c *cluster) seen(heartbeat clusterHeartbeat) {
+	if heartbeat.Node == "" || heartbeat.Node == c.config.Node {
+		return
+	}
+	c.mu.Lock()
+	peer, ok := c.peers[heartbeat.Node]
+	if !ok {
+		peer = &clusterPeer{}
+		c.peers[heartbeat.Node] = peer
+	}
+	peer.heartbeat = heartbeat
+	peer.seen = time.Now()
+	c.mu.Unlock()
+	c.monitor.adoptState(heartbeat.State)
+}
+
+// elect marks the peers whose heartbeats stopped as down and picks the
+// leader among the healthy instances
+func (c *cluster) elect(selfHealthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var candidates []string
+	if selfHealthy {
+		candidates = append(candidates, c.config.Node)
+	}
+	for name, peer := range c.peers {
+		up := time.Since(peer.seen) < clusterMissedHeartbeats*c.config.Interval
+		if up != peer.up {
+			if up {
+				logMessage(logInfo, "Cluster peer", name, "is up")
+			} else {
+				logMessage(logWarning, "Cluster peer", name, "is down")
+			}
+			peer.up = up
+		}
+		if up && peer.heartbeat.Healthy {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	leader := ""
+	if len(candidates) > 0 {
+		leader = candidates[0]
+	}
+	if leader != c.leader {
+		switch {
+		case leader == c.config.Node:
+			logMessage(logInfo, "This instance", c.config.Node, "now leads the cluster and sends the alerts")
+		case c.leader == c.config.Node:
+			logMessage(logInfo, "Instance", leader, "now leads the cluster, no longer sending alerts")
+		}
+		c.leader = leader
+	}
+}
+
+// isLeader reports whether this instance sends the alerts
+func (c *cluster) isLeader() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader == c.config.Node
+}
+
+// leaderOnly wraps an alerting consumer so that only the leader alerts.
+// Without a cluster every instance does. It goes before onStateChange, so
+// that an instance taking over notifies the failures it didn't see notified.
+func (c *cluster) leaderOnly(handle func(CheckResult)) func(CheckResult) {
+	if c == nil {
+		return handle
+	}
+	return func(checkResult CheckResult) {
+		if c.isLeader() {
+			handle(checkResult)
+		}
+	}
+}
+
+// start heartbeats the peers at the interval and re-elects the leader. A
+// peer that can't be reached doesn't lead, so this instance never stays
+// silent waiting for it.
+func (c *cluster) start() {
+	client := &http.Client{Timeout: c.config.Interval}
+	send := func(peerUrl string, heartbeat clusterHeartbeat) {
+		body, _ := json.Marshal(heartbeat)
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(peerUrl, "/")+clusterPath, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		var reply clusterHeartbeat
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&reply) == nil {
+			c.seen(reply)
+		}
+	}
+	beat := func() {
+		heartbeat := c.heartbeat()
+		var wg sync.WaitGroup
+		for _, peerUrl := range c.config.Peers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				send(peerUrl, heartbeat)
+			}()
+		}
+		wg.Wait()
+		c.elect(heartbeat.Healthy)
+	}
+	// The first round finishes before the checks start, so that instances
+	// starting together don't both alert
+	beat()
+	go func() {
+		for range time.Tick(c.config.Interval) {
+			beat()
+		}
+	}()
+}
+
+// handler accepts the heartbeat of a peer and answers with its own
+func (c *cluster) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c.token != "" && r.Header.Get("Authorization") != "Bearer "+c.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var heartbeat clusterHeartbeat
+		if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil {
+			http.Error(w, fmt.Sprintf("invalid heartbeat: %v", err), http.StatusBadRequest)
+			return
+		}
+		c.seen(heartbeat)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.heartbeat())
+	}
+}
+
+// summary describes the cluster for the control socket
+func (c *cluster) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var peers []string
+	for name, peer := range c.peers {
+		state := "down"
+		if peer.up {
+			state = "up"
+		}
+		peers = append(peers, name+" "+state)
+	}
+	sort.Strings(peers)
+	leader := c.leader
+	if leader == "" {
+		leader = "none"
+	}
+	return fmt.Sprintf("Cluster: node %s, leader %s, peers: %s", c.config.Node, leader, strings.Join(peers, ", "))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CoAP (RFC 7252) message types and options
+const (
+	coapConfirmable    = 0
+	coapNonConfirmable = 1
+	coapAck            = 2
+	coapReset          = 3
+	coapGet            = 1
+	coapUriPath        = 11
+	coapUriQuery       = 15
+	coapAckTimeout     = 2 * time.Second
+)
+
+type coapMessage struct {
+	kind      byte
+	code      byte
+	messageId uint16
+	token     []byte
+}
+
+// coapCode formats a response code like 2.05
+func coapCode(code byte) string {
+	return fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+}
+
+func coapOption(b []byte, previous, number int, value []byte) []byte {
+	nibble := func(n int) (byte, []byte) {
+		switch {
+		case n < 13:
+			return byte(n), nil
+		case n < 269:
+			return 13, []byte{byte(n - 13)}
+		default:
+			return 14, binary.BigEndian.AppendUint16(nil, uint16(n-269))
+		}
+	}
+	delta, deltaExt := nibble(number - previous)
+	length, lengthExt := nibble(len(value))
+	b = append(b, delta<<4|length)
+	b = append(append(b, deltaExt...), lengthExt...)
+	return append(b, value...)
+}
+
+// coapRequest builds a confirmable GET for the path and query of the URL
+func coapRequest(u *url.URL, messageId uint16, token []byte) []byte {
+	b := []byte{0x40 | coapConfirmable<<4 | byte(len(token)), coapGet}
+	b = binary.BigEndian.AppendUint16(b, messageId)
+	b = append(b, token...)
+	previous := 0
+	for _, segment := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+		if segment != "" {
+			b = coapOption(b, previous, coapUriPath, []byte(segment))
+			previous = coapUriPath
+		}
+	}
+	for _, query := range strings.Split(u.RawQuery, "&") {
+		if query != "" {
+			b = coapOption(b, previous, coapUriQuery, []byte(query))
+			previous = coapUriQuery
+		}
+	}
+	return b
+}
+
+func parseCoapMessage(b []byte) (coapMessage, error) {
+	if len(b) < 4 || b[0]>>6 != 1 || int(b[0]&0x0f) > 8 || len(b) < 4+int(b[0]&0x0f) {
+		return coapMessage{}, fmt.Errorf("invalid CoAP message")
+	}
+	return coapMessage{
+		kind:      b[0] >> 4 & 0x03,
+		code:      b[1],
+		messageId: binary.BigEndian.Uint16(b[2:]),
+		token:     b[4 : 4+int(b[0]&0x0f)],
+	}, nil
+}
+
+// coapExchange sends the request, retransmitting it until acknowledged, and
+// waits for the response, piggybacked on the acknowledgement or separate
+func coapExchange(ctx context.Context, conn io.ReadWriter, request []byte, messageId uint16, token []byte) (coapMessage, error) {
+	acked := make(chan struct{})
+	responses := make(chan coapMessage, 1)
+	errs := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 2048)
+		// The empty ACK may be duplicated or retransmitted
+		ackSeen := false
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			message, err := parseCoapMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			switch {
+			case message.kind == coapReset && message.messageId == messageId:
+				errs <- fmt.Errorf("request reset by the server")
+				return
+			case message.kind == coapAck && message.messageId == messageId && message.code == 0:
+				if !ackSeen {
+					ackSeen = true
+					close(acked)
+				}
+			case string(message.token) == string(token) && message.code != 0:
+				responses <- message
+				return
+			}
+		}
+	}()
+
+	timeout := coapAckTimeout
+	for attempt := 0; ; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return coapMessage{}, err
+		}
+		select {
+		case response := <-responses:
+			return response, nil
+		case err := <-errs:
+			return coapMessage{}, err
+		case <-ctx.Done():
+			return coapMessage{}, ctx.Err()
+		case <-acked:
+			// Separate response, no more retransmissions
+			select {
+			case response := <-responses:
+				return response, nil
+			case err := <-errs:
+				return coapMessage{}, err
+			case <-ctx.Done():
+				return coapMessage{}, ctx.Err()
+			}
+		case <-time.After(timeout):
+			timeout *= 2
+		}
+	}
+}
+
+// runCoapCheck sends a confirmable GET to a CoAP endpoint (coap:// or,
+// with DTLS and a pre-shared key, coaps://) and validates the response code
+// against expect_status, where 205 stands for 2.05. Any success (2.xx)
+// passes by default.
+func runCoapCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(err error) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+	}
+
+	u, err := url.Parse(check.Dest)
+	if err != nil || (u.Scheme != "coap" && u.Scheme != "coaps") {
+		fail(fmt.Errorf("invalid CoAP URL %s", check.Dest))
+		return
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "5683"
+		if u.Scheme == "coaps" {
+			port = "5684"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	var dialer net.Dialer
+	udp, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer udp.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		udp.SetDeadline(deadline)
+	}
+	var conn io.ReadWriter = udp
+	if u.Scheme == "coaps" {
+		if conn, err = dtlsDial(ctx, udp, check.PSKIdentity, []byte(check.PSK)); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	id := make([]byte, 6)
+	rand.Read(id)
+	messageId, token := binary.BigEndian.Uint16(id), id[2:]
+	response, err := coapExchange(ctx, conn, coapRequest(u, messageId, token), messageId, token)
+	if err != nil {
+		fail(err)
+		return
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	// A separate confirmable response must be acknowledged
+	if response.kind == coapConfirmable {
+		conn.Write(binary.BigEndian.AppendUint16([]byte{0x40 | coapAck<<4, 0}, response.messageId))
+	}
+
+	code := int(response.code>>5)*100 + int(response.code&0x1f)
+	ok := response.code>>5 == 2
+	if len(check.ExpectStatus) > 0 {
+		ok = false
+		for _, status := range check.ExpectStatus {
+			ok = ok || status == code
+		}
+	}
+	if ok {
+		checkResult.status = true
+	} else {
+		checkResult.detail = fmt.Sprintf("CoAP response %s", coapCode(response.code))
+		checkResult.cause = causeUnexpected
+	}
+	c <- checkResult
+}
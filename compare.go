@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Latency change ratio a comparison reports as slower or faster
+const compareLatencyFactor = 1.2
+
+// runSnapshot runs the checks for the window and returns their statistics
+func runSnapshot(checks Checks, window time.Duration) Baseline {
+	c := make(chan CheckResult, 1000)
+	monitor := newMonitor(checks, c)
+	samples := newBaselineSamples()
+	monitor.start()
+	done := time.After(window)
+	for {
+		select {
+		case checkResult := <-c:
+			monitor.handleResult(checkResult)
+			samples.add(checkResult.check.site, checkResult.check.Name, checkResult.duration, checkResult.status)
+		case <-done:
+			return samples.baseline()
+		}
+	}
+}
+
+func formatDelta(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	return sign + strings.TrimSpace(formatDuration(d))
+}
+
+// compareEntries describes how a check changed, with regressed set when it
+// got worse
+func compareEntries(before, after BaselineEntry) (changes []string, regressed bool) {
+	switch {
+	case after.Loss > before.Loss && before.Loss == 0:
+		changes, regressed = append(changes, "new failures"), true
+	case after.Loss > before.Loss:
+		changes, regressed = append(changes, "more loss"), true
+	case after.Loss < before.Loss && after.Loss == 0:
+		changes = append(changes, "recovered")
+	case after.Loss < before.Loss:
+		changes = append(changes, "less loss")
+	}
+	delta := after.P50 - before.P50
+	switch {
+	case float64(after.P50) > compareLatencyFactor*float64(before.P50) && delta >= time.Millisecond:
+		changes, regressed = append(changes, "slower"), true
+	case float64(before.P50) > compareLatencyFactor*float64(after.P50) && -delta >= time.Millisecond:
+		changes = append(changes, "faster")
+	}
+	return changes, regressed
+}
+
+// printComparison lists the changes of every check between two snapshots and
+// returns whether any check regressed
+func printComparison(before, after Baseline) bool {
+	regressed := false
+	fmt.Printf("%-14s %-20s %-20s %-17s %s\n", "TARGET", "P50", "P95", "LOSS", "CHANGE")
+	for _, entry := range after.Checks {
+		previous := before.entry(entry.Site, entry.Name)
+		if previous == nil {
+			fmt.Printf("%-14s %-20s %-20s %-17s %s\n", entry.Name, strings.TrimSpace(formatDuration(entry.P50)),
+				strings.TrimSpace(formatDuration(entry.P95)), fmt.Sprintf("%.1f%%", entry.Loss*100), "new check")
+			continue
+		}
+		changes, worse := compareEntries(*previous, entry)
+		regressed = regressed || worse
+		fmt.Printf("%-14s %-20s %-20s %-17s %s\n", entry.Name,
+			fmt.Sprintf("%s (%s)", strings.TrimSpace(formatDuration(entry.P50)), formatDelta(entry.P50-previous.P50)),
+			fmt.Sprintf("%s (%s)", strings.TrimSpace(formatDuration(entry.P95)), formatDelta(entry.P95-previous.P95)),
+			fmt.Sprintf("%.1f%% (%.1f%%)", entry.Loss*100, previous.Loss*100),
+			strings.Join(changes, ", "))
+	}
+	for _, entry := range before.Checks {
+		if after.entry(entry.Site, entry.Name) == nil {
+			fmt.Printf("%-14s %-20s %-20s %-17s %s\n", entry.Name, "-", "-", "-", "removed")
+		}
+	}
+	return regressed
+}
+
+// runCompare implements the compare subcommand, running the checks for a
+// window and storing and/or comparing the snapshot with an earlier one. Two
+// snapshots given as arguments are compared without running anything. It
+// returns 1 when a check got worse.
+func runCompare(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	configPath := flags.String("config", "checks.yml", "path to the checks configuration")
+	site := flags.String("site", "local", "name of the site this instance runs at")
+	profile := flags.String("profile", profileAuto, "profile of the configuration to use, auto detects it by the network, none runs all checks")
+	window := flags.Duration("for", 5*time.Minute, "how long the checks run")
+	output := flags.String("o", "", "file to store the snapshot of this run to")
+	against := flags.String("against", "", "snapshot of an earlier run to compare this run with")
+	flags.Parse(args)
+
+	if flags.NArg() == 2 {
+		before, err := loadBaseline(flags.Arg(0))
+		if err != nil {
+			fmt.Println("Error reading snapshot:", err)
+			return 1
+		}
+		after, err := loadBaseline(flags.Arg(1))
+		if err != nil {
+			fmt.Println("Error reading snapshot:", err)
+			return 1
+		}
+		if printComparison(*before, *after) {
+			return 1
+		}
+		return 0
+	}
+	if *output == "" && *against == "" {
+		fmt.Println("Usage: network-checks compare [-for 5m] -o before.json")
+		fmt.Println("       network-checks compare [-for 5m] -against before.json [-o after.json]")
+		fmt.Println("       network-checks compare before.json after.json")
+		return 2
+	}
+
+	var before *Baseline
+	if *against != "" {
+		var err error
+		if before, err = loadBaseline(*against); err != nil {
+			fmt.Println("Error reading snapshot:", err)
+			return 1
+		}
+	}
+	checks, err := loadConfig(*configPath, *site, *profile)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Running %d checks for %v\n", len(checks.Checks), *window)
+	snapshot := runSnapshot(checks, *window)
+	if *output != "" {
+		if err := writeBaseline(*output, snapshot); err != nil {
+			fmt.Println("Error writing snapshot:", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Snapshot of %d checks written to %s\n", len(snapshot.Checks), *output)
+	}
+	if before != nil && printComparison(*before, snapshot) {
+		return 1
+	}
+	return 0
+}
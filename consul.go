@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConsulConfig configures registering checks as TTL checks of the local
+// Consul agent
+type ConsulConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Address   string `yaml:"address"`
+	Token     string `yaml:"token"`
+	ServiceId string `yaml:"service_id"`
+}
+
+const defaultConsulAddress = "http://127.0.0.1:8500"
+
+type consulClient struct {
+	config ConsulConfig
+	client *http.Client
+	checks []Check
+}
+
+func newConsulClient(config ConsulConfig, checks []Check) *consulClient {
+	if config.Address == "" {
+		config.Address = defaultConsulAddress
+	}
+	return &consulClient{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		checks: checks,
+	}
+}
+
+func consulCheckId(check Check) string {
+	return "network-checks:" + check.Name
+}
+
+// consulTTL gives a check a few missed runs before Consul marks it critical
+func consulTTL(check Check) time.Duration {
+	ttl := 3 * check.Repeat
+	if ttl < 10*time.Second {
+		ttl = 10 * time.Second
+	}
+	return ttl
+}
+
+func (c *consulClient) put(path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(c.config.Address, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("X-Consul-Token", c.config.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul returned %s", resp.Status)
+	}
+	return nil
+}
+
+type consulCheckRegistration struct {
+	Id        string `json:"ID"`
+	Name      string `json:"Name"`
+	Notes     string `json:"Notes"`
+	TTL       string `json:"TTL"`
+	ServiceId string `json:"ServiceID,omitempty"`
+}
+
+// register registers all checks as TTL checks
+func (c *consulClient) register() error {
+	for _, check := range c.checks {
+		err := c.put("/v1/agent/check/register", consulCheckRegistration{
+			Id:        consulCheckId(check),
+			Name:      check.Name,
+			Notes:     fmt.Sprintf("%s check of %s by network-checks", check.CheckType, check.Dest),
+			TTL:       consulTTL(check).String(),
+			ServiceId: c.config.ServiceId,
+		})
+		if err != nil {
+			return fmt.Errorf("registering %s: %v", check.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *consulClient) deregister() {
+	for _, check := range c.checks {
+		if err := c.put("/v1/agent/check/deregister/"+url.PathEscape(consulCheckId(check)), nil); err != nil {
+			logMessage(logWarning, "Error deregistering Consul check", check.Name+":", err)
+		}
+	}
+}
+
+// update is a consumer pushing every local result as the TTL check status
+func (c *consulClient) update(checkResult CheckResult) {
+	if checkResult.check.remote {
+		return
+	}
+	status := "critical"
+	output := fmt.Sprintf("FAIL after %v", checkResult.duration.Round(time.Millisecond))
+	if checkResult.status {
+		status = "passing"
+		output = fmt.Sprintf("OK in %v", checkResult.duration.Round(time.Millisecond))
+	}
+	err := c.put("/v1/agent/check/update/"+url.PathEscape(consulCheckId(checkResult.check)), map[string]string{
+		"Status": status,
+		"Output": output,
+	})
+	if err != nil {
+		logMessage(logWarning, "Error updating Consul check", checkResult.check.Name+":", err)
+	}
+}
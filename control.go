@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultSocketPath = "/tmp/network-checks.sock"
+
+// startControlSocket listens for line based commands on a Unix socket. Every
+// connection carries a single command and receives a plain text response.
+func startControlSocket(path string, monitor *Monitor, reload func() error) error {
+	// Remove a stale socket left behind by a previous run
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				logMessage(logErr, "Control socket stopped:", err)
+				return
+			}
+			go handleControlConn(conn, monitor, reload)
+		}
+	}()
+	return nil
+}
+
+func handleControlConn(conn net.Conn, monitor *Monitor, reload func() error) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return
+	}
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		fmt.Fprintln(conn, "error: empty command")
+		return
+	}
+
+	switch args[0] {
+	case "status":
+		fmt.Fprint(conn, monitor.status())
+	case "map":
+		monitor.mu.Lock()
+		topology := monitor.topology()
+		monitor.mu.Unlock()
+		if len(args) > 1 && args[1] == "dot" {
+			fmt.Fprint(conn, topology.dot())
+			return
+		}
+		for _, line := range topology.lines(false) {
+			fmt.Fprintln(conn, line)
+		}
+	case "compare":
+		monitor.mu.Lock()
+		comparison := monitor.compareVantages()
+		monitor.mu.Unlock()
+		lines := comparison.lines(false)
+		if len(lines) == 0 {
+			fmt.Fprintln(conn, "no check runs at more than one site")
+		}
+		for _, line := range lines {
+			fmt.Fprintln(conn, line)
+		}
+	case "pause", "resume":
+		if len(args) != 2 {
+			fmt.Fprintf(conn, "error: usage: %s <check>\n", args[0])
+			return
+		}
+		if err := monitor.setPaused(args[1], args[0] == "pause"); err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+	case "ack":
+		if len(args) < 2 {
+			fmt.Fprintln(conn, "error: usage: ack <check> [duration] [comment]")
+			return
+		}
+		var duration time.Duration
+		comment := args[2:]
+		if len(comment) > 0 {
+			if d, err := time.ParseDuration(comment[0]); err == nil {
+				duration, comment = d, comment[1:]
+			}
+		}
+		if err := monitor.acknowledge(args[1], duration, "ctl", strings.Join(comment, " ")); err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+	case "unack":
+		if len(args) != 2 {
+			fmt.Fprintln(conn, "error: usage: unack <check>")
+			return
+		}
+		if err := monitor.unacknowledge(args[1]); err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+	case "silence":
+		if len(args) < 3 {
+			fmt.Fprintln(conn, "error: usage: silence <filter>[,<filter>...] <duration>|<start>/<end> [comment]")
+			return
+		}
+		silence := Silence{Match: strings.Split(args[1], ","), Comment: strings.Join(args[3:], " ")}
+		if duration, err := time.ParseDuration(args[2]); err == nil {
+			silence.End = time.Now().Add(duration).Format(time.RFC3339)
+		} else if start, end, ok := strings.Cut(args[2], "/"); ok {
+			silence.Start, silence.End = start, end
+		} else {
+			silence.End = args[2]
+		}
+		id, err := monitor.addSilence(silence)
+		if err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintf(conn, "ok, silence #%d\n", id)
+	case "unsilence":
+		var id int
+		if len(args) != 2 {
+			fmt.Fprintln(conn, "error: usage: unsilence <id>")
+			return
+		}
+		if _, err := fmt.Sscanf(strings.TrimPrefix(args[1], "#"), "%d", &id); err != nil {
+			fmt.Fprintln(conn, "error: invalid silence id", args[1])
+			return
+		}
+		if err := monitor.deleteSilence(id); err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+	case "note":
+		if len(args) < 3 {
+			fmt.Fprintln(conn, "error: usage: note <check> <text>")
+			return
+		}
+		if err := monitor.addNote(args[1], "ctl", strings.Join(args[2:], " ")); err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+	case "notes":
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+		lines := monitor.listNotes(name)
+		if len(lines) == 0 {
+			fmt.Fprintln(conn, "no notes")
+		}
+		for _, line := range lines {
+			fmt.Fprintln(conn, line)
+		}
+	case "reload":
+		if err := reload(); err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			return
+		}
+		fmt.Fprintln(conn, "ok")
+	default:
+		fmt.Fprintf(conn, "error: unknown command %q\n", args[0])
+	}
+}
+
+// runCtl implements the ctl subcommand, sending a command to a running
+// instance and printing its response. It returns the process exit code.
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := flags.String("socket", defaultSocketPath, "path of the control socket")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: network-checks ctl [-socket path] status|map [dot]|compare|pause <check>|resume <check>|ack <check> [duration] [comment]|unack <check>|silence <filters> <duration>|<start>/<end> [comment]|unsilence <id>|note <check> <text>|notes [check]|reload")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Println("Error connecting to control socket:", err)
+		return 1
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, strings.Join(flags.Args(), " ")); err != nil {
+		fmt.Println("Error sending command:", err)
+		return 1
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		fmt.Println("Error reading response:", err)
+		return 1
+	}
+	fmt.Print(string(response))
+	if strings.HasPrefix(string(response), "error:") {
+		return 1
+	}
+	return 0
+}
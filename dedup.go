@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probe runs a check and sends its result to the channel
+type probe func(context.Context, Check, chan CheckResult)
+
+// probeSharing lets checks of the same type resolving to the same address
+// and port share one probe, whose result is fanned out to all of them
+type probeSharing struct {
+	mu       sync.Mutex
+	inflight map[string]*sharedProbe
+}
+
+type sharedProbe struct {
+	done   chan struct{}
+	result CheckResult
+}
+
+// probeOptions are the settings changing how a check probes or what it
+// accepts. A shared result was judged by the expectations of the check that
+// probed, so only checks with the same options share one.
+type probeOptions struct {
+	Timeout       time.Duration
+	Steps         []HTTPStep
+	Connection    string
+	MinSize       byteSize
+	MinThroughput byteSize
+	ExpectSHA256  string
+	ExpectStatus  []int
+	PinSPKI       []string
+	PinSerial     []string
+	OCSP          bool
+	OCSPStaple    bool
+	ICMPMode      string
+	Count         int
+	Interval      time.Duration
+}
+
+func newProbeOptions(check Check) probeOptions {
+	return probeOptions{
+		Timeout:       check.deadline(),
+		Steps:         check.Steps,
+		Connection:    check.Connection,
+		MinSize:       check.MinSize,
+		MinThroughput: check.MinThroughput,
+		ExpectSHA256:  check.ExpectSHA256,
+		ExpectStatus:  check.ExpectStatus,
+		PinSPKI:       check.PinSPKI,
+		PinSerial:     check.PinSerial,
+		OCSP:          check.OCSP,
+		OCSPStaple:    check.OCSPStaple,
+		ICMPMode:      check.ICMPMode,
+		Count:         check.Count,
+		Interval:      check.Interval,
+	}
+}
+
+// probeKey identifies what a check actually probes, empty when it can't be
+// shared. HTTP probes are only shared by requests of the same Host and path,
+// which virtual hosts may answer differently.
+func probeKey(ctx context.Context, check Check) string {
+	if check.Via != "" || (check.CheckType != "http" && check.CheckType != "icmp") {
+		return ""
+	}
+	host, port, request := destHost(check.Dest), "", ""
+	if check.CheckType == "http" {
+		u, err := url.Parse(check.Dest)
+		if err != nil {
+			return ""
+		}
+		port = u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		request = " " + u.Scheme + "://" + strings.ToLower(u.Hostname()) + u.RequestURI()
+	}
+	addrs, err := resolveHost(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s%s %+v", check.CheckType, net.JoinHostPort(addrs[0], port), request, newProbeOptions(check))
+}
+
+// wrap returns a probe joining a running probe of the same address instead
+// of probing again
+func (s *probeSharing) wrap(run probe) probe {
+	return func(ctx context.Context, check Check, c chan CheckResult) {
+		key := probeKey(ctx, check)
+		if key == "" {
+			run(ctx, check, c)
+			return
+		}
+
+		s.mu.Lock()
+		if s.inflight == nil {
+			s.inflight = make(map[string]*sharedProbe)
+		}
+		if shared, ok := s.inflight[key]; ok {
+			s.mu.Unlock()
+			select {
+			case <-shared.done:
+				result := shared.result
+				result.check = check
+				// The traffic was accounted for by the check which probed
+				result.bytes = 0
+				c <- result
+			case <-ctx.Done():
+				run(ctx, check, c)
+			}
+			return
+		}
+		shared := &sharedProbe{done: make(chan struct{})}
+		s.inflight[key] = shared
+		s.mu.Unlock()
+
+		own := make(chan CheckResult, 1)
+		run(ctx, check, own)
+		shared.result = <-own
+
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+		close(shared.done)
+		c <- shared.result
+	}
+}
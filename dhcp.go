@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DHCP (RFC 2131) message types and options
+const (
+	dhcpDiscover      = 1
+	dhcpOffer         = 2
+	dhcpOptionType    = 53
+	dhcpOptionServer  = 54
+	dhcpOptionParams  = 55
+	dhcpOptionEnd     = 255
+	dhcpServerPort    = 67
+	dhcpClientPort    = 68
+	dhcpMinPacketSize = 300
+)
+
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// dhcpDiscoverPacket asks for offers for the hardware address, broadcast
+// back to the client as it has no address yet
+func dhcpDiscoverPacket(xid []byte, hardwareAddr net.HardwareAddr) []byte {
+	b := []byte{1, 1, 6, 0}
+	b = append(b, xid...)
+	b = append(b, 0, 0, 0x80, 0)
+	b = append(b, make([]byte, 16)...)
+	chaddr := make([]byte, 16)
+	copy(chaddr, hardwareAddr)
+	b = append(b, chaddr...)
+	b = append(b, make([]byte, 192)...)
+	b = append(b, dhcpMagicCookie...)
+	b = append(b, dhcpOptionType, 1, dhcpDiscover)
+	b = append(b, dhcpOptionParams, 3, 1, 3, 6)
+	b = append(b, dhcpOptionEnd)
+	for len(b) < dhcpMinPacketSize {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// parseDhcpOffer returns the server identifier of an offer for the
+// transaction
+func parseDhcpOffer(b []byte, xid []byte) (net.IP, bool) {
+	if len(b) < 240 || b[0] != 2 || !bytes.Equal(b[4:8], xid) || !bytes.Equal(b[236:240], dhcpMagicCookie) {
+		return nil, false
+	}
+	var server net.IP
+	offer := false
+	for options := b[240:]; len(options) > 0 && options[0] != dhcpOptionEnd; {
+		if options[0] == 0 {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			break
+		}
+		value := options[2 : 2+int(options[1])]
+		switch options[0] {
+		case dhcpOptionType:
+			offer = len(value) == 1 && value[0] == dhcpOffer
+		case dhcpOptionServer:
+			if len(value) == 4 {
+				server = net.IP(value)
+			}
+		}
+		options = options[2+len(value):]
+	}
+	return server, offer
+}
+
+// runDhcpCheck broadcasts a DHCPDISCOVER on the interface in dest and waits
+// for an offer, from the server in expect if set. The lease is never
+// requested, so no address is taken.
+func runDhcpCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(err error) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+	}
+
+	iface, err := net.InterfaceByName(check.Dest)
+	if err != nil {
+		fail(err)
+		return
+	}
+	conn, err := dhcpListen(ctx, iface.Name)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	xid := make([]byte, 4)
+	rand.Read(xid)
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpServerPort}
+	if _, err := conn.WriteTo(dhcpDiscoverPacket(xid, iface.HardwareAddr), broadcast); err != nil {
+		fail(err)
+		return
+	}
+	buf := make([]byte, 1500)
+	var unexpected net.IP
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil && unexpected != nil {
+			fail(fmt.Errorf("offer only from %s, expected %s", unexpected, check.Expect))
+			return
+		}
+		if err != nil {
+			fail(fmt.Errorf("no DHCP offer: %v", err))
+			return
+		}
+		server, ok := parseDhcpOffer(buf[:n], xid)
+		if !ok {
+			continue
+		}
+		// The expected server may still answer
+		if check.Expect != "" && !server.Equal(net.ParseIP(check.Expect)) {
+			unexpected = server
+			continue
+		}
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.status = true
+		c <- checkResult
+		return
+	}
+}
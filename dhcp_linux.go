@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dhcpListen opens the DHCP client port bound to the interface, which
+// requires root or the CAP_NET_BIND_SERVICE and CAP_NET_RAW capabilities
+func dhcpListen(ctx context.Context, iface string) (net.PacketConn, error) {
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			controlErr := c.Control(func(fd uintptr) {
+				if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+					return
+				}
+				if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1); err != nil {
+					return
+				}
+				err = unix.BindToDevice(int(fd), iface)
+			})
+			if controlErr != nil {
+				return controlErr
+			}
+			return err
+		},
+	}
+	conn, err := config.ListenPacket(ctx, "udp4", fmt.Sprintf("0.0.0.0:%d", dhcpClientPort))
+	if err != nil {
+		return nil, fmt.Errorf("error opening the DHCP client port: %v", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func dhcpListen(ctx context.Context, iface string) (net.PacketConn, error) {
+	return nil, errors.New("DHCP checks are only supported on Linux")
+}
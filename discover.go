@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Ports a discovered host is probed on
+	discoverPorts = "21,22,23,25,53,80,110,143,389,443,445,502,554,636,1883,3306,3389,5060,5432,5683,8080,8443,9092,9200"
+	// Hosts probed at the same time
+	discoverConcurrency = 64
+	// Largest subnet swept without an explicit -subnet
+	discoverMaxHosts = 1024
+)
+
+// Files mapping MAC address prefixes to vendors, as shipped by ieee-data,
+// hwdata and nmap
+var ouiFiles = []string{
+	"/usr/share/ieee-data/oui.txt",
+	"/var/lib/ieee-data/oui.txt",
+	"/usr/share/hwdata/oui.txt",
+	"/usr/share/misc/oui.txt",
+	"/usr/share/nmap/nmap-mac-prefixes",
+}
+
+type discoveredHost struct {
+	ip       net.IP
+	hostname string
+	mac      string
+	vendor   string
+	pings    bool
+	ports    []int
+}
+
+func (h discoveredHost) name() string {
+	if h.hostname != "" {
+		name, _, _ := strings.Cut(h.hostname, ".")
+		return name
+	}
+	return h.ip.String()
+}
+
+func (h discoveredHost) dest() string {
+	if h.hostname != "" {
+		return h.hostname
+	}
+	return h.ip.String()
+}
+
+func (h discoveredHost) hasPort(port int) bool {
+	for _, p := range h.ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func runDiscover(args []string) int {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	subnets := flags.String("subnet", "", "comma-separated subnets to sweep, by default those of the local interfaces")
+	ports := flags.String("ports", discoverPorts, "ports probed on every host")
+	timeout := flags.Duration("timeout", time.Second, "timeout of every probe")
+	repeat := flags.Duration("repeat", defaultImportRepeat, "repeat of the suggested checks")
+	output := flags.String("o", "-", "file to write the checks to, - for stdout")
+	flags.Parse(args)
+
+	probePorts, err := parsePorts(*ports)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+	var networks []*net.IPNet
+	if *subnets != "" {
+		for _, subnet := range strings.Split(*subnets, ",") {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(subnet))
+			if err != nil || network.IP.To4() == nil {
+				fmt.Printf("Error: invalid IPv4 subnet %q\n", subnet)
+				return 2
+			}
+			networks = append(networks, network)
+		}
+	} else if networks, err = localSubnets(); err != nil {
+		fmt.Println("Error listing interfaces:", err)
+		return 1
+	}
+	if len(networks) == 0 {
+		fmt.Println("Error: no subnet to sweep, use -subnet")
+		return 1
+	}
+
+	var hosts []discoveredHost
+	for _, network := range networks {
+		fmt.Fprintf(os.Stderr, "Sweeping %s\n", network)
+		hosts = append(hosts, sweepSubnet(network, probePorts, *timeout)...)
+	}
+	identifyHosts(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(os.Stderr, "Found %s", host.ip)
+		for _, info := range []string{host.hostname, host.mac, host.vendor} {
+			if info != "" {
+				fmt.Fprintf(os.Stderr, " %s", info)
+			}
+		}
+		if len(host.ports) > 0 {
+			fmt.Fprintf(os.Stderr, " ports %s", formatPorts(host.ports))
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+
+	if err := writeChecks(*output, suggestChecks(hosts, *repeat)); err != nil {
+		fmt.Println("Error writing checks:", err)
+		return 1
+	}
+	return 0
+}
+
+// localSubnets returns the IPv4 subnets of the interfaces that are up,
+// narrowed down around the interface address when they are too large to sweep
+func localSubnets() ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var networks []*net.IPNet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ones, bits := ipNet.Mask.Size()
+			for bits-ones > 1 && 1<<(bits-ones) > discoverMaxHosts {
+				ones++
+			}
+			mask := net.CIDRMask(ones, bits)
+			networks = append(networks, &net.IPNet{IP: ipNet.IP.To4().Mask(mask), Mask: mask})
+		}
+	}
+	return networks, nil
+}
+
+// subnetHosts lists the addresses of a subnet without the network and
+// broadcast addresses
+func subnetHosts(network *net.IPNet) []net.IP {
+	base := network.IP.To4()
+	ones, bits := network.Mask.Size()
+	size := uint32(1) << (bits - ones)
+	first, last := uint32(1), size-1
+	if size <= 2 {
+		first, last = 0, size
+	}
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	var ips []net.IP
+	for i := first; i < last; i++ {
+		n := start + i
+		ips = append(ips, net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).To4())
+	}
+	return ips
+}
+
+// sweepSubnet pings every address of the subnet and connects to the ports.
+// Hosts that answer neither but resolved on the link (ARP) are kept as well.
+func sweepSubnet(network *net.IPNet, ports []int, timeout time.Duration) []discoveredHost {
+	var mu sync.Mutex
+	found := make(map[string]*discoveredHost)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, discoverConcurrency)
+	for _, ip := range subnetHosts(network) {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(ip net.IP) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			host := discoveredHost{ip: ip, pings: discoverPing(ip, timeout)}
+			for _, port := range ports {
+				conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), timeout)
+				if err == nil {
+					conn.Close()
+					host.ports = append(host.ports, port)
+				}
+			}
+			if host.pings || len(host.ports) > 0 {
+				mu.Lock()
+				found[ip.String()] = &host
+				mu.Unlock()
+			}
+		}(ip)
+	}
+	wg.Wait()
+
+	for ip := range arpTable() {
+		if _, ok := found[ip]; !ok && network.Contains(net.ParseIP(ip)) {
+			found[ip] = &discoveredHost{ip: net.ParseIP(ip).To4()}
+		}
+	}
+	var hosts []discoveredHost
+	for _, host := range found {
+		hosts = append(hosts, *host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return string(hosts[i].ip.To4()) < string(hosts[j].ip.To4())
+	})
+	return hosts
+}
+
+func discoverPing(ip net.IP, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Second)
+	defer cancel()
+	args := []string{"-c", "1", "-W", "1", ip.String()}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", strconv.Itoa(int(timeout.Milliseconds())), ip.String()}
+	}
+	return exec.CommandContext(ctx, "ping", args...).Run() == nil
+}
+
+// arpTable returns the resolved link-layer addresses by IP address. It is
+// only available on Linux.
+func arpTable() map[string]string {
+	table := make(map[string]string)
+	file, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return table
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[2] != "0x0" && fields[3] != "00:00:00:00:00:00" {
+			table[fields[0]] = fields[3]
+		}
+	}
+	return table
+}
+
+// identifyHosts adds the host names, MAC addresses and their vendors
+func identifyHosts(hosts []discoveredHost) {
+	arp := arpTable()
+	vendors := ouiVendors()
+	for i := range hosts {
+		host := &hosts[i]
+		if names, err := net.LookupAddr(host.ip.String()); err == nil && len(names) > 0 {
+			host.hostname = strings.TrimSuffix(names[0], ".")
+		}
+		host.mac = arp[host.ip.String()]
+		if mac, err := net.ParseMAC(host.mac); err == nil && len(mac) >= 3 {
+			if mac[0]&0x02 != 0 {
+				// Locally administered, typically a randomized address
+				host.vendor = "(random MAC)"
+			} else {
+				host.vendor = vendors[fmt.Sprintf("%02X%02X%02X", mac[0], mac[1], mac[2])]
+			}
+		}
+	}
+}
+
+// ouiVendors reads the first vendor database found, keyed by the upper-case
+// hex OUI, e.g. 001B63
+func ouiVendors() map[string]string {
+	vendors := make(map[string]string)
+	for _, path := range ouiFiles {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// ieee-data: "00-1B-63   (hex)\t\tApple, Inc."
+			if prefix, vendor, ok := strings.Cut(line, "(hex)"); ok {
+				vendors[strings.ReplaceAll(strings.TrimSpace(prefix), "-", "")] = strings.TrimSpace(vendor)
+				continue
+			}
+			// nmap: "001B63 Apple"
+			if prefix, vendor, ok := strings.Cut(line, " "); ok && len(prefix) == 6 && !strings.HasPrefix(line, "#") {
+				vendors[strings.ToUpper(prefix)] = vendor
+			}
+		}
+		return vendors
+	}
+	return vendors
+}
+
+// suggestChecks creates checks for the services a host appears to offer
+func suggestChecks(hosts []discoveredHost, repeat time.Duration) []Check {
+	var checks []Check
+	used := make(map[string]bool)
+	add := func(host discoveredHost, checkType, dest string) *Check {
+		checks = append(checks, Check{
+			Name:      uniqueName(host.name(), checkType, used),
+			CheckType: checkType,
+			Dest:      dest,
+			Repeat:    repeat,
+			Group:     "discovered",
+		})
+		return &checks[len(checks)-1]
+	}
+
+	for _, host := range hosts {
+		var other []int
+		for _, port := range host.ports {
+			switch port {
+			case 80:
+				if !host.hasPort(443) {
+					add(host, "http", "http://"+host.dest())
+				}
+			case 443:
+				add(host, "http", "https://"+host.dest())
+			case 8080, 8443:
+				scheme := "http"
+				if port == 8443 {
+					scheme = "https"
+				}
+				add(host, "http", fmt.Sprintf("%s://%s:%d", scheme, host.dest(), port))
+			case 3389:
+				add(host, "rdp", host.dest())
+			case 502:
+				add(host, "modbus", host.dest())
+			default:
+				other = append(other, port)
+			}
+		}
+		if len(other) > 0 {
+			check := add(host, "ports", host.dest())
+			check.Ports = strings.ReplaceAll(formatPorts(other), " ", "")
+		}
+		if host.pings || len(host.ports) == 0 {
+			add(host, "icmp", host.dest())
+		}
+	}
+	return checks
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Defaults of the display configuration
+const (
+	defaultHistoryLength = 50
+	defaultShortWindow   = 10
+	defaultLongWindow    = 100
+	// The history strip isn't shortened below this to fit the terminal
+	minHistoryLength = 10
+)
+
+// Upper bounds of the latencies colored green, yellow and orange in the
+// history strip, slower runs are red
+var defaultHeatBuckets = []time.Duration{50 * time.Millisecond, 150 * time.Millisecond, 500 * time.Millisecond}
+
+// Colors of the latency buckets, orange from the 256 color palette
+var heatColors = [][]color.Attribute{
+	{color.FgGreen},
+	{color.FgYellow},
+	{38, 5, 208},
+	{color.FgRed},
+}
+
+// Glyphs of the history strip
+const (
+	glyphOk      = "."
+	glyphSlow    = "~"
+	glyphTimeout = "T"
+	glyphError   = "F"
+)
+
+// Latency columns of the table
+const (
+	columnLast  = "last"
+	columnShort = "short"
+	columnLong  = "long"
+)
+
+var latencyColumns = []string{columnLast, columnShort, columnLong}
+
+// DisplayConfig sizes the table of the terminal UI: the runs in the history
+// strip, the runs averaged in the LAST columns and which of them are shown.
+// The strip is shortened further when the terminal is too narrow for it.
+type DisplayConfig struct {
+	History     int      `yaml:"history"`
+	ShortWindow int      `yaml:"short_window"`
+	LongWindow  int      `yaml:"long_window"`
+	Columns     []string `yaml:"columns"`
+	// Upper bounds of the green, yellow and orange latencies in the history
+	// strip
+	Heat []time.Duration `yaml:"heat"`
+	// The table is redrawn at most this often, by default after every run
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+func (d DisplayConfig) validate() error {
+	for _, size := range []struct {
+		name  string
+		value int
+	}{{"history", d.History}, {"short_window", d.ShortWindow}, {"long_window", d.LongWindow}} {
+		if size.value < 0 {
+			return fmt.Errorf("display: %s (%d) must not be negative", size.name, size.value)
+		}
+	}
+	if d.historyLength() > chartHistory {
+		return fmt.Errorf("display: history (%d) must be at most %d", d.historyLength(), chartHistory)
+	}
+	if d.shortWindow() > d.longWindow() {
+		return fmt.Errorf("display: short_window (%d) must be at most long_window (%d)", d.shortWindow(), d.longWindow())
+	}
+	for _, column := range d.Columns {
+		if !contains(latencyColumns, column) {
+			return fmt.Errorf("display: unknown column %q, expected one of %s", column, strings.Join(latencyColumns, ", "))
+		}
+	}
+	if len(d.Heat) > 0 {
+		if len(d.Heat) != len(defaultHeatBuckets) {
+			return fmt.Errorf("display: heat needs %d bounds for green, yellow and orange, got %d", len(defaultHeatBuckets), len(d.Heat))
+		}
+		for i := 1; i < len(d.Heat); i++ {
+			if d.Heat[i] <= d.Heat[i-1] {
+				return fmt.Errorf("display: heat bounds must increase, %v isn't above %v", d.Heat[i], d.Heat[i-1])
+			}
+		}
+	}
+	if d.Refresh < 0 {
+		return fmt.Errorf("display: refresh (%v) must not be negative", d.Refresh)
+	}
+	return nil
+}
+
+func (d DisplayConfig) historyLength() int {
+	if d.History > 0 {
+		return d.History
+	}
+	return defaultHistoryLength
+}
+
+func (d DisplayConfig) shortWindow() int {
+	if d.ShortWindow > 0 {
+		return d.ShortWindow
+	}
+	return defaultShortWindow
+}
+
+func (d DisplayConfig) longWindow() int {
+	if d.LongWindow > 0 {
+		return d.LongWindow
+	}
+	return defaultLongWindow
+}
+
+// columns returns the latency columns shown, in the order of the table
+func (d DisplayConfig) columns() []string {
+	if len(d.Columns) == 0 {
+		return latencyColumns
+	}
+	var columns []string
+	for _, column := range latencyColumns {
+		if contains(d.Columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// latencyHeader returns the headers of the latency columns and their widths
+func (d DisplayConfig) latencyHeader() ([]string, []int) {
+	columns := d.columns()
+	headers := make([]string, len(columns))
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		switch column {
+		case columnLast:
+			headers[i] = "LAST"
+		case columnShort:
+			headers[i] = fmt.Sprintf("LAST %d", d.shortWindow())
+		case columnLong:
+			headers[i] = fmt.Sprintf("LAST %d", d.longWindow())
+		}
+		widths[i] = max(len(headers[i]), 6)
+	}
+	return headers, widths
+}
+
+// latencyValues renders the latency columns of a check
+func (d DisplayConfig) latencyValues(checkResult CheckResult, stat CheckResultStat) []string {
+	columns := d.columns()
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		switch column {
+		case columnLast:
+			values[i] = formatDuration(checkResult.duration)
+		case columnShort:
+			values[i] = formatDuration(averageDuration(stat.shortDurations))
+		case columnLong:
+			values[i] = formatDuration(averageDuration(stat.longDurations))
+		}
+	}
+	return values
+}
+
+// joinColumns right-aligns the values to the widths, separated by bars
+func joinColumns(values []string, widths []int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%*s", widths[i], value)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// fitHistory returns how many runs of the history strip fit in the width
+// left by the other columns
+func (d DisplayConfig) fitHistory(width int) int {
+	return min(d.historyLength(), max(width, minHistoryLength))
+}
+
+// historyStrip renders the latest runs of the history, the latest first, one
+// glyph per run: . when it was ok, ~ when slow (degraded), T when it timed
+// out and F when it failed otherwise. Runs that worked are colored by the
+// latency bucket they fall into, failures by the severity of the check.
+func (d DisplayConfig) historyStrip(history []historySample, length int, severity string, selected bool) string {
+	buckets := d.Heat
+	if len(buckets) == 0 {
+		buckets = defaultHeatBuckets
+	}
+	var b strings.Builder
+	for i := len(history) - 1; i >= 0 && len(history)-i <= length; i-- {
+		sample := history[i]
+		var glyph string
+		var glyphColor *color.Color
+		if sample.status {
+			bucket := 0
+			for bucket < len(buckets) && sample.duration > buckets[bucket] {
+				bucket++
+			}
+			glyph, glyphColor = glyphOk, color.New(heatColors[bucket]...)
+			if sample.degraded {
+				glyph = glyphSlow
+			}
+		} else {
+			glyph, glyphColor = glyphError, failureColor(severity)
+			if sample.cause == causeTimeout {
+				glyph = glyphTimeout
+			}
+		}
+		if selected {
+			glyphColor.Add(color.ReverseVideo)
+		}
+		b.WriteString(glyphColor.Sprint(glyph))
+	}
+	// Padded to the length with the glyphs counted as one column each
+	if shown := min(len(history), length); shown < length {
+		b.WriteString(strings.Repeat(" ", length-shown))
+	}
+	return b.String()
+}
+
+// throttledDraw returns a consumer redrawing the table at most once per
+// interval. A redraw asked for sooner is postponed to the end of the
+// interval, so the latest result is always shown eventually.
+func (m *Monitor) throttledDraw(interval time.Duration) func(CheckResult) {
+	if interval <= 0 {
+		return func(CheckResult) { m.draw() }
+	}
+	var mu sync.Mutex
+	var last time.Time
+	pending := false
+	return func(CheckResult) {
+		mu.Lock()
+		if pending {
+			mu.Unlock()
+			return
+		}
+		if wait := time.Until(last.Add(interval)); wait > 0 {
+			pending = true
+			mu.Unlock()
+			time.AfterFunc(wait, func() {
+				mu.Lock()
+				pending = false
+				last = time.Now()
+				mu.Unlock()
+				m.draw()
+			})
+			return
+		}
+		last = time.Now()
+		mu.Unlock()
+		m.draw()
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+)
+
+// Minimal DNS client for the DNS based checks, speaking the wire format
+// directly so that DNSSEC records can be inspected
+
+const (
+	dnsTypeA      = 1
+	dnsTypeNS     = 2
+	dnsTypeCNAME  = 5
+	dnsTypeSOA    = 6
+	dnsTypePTR    = 12
+	dnsTypeMX     = 15
+	dnsTypeTXT    = 16
+	dnsTypeAAAA   = 28
+	dnsTypeOPT    = 41
+	dnsTypeDS     = 43
+	dnsTypeRRSIG  = 46
+	dnsTypeDNSKEY = 48
+	dnsClassIN    = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+)
+
+var dnsTypes = map[string]uint16{
+	"A": dnsTypeA, "NS": dnsTypeNS, "CNAME": dnsTypeCNAME, "SOA": dnsTypeSOA, "PTR": dnsTypePTR,
+	"MX": dnsTypeMX, "TXT": dnsTypeTXT, "AAAA": dnsTypeAAAA, "DS": dnsTypeDS, "DNSKEY": dnsTypeDNSKEY,
+}
+
+var dnsRcodes = map[int]string{
+	0: "NOERROR", 1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+}
+
+const defaultDnsResolver = "1.1.1.1:53"
+
+// dnsResolver returns the resolver configured for a check, defaulting to the
+// first nameserver of the system
+func dnsResolver(check Check) string {
+	resolver := check.Resolver
+	if resolver == "" {
+		resolver = systemDnsResolver()
+	}
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+	return resolver
+}
+
+func systemDnsResolver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return defaultDnsResolver
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1]
+		}
+	}
+	return defaultDnsResolver
+}
+
+// dnsRR is a resource record with its data still in wire format. Names in
+// the data may be compressed and refer to the whole message.
+type dnsRR struct {
+	name   string
+	rrtype uint16
+	class  uint16
+	ttl    uint32
+	rdata  []byte
+	msg    []byte
+	offset int
+}
+
+type dnsResponse struct {
+	rcode         int
+	authenticated bool
+	answer        []dnsRR
+	// Bytes of the query and the response
+	size int
+}
+
+// canonicalName lower cases a name and makes it fully qualified
+func canonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// isSubdomain reports whether the name is the zone or below it, both
+// canonical
+func isSubdomain(name string, zone string) bool {
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func packName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(canonicalName(name), "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// unpackName reads a possibly compressed name at the offset and returns it
+// with the offset following it
+func unpackName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, fmt.Errorf("name out of bounds")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")) + ".", end, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) || jumps > 100 {
+				return "", 0, fmt.Errorf("invalid name compression")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, fmt.Errorf("label out of bounds")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+func dnsQuery(name string, rrtype uint16, dnssec bool) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], uint16(rand.Intn(1<<16)))
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 1)
+	msg = append(msg, packName(name)...)
+	msg = binary.BigEndian.AppendUint16(msg, rrtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+
+	// EDNS0 with the DNSSEC OK bit, so that signatures are returned
+	flags := uint32(0)
+	if dnssec {
+		flags = 0x8000
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypeOPT)
+	msg = binary.BigEndian.AppendUint16(msg, 1232)
+	msg = binary.BigEndian.AppendUint32(msg, flags)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	if dnssec {
+		// Ask for the AD bit of a validating resolver
+		binary.BigEndian.PutUint16(msg[2:], 0x0120)
+	}
+	return msg
+}
+
+func parseDnsResponse(msg []byte, id uint16) (*dnsResponse, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("short DNS response")
+	}
+	if binary.BigEndian.Uint16(msg) != id {
+		return nil, fmt.Errorf("DNS response id mismatch")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	response := &dnsResponse{
+		rcode:         int(flags & 0xf),
+		authenticated: flags&0x0020 != 0,
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := unpackName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4
+	}
+	for i := 0; i < answers; i++ {
+		name, next, err := unpackName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, fmt.Errorf("record out of bounds")
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		if next+10+length > len(msg) {
+			return nil, fmt.Errorf("record data out of bounds")
+		}
+		response.answer = append(response.answer, dnsRR{
+			name:   name,
+			rrtype: binary.BigEndian.Uint16(msg[next:]),
+			class:  binary.BigEndian.Uint16(msg[next+2:]),
+			ttl:    binary.BigEndian.Uint32(msg[next+4:]),
+			rdata:  msg[next+10 : next+10+length],
+			msg:    msg,
+			offset: next + 10,
+		})
+		offset = next + 10 + length
+	}
+	return response, nil
+}
+
+// dnsExchange sends a query over UDP and retries over TCP when the response
+// is truncated
+func dnsExchange(ctx context.Context, resolver string, name string, rrtype uint16, dnssec bool) (*dnsResponse, error) {
+	query := dnsQuery(name, rrtype, dnssec)
+	id := binary.BigEndian.Uint16(query)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 3 || buf[2]&0x02 == 0 {
+		response, err := parseDnsResponse(buf[:n], id)
+		if err == nil {
+			response.size = len(query) + n
+		}
+		return response, err
+	}
+
+	tcp, err := dialer.DialContext(ctx, "tcp", resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer tcp.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		tcp.SetDeadline(deadline)
+	}
+	if _, err := tcp.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(tcp)
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		return nil, err
+	}
+	response, err := parseDnsResponse(msg, id)
+	if err == nil {
+		response.size = 2*len(query) + n + 2 + len(msg)
+	}
+	return response, err
+}
+
+// records returns the records of a type owned by the name
+func (r *dnsResponse) records(name string, rrtype uint16) []dnsRR {
+	var records []dnsRR
+	for _, rr := range r.answer {
+		if rr.rrtype == rrtype && rr.name == canonicalName(name) {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// canonicalRdata returns the record data with embedded names uncompressed
+// and lower cased (RFC 4034 6.2)
+func (rr dnsRR) canonicalRdata() ([]byte, error) {
+	var names, prefix, suffix int
+	switch rr.rrtype {
+	case dnsTypeNS, dnsTypeCNAME, dnsTypePTR:
+		names = 1
+	case dnsTypeMX:
+		names, prefix = 1, 2
+	case dnsTypeSOA:
+		names, suffix = 2, 20
+	default:
+		return rr.rdata, nil
+	}
+	if len(rr.rdata) < prefix {
+		return nil, fmt.Errorf("short record data")
+	}
+	b := append([]byte(nil), rr.rdata[:prefix]...)
+	offset := rr.offset + prefix
+	for i := 0; i < names; i++ {
+		name, next, err := unpackName(rr.msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next > rr.offset+len(rr.rdata) {
+			return nil, fmt.Errorf("name out of the record data")
+		}
+		b = append(b, packName(name)...)
+		offset = next
+	}
+	if offset+suffix > rr.offset+len(rr.rdata) {
+		return nil, fmt.Errorf("record data out of bounds")
+	}
+	return append(b, rr.msg[offset:offset+suffix]...), nil
+}
+
+// target returns the name a PTR, CNAME or NS record points to
+func (rr dnsRR) target() (string, error) {
+	name, _, err := unpackName(rr.msg, rr.offset)
+	return name, err
+}
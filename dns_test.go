@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testRecord returns a record of the type with the rdata, placed in a
+// message after a header and the name example.com at offset 12, which
+// compression pointers (0xc0 0x0c) can refer to, and followed by the
+// trailing bytes, e.g. of further records
+func testRecord(rrtype uint16, rdata []byte, trailing []byte) dnsRR {
+	msg := append(make([]byte, 12), packName("example.com")...)
+	offset := len(msg)
+	msg = append(append(msg, rdata...), trailing...)
+	return dnsRR{name: "example.com.", rrtype: rrtype, class: dnsClassIN, rdata: msg[offset : offset+len(rdata)], msg: msg, offset: offset}
+}
+
+func TestCanonicalRdata(t *testing.T) {
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	tests := []struct {
+		name     string
+		rr       dnsRR
+		want     []byte
+		wantsErr bool
+	}{
+		{"MX", testRecord(dnsTypeMX, join([]byte{0, 10}, packName("Mail.Example.com")), nil), join([]byte{0, 10}, packName("mail.example.com")), false},
+		{"MX compressed", testRecord(dnsTypeMX, []byte{0, 10, 0xc0, 0x0c}, nil), join([]byte{0, 10}, packName("example.com")), false},
+		{"MX empty", testRecord(dnsTypeMX, nil, nil), nil, true},
+		{"MX short preference", testRecord(dnsTypeMX, []byte{0}, []byte{0, 0}), nil, true},
+		{"MX without exchange", testRecord(dnsTypeMX, []byte{0, 10}, nil), nil, true},
+		{"MX name past the record", testRecord(dnsTypeMX, []byte{0, 10, 4, 'm', 'a'}, []byte{'i', 'l', 0}), nil, true},
+		{"NS compression loop", testRecord(dnsTypeNS, []byte{0xc0, 0x19}, nil), nil, true},
+		{"NS pointer out of the message", testRecord(dnsTypeNS, []byte{0xc0, 0xff}, nil), nil, true},
+		{"SOA without serials", testRecord(dnsTypeSOA, []byte{0xc0, 0x0c, 0xc0, 0x0c}, make([]byte, 20)), nil, true},
+		{"A as is", testRecord(dnsTypeA, []byte{192, 0, 2, 1}, nil), []byte{192, 0, 2, 1}, false},
+	}
+	for _, test := range tests {
+		got, err := test.rr.canonicalRdata()
+		if test.wantsErr {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", test.name, got)
+			}
+		} else if err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestParseRRSIG(t *testing.T) {
+	fields := []byte{0, 1, 13, 2, 0, 0, 14, 16, 0, 0, 0, 1, 0, 0, 0, 0, 0x12, 0x34}
+	tests := []struct {
+		name          string
+		rr            dnsRR
+		wantSigner    string
+		wantSignature []byte
+		wantsErr      bool
+	}{
+		{"compressed signer", testRecord(dnsTypeRRSIG, append(append(fields[:18:18], 0xc0, 0x0c), 1, 2, 3), nil), "example.com.", []byte{1, 2, 3}, false},
+		{"signer", testRecord(dnsTypeRRSIG, append(append(fields[:18:18], packName("Example.com")...), 1, 2), nil), "example.com.", []byte{1, 2}, false},
+		{"empty", testRecord(dnsTypeRRSIG, nil, nil), "", nil, true},
+		{"short", testRecord(dnsTypeRRSIG, fields[:10], nil), "", nil, true},
+		{"pointer past the record", testRecord(dnsTypeRRSIG, append(fields[:18:18], 0xc0), []byte{0x0c, 0, 0}), "", nil, true},
+		{"signer past the record", testRecord(dnsTypeRRSIG, append(fields[:18:18], 3, 'c', 'o'), []byte{'m', 0}), "", nil, true},
+		{"signer out of the message", testRecord(dnsTypeRRSIG, append(fields[:18:18], 3, 'c', 'o'), nil), "", nil, true},
+	}
+	for _, test := range tests {
+		sig, err := parseRRSIG(test.rr)
+		if test.wantsErr {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.name, sig)
+			}
+		} else if err != nil || sig.signer != test.wantSigner || !bytes.Equal(sig.signature, test.wantSignature) || sig.keyTag != 0x1234 {
+			t.Errorf("%s: got %+v, %v, want signer %s and signature %v", test.name, sig, err, test.wantSigner, test.wantSignature)
+		}
+	}
+}
+
+func TestIsSubdomain(t *testing.T) {
+	tests := []struct {
+		name, zone string
+		want       bool
+	}{
+		{"www.example.com.", "example.com.", true},
+		{"example.com.", "example.com.", true},
+		{"example.com.", "com.", true},
+		{"example.com.", ".", true},
+		{"www.example.com.", "ample.com.", false},
+		{"example.com.", "example.org.", false},
+		{"com.", "example.com.", false},
+	}
+	for _, test := range tests {
+		if got := isSubdomain(test.name, test.zone); got != test.want {
+			t.Errorf("isSubdomain(%s, %s) = %v, want %v", test.name, test.zone, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long names resolved by the system resolver, e.g. from /etc/hosts,
+// are cached as no TTL is known for them
+const dnsCacheFallbackTTL = time.Minute
+
+// dnsLookup tells how the address a check probed was resolved
+type dnsLookup struct {
+	// Whether the addresses came from the cache
+	cached bool
+	// Time since the addresses were resolved
+	age time.Duration
+}
+
+// dnsCacheEntry holds the addresses of a name until its TTL expires
+type dnsCacheEntry struct {
+	addrs      []string
+	resolvedAt time.Time
+	expires    time.Time
+	err        error
+	// Closed once a running lookup completes
+	done chan struct{}
+}
+
+// resolverCache resolves the names of the checks, honoring the TTLs of the
+// records. Concurrent lookups of a name share one query, so hundreds of
+// checks of a host cost the resolver a query per TTL.
+type resolverCache struct {
+	mu       sync.Mutex
+	disabled bool
+	entries  map[string]*dnsCacheEntry
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+var dnsCache = &resolverCache{}
+
+// lookup returns the addresses of the host, IPv4 first
+func (r *resolverCache) lookup(ctx context.Context, host string) ([]string, dnsLookup, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, dnsLookup{}, nil
+	}
+	if r.disabled {
+		r.misses.Add(1)
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		return addrs, dnsLookup{}, err
+	}
+
+	r.mu.Lock()
+	if r.entries == nil {
+		r.entries = make(map[string]*dnsCacheEntry)
+	}
+	entry, ok := r.entries[host]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		r.mu.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, dnsLookup{}, ctx.Err()
+		}
+		if entry.err != nil {
+			return nil, dnsLookup{}, entry.err
+		}
+		r.hits.Add(1)
+		return entry.addrs, dnsLookup{cached: true, age: time.Since(entry.resolvedAt)}, nil
+	}
+	entry = &dnsCacheEntry{done: make(chan struct{})}
+	r.entries[host] = entry
+	r.mu.Unlock()
+
+	r.misses.Add(1)
+	// The query outlives the check starting it, as others may be waiting
+	queryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	entry.addrs, entry.expires, entry.err = resolveWithTTL(queryCtx, host)
+	entry.resolvedAt = time.Now()
+	if entry.err != nil {
+		// Failures aren't cached, the next check asks again
+		entry.expires = time.Time{}
+	}
+	close(entry.done)
+	return entry.addrs, dnsLookup{}, entry.err
+}
+
+// hostsFileAddrs returns the addresses of the host in /etc/hosts
+func hostsFileAddrs(host string) []string {
+	data, err := os.ReadFile("/etc/hosts")
+	if err != nil {
+		return nil
+	}
+	var addrs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		for _, name := range fields[min(1, len(fields)):] {
+			if strings.EqualFold(name, host) && net.ParseIP(fields[0]) != nil {
+				addrs = append(addrs, fields[0])
+			}
+		}
+	}
+	return addrs
+}
+
+// resolveWithTTL queries the A and AAAA records of the host in parallel
+// from the system nameserver, returning them with the expiry of the lowest
+// TTL. Names in /etc/hosts, names the nameserver doesn't know (e.g. through
+// search domains) and systems without /etc/resolv.conf are resolved like
+// the system does.
+func resolveWithTTL(ctx context.Context, host string) ([]string, time.Time, error) {
+	if addrs := hostsFileAddrs(host); len(addrs) > 0 {
+		return addrs, time.Now().Add(dnsCacheFallbackTTL), nil
+	}
+	if _, err := os.Stat("/etc/resolv.conf"); err != nil {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		return addrs, time.Now().Add(dnsCacheFallbackTTL), err
+	}
+	resolver := systemDnsResolver()
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+	rrtypes := []uint16{dnsTypeA, dnsTypeAAAA}
+	responses := make([]*dnsResponse, len(rrtypes))
+	var wg sync.WaitGroup
+	for i, rrtype := range rrtypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := dnsExchange(ctx, resolver, host, rrtype, false)
+			if err == nil && response.rcode == dnsRcodeSuccess {
+				responses[i] = response
+			}
+		}()
+	}
+	wg.Wait()
+
+	var addrs []string
+	ttl := uint32(0)
+	first := true
+	for i, response := range responses {
+		if response == nil {
+			continue
+		}
+		for _, rr := range response.answer {
+			// The TTL of a CNAME the address was found through counts too
+			if first || rr.ttl < ttl {
+				ttl, first = rr.ttl, false
+			}
+			if rr.rrtype == rrtypes[i] {
+				addrs = append(addrs, net.IP(rr.rdata).String())
+			}
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, time.Now().Add(time.Duration(ttl) * time.Second), nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	return addrs, time.Now().Add(dnsCacheFallbackTTL), err
+}
+
+type dnsLookupKey struct{}
+
+// withLookupTrace returns a context recording how the dials of a check
+// resolved their address, and a function returning the latest, nil when
+// nothing was resolved
+func withLookupTrace(ctx context.Context) (context.Context, func() *dnsLookup) {
+	var lookup atomic.Pointer[dnsLookup]
+	return context.WithValue(ctx, dnsLookupKey{}, &lookup), lookup.Load
+}
+
+// resolveHost resolves the host through the cache, recording the lookup in
+// the context
+func resolveHost(ctx context.Context, host string) ([]string, error) {
+	addrs, lookup, err := dnsCache.lookup(ctx, host)
+	if err == nil && net.ParseIP(host) == nil {
+		if trace, ok := ctx.Value(dnsLookupKey{}).(*atomic.Pointer[dnsLookup]); ok {
+			trace.Store(&lookup)
+		}
+	}
+	return addrs, err
+}
+
+// dialCached dials the address with its host resolved through the cache,
+// trying the addresses in order
+func dialCached(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := resolveHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSSEC algorithm numbers
+const (
+	dnssecRSASHA1         = 5
+	dnssecRSASHA1NSEC3    = 7
+	dnssecRSASHA256       = 8
+	dnssecRSASHA512       = 10
+	dnssecECDSAP256SHA256 = 13
+	dnssecECDSAP384SHA384 = 14
+	dnssecED25519         = 15
+)
+
+const dnskeyZoneFlag = 0x0100
+
+type rrsig struct {
+	typeCovered uint16
+	algorithm   uint8
+	labels      uint8
+	originalTTL uint32
+	expiration  uint32
+	inception   uint32
+	keyTag      uint16
+	signer      string
+	signature   []byte
+	// The record data up to the signature, with the signer name canonical
+	signed []byte
+}
+
+func parseRRSIG(rr dnsRR) (rrsig, error) {
+	if len(rr.rdata) < 19 {
+		return rrsig{}, fmt.Errorf("short RRSIG record")
+	}
+	signer, next, err := unpackName(rr.msg, rr.offset+18)
+	if err != nil {
+		return rrsig{}, err
+	}
+	if next > rr.offset+len(rr.rdata) {
+		return rrsig{}, fmt.Errorf("RRSIG signer out of the record data")
+	}
+	return rrsig{
+		typeCovered: binary.BigEndian.Uint16(rr.rdata),
+		algorithm:   rr.rdata[2],
+		labels:      rr.rdata[3],
+		originalTTL: binary.BigEndian.Uint32(rr.rdata[4:]),
+		expiration:  binary.BigEndian.Uint32(rr.rdata[8:]),
+		inception:   binary.BigEndian.Uint32(rr.rdata[12:]),
+		keyTag:      binary.BigEndian.Uint16(rr.rdata[16:]),
+		signer:      signer,
+		signature:   rr.rdata[next-rr.offset:],
+		signed:      append(append([]byte(nil), rr.rdata[:18]...), packName(signer)...),
+	}, nil
+}
+
+// signatures returns the signatures covering the records of a type owned by
+// the name made by the zone
+func (r *dnsResponse) signatures(name string, rrtype uint16, zone string) []rrsig {
+	var sigs []rrsig
+	for _, rr := range r.records(name, dnsTypeRRSIG) {
+		if sig, err := parseRRSIG(rr); err == nil && sig.typeCovered == rrtype && sig.signer == zone {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// keyTag computes the tag identifying a DNSKEY (RFC 4034 appendix B)
+func keyTag(dnskey []byte) uint16 {
+	var sum uint32
+	for i, b := range dnskey {
+		if i&1 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	return uint16(sum + sum>>16&0xffff)
+}
+
+// serialTime converts an RRSIG timestamp using serial number arithmetic
+func serialTime(t uint32, now time.Time) time.Time {
+	return now.Add(time.Duration(int32(t-uint32(now.Unix()))) * time.Second)
+}
+
+// signedData builds the data covered by a signature over an RRset in
+// canonical form (RFC 4034 3.1.8.1)
+func (sig rrsig) signedData(rrset []dnsRR) ([]byte, error) {
+	owner := rrset[0].name
+	// A record synthesized from a wildcard is signed with the wildcard owner
+	if labels := strings.Split(strings.TrimSuffix(owner, "."), "."); owner != "." && int(sig.labels) < len(labels) {
+		owner = "*." + strings.Join(labels[len(labels)-int(sig.labels):], ".") + "."
+	}
+
+	var records [][]byte
+	for _, rr := range rrset {
+		rdata, err := rr.canonicalRdata()
+		if err != nil {
+			return nil, err
+		}
+		record := packName(owner)
+		record = binary.BigEndian.AppendUint16(record, rr.rrtype)
+		record = binary.BigEndian.AppendUint16(record, rr.class)
+		record = binary.BigEndian.AppendUint32(record, sig.originalTTL)
+		record = binary.BigEndian.AppendUint16(record, uint16(len(rdata)))
+		records = append(records, append(record, rdata...))
+	}
+	sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i], records[j]) < 0 })
+
+	data := append([]byte(nil), sig.signed...)
+	for i, record := range records {
+		if i > 0 && bytes.Equal(record, records[i-1]) {
+			continue
+		}
+		data = append(data, record...)
+	}
+	return data, nil
+}
+
+// verify checks the signature with the public key of a DNSKEY record
+func (sig rrsig) verify(dnskey []byte, data []byte) error {
+	if len(dnskey) < 5 {
+		return fmt.Errorf("short DNSKEY record")
+	}
+	key := dnskey[4:]
+	switch sig.algorithm {
+	case dnssecRSASHA1, dnssecRSASHA1NSEC3, dnssecRSASHA256, dnssecRSASHA512:
+		publicKey, err := parseRSAKey(key)
+		if err != nil {
+			return err
+		}
+		hash := crypto.SHA1
+		switch sig.algorithm {
+		case dnssecRSASHA256:
+			hash = crypto.SHA256
+		case dnssecRSASHA512:
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(data)
+		return rsa.VerifyPKCS1v15(publicKey, hash, h.Sum(nil), sig.signature)
+	case dnssecECDSAP256SHA256, dnssecECDSAP384SHA384:
+		curve, digest := elliptic.P256(), sha256.Sum256(data)
+		hashed := digest[:]
+		if sig.algorithm == dnssecECDSAP384SHA384 {
+			curve = elliptic.P384()
+			digest := sha512.Sum384(data)
+			hashed = digest[:]
+		}
+		size := len(key) / 2
+		if len(key) != 2*size || len(sig.signature) != len(key) {
+			return fmt.Errorf("invalid ECDSA key or signature length")
+		}
+		publicKey := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key[:size]),
+			Y:     new(big.Int).SetBytes(key[size:]),
+		}
+		r := new(big.Int).SetBytes(sig.signature[:size])
+		s := new(big.Int).SetBytes(sig.signature[size:])
+		if !ecdsa.Verify(publicKey, hashed, r, s) {
+			return fmt.Errorf("verification error")
+		}
+		return nil
+	case dnssecED25519:
+		if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, data, sig.signature) {
+			return fmt.Errorf("verification error")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %d", sig.algorithm)
+}
+
+// parseRSAKey parses an RSA public key in the DNSKEY format (RFC 3110)
+func parseRSAKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 3 {
+		return nil, fmt.Errorf("short RSA key")
+	}
+	exponentLength, offset := int(key[0]), 1
+	if exponentLength == 0 {
+		exponentLength, offset = int(binary.BigEndian.Uint16(key[1:])), 3
+	}
+	if offset+exponentLength >= len(key) || exponentLength > 4 {
+		return nil, fmt.Errorf("invalid RSA key")
+	}
+	exponent := new(big.Int).SetBytes(key[offset : offset+exponentLength])
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(key[offset+exponentLength:]),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// verifyRRset verifies that an RRset carries a currently valid signature by
+// one of the zone keys and returns the keys with valid signatures
+func verifyRRset(rrset []dnsRR, sigs []rrsig, dnskeys []dnsRR, now time.Time) ([]dnsRR, error) {
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("%s is not signed", rrset[0].name)
+	}
+	var signers []dnsRR
+	var lastErr error
+	for _, sig := range sigs {
+		data, err := sig.signedData(rrset)
+		if err != nil {
+			return nil, err
+		}
+		lastErr = fmt.Errorf("no DNSKEY with tag %d signs %s", sig.keyTag, rrset[0].name)
+		for _, dnskey := range dnskeys {
+			if len(dnskey.rdata) < 4 || keyTag(dnskey.rdata) != sig.keyTag || dnskey.rdata[3] != sig.algorithm ||
+				binary.BigEndian.Uint16(dnskey.rdata)&dnskeyZoneFlag == 0 {
+				continue
+			}
+			if err := sig.verify(dnskey.rdata, data); err != nil {
+				lastErr = fmt.Errorf("bogus signature of %s by key %d: %v", rrset[0].name, sig.keyTag, err)
+				continue
+			}
+			if expiration := serialTime(sig.expiration, now); now.After(expiration) {
+				lastErr = fmt.Errorf("signature of %s by key %d expired %s", rrset[0].name, sig.keyTag, expiration.UTC().Format(time.RFC3339))
+				continue
+			}
+			if inception := serialTime(sig.inception, now); now.Before(inception) {
+				lastErr = fmt.Errorf("signature of %s by key %d not valid before %s", rrset[0].name, sig.keyTag, inception.UTC().Format(time.RFC3339))
+				continue
+			}
+			signers = append(signers, dnskey)
+		}
+	}
+	if len(signers) == 0 {
+		return nil, lastErr
+	}
+	return signers, nil
+}
+
+// matchesDS reports whether a DS record of the parent zone refers to the key
+func matchesDS(owner string, dnskey []byte, ds []byte) bool {
+	if len(ds) < 5 || binary.BigEndian.Uint16(ds) != keyTag(dnskey) || ds[2] != dnskey[3] {
+		return false
+	}
+	data := append(packName(owner), dnskey...)
+	var digest []byte
+	switch ds[3] {
+	case 1:
+		sum := sha1.Sum(data)
+		digest = sum[:]
+	case 2:
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	case 4:
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(digest, ds[4:])
+}
+
+// validateDnssec validates the signature of the records and of the zone keys
+// signing them, and that the parent zone delegates to these keys. The
+// parent's DS records themselves are trusted as returned by the resolver.
+func validateDnssec(ctx context.Context, resolver string, name string, rrtype uint16, transferred *int64) error {
+	now := time.Now()
+	exchange := func(name string, rrtype uint16) (*dnsResponse, error) {
+		response, err := dnsExchange(ctx, resolver, name, rrtype, true)
+		if err != nil {
+			return nil, err
+		}
+		*transferred += int64(response.size)
+		switch response.rcode {
+		case dnsRcodeSuccess:
+			return response, nil
+		case dnsRcodeServFail:
+			return nil, fmt.Errorf("SERVFAIL for %s, a validating resolver considers it bogus", name)
+		}
+		return nil, fmt.Errorf("%s for %s", dnsRcodes[response.rcode], name)
+	}
+
+	response, err := exchange(name, rrtype)
+	if err != nil {
+		return err
+	}
+	rrset := response.records(name, rrtype)
+	if len(rrset) == 0 {
+		return fmt.Errorf("no records for %s", name)
+	}
+	// Only the zone of the name or one of its parents may sign it, any
+	// other signer would be validated against keys that don't vouch for it
+	var zone string
+	for _, rr := range response.records(name, dnsTypeRRSIG) {
+		if sig, err := parseRRSIG(rr); err == nil && sig.typeCovered == rrtype {
+			if !isSubdomain(canonicalName(name), sig.signer) {
+				return fmt.Errorf("%s is signed by %s, which is not its zone", name, sig.signer)
+			}
+			zone = sig.signer
+		}
+	}
+	sigs := response.signatures(name, rrtype, zone)
+	if len(sigs) == 0 {
+		return fmt.Errorf("%s is not signed", name)
+	}
+
+	keys, err := exchange(zone, dnsTypeDNSKEY)
+	if err != nil {
+		return err
+	}
+	dnskeys := keys.records(zone, dnsTypeDNSKEY)
+	if len(dnskeys) == 0 {
+		return fmt.Errorf("no DNSKEY records for %s", zone)
+	}
+	if _, err := verifyRRset(rrset, sigs, dnskeys, now); err != nil {
+		return err
+	}
+	keySigners, err := verifyRRset(dnskeys, keys.signatures(zone, dnsTypeDNSKEY, zone), dnskeys, now)
+	if err != nil {
+		return err
+	}
+
+	// The root zone keys are the trust anchor
+	if zone == "." {
+		return nil
+	}
+	parent, err := exchange(zone, dnsTypeDS)
+	if err != nil {
+		return err
+	}
+	dsRecords := parent.records(zone, dnsTypeDS)
+	if len(dsRecords) == 0 {
+		return fmt.Errorf("no DS records for %s in the parent zone", zone)
+	}
+	for _, key := range keySigners {
+		for _, ds := range dsRecords {
+			if matchesDS(zone, key.rdata, ds.rdata) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no DS record of the parent zone matches the keys signing %s", zone)
+}
+
+func runDnssecCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	recordType := check.RecordType
+	if recordType == "" {
+		recordType = "A"
+	}
+	rrtype, ok := dnsTypes[strings.ToUpper(recordType)]
+	if !ok {
+		checkResult.detail = fmt.Sprintf("unsupported record type %s", recordType)
+		c <- checkResult
+		return
+	}
+
+	err := validateDnssec(ctx, dnsResolver(check), check.Dest, rrtype, &checkResult.bytes)
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+	} else {
+		checkResult.status = true
+	}
+	c <- checkResult
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Outcomes of a doctor finding
+const (
+	doctorOk   = "ok"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+// doctorFinding is the outcome of verifying one aspect of the environment,
+// with a suggestion how to fix it unless it's ok
+type doctorFinding struct {
+	name    string
+	outcome string
+	message string
+	fix     string
+}
+
+// doctorICMP finds the ICMP modes the checker can ping with
+func doctorICMP() doctorFinding {
+	finding := doctorFinding{name: "ICMP"}
+	var available []string
+	if icmpAPISupported {
+		available = append(available, icmpAPI)
+	}
+	for _, mode := range []string{icmpRaw, icmpDgram} {
+		if conn, err := listenICMP(mode, false); err == nil {
+			conn.Close()
+			available = append(available, mode)
+		}
+	}
+	if _, err := exec.LookPath("ping"); err == nil {
+		available = append(available, icmpExec)
+	}
+	switch {
+	case len(available) > 0 && available[0] == icmpAPI:
+		finding.outcome, finding.message = doctorOk, "IP Helper API available, modes: "+strings.Join(available, ", ")
+	case len(available) > 0 && available[0] == icmpRaw:
+		finding.outcome, finding.message = doctorOk, "raw sockets available, modes: "+strings.Join(available, ", ")
+	case len(available) > 0 && available[0] == icmpDgram:
+		finding.outcome, finding.message = doctorOk, "unprivileged ICMP sockets available, modes: "+strings.Join(available, ", ")
+	default:
+		finding.outcome = doctorWarn
+		finding.message = "no ICMP sockets, icmp checks fall back to the udp mode, which hosts may filter"
+		if len(available) > 0 {
+			finding.message += ", or exec"
+		}
+		switch runtime.GOOS {
+		case "linux":
+			finding.fix = "run as root, grant the binary CAP_NET_RAW (sudo setcap cap_net_raw+ep network-checks) " +
+				"or allow unprivileged pings (sudo sysctl -w net.ipv4.ping_group_range=\"0 2147483647\")"
+		default:
+			finding.fix = "run as root or administrator, or set icmp_mode: exec to use the system ping command"
+		}
+	}
+	return finding
+}
+
+// doctorIPv6 finds whether IPv6 destinations are reachable: a global
+// address and a route to the internet
+func doctorIPv6() doctorFinding {
+	finding := doctorFinding{name: "IPv6"}
+	conn, err := net.Dial("udp6", "[2606:4700:4700::1111]:53")
+	if err != nil {
+		finding.outcome = doctorWarn
+		finding.message = "no route to IPv6 destinations: " + err.Error()
+		finding.fix = "checks of IPv6-only destinations will fail; prefer IPv4 addresses or enable IPv6 on the network"
+		return finding
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	if !local.IsGlobalUnicast() || local.IsPrivate() {
+		finding.outcome = doctorWarn
+		finding.message = "no global IPv6 address, only " + local.String()
+		finding.fix = "checks of IPv6-only destinations will fail; prefer IPv4 addresses or enable IPv6 on the network"
+		return finding
+	}
+	finding.outcome, finding.message = doctorOk, "available, source address "+local.String()
+	return finding
+}
+
+// doctorResolver verifies the resolver configuration and that it resolves
+// the name
+func doctorResolver(name string) doctorFinding {
+	finding := doctorFinding{name: "Resolver"}
+	var servers []string
+	if file, err := os.Open("/etc/resolv.conf"); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, fields[1])
+			}
+		}
+		file.Close()
+		if len(servers) == 0 {
+			finding.outcome = doctorFail
+			finding.message = "no nameserver in /etc/resolv.conf"
+			finding.fix = "add a nameserver line to /etc/resolv.conf or fix the network manager generating it"
+			return finding
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	configured := "system resolver"
+	if len(servers) > 0 {
+		configured = "nameservers " + strings.Join(servers, ", ")
+	}
+	if err != nil {
+		finding.outcome = doctorFail
+		finding.message = fmt.Sprintf("resolving %s with the %s failed: %v", name, configured, err)
+		finding.fix = "check the nameservers are reachable (a firewall may block port 53); checks of names will fail with dns_error"
+		return finding
+	}
+	finding.outcome = doctorOk
+	finding.message = fmt.Sprintf("%s resolves to %s in %v with the %s", name, addrs[0], time.Since(start).Round(time.Millisecond), configured)
+	return finding
+}
+
+// doctorReach verifies that a known-good target accepts connections
+func doctorReach(target string) doctorFinding {
+	finding := doctorFinding{name: "Connectivity"}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", target, 5*time.Second)
+	if err != nil {
+		finding.outcome = doctorFail
+		finding.message = fmt.Sprintf("connecting to %s failed: %v", target, err)
+		finding.fix = "check the default route and firewall; behind a proxy only checks of local destinations work"
+		return finding
+	}
+	conn.Close()
+	finding.outcome = doctorOk
+	finding.message = fmt.Sprintf("connected to %s in %v", target, time.Since(start).Round(time.Millisecond))
+	return finding
+}
+
+// doctorTerminal verifies that the terminal UI can be shown
+func doctorTerminal() doctorFinding {
+	finding := doctorFinding{name: "Terminal"}
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		finding.outcome = doctorWarn
+		finding.message = "output is not a terminal, the table is redrawn as plain text"
+		finding.fix = "use -daemon to log instead of drawing when running as a service or piping the output"
+		return finding
+	}
+	columns, rows := terminalSize()
+	finding.outcome = doctorOk
+	finding.message = fmt.Sprintf("%dx%d", columns, rows)
+	switch {
+	case color.NoColor:
+		finding.outcome = doctorWarn
+		finding.message += ", without colors"
+		finding.fix = "unset NO_COLOR and set TERM (e.g. TERM=xterm-256color), statuses are told apart by color"
+	case columns < 100:
+		finding.outcome = doctorWarn
+		finding.message += ", narrower than the table"
+		finding.fix = "widen the terminal to at least 100 columns"
+	default:
+		finding.message += " with colors (TERM " + os.Getenv("TERM") + ")"
+	}
+	restore, err := enableKeyboardInput()
+	if err == nil {
+		restore()
+	} else if finding.outcome == doctorOk {
+		finding.outcome = doctorWarn
+		finding.message += ", no keyboard input: " + err.Error()
+		finding.fix = "run in an interactive terminal to select checks and open charts"
+	}
+	return finding
+}
+
+// doctorConfig verifies that the configuration loads
+func doctorConfig(path string, profile string) doctorFinding {
+	finding := doctorFinding{name: "Configuration"}
+	checks, err := loadConfig(path, "local", profile)
+	if err != nil {
+		finding.outcome = doctorFail
+		finding.message = fmt.Sprintf("%s: %v", path, err)
+		finding.fix = "fix the error, `network-checks schema` describes the options and `network-checks init` writes a new configuration"
+		return finding
+	}
+	if len(checks.Checks) == 0 {
+		finding.outcome = doctorWarn
+		finding.message = path + " has no checks"
+		finding.fix = "add checks, or run `network-checks discover` to suggest some for the local network"
+		return finding
+	}
+	finding.outcome = doctorOk
+	finding.message = fmt.Sprintf("%s has %d checks", path, len(checks.Checks))
+	return finding
+}
+
+// runDoctor implements the doctor subcommand verifying the environment the
+// checks run in, with suggestions how to fix what's wrong. It exits with 1
+// when something fails.
+func runDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := flags.String("config", "checks.yml", "configuration to validate")
+	profile := flags.String("profile", profileAuto, "profile of the configuration to use")
+	target := flags.String("target", "1.1.1.1:443", "known-good host:port to connect to")
+	name := flags.String("name", "example.com", "name to resolve")
+	flags.Parse(args)
+
+	findings := []doctorFinding{
+		doctorConfig(*configPath, *profile),
+		doctorICMP(),
+		doctorResolver(*name),
+		doctorReach(*target),
+		doctorIPv6(),
+		doctorTerminal(),
+	}
+	colors := map[string]*color.Color{
+		doctorOk:   color.New(color.FgGreen),
+		doctorWarn: color.New(color.FgYellow),
+		doctorFail: color.New(color.FgRed, color.Bold),
+	}
+	exitCode := 0
+	for _, finding := range findings {
+		colors[finding.outcome].Printf("%-4s ", strings.ToUpper(finding.outcome))
+		fmt.Printf("%-14s %s\n", finding.name, finding.message)
+		if finding.fix != "" {
+			fmt.Printf("%19s fix: %s\n", "", finding.fix)
+		}
+		if finding.outcome == doctorFail {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
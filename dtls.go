@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Minimal DTLS 1.2 client (RFC 6347) with the pre-shared key cipher suite
+// mandatory for CoAP, TLS_PSK_WITH_AES_128_CCM_8 (RFC 6655)
+
+const (
+	dtlsChangeCipherSpec = 20
+	dtlsAlert            = 21
+	dtlsHandshake        = 22
+	dtlsApplicationData  = 23
+
+	dtlsClientHello        = 1
+	dtlsServerHello        = 2
+	dtlsHelloVerifyRequest = 3
+	dtlsServerKeyExchange  = 12
+	dtlsServerHelloDone    = 14
+	dtlsClientKeyExchange  = 16
+	dtlsFinished           = 20
+
+	dtlsPskAes128Ccm8 = 0xc0a8
+	dtlsVersion       = 0xfefd
+	ccmTagSize        = 8
+)
+
+var errHelloVerify = fmt.Errorf("hello verify request")
+
+// tlsPRF is the TLS 1.2 pseudorandom function with SHA-256
+func tlsPRF(secret []byte, label string, seed []byte, length int) []byte {
+	seed = append([]byte(label), seed...)
+	var out []byte
+	a := seed
+	for len(out) < length {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(a)
+		a = mac.Sum(nil)
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = append(out, mac.Sum(nil)...)
+	}
+	return out[:length]
+}
+
+// ccm implements AES-CCM (RFC 3610) with 8 byte tags and 12 byte nonces
+type ccm struct {
+	block cipher.Block
+}
+
+func (c ccm) counter(nonce []byte, i int) []byte {
+	a := make([]byte, 16)
+	a[0] = 2 // L - 1 with L = 3
+	copy(a[1:], nonce)
+	a[13], a[14], a[15] = byte(i>>16), byte(i>>8), byte(i)
+	return a
+}
+
+func (c ccm) mac(nonce, plaintext, additional []byte) []byte {
+	b := make([]byte, 16)
+	b[0] = 0x40 | (ccmTagSize-2)/2<<3 | 2
+	copy(b[1:], nonce)
+	b[13], b[14], b[15] = byte(len(plaintext)>>16), byte(len(plaintext)>>8), byte(len(plaintext))
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(additional)))
+	data = append(data, additional...)
+	for len(data)%16 != 0 {
+		data = append(data, 0)
+	}
+	data = append(data, plaintext...)
+	for len(data)%16 != 0 {
+		data = append(data, 0)
+	}
+	x := make([]byte, 16)
+	c.block.Encrypt(x, b)
+	for i := 0; i < len(data); i += 16 {
+		subtle.XORBytes(x, x, data[i:i+16])
+		c.block.Encrypt(x, x)
+	}
+	return x[:ccmTagSize]
+}
+
+func (c ccm) crypt(nonce, in []byte) []byte {
+	out := make([]byte, len(in))
+	stream := make([]byte, 16)
+	for i := 0; i < len(in); i += 16 {
+		c.block.Encrypt(stream, c.counter(nonce, i/16+1))
+		subtle.XORBytes(out[i:], in[i:], stream)
+	}
+	return out
+}
+
+func (c ccm) tag(nonce, mac []byte) []byte {
+	s0 := make([]byte, 16)
+	c.block.Encrypt(s0, c.counter(nonce, 0))
+	tag := make([]byte, ccmTagSize)
+	subtle.XORBytes(tag, mac, s0)
+	return tag
+}
+
+func (c ccm) seal(nonce, plaintext, additional []byte) []byte {
+	return append(c.crypt(nonce, plaintext), c.tag(nonce, c.mac(nonce, plaintext, additional))...)
+}
+
+func (c ccm) open(nonce, ciphertext, additional []byte) ([]byte, error) {
+	if len(ciphertext) < ccmTagSize {
+		return nil, fmt.Errorf("DTLS record too short")
+	}
+	split := len(ciphertext) - ccmTagSize
+	plaintext := c.crypt(nonce, ciphertext[:split])
+	if !hmac.Equal(c.tag(nonce, c.mac(nonce, plaintext, additional)), ciphertext[split:]) {
+		return nil, fmt.Errorf("DTLS record authentication failed")
+	}
+	return plaintext, nil
+}
+
+type dtlsRecord struct {
+	contentType byte
+	epoch       uint16
+	seq         uint64
+	payload     []byte
+}
+
+// dtlsConn is a DTLS session over a connected UDP socket
+type dtlsConn struct {
+	conn        net.Conn
+	epoch       uint16
+	seq         uint64
+	messageSeq  uint16
+	writeCipher ccm
+	writeIV     []byte
+	readCipher  ccm
+	readIV      []byte
+	transcript  bytes.Buffer
+	pending     []dtlsRecord
+}
+
+func (d *dtlsConn) record(contentType byte, payload []byte) []byte {
+	header := []byte{contentType, dtlsVersion >> 8, dtlsVersion & 0xff}
+	header = binary.BigEndian.AppendUint16(header, d.epoch)
+	header = append(header, byte(d.seq>>40), byte(d.seq>>32), byte(d.seq>>24), byte(d.seq>>16), byte(d.seq>>8), byte(d.seq))
+	if d.epoch > 0 {
+		explicit := header[3:11]
+		nonce := append(append([]byte{}, d.writeIV...), explicit...)
+		additional := binary.BigEndian.AppendUint16(append(append([]byte{}, explicit...), header[:3]...), uint16(len(payload)))
+		payload = append(append([]byte{}, explicit...), d.writeCipher.seal(nonce, payload, additional)...)
+	}
+	d.seq++
+	return append(binary.BigEndian.AppendUint16(header, uint16(len(payload))), payload...)
+}
+
+// handshakeMessage frames a handshake message as a single fragment and adds
+// it to the transcript
+func (d *dtlsConn) handshakeMessage(msgType byte, body []byte, transcript bool) []byte {
+	length := []byte{byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	message := append([]byte{msgType}, length...)
+	message = binary.BigEndian.AppendUint16(message, d.messageSeq)
+	message = append(message, 0, 0, 0)
+	message = append(message, length...)
+	message = append(message, body...)
+	d.messageSeq++
+	if transcript {
+		d.transcript.Write(message)
+	}
+	return message
+}
+
+// receive returns the next record, decrypting records of epoch 1
+func (d *dtlsConn) receive() (dtlsRecord, error) {
+	for len(d.pending) == 0 {
+		buf := make([]byte, 65535)
+		n, err := d.conn.Read(buf)
+		if err != nil {
+			return dtlsRecord{}, err
+		}
+		for b := buf[:n]; len(b) >= 13; {
+			length := int(binary.BigEndian.Uint16(b[11:]))
+			if len(b) < 13+length {
+				break
+			}
+			epoch := binary.BigEndian.Uint16(b[3:])
+			record := dtlsRecord{
+				contentType: b[0],
+				epoch:       epoch,
+				seq:         binary.BigEndian.Uint64(append([]byte{0, 0}, b[5:11]...)),
+				payload:     b[13 : 13+length],
+			}
+			if epoch > 0 {
+				if len(record.payload) < 8 {
+					return dtlsRecord{}, fmt.Errorf("DTLS record too short")
+				}
+				explicit := record.payload[:8]
+				nonce := append(append([]byte{}, d.readIV...), explicit...)
+				ciphertext := record.payload[8:]
+				additional := append(append([]byte{}, b[3:11]...), b[:3]...)
+				additional = binary.BigEndian.AppendUint16(additional, uint16(len(ciphertext)-ccmTagSize))
+				plaintext, err := d.readCipher.open(nonce, ciphertext, additional)
+				if err != nil {
+					return dtlsRecord{}, err
+				}
+				record.payload = plaintext
+			}
+			d.pending = append(d.pending, record)
+			b = b[13+length:]
+		}
+	}
+	record := d.pending[0]
+	d.pending = d.pending[1:]
+	if record.contentType == dtlsAlert && len(record.payload) == 2 {
+		return dtlsRecord{}, fmt.Errorf("DTLS alert %d", record.payload[1])
+	}
+	return record, nil
+}
+
+// exchange sends a flight and retransmits it until wait returns
+func (d *dtlsConn) exchange(ctx context.Context, flight []byte, wait func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- wait() }()
+	for interval := time.Second; ; interval *= 2 {
+		if _, err := d.conn.Write(flight); err != nil {
+			return err
+		}
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// readHandshake reads handshake messages up to one of the type
+func (d *dtlsConn) readHandshake(until byte, handle func(msgType byte, body []byte) error) error {
+	for {
+		record, err := d.receive()
+		if err != nil {
+			return err
+		}
+		if record.contentType != dtlsHandshake {
+			continue
+		}
+		for b := record.payload; len(b) >= 12; {
+			length := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+			fragmentLength := int(b[9])<<16 | int(b[10])<<8 | int(b[11])
+			if fragmentLength != length || len(b) < 12+length {
+				return fmt.Errorf("fragmented DTLS handshake messages aren't supported")
+			}
+			message := b[:12+length]
+			b = b[12+length:]
+			if message[0] != dtlsHelloVerifyRequest {
+				d.transcript.Write(message)
+			}
+			if err := handle(message[0], message[12:]); err != nil {
+				return err
+			}
+			if message[0] == until {
+				return nil
+			}
+		}
+	}
+}
+
+// dtlsDial performs the handshake with the pre-shared key
+func dtlsDial(ctx context.Context, conn net.Conn, identity string, psk []byte) (*dtlsConn, error) {
+	d := &dtlsConn{conn: conn}
+	clientRandom := make([]byte, 32)
+	rand.Read(clientRandom)
+	clientHello := func(cookie []byte) []byte {
+		body := []byte{dtlsVersion >> 8, dtlsVersion & 0xff}
+		body = append(body, clientRandom...)
+		body = append(body, 0, byte(len(cookie)))
+		body = append(body, cookie...)
+		body = append(body, 0, 2, dtlsPskAes128Ccm8>>8, dtlsPskAes128Ccm8&0xff, 1, 0)
+		return body
+	}
+
+	// The server may ask to repeat the hello with a cookie, which isn't part
+	// of the transcript
+	var cookie []byte
+	var serverRandom []byte
+	handleServerFlight := func(msgType byte, body []byte) error {
+		switch msgType {
+		case dtlsHelloVerifyRequest:
+			if len(body) < 3 || len(body) < 3+int(body[2]) {
+				return fmt.Errorf("invalid DTLS hello verify request")
+			}
+			cookie = append([]byte{}, body[3:3+int(body[2])]...)
+			return errHelloVerify
+		case dtlsServerHello:
+			if len(body) < 35 {
+				return fmt.Errorf("invalid DTLS server hello")
+			}
+			serverRandom = append([]byte{}, body[2:34]...)
+			sessionLength := int(body[34])
+			if len(body) < 37+sessionLength || binary.BigEndian.Uint16(body[35+sessionLength:]) != dtlsPskAes128Ccm8 {
+				return fmt.Errorf("server didn't select TLS_PSK_WITH_AES_128_CCM_8")
+			}
+		}
+		return nil
+	}
+	first := d.handshakeMessage(dtlsClientHello, clientHello(nil), false)
+	err := d.exchange(ctx, d.record(dtlsHandshake, first), func() error {
+		return d.readHandshake(dtlsServerHelloDone, handleServerFlight)
+	})
+	if err == errHelloVerify {
+		d.transcript.Reset()
+		second := d.handshakeMessage(dtlsClientHello, clientHello(cookie), true)
+		err = d.exchange(ctx, d.record(dtlsHandshake, second), func() error {
+			return d.readHandshake(dtlsServerHelloDone, handleServerFlight)
+		})
+	} else if err == nil {
+		// Without a cookie exchange the first hello starts the transcript
+		transcript := append(append([]byte{}, first...), d.transcript.Bytes()...)
+		d.transcript.Reset()
+		d.transcript.Write(transcript)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if serverRandom == nil {
+		return nil, fmt.Errorf("no DTLS server hello")
+	}
+
+	preMaster := binary.BigEndian.AppendUint16(nil, uint16(len(psk)))
+	preMaster = append(preMaster, make([]byte, len(psk))...)
+	preMaster = binary.BigEndian.AppendUint16(preMaster, uint16(len(psk)))
+	preMaster = append(preMaster, psk...)
+	master := tlsPRF(preMaster, "master secret", append(append([]byte{}, clientRandom...), serverRandom...), 48)
+	keys := tlsPRF(master, "key expansion", append(append([]byte{}, serverRandom...), clientRandom...), 40)
+	clientBlock, _ := aes.NewCipher(keys[0:16])
+	serverBlock, _ := aes.NewCipher(keys[16:32])
+
+	keyExchange := binary.BigEndian.AppendUint16(nil, uint16(len(identity)))
+	keyExchange = append(keyExchange, identity...)
+	flight := d.record(dtlsHandshake, d.handshakeMessage(dtlsClientKeyExchange, keyExchange, true))
+	flight = append(flight, d.record(dtlsChangeCipherSpec, []byte{1})...)
+	d.epoch, d.seq = 1, 0
+	d.writeCipher, d.writeIV = ccm{clientBlock}, keys[32:36]
+	d.readCipher, d.readIV = ccm{serverBlock}, keys[36:40]
+	hash := sha256.Sum256(d.transcript.Bytes())
+	flight = append(flight, d.record(dtlsHandshake, d.handshakeMessage(dtlsFinished, tlsPRF(master, "client finished", hash[:], 12), true))...)
+	expected := sha256.Sum256(d.transcript.Bytes())
+	serverFinished := tlsPRF(master, "server finished", expected[:], 12)
+
+	err = d.exchange(ctx, flight, func() error {
+		return d.readHandshake(dtlsFinished, func(msgType byte, body []byte) error {
+			if msgType == dtlsFinished && !hmac.Equal(body, serverFinished) {
+				return fmt.Errorf("DTLS server finished doesn't verify, wrong pre-shared key?")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dtlsConn) Write(b []byte) (int, error) {
+	_, err := d.conn.Write(d.record(dtlsApplicationData, b))
+	return len(b), err
+}
+
+func (d *dtlsConn) Read(b []byte) (int, error) {
+	for {
+		record, err := d.receive()
+		if err != nil {
+			return 0, err
+		}
+		if record.contentType == dtlsApplicationData && record.epoch == 1 {
+			return copy(b, record.payload), nil
+		}
+	}
+}
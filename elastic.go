@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type clusterHealth struct {
+	ClusterName         string `json:"cluster_name"`
+	Status              string `json:"status"`
+	Nodes               int    `json:"number_of_nodes"`
+	ActiveShards        int    `json:"active_shards"`
+	RelocatingShards    int    `json:"relocating_shards"`
+	InitializingShards  int    `json:"initializing_shards"`
+	UnassignedShards    int    `json:"unassigned_shards"`
+	ActivePrimaryShards int    `json:"active_primary_shards"`
+}
+
+func (h clusterHealth) shards() string {
+	return fmt.Sprintf("%s on %d nodes: %d active, %d relocating, %d initializing, %d unassigned shards",
+		h.Status, h.Nodes, h.ActiveShards, h.RelocatingShards, h.InitializingShards, h.UnassignedShards)
+}
+
+// runElasticsearchCheck reads the cluster health of Elasticsearch or
+// OpenSearch (http(s)://[user:password@]host:port). Green is OK, yellow is
+// degraded and red fails.
+func runElasticsearchCheck(ctx context.Context, check Check, c chan CheckResult) {
+	ctx, transferred := withTrafficTrace(ctx)
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+
+	var health clusterHealth
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(check.Dest, "/")+"/_cluster/health", nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = httpClient.Do(req); err == nil {
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("cluster health returned %s", resp.Status)
+			} else if err = json.NewDecoder(resp.Body).Decode(&health); err != nil {
+				err = fmt.Errorf("error decoding cluster health: %v", err)
+			}
+			resp.Body.Close()
+		}
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	checkResult.bytes = transferred()
+
+	switch {
+	case err != nil:
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+	case health.Status == "green":
+		checkResult.status = true
+	case health.Status == "yellow":
+		checkResult.status = true
+		checkResult.degraded = true
+		checkResult.detail = health.shards()
+	case health.Status == "red":
+		checkResult.detail = health.shards()
+		checkResult.cause = causeUnexpected
+	default:
+		checkResult.detail = fmt.Sprintf("unknown cluster status %q", health.Status)
+		checkResult.cause = causeParse
+	}
+	c <- checkResult
+}
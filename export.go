@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// runExport implements the export subcommand converting checks into the
+// configuration of other monitoring tools. It returns the process exit code.
+func runExport(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: network-checks export blackbox [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "blackbox":
+		return runExportBlackbox(args[1:])
+	default:
+		fmt.Printf("Unknown export target %q\n", args[0])
+		return 2
+	}
+}
+
+func runExportBlackbox(args []string) int {
+	flags := flag.NewFlagSet("export blackbox", flag.ExitOnError)
+	configPath := flags.String("config", "checks.yml", "path to the checks configuration")
+	modulesPath := flags.String("blackbox", "blackbox.yml", "blackbox_exporter configuration to write the modules to")
+	targetsPath := flags.String("targets", "prometheus.yml", "Prometheus configuration to write the /probe scrape jobs to")
+	exporter := flags.String("exporter", "127.0.0.1:9115", "address Prometheus reaches the blackbox_exporter at")
+	force := flags.Bool("force", false, "overwrite existing files")
+	flags.Parse(args)
+
+	checks, err := loadChecksFromYaml(*configPath)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return 1
+	}
+	blackbox, prometheus, skipped := exportBlackbox(checks.Checks, *exporter)
+	for _, reason := range skipped {
+		fmt.Fprintln(os.Stderr, "Skipped", reason)
+	}
+	if !*force {
+		for _, path := range []string{*modulesPath, *targetsPath} {
+			if _, err := os.Stat(path); err == nil {
+				fmt.Printf("%s exists, use -force to overwrite it\n", path)
+				return 1
+			}
+		}
+	}
+	if err := writeYaml(*modulesPath, blackbox); err != nil {
+		fmt.Println("Error writing blackbox configuration:", err)
+		return 1
+	}
+	if err := writeYaml(*targetsPath, prometheus); err != nil {
+		fmt.Println("Error writing Prometheus configuration:", err)
+		return 1
+	}
+	return 0
+}
+
+func writeYaml(path string, in interface{}) error {
+	data, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// blackboxModuleName names the module of a prober and timeout after the
+// modules of the example blackbox_exporter configuration, e.g. http_2xx or
+// icmp_1500ms
+func blackboxModuleName(prober string, timeout time.Duration) string {
+	name := prober
+	if prober == "http" {
+		name = "http_2xx"
+	}
+	if timeout > 0 {
+		name += "_" + promDuration(timeout).String()
+	}
+	return name
+}
+
+// unexportedExpectations returns the settings of an http check the modules
+// of the blackbox_exporter have no equivalent of
+func unexportedExpectations(check Check) []string {
+	var settings []string
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{"min_size", check.MinSize > 0},
+		{"min_throughput", check.MinThroughput > 0},
+		{"expect_sha256", check.ExpectSHA256 != ""},
+		{"pin_spki", len(check.PinSPKI) > 0},
+		{"pin_serial", len(check.PinSerial) > 0},
+		{"ocsp", check.OCSP},
+	} {
+		if setting.set {
+			settings = append(settings, setting.name)
+		}
+	}
+	return settings
+}
+
+// exportBlackbox creates a blackbox_exporter module for every prober and
+// timeout the http and icmp checks use, and a Prometheus job probing the
+// checks of the same tag (their type without one), module and interval. The
+// name of a check is kept in the check label of its target, so importing
+// the result gives back the checks. It returns the reasons for checks that
+// couldn't be exported.
+func exportBlackbox(checks []Check, exporter string) (blackboxConfig, prometheusConfig, []string) {
+	blackbox := blackboxConfig{Modules: make(map[string]blackboxModule)}
+	var prometheus prometheusConfig
+	var skipped []string
+
+	type jobKey struct {
+		tag    string
+		module string
+		repeat time.Duration
+	}
+	jobs := make(map[jobKey]int)
+	used := make(map[string]bool)
+	for _, check := range checks {
+		switch {
+		case check.CheckType != "http" && check.CheckType != "icmp":
+			skipped = append(skipped, fmt.Sprintf("%s: %s checks have no blackbox prober", check.Name, check.CheckType))
+			continue
+		case check.Via != "":
+			skipped = append(skipped, fmt.Sprintf("%s: checks run via %s can't be probed by the blackbox_exporter", check.Name, check.Via))
+			continue
+		case len(check.Steps) > 0:
+			skipped = append(skipped, fmt.Sprintf("%s: multi-step http checks are not supported", check.Name))
+			continue
+		case len(unexportedExpectations(check)) > 0:
+			skipped = append(skipped, fmt.Sprintf("%s: the blackbox_exporter can't check %s", check.Name, strings.Join(unexportedExpectations(check), ", ")))
+			continue
+		}
+
+		module := blackboxModuleName(check.CheckType, check.Timeout)
+		blackbox.Modules[module] = blackboxModule{Prober: check.CheckType, Timeout: promDuration(check.Timeout)}
+		repeat := check.Repeat
+		if repeat <= 0 {
+			repeat = defaultImportRepeat
+		}
+		key := jobKey{tag: check.CheckType, module: module, repeat: repeat}
+		if len(check.Tags) > 0 {
+			key.tag = check.Tags[0]
+		}
+		i, ok := jobs[key]
+		if !ok {
+			i = len(prometheus.ScrapeConfigs)
+			jobs[key] = i
+			prometheus.ScrapeConfigs = append(prometheus.ScrapeConfigs, prometheusScrapeConfig{
+				JobName:        uniqueName(key.tag, module, used),
+				MetricsPath:    "/probe",
+				ScrapeInterval: promDuration(repeat),
+				Params:         map[string][]string{"module": {module}},
+				RelabelConfigs: []prometheusRelabel{
+					{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+					{SourceLabels: []string{"__param_target"}, TargetLabel: "instance"},
+					{TargetLabel: "__address__", Replacement: exporter},
+				},
+			})
+		}
+		job := &prometheus.ScrapeConfigs[i]
+		job.StaticConfigs = append(job.StaticConfigs, prometheusStaticConfig{
+			Targets: []string{check.Dest},
+			Labels:  map[string]string{"check": check.Name},
+		})
+	}
+	sort.SliceStable(prometheus.ScrapeConfigs, func(a, b int) bool {
+		return prometheus.ScrapeConfigs[a].JobName < prometheus.ScrapeConfigs[b].JobName
+	})
+	return blackbox, prometheus, skipped
+}
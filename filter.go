@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// checkFilter selects checks by a field, either equal to a value (tag=wan)
+// or matching a regular expression (name~camera)
+type checkFilter struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+// checkFilters collects repeated -only or -exclude flags
+type checkFilters []checkFilter
+
+var checkFilterFields = []string{"name", "type", "dest", "group", "tag", "via"}
+
+func (f *checkFilters) String() string {
+	var filters []string
+	for _, filter := range *f {
+		filters = append(filters, filter.field+"~"+filter.pattern.String())
+	}
+	return strings.Join(filters, ",")
+}
+
+func (f *checkFilters) Set(value string) error {
+	i := strings.IndexAny(value, "=~")
+	if i < 0 || !contains(checkFilterFields, value[:i]) {
+		return fmt.Errorf("expected <field>=<value> or <field>~<regexp> with field one of %s", strings.Join(checkFilterFields, ", "))
+	}
+	expression := value[i+1:]
+	if value[i] == '=' {
+		expression = "^" + regexp.QuoteMeta(expression) + "$"
+	}
+	pattern, err := regexp.Compile(expression)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, checkFilter{field: value[:i], pattern: pattern})
+	return nil
+}
+
+func (f checkFilter) matches(check Check) bool {
+	values := map[string][]string{
+		"name":  {check.Name},
+		"type":  {check.CheckType},
+		"dest":  {check.Dest},
+		"group": {check.Group},
+		"tag":   check.Tags,
+		"via":   {check.Via},
+	}[f.field]
+	for _, value := range values {
+		if f.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f checkFilters) matchesAny(check Check) bool {
+	for _, filter := range f {
+		if filter.matches(check) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterChecks keeps the checks matching any of the only filters (all when
+// there are none) and none of the exclude filters
+func filterChecks(checks []Check, only, exclude checkFilters) []Check {
+	var kept []Check
+	for _, check := range checks {
+		if (len(only) == 0 || only.matchesAny(check)) && !exclude.matchesAny(check) {
+			check.id = len(kept)
+			kept = append(kept, check)
+		}
+	}
+	return kept
+}
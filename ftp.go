@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpConn is the control connection of an FTP session
+type ftpConn struct {
+	conn    net.Conn
+	text    *textproto.Conn
+	tls     *tls.Config
+	private bool
+}
+
+func (f *ftpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	if err := f.text.PrintfLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	return f.text.ReadResponse(expect)
+}
+
+// secure upgrades the control connection after AUTH TLS or right away for
+// implicit FTPS
+func (f *ftpConn) secure(ctx context.Context) error {
+	tlsConn := tls.Client(f.conn, f.tls)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	f.conn = tlsConn
+	f.text = textproto.NewConn(tlsConn)
+	return nil
+}
+
+// list reads the names in a directory over a passive data connection
+func (f *ftpConn) list(ctx context.Context, path string) error {
+	_, message, err := f.cmd(229, "EPSV")
+	if err != nil {
+		return err
+	}
+	// 229 Entering Extended Passive Mode (|||port|)
+	start, end := strings.Index(message, "(|||"), strings.LastIndex(message, "|)")
+	if start < 0 || end < start {
+		return fmt.Errorf("invalid EPSV reply %q", message)
+	}
+	host, _, _ := net.SplitHostPort(f.conn.RemoteAddr().String())
+	var dialer net.Dialer
+	data, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, message[start+4:end]))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		data.SetDeadline(deadline)
+	}
+	if err := f.text.PrintfLine("NLST %s", path); err != nil {
+		return err
+	}
+	if _, _, err := f.text.ReadResponse(1); err != nil {
+		return err
+	}
+	var reader io.Reader = data
+	if f.private {
+		tlsData := tls.Client(data, f.tls)
+		if err := tlsData.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		reader = tlsData
+	}
+	if _, err := io.Copy(io.Discard, io.LimitReader(reader, 1<<20)); err != nil {
+		return err
+	}
+	_, _, err = f.text.ReadResponse(2)
+	return err
+}
+
+// ftpLogin connects to an FTP server and logs in, anonymously without user.
+// ftps:// URLs use explicit TLS (AUTH TLS), or implicit TLS on port 990.
+func ftpLogin(ctx context.Context, u *url.URL, user, password string) (*ftpConn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	f := &ftpConn{
+		conn: conn,
+		text: textproto.NewConn(conn),
+		tls:  &tls.Config{ServerName: u.Hostname(), ClientSessionCache: tls.NewLRUClientSessionCache(1)},
+	}
+	implicit := u.Scheme == "ftps" && u.Port() == "990"
+	if implicit {
+		err = f.secure(ctx)
+	}
+	if err == nil {
+		_, _, err = f.text.ReadResponse(220)
+	}
+	if err == nil && u.Scheme == "ftps" && !implicit {
+		if _, _, err = f.cmd(234, "AUTH TLS"); err == nil {
+			err = f.secure(ctx)
+		}
+	}
+	if err == nil && u.Scheme == "ftps" {
+		if _, _, err = f.cmd(200, "PBSZ 0"); err == nil {
+			_, _, err = f.cmd(200, "PROT P")
+			f.private = err == nil
+		}
+	}
+	if user == "" {
+		user, password = "anonymous", "network-checks@"
+	}
+	if err == nil {
+		var code int
+		code, _, err = f.cmd(0, "USER %s", user)
+		if err == nil && code == 331 {
+			code, _, err = f.cmd(0, "PASS %s", password)
+		}
+		if err == nil && code != 230 {
+			err = fmt.Errorf("login failed with %d", code)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// runFtpCheck logs in to an FTP, FTPS or SFTP server. A path ending with /
+// in the URL is listed, any other path must be an existing file. The
+// latency covers connecting and the login.
+func runFtpCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	u, err := url.Parse(check.Dest)
+	if err != nil || (u.Scheme != "ftp" && u.Scheme != "ftps" && u.Scheme != "sftp") {
+		checkResult.detail = fmt.Sprintf("invalid FTP URL %s", check.Dest)
+		c <- checkResult
+		return
+	}
+	if u.Scheme == "sftp" {
+		runSftpCheck(ctx, check, u, c)
+		return
+	}
+
+	f, err := ftpLogin(ctx, u, check.Username, check.Password)
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+	defer f.conn.Close()
+
+	path := u.Path
+	switch {
+	case path == "" || path == "/":
+	case strings.HasSuffix(path, "/"):
+		err = f.list(ctx, path)
+	default:
+		// 213 with the size of a file, an error for directories
+		if _, _, err = f.cmd(213, "SIZE %s", path); err != nil {
+			err = fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	f.cmd(0, "QUIT")
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+	} else {
+		checkResult.status = true
+	}
+	c <- checkResult
+}
+
+// runSftpCheck runs the system sftp client in batch mode, authenticating
+// with the user's ssh agent/keys like probes run via ssh
+func runSftpCheck(ctx context.Context, check Check, u *url.URL, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	args := []string{"-b", "-", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5"}
+	if u.Port() != "" {
+		args = append(args, "-P", u.Port())
+	}
+	target := u.Hostname()
+	if user := check.Username; user != "" {
+		target = user + "@" + target
+	} else if u.User != nil {
+		target = u.User.Username() + "@" + target
+	}
+	// pwd only proves the login, ls also works for a file
+	command := "pwd"
+	if u.Path != "" && u.Path != "/" {
+		command = "ls " + strconv.Quote(u.Path)
+	}
+	cmd := exec.CommandContext(ctx, "sftp", append(args, target)...)
+	cmd.Stdin = strings.NewReader(command + "\n")
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		checkResult.detail = fmt.Sprintf("sftp: %s", lines[len(lines)-1])
+	} else {
+		checkResult.status = true
+	}
+	c <- checkResult
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type GeoIPConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Url     string `yaml:"url"`
+}
+
+type GeoInfo struct {
+	Ip          string
+	Country     string
+	CountryCode string
+	Asn         string
+	Isp         string
+}
+
+const defaultGeoIPUrl = "http://ip-api.com/json/"
+
+// destHost extracts the host part of a check destination, which is either
+// a plain hostname/IP (icmp) or a URL (http).
+func destHost(dest string) string {
+	if u, err := url.Parse(dest); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return dest
+}
+
+func lookupGeoInfo(client *http.Client, baseUrl string, dest string) (*GeoInfo, error) {
+	host := destHost(dest)
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	ip := ips[0].String()
+
+	resp, err := client.Get(baseUrl + url.PathEscape(ip) + "?fields=status,message,country,countryCode,isp,as")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status      string `json:"status"`
+		Message     string `json:"message"`
+		Country     string `json:"country"`
+		CountryCode string `json:"countryCode"`
+		Isp         string `json:"isp"`
+		As          string `json:"as"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding geoip response: %v", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("geoip lookup failed: %s", body.Message)
+	}
+
+	// ip-api returns "AS15169 Google LLC", keep just the number
+	asn := body.As
+	if fields := strings.Fields(body.As); len(fields) > 0 {
+		asn = fields[0]
+	}
+
+	return &GeoInfo{
+		Ip:          ip,
+		Country:     body.Country,
+		CountryCode: body.CountryCode,
+		Asn:         asn,
+		Isp:         body.Isp,
+	}, nil
+}
+
+// enrichGeoInfo looks up geo information for all checks in parallel. Failed
+// lookups are reported and leave the check without geo information.
+func enrichGeoInfo(config GeoIPConfig, checks []Check) {
+	baseUrl := config.Url
+	if baseUrl == "" {
+		baseUrl = defaultGeoIPUrl
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(check *Check) {
+			defer wg.Done()
+			geo, err := lookupGeoInfo(client, baseUrl, check.Dest)
+			if err != nil {
+				logMessage(logWarning, fmt.Sprintf("GeoIP lookup for %s failed: %v", check.Name, err))
+				return
+			}
+			check.geo = geo
+		}(&checks[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal gRPC server for the service in network_checks.proto, speaking
+// the protobuf wire format directly. It's served over the HTTP/2 of the
+// TLS API listener.
+
+const grpcServicePath = "/networkchecks.v1.NetworkChecks/"
+
+// grpcMaxRequestSize limits a request message, like the 4MB default of gRPC
+const grpcMaxRequestSize = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is an error with a gRPC status code
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e grpcError) Error() string {
+	return e.message
+}
+
+// Protobuf wire types
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+func pbTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func pbString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return pbMessage(b, field, []byte(s))
+}
+
+func pbInt(b []byte, field int, n int64) []byte {
+	if n == 0 {
+		return b
+	}
+	return binary.AppendUvarint(pbTag(b, field, pbVarint), uint64(n))
+}
+
+func pbBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return pbInt(b, field, 1)
+}
+
+func pbMessage(b []byte, field int, message []byte) []byte {
+	b = binary.AppendUvarint(pbTag(b, field, pbBytes), uint64(len(message)))
+	return append(b, message...)
+}
+
+// pbStringMap encodes a map as repeated entries with the key as field 1 and
+// the value as field 2, in key order
+func pbStringMap(b []byte, field int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b = pbMessage(b, field, pbString(pbString(nil, 1, key), 2, m[key]))
+	}
+	return b
+}
+
+// pbField is a decoded field of a message, with either value set
+type pbField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// pbDecode splits a message into its fields. Fixed size fields aren't used
+// by the service and are rejected.
+func pbDecode(b []byte) ([]pbField, error) {
+	var fields []pbField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field tag")
+		}
+		b = b[n:]
+		field := pbField{number: int(tag >> 3)}
+		switch tag & 7 {
+		case pbVarint:
+			field.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint of field %d", field.number)
+			}
+			b = b[n:]
+		case pbBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, fmt.Errorf("invalid length of field %d", field.number)
+			}
+			field.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d of field %d", tag&7, field.number)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// grpcRequest holds the fields of a request message by number
+type grpcRequest map[int][]pbField
+
+func (r grpcRequest) string(number int) string {
+	fields := r[number]
+	if len(fields) == 0 {
+		return ""
+	}
+	return string(fields[len(fields)-1].bytes)
+}
+
+func (r grpcRequest) strings(number int) []string {
+	var values []string
+	for _, field := range r[number] {
+		values = append(values, string(field.bytes))
+	}
+	return values
+}
+
+func (r grpcRequest) int(number int) int64 {
+	fields := r[number]
+	if len(fields) == 0 {
+		return 0
+	}
+	return int64(fields[len(fields)-1].varint)
+}
+
+// pbResult encodes a result as the Result message
+func pbResult(checkResult CheckResult) []byte {
+	check := checkResult.check
+	b := pbString(nil, 1, check.site)
+	b = pbString(b, 2, check.ID)
+	b = pbString(b, 3, check.Name)
+	b = pbString(b, 4, check.CheckType)
+	b = pbString(b, 5, check.Dest)
+	b = pbBool(b, 6, checkResult.status)
+	b = pbInt(b, 7, checkResult.runAt.UnixNano())
+	b = pbInt(b, 8, int64(checkResult.duration))
+	b = pbStringMap(b, 9, check.exportedLabels())
+	b = pbString(b, 10, checkResult.detail)
+	b = pbBool(b, 11, checkResult.degraded)
+	b = pbString(b, 15, checkResult.state())
+	return pbInt(b, 16, int64(check.DegradedAbove))
+}
+
+// pbRecordedResult encodes a result of the recording as the Result message
+func pbRecordedResult(result AgentResult) []byte {
+	b := pbResult(result.checkResult(0))
+	b = pbInt(b, 12, int64(result.Samples))
+	b = pbInt(b, 13, int64(result.Failures))
+	return pbInt(b, 14, int64(result.Period))
+}
+
+// resultStream fans the recorded results out to the streaming clients
+type resultStream struct {
+	mu          sync.Mutex
+	subscribers map[chan CheckResult]bool
+}
+
+// publish is a consumer offering every result to the subscribers. A client
+// too slow to keep up misses results rather than holding up the others.
+func (s *resultStream) publish(checkResult CheckResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- checkResult:
+		default:
+		}
+	}
+}
+
+func (s *resultStream) subscribe() chan CheckResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan CheckResult]bool)
+	}
+	subscriber := make(chan CheckResult, 100)
+	s.subscribers[subscriber] = true
+	return subscriber
+}
+
+func (s *resultStream) unsubscribe(subscriber chan CheckResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, subscriber)
+}
+
+// grpcServer implements the methods of the service
+type grpcServer struct {
+	token     string
+	monitor   *Monitor
+	reload    func() error
+	recording string
+	results   *resultStream
+}
+
+// grpcSend writes a length-prefixed, uncompressed message and flushes it
+func grpcSend(w http.ResponseWriter, message []byte) error {
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (s *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requires HTTP/2, i.e. the API listener with TLS", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	err := s.call(w, r)
+	code, message := grpcOK, ""
+	if err != nil {
+		code, message = grpcInternal, err.Error()
+		if grpcErr, ok := err.(grpcError); ok {
+			code = grpcErr.code
+		}
+	}
+	w.Header().Set("Grpc-Status", fmt.Sprint(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", message)
+	}
+}
+
+// call reads the request message and runs the method
+func (s *grpcServer) call(w http.ResponseWriter, r *http.Request) error {
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		return grpcError{grpcUnauthenticated, "invalid token"}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, grpcMaxRequestSize+5))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return grpcError{grpcResourceExhausted, fmt.Sprintf("request message is larger than %s", formatBytes(grpcMaxRequestSize))}
+	} else if err != nil {
+		return err
+	}
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:])) != len(body)-5 {
+		return grpcError{grpcInvalidArgument, "expected one uncompressed request message"}
+	}
+	fields, err := pbDecode(body[5:])
+	if err != nil {
+		return grpcError{grpcInvalidArgument, err.Error()}
+	}
+	request := make(grpcRequest)
+	for _, field := range fields {
+		request[field.number] = append(request[field.number], field)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	switch strings.TrimPrefix(r.URL.Path, grpcServicePath) {
+	case "StreamResults":
+		return s.streamResults(w, r, request)
+	case "ListChecks":
+		return grpcSend(w, s.listChecks())
+	case "PauseCheck", "ResumeCheck":
+		if err := s.monitor.setPaused(request.string(1), strings.HasPrefix(r.URL.Path, grpcServicePath+"Pause")); err != nil {
+			return grpcError{grpcNotFound, err.Error()}
+		}
+		return grpcSend(w, nil)
+	case "AcknowledgeCheck":
+		if err := s.monitor.acknowledge(request.string(1), time.Duration(request.int(2)), "grpc", request.string(3)); err != nil {
+			return grpcError{grpcFailedPrecondition, err.Error()}
+		}
+		return grpcSend(w, nil)
+	case "AddNote":
+		if err := s.monitor.addNote(request.string(1), "grpc", request.string(2)); err != nil {
+			return grpcError{grpcInvalidArgument, err.Error()}
+		}
+		return grpcSend(w, nil)
+	case "UnacknowledgeCheck":
+		if err := s.monitor.unacknowledge(request.string(1)); err != nil {
+			return grpcError{grpcNotFound, err.Error()}
+		}
+		return grpcSend(w, nil)
+	case "AddSilence":
+		silence := Silence{Match: request.strings(2), Comment: request.string(5)}
+		if start := request.int(3); start != 0 {
+			silence.Start = time.Unix(0, start).Format(time.RFC3339Nano)
+		}
+		if end := request.int(4); end != 0 {
+			silence.End = time.Unix(0, end).Format(time.RFC3339Nano)
+		}
+		id, err := s.monitor.addSilence(silence)
+		if err != nil {
+			return grpcError{grpcInvalidArgument, err.Error()}
+		}
+		return grpcSend(w, pbInt(nil, 1, int64(id)))
+	case "DeleteSilence":
+		if err := s.monitor.deleteSilence(int(request.int(1))); err != nil {
+			return grpcError{grpcNotFound, err.Error()}
+		}
+		return grpcSend(w, nil)
+	case "ListSilences":
+		return grpcSend(w, s.listSilences())
+	case "Reload":
+		if err := s.reload(); err != nil {
+			return grpcError{grpcFailedPrecondition, err.Error()}
+		}
+		return grpcSend(w, nil)
+	case "History":
+		return s.history(w, request)
+	}
+	return grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+}
+
+func (s *grpcServer) streamResults(w http.ResponseWriter, r *http.Request, request grpcRequest) error {
+	names := make(map[string]bool)
+	for _, name := range request.strings(1) {
+		names[name] = true
+	}
+	subscriber := s.results.subscribe()
+	defer s.results.unsubscribe(subscriber)
+	for {
+		select {
+		case checkResult := <-subscriber:
+			if len(names) > 0 && !names[checkResult.check.Name] {
+				continue
+			}
+			if err := grpcSend(w, pbResult(checkResult)); err != nil {
+				return err
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *grpcServer) listChecks() []byte {
+	m := s.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var response []byte
+	for i, checkResult := range m.results {
+		check := checkResult.check
+		if i < len(m.checks.Checks) {
+			check = m.checks.Checks[i]
+		}
+		b := pbString(nil, 1, check.site)
+		b = pbString(b, 2, check.ID)
+		b = pbString(b, 3, check.Name)
+		b = pbString(b, 4, check.CheckType)
+		b = pbString(b, 5, check.Dest)
+		b = pbStringMap(b, 6, check.exportedLabels())
+		b = pbBool(b, 7, !check.remote && m.paused[check.Name])
+		if checkResult.execCount > 0 {
+			b = pbMessage(b, 8, pbResult(checkResult))
+		}
+		b = pbString(b, 9, check.Owner)
+		b = pbString(b, 10, check.Contact)
+		b = pbString(b, 11, check.Runbook)
+		b = pbString(b, 13, check.severity())
+		if ack, ok := m.ackOf(checkResult.check); ok {
+			b = pbMessage(b, 12, pbString(pbString(pbInt(nil, 1, ack.until.UnixNano()), 2, ack.by), 3, ack.comment))
+		}
+		if i < len(m.stats) {
+			for _, note := range m.notesOf(checkResult.check, m.stats[i].stateSince) {
+				b = pbMessage(b, 14, pbString(pbString(pbInt(nil, 1, note.At.UnixNano()), 2, note.By), 3, note.Text))
+			}
+		}
+		response = pbMessage(response, 1, b)
+	}
+	return response
+}
+
+func (s *grpcServer) listSilences() []byte {
+	s.monitor.mu.Lock()
+	defer s.monitor.mu.Unlock()
+	var response []byte
+	for _, silence := range s.monitor.currentSilences() {
+		b := pbInt(nil, 1, int64(silence.id))
+		for _, match := range silence.Match {
+			b = pbString(b, 2, match)
+		}
+		if !silence.from.IsZero() {
+			b = pbInt(b, 3, silence.from.UnixNano())
+		}
+		b = pbInt(b, 4, silence.until.UnixNano())
+		b = pbString(b, 5, silence.Comment)
+		b = pbBool(b, 6, silence.active(time.Now()))
+		response = pbMessage(response, 1, b)
+	}
+	return response
+}
+
+func (s *grpcServer) history(w http.ResponseWriter, request grpcRequest) error {
+	if s.recording == "" {
+		return grpcError{grpcFailedPrecondition, "no recording, run with -record"}
+	}
+	name := request.string(1)
+	if name == "" {
+		return grpcError{grpcInvalidArgument, "name is required"}
+	}
+	since, until := time.Unix(0, request.int(2)), time.Unix(0, request.int(3))
+	return readRecording(s.recording, func(result AgentResult) error {
+		if result.Name != name && result.ID != name {
+			return nil
+		}
+		if request.int(2) != 0 && result.RunAt.Before(since) {
+			return nil
+		}
+		if request.int(3) != 0 && result.RunAt.After(until) {
+			return nil
+		}
+		return grpcSend(w, pbRecordedResult(result))
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// healthScore is the weighted share of the checks working, 0 to 100: an OK
+// check counts fully, a degraded one half and a failing one not at all.
+// Checks count by their weight, 1 unless configured; paused checks and
+// checks that didn't run yet don't count.
+type healthScore struct {
+	Score int `json:"score"`
+	// Scores of the sites reporting results, with agents
+	Sites map[string]int `json:"sites,omitempty"`
+}
+
+// weight returns how much the check counts in the health score
+func (c Check) weight() float64 {
+	if c.Weight == nil {
+		return 1
+	}
+	return *c.Weight
+}
+
+// health computes the health score, false when no check counts yet.
+// Callers hold m.mu.
+func (m *Monitor) health() (healthScore, bool) {
+	type sum struct{ score, weight float64 }
+	var total sum
+	sites := make(map[string]*sum)
+	for i, checkResult := range m.results {
+		check := checkResult.check
+		// The configuration holds the weights, also of the checks agents
+		// report by the same name
+		weight := check.weight()
+		for _, configured := range m.checks.Checks {
+			if configured.Name == check.Name {
+				weight = configured.weight()
+			}
+		}
+		if i < len(m.checks.Checks) && m.paused[check.Name] {
+			continue
+		}
+		if checkResult.execCount == 0 || weight <= 0 {
+			continue
+		}
+		score := 0.0
+		if checkResult.status {
+			score = 1
+			if checkResult.degraded {
+				score = 0.5
+			}
+		}
+		site, ok := sites[check.site]
+		if !ok {
+			site = &sum{}
+			sites[check.site] = site
+		}
+		for _, s := range []*sum{&total, site} {
+			s.score += score * weight
+			s.weight += weight
+		}
+	}
+	if total.weight == 0 {
+		return healthScore{}, false
+	}
+	health := healthScore{Score: int(math.Round(100 * total.score / total.weight))}
+	if len(sites) > 1 {
+		health.Sites = make(map[string]int)
+		for name, site := range sites {
+			health.Sites[name] = int(math.Round(100 * site.score / site.weight))
+		}
+	}
+	return health, true
+}
+
+// String renders the score like 87/100 (home 100, office 74)
+func (h healthScore) String() string {
+	text := fmt.Sprintf("%d/100", h.Score)
+	if len(h.Sites) > 0 {
+		names := make([]string, 0, len(h.Sites))
+		for name := range h.Sites {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var sites []string
+		for _, name := range names {
+			sites = append(sites, fmt.Sprintf("%s %d", name, h.Sites[name]))
+		}
+		text += " (" + strings.Join(sites, ", ") + ")"
+	}
+	return text
+}
+
+// healthColor returns the color the score is shown in
+func healthColor(score int) *color.Color {
+	switch {
+	case score >= 90:
+		return color.New(color.FgGreen, color.Bold)
+	case score >= 50:
+		return color.New(color.FgYellow, color.Bold)
+	}
+	return color.New(color.FgRed, color.Bold)
+}
+
+// healthHandler serves the health score as JSON, 503 while no check ran yet
+func healthHandler(monitor *Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		monitor.mu.Lock()
+		health, ok := monitor.health()
+		monitor.mu.Unlock()
+		if !ok {
+			http.Error(w, "no results yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HeartbeatConfig configures a dead man's switch: the URL is requested
+// periodically while the monitor is healthy, so that an external service
+// (e.g. healthchecks.io) alerts when the pings stop
+type HeartbeatConfig struct {
+	Url      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+const defaultHeartbeatInterval = time.Minute
+
+// startHeartbeat pings the heartbeat URL for as long as the monitor is
+// healthy. Pings are skipped, not failed, when it isn't, so that the
+// external service alerts on the missing pings.
+func startHeartbeat(config HeartbeatConfig, monitor *Monitor) {
+	if config.Url == "" {
+		return
+	}
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	ping := func() {
+		if err := monitor.healthy(interval); err != nil {
+			logMessage(logWarning, "Skipping heartbeat:", err)
+			return
+		}
+		resp, err := client.Get(config.Url)
+		if err != nil {
+			logMessage(logWarning, "Heartbeat failed:", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logMessage(logWarning, fmt.Sprintf("Heartbeat failed: %s", resp.Status))
+		}
+	}
+	go func() {
+		ping()
+		for range time.Tick(interval) {
+			ping()
+		}
+	}()
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Days shown in a heatmap by default
+const heatmapDays = 14
+
+// Metrics a heatmap shows
+const (
+	heatmapLoss = iota
+	heatmapLatency
+)
+
+// How long the terminal UI reuses a heatmap read from the recording
+const heatmapRefresh = time.Minute
+
+// heatmapCell aggregates the results of one hour
+type heatmapCell struct {
+	ok, total int
+	// Sum of the durations of the successful results
+	duration time.Duration
+}
+
+func (c heatmapCell) loss() float64 {
+	if c.total == 0 {
+		return 0
+	}
+	return 1 - float64(c.ok)/float64(c.total)
+}
+
+func (c heatmapCell) latency() time.Duration {
+	if c.ok == 0 {
+		return 0
+	}
+	return c.duration / time.Duration(c.ok)
+}
+
+// heatmap holds the results of a check by day and hour of day
+type heatmap struct {
+	first time.Time
+	days  [][24]heatmapCell
+}
+
+func newHeatmap(days int, now time.Time) *heatmap {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return &heatmap{first: today.AddDate(0, 0, -(days - 1)), days: make([][24]heatmapCell, days)}
+}
+
+// add accounts for runs of the check, ok of them successful taking the
+// durations in total
+func (h *heatmap) add(runAt time.Time, ok, total int, durations time.Duration) {
+	runAt = runAt.In(h.first.Location())
+	midnight := time.Date(runAt.Year(), runAt.Month(), runAt.Day(), 0, 0, 0, 0, h.first.Location())
+	// Rounded, as days around DST changes aren't 24 hours long
+	day := int(midnight.Sub(h.first).Hours()/24 + 0.5)
+	if runAt.Before(h.first) || day >= len(h.days) {
+		return
+	}
+	cell := &h.days[day][runAt.Hour()]
+	cell.total += total
+	cell.ok += ok
+	cell.duration += durations
+}
+
+func (h *heatmap) date(day int) string {
+	return h.first.AddDate(0, 0, day).Format(time.DateOnly)
+}
+
+// maxLatency returns the highest hourly average latency
+func (h *heatmap) maxLatency() time.Duration {
+	var max time.Duration
+	for _, day := range h.days {
+		for _, cell := range day {
+			if cell.latency() > max {
+				max = cell.latency()
+			}
+		}
+	}
+	return max
+}
+
+// latencyLevel scales the latency of a cell to 1..levels, 0 without data
+func (h *heatmap) latencyLevel(cell heatmapCell, max time.Duration, levels int) int {
+	if cell.ok == 0 {
+		return 0
+	}
+	if max == 0 {
+		return 1
+	}
+	return 1 + int(int64(cell.latency())*int64(levels-1)/int64(max))
+}
+
+// readHeatmaps builds the heatmaps of every check in a recording made with
+// -record, by check identity
+func readHeatmaps(recording string, days int, now time.Time) (map[string]*heatmap, error) {
+	heatmaps := make(map[string]*heatmap)
+	err := readRecording(recording, func(result AgentResult) error {
+		h, ok := heatmaps[result.identity()]
+		if !ok {
+			h = newHeatmap(days, now)
+			heatmaps[result.identity()] = h
+		}
+		successful, total, durations := result.counts()
+		h.add(result.RunAt, successful, total, durations)
+		return nil
+	})
+	return heatmaps, err
+}
+
+// displayHeatmap draws the loss or latency of a check by day (rows) and hour
+// of day (columns)
+func displayHeatmap(name string, h *heatmap, metric int, err error) {
+	fmt.Print("\033[H\033[2J") // Clear terminal screen
+	title := "loss"
+	if metric == heatmapLatency {
+		title = "latency"
+	}
+	fmt.Printf("%s - %s by hour of day in %s (m loss/latency, q back)\n\n", name, title, time.Now().Format("MST"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("%-10s ", "")
+	for hour := 0; hour < 24; hour += 3 {
+		fmt.Printf("%-6d", hour)
+	}
+	fmt.Println()
+
+	_, height := terminalSize()
+	first := 0
+	if rows := height - 6; len(h.days) > rows && rows > 0 {
+		first = len(h.days) - rows
+	}
+	max := h.maxLatency()
+	none := color.New(color.FgHiBlack)
+	lossColors := []*color.Color{color.New(color.FgGreen), color.New(color.FgYellow), color.New(color.FgHiRed), color.New(color.FgRed)}
+	for day := first; day < len(h.days); day++ {
+		fmt.Printf("%-10s ", h.date(day))
+		for _, cell := range h.days[day] {
+			switch {
+			case cell.total == 0:
+				none.Print("··")
+			case metric == heatmapLatency && cell.ok == 0:
+				color.New(color.FgRed).Print("xx")
+			case metric == heatmapLatency:
+				block := string(histogramBlocks[h.latencyLevel(cell, max, len(histogramBlocks)-1)])
+				color.New(color.FgCyan).Print(block + block)
+			default:
+				lossColors[lossLevel(cell.loss())].Print("██")
+			}
+		}
+		fmt.Println()
+	}
+	if metric == heatmapLatency {
+		fmt.Printf("\n%-10s ▁ fast … █ %s average, xx all failed, ·· no data\n", "", formatDuration(max))
+	} else {
+		fmt.Printf("\n%-10s green no loss, yellow <1%%, light red <5%%, red more, ·· no data\n", "")
+	}
+}
+
+// lossLevel maps a loss ratio to 0 (none) .. 3 (5% and more)
+func lossLevel(loss float64) int {
+	switch {
+	case loss == 0:
+		return 0
+	case loss < 0.01:
+		return 1
+	case loss < 0.05:
+		return 2
+	default:
+		return 3
+	}
+}
+
+type heatmapReportCell struct {
+	Title string
+	Class string
+}
+
+type heatmapReportDay struct {
+	Date    string
+	Loss    []heatmapReportCell
+	Latency []heatmapReportCell
+}
+
+type heatmapReportCheck struct {
+	Name string
+	Days []heatmapReportDay
+}
+
+type heatmapReport struct {
+	Generated time.Time
+	Hours     []int
+	Checks    []heatmapReportCheck
+}
+
+// buildHeatmapReport lays out the heatmaps of the configured checks
+func buildHeatmapReport(checks []Check, heatmaps map[string]*heatmap, days int, now time.Time) heatmapReport {
+	report := heatmapReport{Generated: now}
+	for hour := 0; hour < 24; hour++ {
+		report.Hours = append(report.Hours, hour)
+	}
+	lossClasses := []string{"up", "minor", "major", "down"}
+	for _, check := range checks {
+		h, ok := heatmaps[check.identity()]
+		if !ok {
+			h = newHeatmap(days, now)
+		}
+		max := h.maxLatency()
+		reportCheck := heatmapReportCheck{Name: check.Name}
+		for day := range h.days {
+			reportDay := heatmapReportDay{Date: h.date(day)}
+			for hour, cell := range h.days[day] {
+				when := fmt.Sprintf("%s %02d:00", h.date(day), hour)
+				if cell.total == 0 {
+					reportDay.Loss = append(reportDay.Loss, heatmapReportCell{when + ": no data", "none"})
+					reportDay.Latency = append(reportDay.Latency, heatmapReportCell{when + ": no data", "none"})
+					continue
+				}
+				reportDay.Loss = append(reportDay.Loss, heatmapReportCell{
+					Title: fmt.Sprintf("%s: %.2f%% loss of %d", when, cell.loss()*100, cell.total),
+					Class: lossClasses[lossLevel(cell.loss())],
+				})
+				latency := heatmapReportCell{Title: when + ": all failed", Class: "down"}
+				if cell.ok > 0 {
+					latency = heatmapReportCell{
+						Title: fmt.Sprintf("%s: %v average", when, cell.latency().Round(time.Microsecond)),
+						Class: fmt.Sprintf("l%d", h.latencyLevel(cell, max, 8)),
+					}
+				}
+				reportDay.Latency = append(reportDay.Latency, latency)
+			}
+			reportCheck.Days = append(reportCheck.Days, reportDay)
+		}
+		report.Checks = append(report.Checks, reportCheck)
+	}
+	return report
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Heatmaps</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.maps { display: flex; gap: 3em; flex-wrap: wrap; }
+table { border-collapse: separate; border-spacing: 2px; font-size: small; }
+td.cell { width: 14px; height: 14px; border-radius: 2px; }
+th { font-weight: normal; color: #888; }
+.up { background: #2e9d4f; } .minor { background: #e3c04d; } .major { background: #f08a24; }
+.down { background: #d64541; } .none { background: #eee; }
+.l1 { background: #dbe9f6; } .l2 { background: #bad6eb; } .l3 { background: #89bedc; }
+.l4 { background: #539ecd; } .l5 { background: #2b7bba; } .l6 { background: #0b559f; }
+.l7 { background: #08306b; } .l8 { background: #041a3b; }
+footer { color: #888; font-size: small; margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>Heatmaps by hour of day ({{.Generated.Format "MST"}})</h1>
+{{$hours := .Hours}}
+{{range .Checks}}
+<h2>{{.Name}}</h2>
+<div class="maps">
+<div><h3>Loss</h3>
+<table><tr><th></th>{{range $hours}}<th>{{if eq (printf "%d" .) "0" "6" "12" "18"}}{{.}}{{end}}</th>{{end}}</tr>
+{{range .Days}}<tr><th>{{.Date}}</th>{{range .Loss}}<td class="cell {{.Class}}" title="{{.Title}}"></td>{{end}}</tr>
+{{end}}</table></div>
+<div><h3>Latency</h3>
+<table><tr><th></th>{{range $hours}}<th>{{if eq (printf "%d" .) "0" "6" "12" "18"}}{{.}}{{end}}</th>{{end}}</tr>
+{{range .Days}}<tr><th>{{.Date}}</th>{{range .Latency}}<td class="cell {{.Class}}" title="{{.Title}}"></td>{{end}}</tr>
+{{end}}</table></div>
+</div>
+{{end}}
+<footer>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</footer>
+</body>
+</html>
+`))
+
+// runHeatmap implements the heatmap subcommand writing an HTML report of the
+// loss and latency of every check by day and hour of day. It returns the
+// process exit code.
+func runHeatmap(args []string) int {
+	flags := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	configPath := flags.String("config", "checks.yml", "path to the checks configuration")
+	from := flags.String("from", "", "recording made with -record")
+	output := flags.String("o", "heatmap.html", "file to write the report to")
+	days := flags.Int("days", heatmapDays, "number of days shown")
+	tz := flags.String("tz", "Local", "time zone of the hours, e.g. UTC or Europe/Prague")
+	flags.Parse(args)
+	if *from == "" || *days < 1 {
+		fmt.Println("Usage: network-checks heatmap -from results.jsonl [-config checks.yml] [-o heatmap.html] [-days 14]")
+		return 2
+	}
+
+	checks, err := loadChecksFromYaml(*configPath)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return 1
+	}
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+	now := time.Now().In(location)
+	heatmaps, err := readHeatmaps(*from, *days, now)
+	if err != nil {
+		fmt.Println("Error reading recording:", err)
+		return 1
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Println("Error writing report:", err)
+		return 1
+	}
+	defer file.Close()
+	if err := heatmapTemplate.Execute(file, buildHeatmapReport(checks.Checks, heatmaps, *days, now)); err != nil {
+		fmt.Println("Error writing report:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+type HistogramConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Upper bounds of the buckets, latencies above the last one fall into an
+	// additional overflow bucket
+	Buckets []time.Duration `yaml:"buckets"`
+}
+
+var defaultHistogramBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+var histogramBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// histogram renders the distribution of durations as one block character per
+// bucket, scaled to the fullest bucket
+func histogram(durations []time.Duration, buckets []time.Duration) string {
+	if len(buckets) == 0 {
+		buckets = defaultHistogramBuckets
+	}
+	counts := make([]int, len(buckets)+1)
+	for _, d := range durations {
+		i := 0
+		for i < len(buckets) && d > buckets[i] {
+			i++
+		}
+		counts[i]++
+	}
+
+	var max int
+	for _, count := range counts {
+		if count > max {
+			max = count
+		}
+	}
+
+	var b strings.Builder
+	for _, count := range counts {
+		level := 0
+		if count > 0 {
+			// Any sample at all is visible
+			level = 1 + count*(len(histogramBlocks)-2)/max
+		}
+		b.WriteRune(histogramBlocks[level])
+	}
+	return b.String()
+}
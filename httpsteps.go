@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPStep is a request of a multi-step http check, e.g. a login
+type HTTPStep struct {
+	// GET by default, POST when a form is given
+	Method string `yaml:"method,omitempty"`
+	// Relative to the dest of the check
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Fields sent URL-encoded, or the raw body
+	Form map[string]string `yaml:"form,omitempty"`
+	Body string            `yaml:"body,omitempty"`
+	// Accepted response codes, any below 400 by default
+	ExpectStatus []int `yaml:"expect_status,omitempty"`
+	// Text the body has to contain
+	ExpectBody string `yaml:"expect_body,omitempty"`
+}
+
+// request builds the request of the step, resolved against the base URL
+func (step HTTPStep) request(ctx context.Context, base *url.URL) (*http.Request, error) {
+	target, err := base.Parse(step.URL)
+	if err != nil {
+		return nil, err
+	}
+	method := step.Method
+	var body io.Reader
+	contentType := ""
+	switch {
+	case len(step.Form) > 0:
+		form := url.Values{}
+		for key, value := range step.Form {
+			form.Set(key, value)
+		}
+		body = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+		if method == "" {
+			method = http.MethodPost
+		}
+	case step.Body != "":
+		body = strings.NewReader(step.Body)
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for key, value := range step.Headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// verify checks the response of the step against its expectations
+func (step HTTPStep) verify(status int, body string) error {
+	if len(step.ExpectStatus) > 0 {
+		for _, expected := range step.ExpectStatus {
+			if status == expected {
+				status = 0
+			}
+		}
+		if status != 0 {
+			return unexpectedf("status %d, expected %v", status, step.ExpectStatus)
+		}
+	} else if status >= 400 {
+		return unexpectedf("status %d", status)
+	}
+	if step.ExpectBody != "" && !strings.Contains(body, step.ExpectBody) {
+		return unexpectedf("body doesn't contain %q", step.ExpectBody)
+	}
+	return nil
+}
+
+// runHttpSteps runs the steps of an http check in order, sharing cookies
+// between them like a browser session. The check fails at the first step
+// failing.
+func runHttpSteps(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{check: check, runAt: time.Now()}
+	base, err := url.Parse(check.Dest)
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+
+	ctx, transferred := withTrafficTrace(ctx)
+	ctx, lookup := withLookupTrace(ctx)
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Transport: httpClient.Transport, Jar: jar}
+	if check.Connection == connectionFresh {
+		client.Transport = freshHttpClient.Transport
+	}
+	for i, step := range check.Steps {
+		err := func() error {
+			req, err := step.request(ctx, base)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return step.verify(resp.StatusCode, string(body))
+		}()
+		if err != nil {
+			checkResult.detail = fmt.Sprintf("step %d (%s): %v", i+1, step.URL, err)
+			checkResult.cause = errorCause(err)
+			break
+		}
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	checkResult.bytes = transferred()
+	checkResult.dns = lookup()
+	checkResult.status = checkResult.detail == ""
+	c <- checkResult
+}
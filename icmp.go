@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ways of sending pings, set per check with icmp_mode
+const (
+	// The best mode available, in the order below
+	icmpAuto = "auto"
+	// IcmpSendEcho of the IP Helper API of Windows, which needs no
+	// privileges
+	icmpAPI = "api"
+	// Raw ICMP sockets, which need root or CAP_NET_RAW
+	icmpRaw = "raw"
+	// Unprivileged ICMP datagram sockets of Linux (see ping_group_range)
+	// and macOS
+	icmpDgram = "dgram"
+	// A UDP datagram to a closed port, answered with port unreachable
+	icmpUDP = "udp"
+	// The system ping command, also used for checks run via SSH
+	icmpExec = "exec"
+)
+
+// Port the udp mode probes, the first of traceroute
+const icmpUDPPort = 33434
+
+// Identifies the pings of concurrent checks on raw sockets, which receive
+// all replies
+var icmpIds atomic.Uint32
+
+// icmpAvailable finds the best mode available once, by opening a socket of
+// every mode
+var icmpAvailable = sync.OnceValue(func() string {
+	if icmpAPISupported {
+		return icmpAPI
+	}
+	for _, mode := range []string{icmpRaw, icmpDgram} {
+		conn, err := listenICMP(mode, false)
+		if err == nil {
+			conn.Close()
+			return mode
+		}
+	}
+	return icmpUDP
+})
+
+// icmpMode returns the mode a check pings with
+func icmpMode(check Check) (string, error) {
+	switch check.ICMPMode {
+	case "", icmpAuto:
+		if check.Via != "" {
+			return icmpExec, nil
+		}
+		return icmpAvailable(), nil
+	case icmpAPI, icmpRaw, icmpDgram, icmpUDP, icmpExec:
+		return check.ICMPMode, nil
+	}
+	return "", fmt.Errorf("unknown icmp_mode %s, expected auto, api, raw, dgram, udp or exec", check.ICMPMode)
+}
+
+// listenICMP opens a socket sending and receiving ICMP echo messages
+func listenICMP(mode string, v6 bool) (net.PacketConn, error) {
+	switch {
+	case mode == icmpRaw && v6:
+		return net.ListenPacket("ip6:ipv6-icmp", "::")
+	case mode == icmpRaw:
+		return net.ListenPacket("ip4:icmp", "0.0.0.0")
+	case mode == icmpDgram:
+		return listenICMPDgram(v6)
+	}
+	return nil, fmt.Errorf("no ICMP socket in %s mode", mode)
+}
+
+// icmpEcho builds an echo request; the kernel computes the checksum of ICMPv6
+func icmpEcho(v6 bool, id, seq int) []byte {
+	message := make([]byte, 8, 8+len("network-checks"))
+	message[0] = 8
+	if v6 {
+		message[0] = 128
+	}
+	binary.BigEndian.PutUint16(message[4:], uint16(id))
+	binary.BigEndian.PutUint16(message[6:], uint16(seq))
+	message = append(message, "network-checks"...)
+	if !v6 {
+		var sum uint32
+		for i := 0; i+1 < len(message); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(message[i:]))
+		}
+		if len(message)%2 == 1 {
+			sum += uint32(message[len(message)-1]) << 8
+		}
+		sum = sum>>16 + sum&0xffff
+		sum += sum >> 16
+		binary.BigEndian.PutUint16(message[2:], ^uint16(sum))
+	}
+	return message
+}
+
+// isEchoReply reports whether a message is the reply to the request with
+// the sequence number. Datagram sockets of Linux replace the id with their
+// own and only receive their replies, so it isn't compared there.
+func isEchoReply(message []byte, v6 bool, mode string, id, seq int) bool {
+	// Datagram sockets of macOS include the IPv4 header
+	if !v6 && len(message) >= 20 && message[0]>>4 == 4 {
+		message = message[int(message[0]&0x0f)*4:]
+	}
+	replyType := byte(0)
+	if v6 {
+		replyType = 129
+	}
+	if len(message) < 8 || message[0] != replyType || int(binary.BigEndian.Uint16(message[6:])) != seq&0xffff {
+		return false
+	}
+	return mode == icmpDgram || int(binary.BigEndian.Uint16(message[4:])) == id&0xffff
+}
+
+// nativePing sends one echo request, or in udp mode one datagram, and
+// returns the round-trip time
+func nativePing(ctx context.Context, dest string, mode string, seq int) (time.Duration, error) {
+	ip, err := resolvePingIP(ctx, dest)
+	if err != nil {
+		return 0, err
+	}
+	return pingIP(ctx, ip, mode, seq)
+}
+
+// resolvePingIP returns the address of the destination to ping
+func resolvePingIP(ctx context.Context, dest string) (net.IP, error) {
+	addrs, err := resolveHost(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address of %s", dest)
+	}
+	return net.ParseIP(addrs[0]), nil
+}
+
+// pingIP sends one echo request to the address and waits for its reply
+func pingIP(ctx context.Context, ip net.IP, mode string, seq int) (time.Duration, error) {
+	switch mode {
+	case icmpUDP:
+		return udpPing(ctx, ip)
+	case icmpAPI:
+		return icmpSendEcho(ctx, ip)
+	}
+	v6 := ip.To4() == nil
+	conn, err := listenICMP(mode, v6)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Closing the socket interrupts the read when the context is canceled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var to net.Addr = &net.IPAddr{IP: ip}
+	if mode == icmpDgram {
+		to = &net.UDPAddr{IP: ip}
+	}
+	id := os.Getpid() ^ int(icmpIds.Add(1))
+	start := time.Now()
+	if _, err := conn.WriteTo(icmpEcho(v6, id, seq), to); err != nil {
+		return 0, err
+	}
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded) {
+				return 0, fmt.Errorf("no reply")
+			}
+			return 0, err
+		}
+		if fromIP(from).Equal(ip) && isEchoReply(buffer[:n], v6, mode, id, seq) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// fromIP returns the IP address a packet came from
+func fromIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.IPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// udpPing sends a datagram to a port that is most likely closed. The port
+// unreachable error it provokes tells the host is up, like a ping reply.
+func udpPing(ctx context.Context, ip net.IP) (time.Duration, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), fmt.Sprint(icmpUDPPort)))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	start := time.Now()
+	if _, err := conn.Write([]byte("network-checks")); err != nil {
+		return 0, err
+	}
+	_, err = conn.Read(make([]byte, 1500))
+	switch {
+	case err == nil || isPortUnreachable(err):
+		return time.Since(start), nil
+	case ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded):
+		return 0, fmt.Errorf("no reply")
+	}
+	return 0, err
+}
+
+// runNativeIcmpCheck pings the destination once over a socket of the mode
+func runNativeIcmpCheck(ctx context.Context, check Check, mode string, c chan CheckResult) {
+	checkResult := CheckResult{check: check, runAt: time.Now(), mode: mode}
+	ctx, lookup := withLookupTrace(ctx)
+	rtt, err := nativePing(ctx, check.Dest, mode, 1)
+	checkResult.duration = rtt
+	checkResult.dns = lookup()
+	if err != nil {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = fmt.Sprintf("%v (%s)", err, mode)
+		checkResult.cause = errorCause(err)
+	} else {
+		checkResult.status = true
+	}
+	if check.Via == "" {
+		checkResult.bytes = icmpProbeBytes
+		if err == nil {
+			checkResult.bytes += icmpProbeBytes
+		}
+	}
+	c <- checkResult
+}
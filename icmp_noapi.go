@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Only Windows has the IP Helper API
+const icmpAPISupported = false
+
+func icmpSendEcho(ctx context.Context, ip net.IP) (time.Duration, error) {
+	return 0, fmt.Errorf("the %s mode is only available on Windows", icmpAPI)
+}
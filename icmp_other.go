@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || windows)
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func listenICMPDgram(v6 bool) (net.PacketConn, error) {
+	return nil, fmt.Errorf("unprivileged ICMP sockets aren't supported on this platform")
+}
+
+// isPortUnreachable reports whether reading a connected UDP socket failed
+// because of an ICMP port unreachable
+func isPortUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
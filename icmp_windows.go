@@ -0,0 +1,145 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// The IP Helper API pings without privileges on Windows
+const icmpAPISupported = true
+
+var (
+	iphlpapi            = windows.NewLazySystemDLL("iphlpapi.dll")
+	procIcmpCreateFile  = iphlpapi.NewProc("IcmpCreateFile")
+	procIcmp6CreateFile = iphlpapi.NewProc("Icmp6CreateFile")
+	procIcmpCloseHandle = iphlpapi.NewProc("IcmpCloseHandle")
+	procIcmpSendEcho    = iphlpapi.NewProc("IcmpSendEcho")
+	procIcmp6SendEcho2  = iphlpapi.NewProc("Icmp6SendEcho2")
+)
+
+// Status codes of echo replies (IP_STATUS), IP_SUCCESS is 0
+const (
+	ipReqTimedOut       = 11010
+	ipDestNetUnreach    = 11002
+	ipDestHostUnreach   = 11003
+	ipDestProtUnreach   = 11004
+	ipDestPortUnreach   = 11005
+	ipTtlExpiredTransit = 11013
+)
+
+// icmpEchoReply is ICMP_ECHO_REPLY of the IP Helper API
+type icmpEchoReply struct {
+	Address       [4]byte
+	Status        uint32
+	RoundTripTime uint32
+	DataSize      uint16
+	Reserved      uint16
+	Data          uintptr
+	Options       struct {
+		TTL, TOS, Flags, OptionsSize uint8
+		OptionsData                  uintptr
+	}
+}
+
+// Offset of the status in ICMPV6_ECHO_REPLY, after the packed 26 byte
+// IPV6_ADDRESS_EX
+const icmp6ReplyStatusOffset = 28
+
+func listenICMPDgram(v6 bool) (net.PacketConn, error) {
+	return nil, fmt.Errorf("unprivileged ICMP sockets aren't supported on this platform")
+}
+
+// isPortUnreachable reports whether reading a connected UDP socket failed
+// because of an ICMP port unreachable, which Windows reports as a reset
+// (WSAECONNRESET)
+func isPortUnreachable(err error) bool {
+	var errno syscall.Errno
+	return errors.Is(err, syscall.ECONNREFUSED) || (errors.As(err, &errno) && (errno == 10054 || errno == 10061))
+}
+
+// icmpSendEcho pings the address once with IcmpSendEcho (Icmp6SendEcho2 for
+// IPv6), waiting for the reply until the deadline of the context. The round
+// trip is measured with the monotonic clock like in the other modes, the
+// API only reports whole milliseconds.
+func icmpSendEcho(ctx context.Context, ip net.IP) (time.Duration, error) {
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout < time.Millisecond {
+		return 0, fmt.Errorf("no reply")
+	}
+	v4 := ip.To4()
+	create := procIcmpCreateFile
+	if v4 == nil {
+		create = procIcmp6CreateFile
+	}
+	handle, _, err := create.Call()
+	if windows.Handle(handle) == windows.InvalidHandle {
+		return 0, fmt.Errorf("opening an ICMP handle: %v", err)
+	}
+	defer procIcmpCloseHandle.Call(handle)
+
+	request := []byte("network-checks")
+	// Room for the reply, its data and an ICMP error message
+	reply := make([]byte, int(unsafe.Sizeof(icmpEchoReply{}))+len(request)+8+64)
+	start := time.Now()
+	var replies uintptr
+	if v4 != nil {
+		replies, _, err = procIcmpSendEcho.Call(handle,
+			uintptr(binary.LittleEndian.Uint32(v4)),
+			uintptr(unsafe.Pointer(&request[0])), uintptr(len(request)), 0,
+			uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)),
+			uintptr(timeout.Milliseconds()))
+	} else {
+		var source, dest windows.RawSockaddrInet6
+		source.Family = windows.AF_INET6
+		dest.Family = windows.AF_INET6
+		copy(dest.Addr[:], ip.To16())
+		replies, _, err = procIcmp6SendEcho2.Call(handle, 0, 0, 0,
+			uintptr(unsafe.Pointer(&source)), uintptr(unsafe.Pointer(&dest)),
+			uintptr(unsafe.Pointer(&request[0])), uintptr(len(request)), 0,
+			uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)),
+			uintptr(timeout.Milliseconds()))
+	}
+	rtt := time.Since(start)
+
+	var status uint32
+	switch {
+	case replies == 0:
+		var errno syscall.Errno
+		if !errors.As(err, &errno) {
+			return 0, err
+		}
+		status = uint32(errno)
+	case v4 != nil:
+		status = (*icmpEchoReply)(unsafe.Pointer(&reply[0])).Status
+	default:
+		status = binary.LittleEndian.Uint32(reply[icmp6ReplyStatusOffset:])
+	}
+	switch status {
+	case 0:
+		return rtt, nil
+	case ipReqTimedOut:
+		return 0, fmt.Errorf("no reply")
+	case ipDestNetUnreach:
+		return 0, fmt.Errorf("network unreachable")
+	case ipDestHostUnreach:
+		return 0, fmt.Errorf("host unreachable")
+	case ipDestProtUnreach, ipDestPortUnreach:
+		return 0, fmt.Errorf("destination unreachable")
+	case ipTtlExpiredTransit:
+		return 0, fmt.Errorf("TTL expired in transit")
+	}
+	return 0, fmt.Errorf("echo failed with IP status %d", status)
+}
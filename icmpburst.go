@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spacing of the packets of a burst that doesn't set an interval
+const defaultBurstInterval = time.Second
+
+// Shortest interval the ping of iputils accepts from users other than root
+const minUserPingInterval = 200 * time.Millisecond
+
+// validateBursts rejects bursts the ping command would refuse to send
+func validateBursts(checks []Check) error {
+	for _, check := range checks {
+		if check.CheckType != "icmp" || check.Count <= 1 || check.Interval <= 0 || check.Interval >= minUserPingInterval {
+			continue
+		}
+		mode, err := icmpMode(check)
+		if err != nil {
+			return fmt.Errorf("check %s: %v", check.Name, err)
+		}
+		if mode == icmpExec && check.Via == "" && runtime.GOOS == "linux" && os.Geteuid() != 0 {
+			return fmt.Errorf("check %s: ping only sends at intervals below %v as root, got %v", check.Name, minUserPingInterval, check.Interval)
+		}
+	}
+	return nil
+}
+
+// burstStats summarizes the replies to a burst of pings
+type burstStats struct {
+	sent, received int
+	avg, p95       time.Duration
+	// Mean difference of consecutive round-trip times
+	jitter time.Duration
+}
+
+func (b burstStats) loss() float64 {
+	if b.sent == 0 {
+		return 0
+	}
+	return 1 - float64(b.received)/float64(b.sent)
+}
+
+func (b burstStats) String() string {
+	return fmt.Sprintf("%d/%d received, %.0f%% loss, avg %s, p95 %s, jitter %s", b.received, b.sent, b.loss()*100,
+		strings.TrimSpace(formatDuration(b.avg)), strings.TrimSpace(formatDuration(b.p95)), strings.TrimSpace(formatDuration(b.jitter)))
+}
+
+func newBurstStats(sent int, rtts []time.Duration) burstStats {
+	stats := burstStats{sent: sent, received: min(len(rtts), sent)}
+	if len(rtts) == 0 {
+		return stats
+	}
+	var total, variation time.Duration
+	for i, rtt := range rtts {
+		total += rtt
+		if i > 0 {
+			variation += time.Duration(math.Abs(float64(rtt - rtts[i-1])))
+		}
+	}
+	stats.avg = total / time.Duration(len(rtts))
+	stats.p95 = percentile(rtts, 95)
+	if len(rtts) > 1 {
+		stats.jitter = variation / time.Duration(len(rtts)-1)
+	}
+	return stats
+}
+
+// runIcmpBurst sends a burst of count pings at the interval, like the probes
+// of Smokeping. The check fails when all are lost and is degraded by any
+// loss; its duration is the average round-trip time.
+func runIcmpBurst(ctx context.Context, check Check, mode string, c chan CheckResult) {
+	interval := check.Interval
+	if interval <= 0 {
+		interval = defaultBurstInterval
+	}
+	checkResult := CheckResult{check: check, runAt: time.Now(), mode: mode}
+	var rtts []time.Duration
+	var err error
+	if mode == icmpExec {
+		rtts, err = execBurst(ctx, check, interval)
+	} else {
+		var lookup func() *dnsLookup
+		ctx, lookup = withLookupTrace(ctx)
+		rtts, err = nativeBurst(ctx, check, mode, interval)
+		checkResult.dns = lookup()
+	}
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+
+	stats := newBurstStats(check.Count, rtts)
+	checkResult.burst = &stats
+	checkResult.duration = stats.avg
+	if check.Via == "" {
+		probeBytes := int64(icmpProbeBytes)
+		if runtime.GOOS == "windows" && mode == icmpExec {
+			probeBytes = icmpProbeBytesWindows
+		}
+		checkResult.bytes = probeBytes * int64(stats.sent+stats.received)
+	}
+	checkResult.status = stats.received > 0
+	checkResult.degraded = stats.received > 0 && stats.received < stats.sent
+	checkResult.detail = stats.String()
+	if stats.received == 0 {
+		checkResult.cause = causeUnreachable
+	}
+	if ctx.Err() != nil && stats.received < stats.sent {
+		checkResult.detail = "burst didn't finish within the timeout, " + checkResult.detail
+	}
+	c <- checkResult
+}
+
+// execBurst sends the burst with the system ping command
+func execBurst(ctx context.Context, check Check, interval time.Duration) ([]time.Duration, error) {
+	goos := runtime.GOOS
+	if check.Via != "" {
+		goos = "linux"
+	}
+	// Windows always sends one ping a second
+	args := []string{"-n", strconv.Itoa(check.Count), "-w", "1000", check.Dest}
+	if goos != "windows" {
+		// macOS waits for the reply in milliseconds, the others in seconds
+		wait := "1"
+		if goos == "darwin" {
+			wait = "1000"
+		}
+		args = []string{"-c", strconv.Itoa(check.Count), "-i", strconv.FormatFloat(interval.Seconds(), 'f', -1, 64), "-W", wait, check.Dest}
+	}
+	cmd, err := pingCommand(ctx, check.Via, goos, args...)
+	if err != nil {
+		return nil, err
+	}
+	var output strings.Builder
+	cmd.Stdout = &output
+	// ping exits with an error when any packet was lost, the replies tell
+	cmd.Run()
+	return parsePingReplies(output.String()), nil
+}
+
+// nativeBurst sends the burst over sockets to the destination resolved once,
+// waiting for every reply up to a second like ping -W 1
+func nativeBurst(ctx context.Context, check Check, mode string, interval time.Duration) ([]time.Duration, error) {
+	ip, err := resolvePingIP(ctx, check.Dest)
+	if err != nil {
+		return nil, err
+	}
+	var rtts []time.Duration
+	next := time.Now()
+	for seq := 1; seq <= check.Count && ctx.Err() == nil; seq++ {
+		pingCtx, cancel := context.WithTimeout(ctx, time.Second)
+		if rtt, err := pingIP(pingCtx, ip, mode, seq); err == nil {
+			rtts = append(rtts, rtt)
+		}
+		cancel()
+		if seq == check.Count {
+			break
+		}
+		next = next.Add(interval)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(next)):
+		}
+	}
+	return rtts, nil
+}
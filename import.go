@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Interval of imported checks when the source doesn't define one
+const defaultImportRepeat = 30 * time.Second
+
+// runImport implements the import subcommand converting configurations of
+// other monitoring tools into checks. It returns the process exit code.
+func runImport(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: network-checks import blackbox|uptime-kuma [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "blackbox":
+		return runImportBlackbox(args[1:])
+	case "uptime-kuma":
+		return runImportUptimeKuma(args[1:])
+	default:
+		fmt.Printf("Unknown import source %q\n", args[0])
+		return 2
+	}
+}
+
+// writeChecks writes the checks as a configuration file, or to stdout when
+// path is empty or "-"
+func writeChecks(path string, checks []Check) error {
+	data, err := yaml.Marshal(Checks{Checks: checks})
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if path != "" && path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// uniqueName makes a check name unique among the already used names
+func uniqueName(base string, suffix string, used map[string]bool) string {
+	name := base
+	if used[name] {
+		name = base + "-" + suffix
+	}
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%s-%d", base, suffix, i)
+	}
+	used[name] = true
+	return name
+}
+
+// promDuration is a duration in the format of Prometheus and the
+// blackbox_exporter, a sequence of whole numbers of units like 1h30m or
+// 1500ms, without fractions
+type promDuration time.Duration
+
+var promDurationUnits = []struct {
+	name string
+	unit time.Duration
+}{
+	{"y", 365 * 24 * time.Hour}, {"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour},
+	{"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}, {"ms", time.Millisecond},
+}
+
+// String renders the duration in the largest unit it's a whole number of,
+// e.g. 1500ms rather than 1.5s which Prometheus rejects. Fractions of a
+// millisecond are rounded.
+func (d promDuration) String() string {
+	duration := time.Duration(d).Round(time.Millisecond)
+	if duration == 0 {
+		return "0s"
+	}
+	for _, unit := range promDurationUnits {
+		if duration%unit.unit == 0 {
+			return fmt.Sprintf("%d%s", duration/unit.unit, unit.name)
+		}
+	}
+	return fmt.Sprintf("%dms", duration/time.Millisecond)
+}
+
+func (d promDuration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *promDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	parsed, err := parsePromDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = promDuration(parsed)
+	return nil
+}
+
+// parsePromDuration parses a Prometheus duration, which unlike Go's has
+// days, weeks and years
+func parsePromDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	rest := value
+	var total time.Duration
+	for rest != "" {
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		n, err := strconv.ParseInt(rest[:digits], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		rest = rest[digits:]
+		// The longest unit name matching, ms rather than m
+		name, unit := "", time.Duration(0)
+		for _, candidate := range promDurationUnits {
+			if strings.HasPrefix(rest, candidate.name) && len(candidate.name) > len(name) {
+				name, unit = candidate.name, candidate.unit
+			}
+		}
+		if name == "" {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		total += time.Duration(n) * unit
+		rest = rest[len(name):]
+	}
+	return total, nil
+}
+
+// Subset of the blackbox_exporter configuration
+type blackboxConfig struct {
+	Modules map[string]blackboxModule `yaml:"modules"`
+}
+
+type blackboxModule struct {
+	Prober  string       `yaml:"prober"`
+	Timeout promDuration `yaml:"timeout,omitempty"`
+}
+
+// Subset of the Prometheus configuration holding the probed targets
+type prometheusConfig struct {
+	Global struct {
+		ScrapeInterval promDuration `yaml:"scrape_interval,omitempty"`
+	} `yaml:"global,omitempty"`
+	ScrapeConfigs []prometheusScrapeConfig `yaml:"scrape_configs"`
+}
+
+type prometheusScrapeConfig struct {
+	JobName        string                   `yaml:"job_name"`
+	MetricsPath    string                   `yaml:"metrics_path"`
+	ScrapeInterval promDuration             `yaml:"scrape_interval,omitempty"`
+	Params         map[string][]string      `yaml:"params"`
+	StaticConfigs  []prometheusStaticConfig `yaml:"static_configs"`
+	RelabelConfigs []prometheusRelabel      `yaml:"relabel_configs,omitempty"`
+}
+
+// Targets of a job, with labels added to their metrics. The check label
+// names the check a target was exported from.
+type prometheusStaticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+type prometheusRelabel struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+}
+
+func runImportBlackbox(args []string) int {
+	flags := flag.NewFlagSet("import blackbox", flag.ExitOnError)
+	configPath := flags.String("config", "blackbox.yml", "blackbox_exporter configuration with the modules")
+	targetsPath := flags.String("targets", "prometheus.yml", "Prometheus configuration with the /probe scrape jobs")
+	output := flags.String("o", "-", "file to write the checks to, - for stdout")
+	flags.Parse(args)
+
+	var blackbox blackboxConfig
+	if err := readYaml(*configPath, &blackbox); err != nil {
+		fmt.Println("Error reading blackbox configuration:", err)
+		return 1
+	}
+	var prometheus prometheusConfig
+	if err := readYaml(*targetsPath, &prometheus); err != nil {
+		fmt.Println("Error reading Prometheus configuration:", err)
+		return 1
+	}
+
+	checks, skipped := convertBlackbox(blackbox, prometheus)
+	for _, reason := range skipped {
+		fmt.Fprintln(os.Stderr, "Skipped", reason)
+	}
+	if err := writeChecks(*output, checks); err != nil {
+		fmt.Println("Error writing checks:", err)
+		return 1
+	}
+	return 0
+}
+
+func readYaml(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+// convertBlackbox creates a check for every target of every Prometheus job
+// probing through the blackbox_exporter. It returns the checks and reasons
+// for targets that couldn't be converted.
+func convertBlackbox(blackbox blackboxConfig, prometheus prometheusConfig) ([]Check, []string) {
+	var checks []Check
+	var skipped []string
+	used := make(map[string]bool)
+
+	for _, job := range prometheus.ScrapeConfigs {
+		if job.MetricsPath != "/probe" || len(job.Params["module"]) == 0 {
+			continue
+		}
+		moduleName := job.Params["module"][0]
+		module, ok := blackbox.Modules[moduleName]
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("job %s: unknown module %s", job.JobName, moduleName))
+			continue
+		}
+
+		repeat := time.Duration(job.ScrapeInterval)
+		if repeat == 0 {
+			repeat = time.Duration(prometheus.Global.ScrapeInterval)
+		}
+		if repeat == 0 {
+			repeat = defaultImportRepeat
+		}
+
+		for _, static := range job.StaticConfigs {
+			for _, target := range static.Targets {
+				check := Check{
+					Dest:    target,
+					Repeat:  repeat,
+					Timeout: time.Duration(module.Timeout),
+					Tags:    []string{job.JobName},
+				}
+				switch module.Prober {
+				case "http":
+					check.CheckType = "http"
+					if !strings.Contains(target, "://") {
+						check.Dest = "http://" + target
+					}
+				case "icmp":
+					check.CheckType = "icmp"
+				default:
+					skipped = append(skipped, fmt.Sprintf("%s: %s prober is not supported", target, module.Prober))
+					continue
+				}
+				name := static.Labels["check"]
+				if name == "" {
+					name = destHost(check.Dest)
+				}
+				check.Name = uniqueName(name, moduleName, used)
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks, skipped
+}
+
+// Subset of an Uptime Kuma backup
+type uptimeKumaBackup struct {
+	MonitorList []uptimeKumaMonitor `json:"monitorList"`
+}
+
+type uptimeKumaMonitor struct {
+	Id       int      `json:"id"`
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Url      string   `json:"url"`
+	Hostname string   `json:"hostname"`
+	Port     int      `json:"port"`
+	Interval int      `json:"interval"`
+	Timeout  int      `json:"timeout"`
+	Active   kumaBool `json:"active"`
+	Parent   *int     `json:"parent"`
+}
+
+// kumaBool accepts both JSON booleans and the 0/1 integers older Uptime Kuma
+// versions export
+type kumaBool bool
+
+func (b *kumaBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+func runImportUptimeKuma(args []string) int {
+	flags := flag.NewFlagSet("import uptime-kuma", flag.ExitOnError)
+	backupPath := flags.String("backup", "", "Uptime Kuma backup (JSON export)")
+	includeInactive := flags.Bool("include-paused", false, "also import paused monitors")
+	output := flags.String("o", "-", "file to write the checks to, - for stdout")
+	flags.Parse(args)
+	if *backupPath == "" {
+		fmt.Println("Usage: network-checks import uptime-kuma -backup backup.json [-o checks.yml]")
+		return 2
+	}
+
+	data, err := os.ReadFile(*backupPath)
+	if err != nil {
+		fmt.Println("Error reading backup:", err)
+		return 1
+	}
+	var backup uptimeKumaBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		fmt.Println("Error reading backup:", err)
+		return 1
+	}
+
+	checks, skipped := convertUptimeKuma(backup, *includeInactive)
+	for _, reason := range skipped {
+		fmt.Fprintln(os.Stderr, "Skipped", reason)
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d checks, skipped %d monitors\n", len(checks), len(skipped))
+	if err := writeChecks(*output, checks); err != nil {
+		fmt.Println("Error writing checks:", err)
+		return 1
+	}
+	return 0
+}
+
+// convertUptimeKuma creates a check for every supported monitor. Monitor
+// groups become check groups. It returns the checks and reasons for monitors
+// that couldn't be converted.
+func convertUptimeKuma(backup uptimeKumaBackup, includeInactive bool) ([]Check, []string) {
+	groups := make(map[int]string)
+	for _, monitor := range backup.MonitorList {
+		if monitor.Type == "group" {
+			groups[monitor.Id] = monitor.Name
+		}
+	}
+
+	var checks []Check
+	var skipped []string
+	used := make(map[string]bool)
+	for _, monitor := range backup.MonitorList {
+		if monitor.Type == "group" {
+			continue
+		}
+		if !bool(monitor.Active) && !includeInactive {
+			skipped = append(skipped, fmt.Sprintf("%s: monitor is paused", monitor.Name))
+			continue
+		}
+
+		check := Check{
+			Repeat:  time.Duration(monitor.Interval) * time.Second,
+			Timeout: time.Duration(monitor.Timeout) * time.Second,
+		}
+		if check.Repeat == 0 {
+			check.Repeat = defaultImportRepeat
+		}
+		if monitor.Parent != nil {
+			check.Group = groups[*monitor.Parent]
+		}
+		switch monitor.Type {
+		case "http", "keyword":
+			check.CheckType = "http"
+			check.Dest = monitor.Url
+			if monitor.Type == "keyword" {
+				fmt.Fprintf(os.Stderr, "Imported %s without its keyword, which is not supported\n", monitor.Name)
+			}
+		case "ping":
+			check.CheckType = "icmp"
+			check.Dest = monitor.Hostname
+		case "port":
+			check.CheckType = "ports"
+			check.Dest = monitor.Hostname
+			check.Ports = strconv.Itoa(monitor.Port)
+		case "":
+			skipped = append(skipped, fmt.Sprintf("%s: monitor has no type", monitor.Name))
+			continue
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s: %s monitors are not supported", monitor.Name, monitor.Type))
+			continue
+		}
+		if check.Dest == "" {
+			skipped = append(skipped, fmt.Sprintf("%s: %s monitor has no target", monitor.Name, monitor.Type))
+			continue
+		}
+		check.Name = uniqueName(monitor.Name, check.CheckType, used)
+		checks = append(checks, check)
+	}
+	return checks, skipped
+}
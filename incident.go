@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultIncidentWindow = 30 * time.Second
+
+// Incident groups checks that started failing within the same window, as
+// they most likely share a root cause.
+type Incident struct {
+	start       time.Time
+	lastFailure time.Time
+	members     []Check
+	failing     map[string]bool
+}
+
+// incidentKey identifies a check across sites
+func incidentKey(check Check) string {
+	return check.site + "/" + check.Name
+}
+
+type incidentTracker struct {
+	window    time.Duration
+	incidents []*Incident
+}
+
+// update records a status transition of a check. A newly failing check joins
+// the latest open incident when it fails within the window of its previous
+// failure, otherwise it opens a new one. Incidents close once all their
+// checks recovered. It returns the incident the check belongs to, if any.
+func (t *incidentTracker) update(check Check, failed bool, at time.Time) *Incident {
+	key := incidentKey(check)
+	window := t.window
+	if window <= 0 {
+		window = defaultIncidentWindow
+	}
+
+	if !failed {
+		var open []*Incident
+		for _, incident := range t.incidents {
+			delete(incident.failing, key)
+			if len(incident.failing) > 0 {
+				open = append(open, incident)
+			} else if len(incident.members) > 1 {
+				logMessage(logInfo, "Incident since", incident.start.Format(time.TimeOnly), "resolved")
+			}
+		}
+		t.incidents = open
+		return nil
+	}
+
+	var incident *Incident
+	if n := len(t.incidents); n > 0 && at.Sub(t.incidents[n-1].lastFailure) <= window {
+		incident = t.incidents[n-1]
+	} else {
+		incident = &Incident{start: at, failing: make(map[string]bool)}
+		t.incidents = append(t.incidents, incident)
+	}
+	incident.lastFailure = at
+	if !incident.failing[key] {
+		incident.failing[key] = true
+		incident.members = append(incident.members, check)
+		if len(incident.members) > 1 {
+			logMessage(logWarning, "Incident since", incident.start.Format(time.TimeOnly)+":", incident.summary())
+		}
+	}
+	return incident
+}
+
+// probableCause guesses the common cause of the failures: a failing check
+// the other failing checks depend on, otherwise tags or a destination all of
+// them share.
+func (incident *Incident) probableCause() string {
+	names := make(map[string]bool)
+	for _, check := range incident.members {
+		names[check.Name] = true
+	}
+
+	// Failing dependencies which don't depend on anything failing themselves
+	var roots []string
+	for _, check := range incident.members {
+		dependsOnFailing := false
+		for _, dependency := range check.DependsOn {
+			if names[dependency] {
+				dependsOnFailing = true
+			}
+		}
+		if dependsOnFailing {
+			continue
+		}
+		for _, other := range incident.members {
+			if contains(other.DependsOn, check.Name) {
+				roots = append(roots, check.Name)
+				break
+			}
+		}
+	}
+	if len(roots) > 0 {
+		return strings.Join(roots, ", ") + " (others depend on it)"
+	}
+
+	shared := incident.members[0].Tags
+	for _, check := range incident.members[1:] {
+		var common []string
+		for _, tag := range shared {
+			if contains(check.Tags, tag) {
+				common = append(common, tag)
+			}
+		}
+		shared = common
+	}
+	if len(shared) > 0 {
+		return "all tagged " + strings.Join(shared, ", ")
+	}
+
+	host := destHost(incident.members[0].Dest)
+	for _, check := range incident.members[1:] {
+		if destHost(check.Dest) != host {
+			return "unknown"
+		}
+	}
+	return "all target " + host
+}
+
+func (incident *Incident) summary() string {
+	var names []string
+	for _, check := range incident.members {
+		names = append(names, check.Name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%d checks failed (%s), probable cause: %s",
+		len(incident.members), strings.Join(names, ", "), incident.probableCause())
+}
+
+// openIncidents returns descriptions of open incidents grouping more than a
+// single check
+func (t *incidentTracker) openIncidents() []string {
+	var lines []string
+	for _, incident := range t.open() {
+		lines = append(lines, incident.describe())
+	}
+	return lines
+}
+
+// open returns the open incidents grouping more than a single check
+func (t *incidentTracker) open() []*Incident {
+	var incidents []*Incident
+	for _, incident := range t.incidents {
+		if len(incident.members) > 1 {
+			incidents = append(incidents, incident)
+		}
+	}
+	return incidents
+}
+
+func (incident *Incident) describe() string {
+	return fmt.Sprintf("INCIDENT since %s: %s", incident.start.Format(time.TimeOnly), incident.summary())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
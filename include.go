@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// includedConfig is the part of the configuration an included file holds
+type includedConfig struct {
+	Include   []string        `yaml:"include,omitempty"`
+	Checks    []Check         `yaml:"checks,omitempty"`
+	Templates []CheckTemplate `yaml:"templates,omitempty"`
+}
+
+// includedPaths resolves the patterns of an include relative to the file
+// including them
+func includedPaths(from string, patterns []string) ([]string, error) {
+	dir := filepath.Dir(from)
+	if strings.HasPrefix(from, configMapPrefix) || isRemoteConfig(from) {
+		dir = "."
+	}
+	var paths []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %v", pattern, err)
+		}
+		// A missing file is an error, a pattern matching nothing isn't
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			matches = []string{pattern}
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// configMerge collects the checks of a configuration and the files it
+// includes, remembering the file defining every check
+type configMerge struct {
+	checks  []Check
+	origin  map[string]string
+	visited map[string]bool
+}
+
+func (m *configMerge) add(path string, checks []Check) error {
+	for _, check := range checks {
+		if other, ok := m.origin[check.Name]; ok && other != path {
+			return fmt.Errorf("check %s is defined in both %s and %s", check.Name, other, path)
+		}
+		m.origin[check.Name] = path
+		m.checks = append(m.checks, check)
+	}
+	return nil
+}
+
+func (m *configMerge) include(from string, patterns []string) error {
+	paths, err := includedPaths(from, patterns)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if m.visited[absolute] {
+			return fmt.Errorf("%s is included more than once", path)
+		}
+		m.visited[absolute] = true
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var part includedConfig
+		if err := yaml.UnmarshalStrict(data, &part); err != nil {
+			return fmt.Errorf("%s: %v", path, explainConfigError(err, reflect.TypeOf(part)))
+		}
+		generated, err := expandTemplates(part.Templates)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := m.add(path, append(part.Checks, generated...)); err != nil {
+			return err
+		}
+		if err := m.include(path, part.Include); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includeConfigs appends the checks of the files the configuration includes,
+// and of those they include in turn. Included files hold only checks,
+// templates and includes.
+func includeConfigs(path string, checks []Check, include []string) ([]Check, error) {
+	m := configMerge{origin: make(map[string]string), visited: make(map[string]bool)}
+	if absolute, err := filepath.Abs(path); err == nil {
+		m.visited[absolute] = true
+	}
+	if err := m.add(path, checks); err != nil {
+		return nil, err
+	}
+	if err := m.include(path, include); err != nil {
+		return nil, err
+	}
+	return m.checks, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// initField is a setting the init wizard asks for
+type initField struct {
+	question string
+	required bool
+	set      func(check *Check, answer string) error
+}
+
+// initType describes a check type for the init wizard
+type initType struct {
+	name        string
+	description string
+	dest        string
+	fields      []string
+}
+
+var initTypes = []initType{
+	{"http", "HTTP(S) request expecting a success", "URL, e.g. https://example.com", []string{"expect_sha256", "ocsp"}},
+	{"icmp", "ping", "host name or address", nil},
+	{"tls", "TLS configuration audit", "host[:port]", []string{"min_tls"}},
+	{"dnssec", "DNSSEC validation of a record", "domain name", []string{"record_type", "resolver"}},
+	{"ptr", "reverse DNS of an address", "IP address", []string{"expect", "resolver"}},
+	{"rdap", "domain registration expiry", "domain name", []string{"expiry_days", "expect"}},
+	{"rtsp", "RTSP video stream", "rtsp:// URL", nil},
+	{"hls", "HLS video stream", "playlist URL", nil},
+	{"sip", "SIP OPTIONS request", "sip: URI, e.g. sip:pbx.example.com", []string{"expect_status"}},
+	{"ldap", "LDAP bind and search", "ldap:// or ldaps:// URL", []string{"username", "password", "search_base"}},
+	{"kafka", "Kafka cluster metadata", "broker host:port", []string{"topic"}},
+	{"elasticsearch", "Elasticsearch/OpenSearch cluster health", "URL, e.g. http://localhost:9200", []string{"username", "password"}},
+	{"smb", "SMB share", "smb://host/share", []string{"username", "password"}},
+	{"ftp", "FTP, FTPS or SFTP login", "ftp://, ftps:// or sftp:// URL", []string{"username", "password"}},
+	{"rdp", "RDP negotiation", "host[:port]", []string{"credssp"}},
+	{"modbus", "Modbus TCP holding register", "host[:port]", []string{"unit", "register", "min", "max"}},
+	{"coap", "CoAP GET", "coap:// or coaps:// URL", []string{"expect_status", "psk_identity", "psk"}},
+	{"dhcp", "DHCP offer", "interface name, e.g. eth0", []string{"expect"}},
+	{"ports", "open TCP ports", "host name or address", []string{"ports", "open"}},
+}
+
+var initFields = map[string]initField{
+	"expect_sha256": {"Expected SHA-256 of the body", false, func(check *Check, s string) error {
+		check.ExpectSHA256 = s
+		return nil
+	}},
+	"ocsp": {"Check certificate revocation with OCSP (y/n)", false, func(check *Check, s string) error {
+		var err error
+		check.OCSP, err = parseYesNo(s)
+		return err
+	}},
+	"min_tls": {"Lowest accepted TLS version, e.g. 1.2", false, func(check *Check, s string) error {
+		check.MinTLS = s
+		return nil
+	}},
+	"record_type": {"Record type, e.g. A", false, func(check *Check, s string) error {
+		check.RecordType = strings.ToUpper(s)
+		return nil
+	}},
+	"resolver": {"Resolver (host:port)", false, func(check *Check, s string) error {
+		check.Resolver = s
+		return nil
+	}},
+	"expect": {"Expected answer", false, func(check *Check, s string) error {
+		check.Expect = s
+		return nil
+	}},
+	"expiry_days": {"Days before the expiry the check fails", false, func(check *Check, s string) error {
+		var err error
+		check.ExpiryDays, err = strconv.Atoi(s)
+		return err
+	}},
+	"expect_status": {"Accepted response codes, comma-separated", false, func(check *Check, s string) error {
+		check.ExpectStatus = nil
+		for _, part := range strings.Split(s, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+			check.ExpectStatus = append(check.ExpectStatus, status)
+		}
+		return nil
+	}},
+	"username": {"User name", false, func(check *Check, s string) error {
+		check.Username = s
+		return nil
+	}},
+	"password": {"Password", false, func(check *Check, s string) error {
+		check.Password = s
+		return nil
+	}},
+	"search_base": {"DN of the entry to read", false, func(check *Check, s string) error {
+		check.SearchBase = s
+		return nil
+	}},
+	"topic": {"Required topic", false, func(check *Check, s string) error {
+		check.Topic = s
+		return nil
+	}},
+	"credssp": {"Start network level authentication (y/n)", false, func(check *Check, s string) error {
+		var err error
+		check.CredSSP, err = parseYesNo(s)
+		return err
+	}},
+	"unit": {"Unit identifier", false, func(check *Check, s string) error {
+		var err error
+		check.Unit, err = strconv.Atoi(s)
+		return err
+	}},
+	"register": {"Holding register", false, func(check *Check, s string) error {
+		var err error
+		check.Register, err = strconv.Atoi(s)
+		return err
+	}},
+	"min": {"Lowest accepted value", false, func(check *Check, s string) error {
+		value, err := strconv.ParseFloat(s, 64)
+		check.Min = &value
+		return err
+	}},
+	"max": {"Highest accepted value", false, func(check *Check, s string) error {
+		value, err := strconv.ParseFloat(s, 64)
+		check.Max = &value
+		return err
+	}},
+	"psk_identity": {"Pre-shared key identity (coaps)", false, func(check *Check, s string) error {
+		check.PSKIdentity = s
+		return nil
+	}},
+	"psk": {"Pre-shared key (coaps)", false, func(check *Check, s string) error {
+		check.PSK = s
+		return nil
+	}},
+	"ports": {"Ports to probe, e.g. 22,80,8000-8010", true, func(check *Check, s string) error {
+		_, err := parsePorts(s)
+		check.Ports = s
+		return err
+	}},
+	"open": {"Ports expected to be open, if not all of them, or none", false, func(check *Check, s string) error {
+		_, err := parseOpenPorts(s)
+		check.Open = s
+		return err
+	}},
+}
+
+// Comments annotating the settings in the written configuration
+var initComments = map[string]string{
+	"name":          "shown in the dashboard, unique",
+	"type":          "kind of probe",
+	"dest":          "what is probed",
+	"repeat":        "how often the check runs",
+	"timeout":       "a run taking longer fails",
+	"group":         "checks are listed by group",
+	"expect_sha256": "body must hash to this",
+	"ocsp":          "certificate must not be revoked",
+	"min_tls":       "older TLS versions fail the check",
+	"expect":        "expected answer",
+	"expiry_days":   "fails this many days before the expiry",
+	"expect_status": "accepted response codes",
+	"search_base":   "entry read after binding",
+	"topic":         "topic that must exist",
+	"credssp":       "start network level authentication",
+	"register":      "holding register read",
+	"min":           "lowest accepted value",
+	"max":           "highest accepted value",
+	"ports":         "ports probed",
+	"open":          "ports expected to be open",
+}
+
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("answer y or n")
+}
+
+// wizard asks questions on in and prints them to out
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask returns the trimmed answer, or the default for an empty one
+func (w wizard) ask(question, defaultAnswer string) (string, error) {
+	if defaultAnswer != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, defaultAnswer)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return defaultAnswer, nil
+	}
+	return line, nil
+}
+
+// askValid repeats the question until set accepts the answer. Empty answers
+// are accepted when optional.
+func (w wizard) askValid(question, defaultAnswer string, optional bool, set func(string) error) error {
+	for {
+		answer, err := w.ask(question, defaultAnswer)
+		if err != nil {
+			return err
+		}
+		if answer == "" && optional {
+			return nil
+		}
+		if answer == "" {
+			err = fmt.Errorf("an answer is required")
+		} else {
+			err = set(answer)
+		}
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintln(w.out, "  Invalid:", err)
+	}
+}
+
+func findInitType(name string) (initType, bool) {
+	for i, t := range initTypes {
+		if name == t.name || name == strconv.Itoa(i+1) {
+			return t, true
+		}
+	}
+	return initType{}, false
+}
+
+// askCheck walks through the settings of one check
+func (w wizard) askCheck(used map[string]bool) (Check, error) {
+	var check Check
+	var checkType initType
+	err := w.askValid("Type", "http", false, func(s string) error {
+		var ok bool
+		if checkType, ok = findInitType(s); !ok {
+			return fmt.Errorf("unknown type %s", s)
+		}
+		check.CheckType = checkType.name
+		return nil
+	})
+	if err != nil {
+		return check, err
+	}
+	err = w.askValid("Destination, "+checkType.dest, "", false, func(s string) error {
+		if strings.Contains(checkType.dest, "://") || strings.Contains(checkType.dest, "URL") {
+			if u, err := url.Parse(s); err != nil || u.Scheme == "" {
+				return fmt.Errorf("%s is not a URL", s)
+			}
+		}
+		check.Dest = s
+		return nil
+	})
+	if err != nil {
+		return check, err
+	}
+	err = w.askValid("Name", destHost(check.Dest), false, func(s string) error {
+		if used[s] {
+			return fmt.Errorf("%s is already used", s)
+		}
+		check.Name = s
+		return nil
+	})
+	if err != nil {
+		return check, err
+	}
+	used[check.Name] = true
+
+	durations := []struct {
+		question, defaultAnswer string
+		value                   *time.Duration
+	}{
+		{"Repeat every", "30s", &check.Repeat},
+		{"Timeout", "", &check.Timeout},
+	}
+	for _, d := range durations {
+		err := w.askValid(d.question, d.defaultAnswer, d.defaultAnswer == "", func(s string) error {
+			var err error
+			*d.value, err = time.ParseDuration(s)
+			if err == nil && *d.value <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+			if err == nil && d.value == &check.Repeat && *d.value < minRepeat {
+				err = fmt.Errorf("must be at least %v", minRepeat)
+			}
+			return err
+		})
+		if err != nil {
+			return check, err
+		}
+	}
+	if err := w.askValid("Group", "", true, func(s string) error { check.Group = s; return nil }); err != nil {
+		return check, err
+	}
+	for _, key := range checkType.fields {
+		field := initFields[key]
+		question := field.question
+		if !field.required {
+			question += " (optional)"
+		}
+		if err := w.askValid(question, "", !field.required, func(s string) error { return field.set(&check, s) }); err != nil {
+			return check, err
+		}
+	}
+	return check, nil
+}
+
+// formatInitConfig writes the checks as YAML commented with what the
+// settings mean
+func formatInitConfig(checks []Check) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("# Checks created by network-checks init, see readme.md for all settings\n")
+	b.WriteString("checks:\n")
+	for _, check := range checks {
+		data, err := yaml.Marshal([]Check{check})
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := findInitType(check.CheckType); ok {
+			fmt.Fprintf(&b, "  # %s\n", t.description)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			key, _, _ := strings.Cut(strings.TrimLeft(line, "- "), ":")
+			if comment, ok := initComments[key]; ok && strings.Contains(line, ": ") {
+				line += "  # " + comment
+			}
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	// Never leave a configuration that can't be loaded
+	var parsed Checks
+	if err := yaml.UnmarshalStrict(b.Bytes(), &parsed); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func runInit(args []string) int {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	output := flags.String("o", "checks.yml", "file to write the checks to")
+	flags.Parse(args)
+
+	w := wizard{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	if _, err := os.Stat(*output); err == nil {
+		overwrite, err := w.ask(*output+" exists, overwrite it? (y/n)", "n")
+		if yes, _ := parseYesNo(overwrite); err != nil || !yes {
+			return 1
+		}
+	}
+
+	fmt.Println("Check types:")
+	for i, t := range initTypes {
+		fmt.Printf("  %2d. %-13s %s\n", i+1, t.name, t.description)
+	}
+	var checks []Check
+	used := make(map[string]bool)
+	for {
+		fmt.Printf("\nCheck %d\n", len(checks)+1)
+		check, err := w.askCheck(used)
+		if err != nil {
+			fmt.Println("Error reading answer:", err)
+			return 1
+		}
+		checks = append(checks, check)
+		another, err := w.ask("Add another check? (y/n)", "n")
+		if yes, _ := parseYesNo(another); err != nil || !yes {
+			break
+		}
+	}
+
+	data, err := formatInitConfig(checks)
+	if err == nil {
+		err = os.WriteFile(*output, data, 0644)
+	}
+	if err != nil {
+		fmt.Println("Error writing checks:", err)
+		return 1
+	}
+	fmt.Printf("Wrote %d checks to %s\n", len(checks), *output)
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	kafkaMetadataKey     = 3
+	kafkaMetadataVersion = 4
+	kafkaMaxResponse     = 16 << 20
+)
+
+// kafkaReader decodes the primitive types of the Kafka protocol, keeping
+// the first error
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		if r.err == nil {
+			r.err = fmt.Errorf("truncated Kafka response")
+		}
+		return make([]byte, n)
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *kafkaReader) int16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) int32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) int32Array() []int32 {
+	n := r.int32()
+	var a []int32
+	for i := int32(0); i < n && r.err == nil; i++ {
+		a = append(a, r.int32())
+	}
+	return a
+}
+
+func kafkaString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+type kafkaPartition struct {
+	errorCode int16
+	id        int32
+	leader    int32
+	replicas  []int32
+	isr       []int32
+}
+
+type kafkaMetadata struct {
+	brokers    int
+	errorCode  int16
+	found      bool
+	partitions []kafkaPartition
+}
+
+// kafkaFetchMetadata requests the brokers of the cluster and the partitions
+// of the topic, if any
+func kafkaFetchMetadata(conn net.Conn, topic string) (kafkaMetadata, error) {
+	const correlationId = 1
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int16(kafkaMetadataKey))
+	binary.Write(&body, binary.BigEndian, int16(kafkaMetadataVersion))
+	binary.Write(&body, binary.BigEndian, int32(correlationId))
+	body.Write(kafkaString("network-checks"))
+	if topic == "" {
+		binary.Write(&body, binary.BigEndian, int32(0))
+	} else {
+		binary.Write(&body, binary.BigEndian, int32(1))
+		body.Write(kafkaString(topic))
+	}
+	// Don't let the check create the topic
+	body.WriteByte(0)
+
+	request := binary.BigEndian.AppendUint32(nil, uint32(body.Len()))
+	if _, err := conn.Write(append(request, body.Bytes()...)); err != nil {
+		return kafkaMetadata{}, err
+	}
+	var size int32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return kafkaMetadata{}, err
+	}
+	if size < 4 || size > kafkaMaxResponse {
+		return kafkaMetadata{}, fmt.Errorf("invalid Kafka response size %d", size)
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return kafkaMetadata{}, err
+	}
+
+	r := &kafkaReader{b: response}
+	if r.int32() != correlationId {
+		return kafkaMetadata{}, fmt.Errorf("unexpected Kafka correlation id")
+	}
+	var metadata kafkaMetadata
+	r.int32() // throttle time
+	metadata.brokers = int(r.int32())
+	for i := 0; i < metadata.brokers && r.err == nil; i++ {
+		r.int32() // node id
+		r.string()
+		r.int32() // port
+		r.string()
+	}
+	r.string() // cluster id
+	r.int32()  // controller id
+	topics := r.int32()
+	for i := int32(0); i < topics && r.err == nil; i++ {
+		errorCode := r.int16()
+		name := r.string()
+		r.next(1) // internal
+		var partitions []kafkaPartition
+		count := r.int32()
+		for j := int32(0); j < count && r.err == nil; j++ {
+			partitions = append(partitions, kafkaPartition{
+				errorCode: r.int16(),
+				id:        r.int32(),
+				leader:    r.int32(),
+				replicas:  r.int32Array(),
+				isr:       r.int32Array(),
+			})
+		}
+		if name == topic {
+			metadata.found = true
+			metadata.errorCode = errorCode
+			metadata.partitions = partitions
+		}
+	}
+	return metadata, r.err
+}
+
+// runKafkaCheck fetches the metadata of a Kafka broker (host:port) and, with
+// topic, fails when the topic is missing or any of its partitions has no
+// leader
+func runKafkaCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	addr := check.Dest
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "9092")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	metadata, err := kafkaFetchMetadata(conn, check.Topic)
+	checkResult.duration = time.Since(checkResult.runAt)
+
+	offline := 0
+	for _, partition := range metadata.partitions {
+		// Replicas being unavailable (9) leaves the partition writable
+		if partition.leader < 0 || (partition.errorCode != 0 && partition.errorCode != 9) {
+			offline++
+		}
+	}
+	switch {
+	case err != nil:
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+	case metadata.brokers == 0:
+		checkResult.detail = "no brokers in the cluster metadata"
+		checkResult.cause = causeUnexpected
+	case check.Topic == "":
+		checkResult.status = true
+	case !metadata.found || metadata.errorCode == 3:
+		checkResult.detail = fmt.Sprintf("topic %s doesn't exist", check.Topic)
+		checkResult.cause = causeUnexpected
+	case metadata.errorCode != 0:
+		checkResult.detail = fmt.Sprintf("topic %s has error code %d", check.Topic, metadata.errorCode)
+		checkResult.cause = causeUnexpected
+	case offline > 0:
+		checkResult.detail = fmt.Sprintf("%d of %d partitions have no leader", offline, len(metadata.partitions))
+		checkResult.cause = causeUnexpected
+	default:
+		checkResult.status = true
+	}
+	c <- checkResult
+}
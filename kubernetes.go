@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	configMapPrefix      = "configmap:"
+	serviceAccountDir    = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	defaultConfigMapKey  = "checks.yml"
+	kubernetesApiTimeout = 10 * time.Second
+)
+
+// readConfig reads the configuration from a file, a remote source or, for a
+// reference like configmap:<namespace>/<name>[/<key>], from a Kubernetes
+// ConfigMap
+func readConfig(path string) ([]byte, error) {
+	if strings.HasPrefix(path, configMapPrefix) {
+		return readConfigMap(strings.TrimPrefix(path, configMapPrefix))
+	}
+	if isRemoteConfig(path) {
+		return readRemoteConfig(path)
+	}
+	return os.ReadFile(path)
+}
+
+// readConfigMap fetches a key of a ConfigMap from the Kubernetes API using
+// the pod's service account, which needs permission to get the ConfigMap
+func readConfigMap(ref string) ([]byte, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid ConfigMap reference %q, expected <namespace>/<name>[/<key>]", ref)
+	}
+	namespace, name, key := parts[0], parts[1], defaultConfigMapKey
+	if len(parts) == 3 {
+		key = parts[2]
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	client := &http.Client{
+		Timeout:   kubernetesApiTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+
+	req, err := http.NewRequest(http.MethodGet,
+		fmt.Sprintf("https://%s:%s/api/v1/namespaces/%s/configmaps/%s", host, port, namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading ConfigMap %s/%s: %s", namespace, name, resp.Status)
+	}
+
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&configMap); err != nil {
+		return nil, err
+	}
+	data, ok := configMap.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("ConfigMap %s/%s has no key %s", namespace, name, key)
+	}
+	return []byte(data), nil
+}
+
+// kubernetesLabels returns the pod, node and namespace the tool runs in,
+// taken from environment variables set via the downward API
+func kubernetesLabels() []string {
+	var labels []string
+	for _, label := range []struct{ name, env string }{
+		{"pod", "POD_NAME"},
+		{"node", "NODE_NAME"},
+		{"namespace", "POD_NAMESPACE"},
+	} {
+		if value := os.Getenv(label.env); value != "" {
+			labels = append(labels, label.name, value)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Label names valid in Prometheus, syslog structured data and most
+// dashboards
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Names of the fields exports already label results with
+var reservedLabels = map[string]bool{
+	"id": true, "name": true, "type": true, "dest": true, "site": true, "group": true,
+	"status": true, "duration_ms": true, "pod": true, "node": true, "namespace": true,
+	"owner": true, "contact": true, "runbook": true, "severity": true,
+	"cause": true, "mode": true, "consumer": true,
+	"geo_country": true, "geo_asn": true, "geo_isp": true,
+}
+
+// exportedLabels returns the labels of the check along with those of its
+// geo information, if it was looked up
+func (c Check) exportedLabels() map[string]string {
+	if c.geo == nil {
+		return c.Labels
+	}
+	labels := make(map[string]string, len(c.Labels)+3)
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+	for name, value := range map[string]string{"geo_country": c.geo.CountryCode, "geo_asn": c.geo.Asn, "geo_isp": c.geo.Isp} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// labelPairs returns the exported labels of the check as name/value pairs
+// sorted by name
+func (c Check) labelPairs() []string {
+	labels := c.exportedLabels()
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, name, labels[name])
+	}
+	return pairs
+}
+
+// formatLabels renders the labels of the check like env=prod,team=core
+func (c Check) formatLabels() string {
+	pairs := c.labelPairs()
+	var labels []string
+	for i := 0; i < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+"="+pairs[i+1])
+	}
+	return strings.Join(labels, ",")
+}
+
+// ownershipPairs returns the owner, contact and runbook of the check as
+// name/value pairs, leaving out those not set
+func (c Check) ownershipPairs() []string {
+	var pairs []string
+	for _, pair := range [][2]string{{"owner", c.Owner}, {"contact", c.Contact}, {"runbook", c.Runbook}} {
+		if pair[1] != "" {
+			pairs = append(pairs, pair[0], pair[1])
+		}
+	}
+	return pairs
+}
+
+// formatOwnership renders the ownership of the check like owner netops,
+// runbook https://wiki/vpn
+func (c Check) formatOwnership() string {
+	pairs := c.ownershipPairs()
+	var fields []string
+	for i := 0; i < len(pairs); i += 2 {
+		fields = append(fields, pairs[i]+" "+pairs[i+1])
+	}
+	return strings.Join(fields, ", ")
+}
+
+// problemDetail returns what's shown below a failing or degraded check: why
+// it failed, if known, and who owns it
+func (c Check) problemDetail(detail string) string {
+	if ownership := c.formatOwnership(); ownership != "" {
+		if detail == "" {
+			return ownership
+		}
+		return detail + " - " + ownership
+	}
+	return detail
+}
+
+// validateChecks rejects checks sharing a name or id, whose results and
+// history couldn't be told apart, invalid labels and runbooks. An id can't
+// be the name of another check either, as checks are looked up by both.
+func validateChecks(checks []Check) error {
+	names := make(map[string]int)
+	for i, check := range checks {
+		if _, found := names[check.Name]; found {
+			return fmt.Errorf("duplicate check name %s", check.Name)
+		}
+		names[check.Name] = i
+	}
+	ids := make(map[string]bool)
+	identities := make(map[string]bool)
+	for i, check := range checks {
+		if check.ID != "" {
+			if ids[check.ID] {
+				return fmt.Errorf("duplicate check id %s", check.ID)
+			}
+			ids[check.ID] = true
+			if other, found := names[check.ID]; found && other != i {
+				return fmt.Errorf("check %s: id %s is the name of another check", check.Name, check.ID)
+			}
+		}
+		if err := validateVia(check); err != nil {
+			return err
+		}
+		switch check.Connection {
+		case "", connectionReuse, connectionFresh:
+		default:
+			return fmt.Errorf("check %s: unknown connection %q, expected reuse or fresh", check.Name, check.Connection)
+		}
+		// A simple bind with a name and no password is unauthenticated
+		// (RFC 4513 5.1.2) and succeeds on most directories
+		if check.CheckType == "ldap" && check.Username != "" && check.Password == "" {
+			return fmt.Errorf("check %s: username without a password, which binds unauthenticated", check.Name)
+		}
+		if identities[check.identity()] {
+			return fmt.Errorf("check %s: duplicate identity %s", check.Name, check.identity())
+		}
+		identities[check.identity()] = true
+		for name := range check.Labels {
+			if !labelNamePattern.MatchString(name) {
+				return fmt.Errorf("check %s: invalid label %q, use letters, digits and underscores", check.Name, name)
+			}
+			if reservedLabels[name] {
+				return fmt.Errorf("check %s: label %s is reserved", check.Name, name)
+			}
+		}
+		if check.Runbook != "" {
+			if u, err := url.Parse(check.Runbook); err != nil || !u.IsAbs() {
+				return fmt.Errorf("check %s: runbook %q isn't an absolute URL", check.Name, check.Runbook)
+			}
+		}
+	}
+	return nil
+}
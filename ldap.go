@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// LDAP protocol operations (RFC 4511)
+const (
+	ldapBindRequest    = 0x60
+	ldapBindResponse   = 0x61
+	ldapUnbindRequest  = 0x42
+	ldapSearchRequest  = 0x63
+	ldapSearchEntry    = 0x64
+	ldapSearchDone     = 0x65
+	ldapSearchRef      = 0x73
+	ldapSimpleAuth     = 0x80
+	ldapFilterPresent  = 0x87
+	ldapMaxMessageSize = 1 << 20
+)
+
+// LDAP result codes
+const (
+	ldapSuccess      = 0
+	ldapNoSuchObject = 32
+	ldapInvalidCreds = 49
+)
+
+// BER tags LDAP uses besides those of SNMP
+const (
+	berBoolean    = 0x01
+	berEnumerated = 0x0a
+)
+
+type berElement struct {
+	tag   byte
+	value []byte
+}
+
+// berRead reads one element of a BER stream
+func berRead(reader *bufio.Reader) (berElement, error) {
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	first, err := reader.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		length = 0
+		for i := 0; i < int(first&0x7f); i++ {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return berElement{}, err
+			}
+			length = length<<8 | int(b)
+		}
+		if first&0x7f > 4 || length > ldapMaxMessageSize {
+			return berElement{}, fmt.Errorf("BER element too large")
+		}
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag, value}, nil
+}
+
+// berElements splits the content of a constructed element
+func berElements(content []byte) ([]berElement, error) {
+	var elements []berElement
+	reader := bufio.NewReader(bytes.NewReader(content))
+	for {
+		element, err := berRead(reader)
+		if err == io.EOF {
+			return elements, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid BER content: %v", err)
+		}
+		elements = append(elements, element)
+	}
+}
+
+// ldapConn exchanges LDAP messages over a connection
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageId int64
+}
+
+func (l *ldapConn) send(op []byte) error {
+	l.messageId++
+	_, err := l.conn.Write(berTLV(berSequence, berInt(berInteger, l.messageId), op))
+	return err
+}
+
+// receive reads the protocol operation of the next message
+func (l *ldapConn) receive() (berElement, error) {
+	message, err := berRead(l.reader)
+	if err != nil {
+		return berElement{}, err
+	}
+	elements, err := berElements(message.value)
+	if err != nil {
+		return berElement{}, err
+	}
+	if message.tag != berSequence || len(elements) < 2 {
+		return berElement{}, fmt.Errorf("invalid LDAP message")
+	}
+	return elements[1], nil
+}
+
+// ldapResult returns the result code and diagnostic message of an LDAPResult
+func ldapResult(op berElement) (int, string, error) {
+	elements, err := berElements(op.value)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(elements) < 3 || elements[0].tag != berEnumerated || len(elements[0].value) == 0 {
+		return 0, "", fmt.Errorf("invalid LDAP result")
+	}
+	code := 0
+	for _, b := range elements[0].value {
+		code = code<<8 | int(b)
+	}
+	return code, string(elements[2].value), nil
+}
+
+func ldapError(operation string, code int, message string) error {
+	names := map[int]string{
+		ldapNoSuchObject: "no such object",
+		ldapInvalidCreds: "invalid credentials",
+	}
+	description := names[code]
+	if description == "" {
+		description = fmt.Sprintf("result %d", code)
+	}
+	if message != "" {
+		description += ": " + message
+	}
+	return fmt.Errorf("%s failed with %s", operation, description)
+}
+
+// bind performs a simple bind, anonymous without a DN
+func (l *ldapConn) bind(dn string, password string) error {
+	err := l.send(berTLV(ldapBindRequest,
+		berInt(berInteger, 3),
+		berTLV(berOctetString, []byte(dn)),
+		berTLV(ldapSimpleAuth, []byte(password))))
+	if err != nil {
+		return err
+	}
+	op, err := l.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return fmt.Errorf("unexpected LDAP response %#x to bind", op.tag)
+	}
+	code, message, err := ldapResult(op)
+	if err == nil && code != ldapSuccess {
+		err = ldapError("bind", code, message)
+	}
+	return err
+}
+
+// searchBase reads the entry of a DN without any attributes
+func (l *ldapConn) searchBase(dn string) error {
+	err := l.send(berTLV(ldapSearchRequest,
+		berTLV(berOctetString, []byte(dn)),
+		berInt(berEnumerated, 0), // baseObject scope
+		berInt(berEnumerated, 0), // never deref aliases
+		berInt(berInteger, 1),
+		berInt(berInteger, 0),
+		berTLV(berBoolean, []byte{0}),
+		berTLV(ldapFilterPresent, []byte("objectClass")),
+		berTLV(berSequence, berTLV(berOctetString, []byte("1.1")))))
+	if err != nil {
+		return err
+	}
+	found := false
+	for {
+		op, err := l.receive()
+		if err != nil {
+			return err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			found = true
+		case ldapSearchRef:
+		case ldapSearchDone:
+			code, message, err := ldapResult(op)
+			if err != nil {
+				return err
+			}
+			if code != ldapSuccess {
+				return ldapError("search", code, message)
+			}
+			if !found {
+				return fmt.Errorf("search of %s returned no entry", dn)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected LDAP response %#x to search", op.tag)
+		}
+	}
+}
+
+// runLdapCheck binds to an LDAP server (ldap://host[:port] or
+// ldaps://host[:port]), anonymously or as username with password, and
+// optionally reads the entry of search_base. The latency covers connecting
+// and the bind.
+func runLdapCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(err error) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+	}
+
+	u, err := url.Parse(check.Dest)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		fail(fmt.Errorf("invalid LDAP URL %s", check.Dest))
+		return
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer conn.Close()
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			fail(err)
+			return
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	l := &ldapConn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := l.bind(check.Username, check.Password); err != nil {
+		fail(err)
+		return
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	if check.SearchBase != "" {
+		if err := l.searchBase(check.SearchBase); err != nil {
+			checkResult.detail = err.Error()
+			checkResult.cause = errorCause(err)
+			c <- checkResult
+			return
+		}
+	}
+	l.send(berTLV(ldapUnbindRequest))
+	checkResult.status = true
+	c <- checkResult
+}
@@ -1,75 +1,439 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"github.com/fatih/color"
 	"gopkg.in/yaml.v2"
+	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"reflect"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 type Check struct {
+	// Identifies the check across reloads and in recordings, the name by
+	// default
+	ID        string        `yaml:"id,omitempty"`
 	Name      string        `yaml:"name"`
 	CheckType string        `yaml:"type"`
 	Dest      string        `yaml:"dest"`
 	Repeat    time.Duration `yaml:"repeat"`
-	id        int
+	// Bounds of the interval of an adaptive check, which probes less often
+	// while it's stable and tightens when it isn't
+	RepeatMin time.Duration `yaml:"repeat_min,omitempty"`
+	RepeatMax time.Duration `yaml:"repeat_max,omitempty"`
+	Via       string        `yaml:"via,omitempty"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+	Overlap   string        `yaml:"overlap,omitempty"`
+	// Down this long, the check probes less and less often, up to every
+	// backoff_max, until it recovers
+	BackoffAfter time.Duration `yaml:"backoff_after,omitempty"`
+	BackoffMax   time.Duration `yaml:"backoff_max,omitempty"`
+	Group        string        `yaml:"group,omitempty"`
+	Tags         []string      `yaml:"tags,omitempty"`
+	// Key/value pairs exports, alerts and the API label results with
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Who to turn to when the check fails and what to do, shown with
+	// failures and sent with alerts
+	Owner     string   `yaml:"owner,omitempty"`
+	Contact   string   `yaml:"contact,omitempty"`
+	Runbook   string   `yaml:"runbook,omitempty"`
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// Part of the network the check is in, e.g. a VLAN, grouping it on the
+	// topology map
+	Segment string `yaml:"segment,omitempty"`
+	// Latency above which a successful run is degraded, e.g. an SLO
+	DegradedAbove time.Duration `yaml:"degraded_above,omitempty"`
+	// How much a failure matters: critical, warning (the default) or info
+	Severity string `yaml:"severity,omitempty"`
+	// Deprecated: use severity: critical
+	Critical bool `yaml:"critical,omitempty"`
+	// How much the check counts in the health score, 1 by default and 0 to
+	// leave it out
+	Weight *float64 `yaml:"weight,omitempty"`
+	// Disabled checks are skipped when loading the configuration
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// DNS checks
+	Resolver   string `yaml:"resolver,omitempty"`
+	RecordType string `yaml:"record_type,omitempty"`
+	// Expected answer, e.g. the host name of a ptr check
+	Expect string `yaml:"expect,omitempty"`
+	// Days before an expiration the check starts failing
+	ExpiryDays int `yaml:"expiry_days,omitempty"`
+	// Check the revocation of the certificate of https checks with OCSP
+	OCSP       bool `yaml:"ocsp,omitempty"`
+	OCSPStaple bool `yaml:"ocsp_staple,omitempty"`
+	// Expected certificate of http and tls checks, by the base64 SHA-256 of
+	// its public key or by its serial
+	PinSPKI   []string `yaml:"pin_spki,omitempty"`
+	PinSerial []string `yaml:"pin_serial,omitempty"`
+	// Lowest TLS version a tls check accepts
+	MinTLS string `yaml:"min_tls,omitempty"`
+	// How an icmp check pings: auto, api, raw, dgram, udp or exec
+	ICMPMode string `yaml:"icmp_mode,omitempty"`
+	// Pings an icmp check sends every run and their spacing
+	Count    int           `yaml:"count,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Hops a path check runs in order, stopping at the first failing one
+	Hops []PathHop `yaml:"hops,omitempty"`
+	// Requests of a multi-step http check, e.g. a login, resolved against
+	// dest and sharing cookies
+	Steps []HTTPStep `yaml:"steps,omitempty"`
+	// Whether an http check reuses a pooled connection (reuse, the default)
+	// or sets up a new one every run (fresh)
+	Connection string `yaml:"connection,omitempty"`
+	// Smallest body and download throughput (per second) an http check
+	// accepts, e.g. 1kB and 1MB
+	MinSize       byteSize `yaml:"min_size,omitempty"`
+	MinThroughput byteSize `yaml:"min_throughput,omitempty"`
+	// Expected SHA-256 of the body of an http check
+	ExpectSHA256 string `yaml:"expect_sha256,omitempty"`
+	// Accepted response codes, e.g. of a sip check
+	ExpectStatus []int `yaml:"expect_status,omitempty"`
+	// Credentials, e.g. the bind DN of an ldap check
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Entry an ldap check reads after binding
+	SearchBase string `yaml:"search_base,omitempty"`
+	// Topic a kafka check requires
+	Topic string `yaml:"topic,omitempty"`
+	// Start network level authentication in an rdp check
+	CredSSP bool `yaml:"credssp,omitempty"`
+	// Holding register a modbus check reads from the unit
+	Unit     int `yaml:"unit,omitempty"`
+	Register int `yaml:"register,omitempty"`
+	// Range the value read by a check must be in
+	Min *float64 `yaml:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty"`
+	// Pre-shared key of a coaps check
+	PSKIdentity string `yaml:"psk_identity,omitempty"`
+	PSK         string `yaml:"psk,omitempty"`
+	// Ports a ports check probes, and those expected to be open
+	Ports      string `yaml:"ports,omitempty"`
+	Open       string `yaml:"open,omitempty"`
+	id         int
+	geo        *GeoInfo
+	site       string
+	remote     bool
+	generation int
+}
+
+// identity returns what the state and history of the check are kept by, so
+// reordering or removing checks doesn't attribute them to another check
+func (c Check) identity() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return c.Name
 }
 
 type Checks struct {
-	Checks []Check `yaml:"checks"`
+	Checks    []Check         `yaml:"checks"`
+	GeoIP     GeoIPConfig     `yaml:"geoip,omitempty"`
+	Anomaly   AnomalyConfig   `yaml:"anomaly,omitempty"`
+	Histogram HistogramConfig `yaml:"histogram,omitempty"`
+	Display   DisplayConfig   `yaml:"display,omitempty"`
+	RRD       RRDConfig       `yaml:"rrd,omitempty"`
+	Consul    ConsulConfig    `yaml:"consul,omitempty"`
+	Heartbeat HeartbeatConfig `yaml:"heartbeat,omitempty"`
+	Syslog    SyslogConfig    `yaml:"syslog,omitempty"`
+	SNMP      SNMPConfig      `yaml:"snmp,omitempty"`
+	Capture   CaptureConfig   `yaml:"capture,omitempty"`
+	Budget    BudgetConfig    `yaml:"budget,omitempty"`
+	RateLimit RateLimitConfig `yaml:"rate_limit,omitempty"`
+	Retention RetentionConfig `yaml:"retention,omitempty"`
+	Cluster   ClusterConfig   `yaml:"cluster,omitempty"`
+	MQTT      MQTTConfig      `yaml:"mqtt,omitempty"`
+	// Checks probing the same address share the probe
+	ShareProbes bool `yaml:"share_probes,omitempty"`
+
+	// Failures within this window are grouped into one incident
+	IncidentWindow time.Duration `yaml:"incident_window,omitempty"`
+	// Check subsets and defaults for different networks
+	Profiles []Profile `yaml:"profiles,omitempty"`
+	// Checks generated from one definition and a list of variables
+	Templates []CheckTemplate `yaml:"templates,omitempty"`
+	// Files with more checks, relative to this one, may be glob patterns
+	Include []string `yaml:"include,omitempty"`
+	// Run every check once before showing results, alerting and reporting
+	// ready
+	Warmup bool `yaml:"warmup,omitempty"`
+	// No alerts are sent for this long after the start
+	StartupGrace time.Duration `yaml:"startup_grace,omitempty"`
+	// Alerts of matching checks are muted in these time ranges
+	Silences []Silence `yaml:"silences,omitempty"`
 }
 
 func loadChecksFromYaml(path string) (Checks, error) {
-	data, err := os.ReadFile(path)
+	data, err := readConfig(path)
 	if err != nil {
 		return Checks{}, err
 	}
+	// Unknown fields are typos, which would silently leave settings unset
 	var checks Checks
-	err = yaml.Unmarshal(data, &checks)
+	err = yaml.UnmarshalStrict(data, &checks)
+	if err != nil {
+		return Checks{}, explainConfigError(err, reflect.TypeOf(checks))
+	}
+	generated, err := expandTemplates(checks.Templates)
 	if err != nil {
 		return Checks{}, err
 	}
-	for i, _ := range checks.Checks {
-		checks.Checks[i].id = i
+	all, err := includeConfigs(path, append(checks.Checks, generated...), checks.Include)
+	if err != nil {
+		return Checks{}, err
 	}
+	if err := validateChecks(all); err != nil {
+		return Checks{}, err
+	}
+	var enabled []Check
+	for _, check := range all {
+		if check.Enabled == nil || *check.Enabled {
+			check.id = len(enabled)
+			enabled = append(enabled, check)
+		}
+	}
+	checks.Checks = enabled
 	return checks, nil
 }
 
+const (
+	// Repeat of checks that don't set one
+	defaultRepeat = 30 * time.Second
+	// Checks may not run more often, so a typo can't flood a target
+	minRepeat = time.Second
+)
+
+// validateRepeats defaults missing repeat intervals and rejects ones shorter
+// than minRepeat, including bare numbers, which YAML reads as nanoseconds
+func validateRepeats(checks []Check) error {
+	for i := range checks {
+		check := &checks[i]
+		switch {
+		case check.Repeat == 0:
+			check.Repeat = defaultRepeat
+		case check.Repeat < time.Millisecond:
+			return fmt.Errorf("check %s: repeat %v is shorter than %v, durations need a unit, e.g. 30s or 1m30s",
+				check.Name, check.Repeat, minRepeat)
+		case check.Repeat < minRepeat:
+			return fmt.Errorf("check %s: repeat %v is shorter than %v", check.Name, check.Repeat, minRepeat)
+		}
+		if check.Timeout < 0 {
+			return fmt.Errorf("check %s: timeout %v is negative", check.Name, check.Timeout)
+		}
+	}
+	return nil
+}
+
+// deadline returns how long a single run of the check may take. Unless set
+// explicitly, a run must finish before the next one is due.
+func (check Check) deadline() time.Duration {
+	if check.Timeout > 0 {
+		return check.Timeout
+	}
+	if check.Repeat > 0 {
+		return check.tick()
+	}
+	return 30 * time.Second
+}
+
 type CheckResult struct {
 	check     Check
 	status    bool
 	runAt     time.Time
 	duration  time.Duration
 	execCount int
+	// Traffic generated by the run on the local network
+	bytes int64
+	// Why the check failed or is degraded, if known
+	detail string
+	// What the failure is classified as, e.g. dns_error or timeout
+	cause string
+	// Working with reduced redundancy, e.g. a yellow cluster
+	degraded bool
+	// Setup of a new connection (DNS, TCP, TLS), zero when one was reused
+	setup time.Duration
+	// Size of the body of an http check and its download throughput in
+	// bytes per second
+	size       int64
+	throughput float64
+	// Loss, latency and jitter of an icmp burst
+	burst *burstStats
+	// How the check probed, e.g. the ICMP mode
+	mode string
+	// How the address of the dest was resolved, nil when it wasn't
+	dns *dnsLookup
 }
 
 type CheckResultStat struct {
-	last10Durations  []time.Duration
-	last100Durations []time.Duration
-	last50Statuses   []bool
+	// Latencies of the short and long averaging windows and statuses of the
+	// runs the loss is compared to the baseline over, the latest first
+	shortDurations []time.Duration
+	longDurations  []time.Duration
+	recentStatuses []bool
+	timeouts       int
+	deviation      string
+	detector       anomalyDetector
+	anomalous      bool
+	history        []historySample
+	bytes          int64
+	// Totals since the start, for the summary of a bounded run
+	failures      int
+	causes        map[string]int
+	minDuration   time.Duration
+	maxDuration   time.Duration
+	totalDuration time.Duration
+	// Connection setup of the latest run that opened a connection
+	lastSetup time.Duration
+	// Resolutions of the dest served from the DNS cache or not, and the
+	// latest one
+	dnsHits, dnsMisses int
+	lastDns            *dnsLookup
+	// Since when a local check is down, and the interval it backs off to
+	backoff   time.Duration
+	downSince time.Time
+	// Current interval of an adaptive check and its stable runs since the
+	// last change
+	adaptiveInterval time.Duration
+	stableRuns       int
+	// Since when the check is ok, degraded or down, and when it last failed
+	stateSince  time.Time
+	lastFailure time.Time
+}
+
+// Connection modes of http checks
+const (
+	connectionReuse = "reuse"
+	connectionFresh = "fresh"
+)
+
+// Bodies of http checks are read up to this size, or min_size if larger
+const maxHttpBodySize = 256 << 20
+
+// Bytes of a body not needed by the check read before closing it, more
+// aren't worth keeping the connection for
+const httpDrainSize = 64 << 10
+
+// readsBody reports whether the http check needs the body of the response
+func (c Check) readsBody() bool {
+	return c.MinSize > 0 || c.MinThroughput > 0 || c.ExpectSHA256 != ""
 }
 
-func runHttpCheck(check Check, c chan CheckResult) {
+func runHttpCheck(ctx context.Context, check Check, c chan CheckResult) {
+	if len(check.Steps) > 0 {
+		runHttpSteps(ctx, check, c)
+		return
+	}
+	if check.Via != "" {
+		runRemoteHttpCheck(ctx, check, c)
+		return
+	}
+
+	// The connection was validated with the configuration
+	client := httpClient
+	if check.Connection == connectionFresh {
+		client = freshHttpClient
+	}
+
+	ctx, transferred := withTrafficTrace(ctx)
+	ctx, setup := withSetupTrace(ctx)
+	ctx, lookup := withLookupTrace(ctx)
 	runAt := time.Now()
-	resp, err := http.Get(check.Dest)
+	var resp *http.Response
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.Dest, nil)
+	if err == nil {
+		resp, err = client.Do(req)
+	}
 	duration := time.Since(runAt)
+	// Reused connections measure the steady state, without the setup of the
+	// occasional new connection
+	if check.Connection != connectionFresh {
+		duration -= setup()
+	}
+	var bodyHash string
+	var size int64
+	var throughput float64
+	truncated := false
+	if err == nil && check.readsBody() {
+		// The body is read for its size, throughput or hash, up to a limit
+		hash := sha256.New()
+		body := io.Discard
+		if check.ExpectSHA256 != "" {
+			body = hash
+		}
+		limit := max(maxHttpBodySize, int64(check.MinSize))
+		started := time.Now()
+		size, err = io.Copy(body, io.LimitReader(resp.Body, limit+1))
+		if transfer := time.Since(started); transfer > 0 {
+			throughput = float64(size) / transfer.Seconds()
+		}
+		if size > limit {
+			size, truncated = limit, true
+		}
+		if err == nil {
+			bodyHash = hex.EncodeToString(hash.Sum(nil))
+		}
+		resp.Body.Close()
+	} else if err == nil {
+		// A short rest of the body is read so that the connection can be
+		// reused
+		io.CopyN(io.Discard, resp.Body, httpDrainSize)
+		resp.Body.Close()
+	}
 
 	checkResult := CheckResult{
 		check:    check,
 		runAt:    runAt,
 		duration: duration,
+		bytes:    transferred(),
+		setup:    setup(),
+		dns:      lookup(),
+
+		size:       size,
+		throughput: throughput,
 	}
 
-	if err != nil || resp.StatusCode != 200 {
-		checkResult.status = false
+	if err != nil {
+		checkResult.cause = errorCause(err)
+	} else if resp.StatusCode != 200 {
+		checkResult.cause = httpStatusCause(resp.StatusCode)
+	} else if check.MinSize > 0 && size < int64(check.MinSize) {
+		checkResult.detail = fmt.Sprintf("body is %s, expected at least %s", formatBytes(size), formatBytes(int64(check.MinSize)))
+		checkResult.cause = causeUnexpected
+	} else if check.MinThroughput > 0 && throughput < float64(check.MinThroughput) {
+		checkResult.detail = fmt.Sprintf("downloaded at %s/s, expected at least %s/s",
+			formatBytes(int64(throughput)), formatBytes(int64(check.MinThroughput)))
+		checkResult.cause = causeUnexpected
+	} else if check.ExpectSHA256 != "" && truncated {
+		checkResult.detail = fmt.Sprintf("body is larger than %s, too large to check its sha256", formatBytes(size))
+		checkResult.cause = causeUnexpected
+	} else if check.ExpectSHA256 != "" && !strings.EqualFold(bodyHash, check.ExpectSHA256) {
+		checkResult.detail = fmt.Sprintf("body sha256 is %s, expected %s", bodyHash, check.ExpectSHA256)
+		checkResult.cause = causeUnexpected
+	} else if err := checkPins(resp.TLS, check); err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = causeTLS
+	} else if check.OCSP && resp.TLS != nil {
+		if err := checkRevocation(ctx, resp.TLS, check.OCSPStaple); err != nil {
+			checkResult.detail = err.Error()
+			checkResult.cause = causeTLS
+		} else {
+			checkResult.status = true
+		}
 	} else {
 		checkResult.status = true
 	}
@@ -77,92 +441,83 @@ func runHttpCheck(check Check, c chan CheckResult) {
 	c <- checkResult
 }
 
-func runIcmpCheck(check Check, c chan CheckResult) {
+func runIcmpCheck(ctx context.Context, check Check, c chan CheckResult) {
+	mode, err := icmpMode(check)
+	if err != nil {
+		c <- CheckResult{check: check, runAt: time.Now(), detail: err.Error()}
+		return
+	}
+	if check.Count > 1 {
+		runIcmpBurst(ctx, check, mode, c)
+		return
+	}
+	if mode != icmpExec {
+		runNativeIcmpCheck(ctx, check, mode, c)
+		return
+	}
 	var cmd *exec.Cmd
 	var pingOutput bytes.Buffer
 
+	// Remote probes are always executed on Unix-like hosts
+	goos := runtime.GOOS
+	if check.Via != "" {
+		goos = "linux"
+	}
+
 	// Detect the OS and set the appropriate ping command
-	if runtime.GOOS == "windows" {
+	var args []string
+	if goos == "windows" {
 		// On Windows, use -n for count and -w for timeout (in milliseconds)
-		cmd = exec.Command("ping", "-n", "1", "-w", "1000", check.Dest)
+		args = []string{"-n", "1", "-w", "1000", check.Dest}
 	} else {
 		// On Unix-like systems (Linux, macOS), use -c for count and -W for timeout (in seconds)
-		cmd = exec.Command("ping", "-c", "1", "-W", "1", check.Dest)
+		args = []string{"-c", "1", "-W", "1", check.Dest}
+	}
+	cmd, err = pingCommand(ctx, check.Via, goos, args...)
+	if err != nil {
+		c <- CheckResult{check: check, runAt: time.Now(), status: false}
+		return
 	}
 
 	// Capture the output
 	cmd.Stdout = &pingOutput
 
 	runAt := time.Now()
-	err := cmd.Run()
+	err = cmd.Run()
 	duration := time.Since(runAt)
 
 	checkResult := CheckResult{
 		check: check,
 		runAt: runAt,
+		mode:  icmpExec,
 	}
-
-	// Parse the ping command output to get the actual round-trip time (RTT)
-	if err == nil {
-		rtt, parseErr := parsePingOutput(pingOutput.String())
-		if parseErr == nil {
-			checkResult.duration = rtt // Set the RTT as the actual duration
-			checkResult.status = true
-		} else {
-			checkResult.status = false
+	// Remote probes don't load the local network
+	if check.Via == "" {
+		probeBytes := int64(icmpProbeBytes)
+		if goos == "windows" {
+			probeBytes = icmpProbeBytesWindows
 		}
+		checkResult.bytes = probeBytes
+		if err == nil {
+			checkResult.bytes += probeBytes
+		}
+	}
+
+	// The round-trip time of the reply is the actual duration
+	if rtts := parsePingReplies(pingOutput.String()); err == nil && len(rtts) > 0 {
+		checkResult.duration = rtts[0]
+		checkResult.status = true
 	} else {
-		checkResult.status = false
 		checkResult.duration = duration // Fallback to the execution time if an error occurs
+		if err == nil {
+			checkResult.detail = "no reply in the ping output"
+			checkResult.cause = causeTimeout
+		}
 	}
 
 	c <- checkResult
 }
 
-func parsePingOutput(output string) (time.Duration, error) {
-	scanner := bufio.NewScanner(strings.NewReader(output))
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// macOS format: round-trip min/avg/max/stddev = 8.390/8.390/8.390/0.000 ms
-		if runtime.GOOS != "windows" && strings.Contains(line, "round-trip") {
-			parts := strings.Split(line, "=")
-			if len(parts) < 2 {
-				continue // Skip this line if it doesn't have the expected format
-			}
-			metrics := strings.Fields(parts[1])
-			if len(metrics) < 1 {
-				continue // Skip if there are not enough fields
-			}
-			avgRTTStr := strings.Split(metrics[0], "/")[1] // Extract the average RTT
-			if avgRTTStr == "" {
-				continue // Ensure the average RTT string exists
-			}
-			rtt, err := strconv.ParseFloat(avgRTTStr, 64)
-			if err != nil {
-				return 0, fmt.Errorf("error parsing RTT: %v", err)
-			}
-			return time.Duration(rtt*1e6) * time.Nanosecond, nil // Convert ms to nanoseconds
-		}
-
-		// Windows format: Minimum = 0ms, Maximum = 0ms, Average = 0ms
-		if runtime.GOOS == "windows" && strings.Contains(line, "Average =") {
-			parts := strings.Split(line, "Average =")
-			if len(parts) < 2 {
-				continue // Skip if the line doesn't have the expected format
-			}
-			rttStr := strings.TrimSpace(parts[1]) // Extract the RTT value (e.g., 1ms)
-			rttStr = strings.Replace(rttStr, "ms", "", 1)
-			rtt, err := strconv.Atoi(rttStr)
-			if err != nil {
-				return 0, fmt.Errorf("error parsing Windows RTT: %v", err)
-			}
-			return time.Duration(rtt) * time.Millisecond, nil // Convert ms to duration
-		}
-	}
-	return 0, fmt.Errorf("RTT not found in output")
-}
-
 func formatDuration(d time.Duration) string {
 	if d < time.Second {
 		// Display in milliseconds if less than 1 second
@@ -185,6 +540,29 @@ func averageDuration(durations []time.Duration) time.Duration {
 	return total / time.Duration(len(durations))
 }
 
+// Relative change of the short window average against the long one that
+// is still considered steady
+const trendTolerance = 0.1
+
+// trend compares the recent average latency to the long term one and returns
+// an arrow pointing up when latency grows, down when it drops.
+func trend(stat CheckResultStat) (string, *color.Color) {
+	// Without enough history both averages are the same few samples
+	if len(stat.longDurations) <= len(stat.shortDurations) {
+		return " ", color.New(color.FgWhite)
+	}
+	recent := averageDuration(stat.shortDurations)
+	longTerm := averageDuration(stat.longDurations)
+	switch {
+	case float64(recent) > float64(longTerm)*(1+trendTolerance):
+		return "↑", color.New(color.FgRed)
+	case float64(recent) < float64(longTerm)*(1-trendTolerance):
+		return "↓", color.New(color.FgGreen)
+	default:
+		return "→", color.New(color.FgWhite)
+	}
+}
+
 func prependSlice(element interface{}, slice interface{}) interface{} {
 	switch s := slice.(type) {
 	case []time.Duration:
@@ -216,101 +594,590 @@ func limitSlice(slice interface{}, maxLength int) interface{} {
 	}
 }
 
-func displayResults(checkResults []CheckResult, checkResultStats []CheckResultStat, drawLock *bool) error {
-	*drawLock = true
-	fmt.Print("\033[H\033[2J") // Clear terminal screen
-	// Print header
-	fmt.Printf("%-14s %-4s   %-4s %6v | %6v | %7v | %4v | %-50s\n",
-		"TARGET", "TYPE", "RES", "LAST", "LAST 10", "LAST 100", "COUNT", "HISTORY")
+type displayOptions struct {
+	showGeo   bool
+	showSite  bool
+	histogram HistogramConfig
+	display   DisplayConfig
+	// Columns of the terminal the table is fitted to
+	width  int
+	footer []string
+	// Active silences, listed below the incidents
+	silences []string
+	// Health score shown above the table, unless no check ran yet
+	health     healthScore
+	showHealth bool
+	// Traffic per check and overall, shown with a traffic budget
+	showTraffic bool
+	traffic     string
+	overBudget  bool
+	// Row highlighted in the interactive UI, -1 for none
+	selected int
+	// Rows of failing checks someone acknowledged
+	acked map[int]bool
+	// Latest note of a row added since it's in its state
+	notes map[int]string
+	// Note being typed in the interactive UI
+	prompt string
+	// Only failing and degraded checks are listed
+	problemsOnly bool
+}
 
+// isProblem reports whether a check failed or is degraded in its latest run
+func isProblem(checkResult CheckResult) bool {
+	return checkResult.execCount > 0 && (!checkResult.status || checkResult.degraded)
+}
+
+// displayOrder returns the row ids in the order they are displayed, the most
+// severe checks first. The same check reported from different sites is kept
+// together.
+func displayOrder(checkResults []CheckResult, showSite bool, problemsOnly bool) []int {
+	var order []int
 	for i, checkResult := range checkResults {
+		if !problemsOnly || isProblem(checkResult) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		checkA, checkB := checkResults[order[a]].check, checkResults[order[b]].check
+		if rankA, rankB := severityRanks[checkA.severity()], severityRanks[checkB.severity()]; rankA != rankB {
+			return rankA < rankB
+		}
+		return showSite && checkA.Name < checkB.Name
+	})
+	return order
+}
+
+func displayResults(checkResults []CheckResult, checkResultStats []CheckResultStat, options displayOptions) error {
+	fmt.Print("\033[H\033[2J") // Clear terminal screen
+
+	order := displayOrder(checkResults, options.showSite, options.problemsOnly)
+	now := time.Now()
+
+	if options.showHealth {
+		if _, err := healthColor(options.health.Score).Printf("HEALTH %s\n\n", options.health); err != nil {
+			return err
+		}
+	}
+
+	// Print header, the history strip gets the width left by the other columns
+	var header strings.Builder
+	if options.showSite {
+		fmt.Fprintf(&header, "%-10s ", "SITE")
+	}
+	latencyHeaders, latencyWidths := options.display.latencyHeader()
+	fmt.Fprintf(&header, "%-14s %-4s   %-4s %s   | ", "TARGET", "TYPE", "RES", joinColumns(latencyHeaders, latencyWidths))
+	if options.histogram.Enabled {
+		buckets := len(options.histogram.Buckets) + 1
+		if buckets == 1 {
+			buckets = len(defaultHistogramBuckets) + 1
+		}
+		fmt.Fprintf(&header, "%-*s | ", buckets, "HIST")
+	}
+	if options.showTraffic {
+		fmt.Fprintf(&header, "%8s | ", "TRAFFIC")
+	}
+	fmt.Fprintf(&header, "%6s | %-12s | %4v | ", "SINCE", "LAST FAILURE", "COUNT")
+	historyLength := options.display.historyLength()
+	if options.width > 0 {
+		historyLength = options.display.fitHistory(options.width - utf8.RuneCountInString(header.String()))
+	}
+	fmt.Printf("%s%-*s\n", header.String(), historyLength, "HISTORY")
+
+	for _, i := range order {
+		checkResult := checkResults[i]
 		statusColor := color.New(color.FgWhite)
 		switch checkResult.status {
 		case true:
 			statusColor = color.New(color.FgGreen)
 		case false:
-			statusColor = color.New(color.FgRed)
+			statusColor = failureColor(checkResult.check.severity())
 		}
 
 		statusMessage := "FAIL"
 		if checkResult.status == true {
 			statusMessage = "OK"
 		}
+		// Working, but noticeably worse than the baseline or than usual
+		if checkResult.status && checkResult.degraded {
+			statusColor = color.New(color.FgYellow)
+			statusMessage = "DEGR"
+		} else if checkResult.status && checkResultStats[i].deviation != "" {
+			statusColor = color.New(color.FgYellow)
+			statusMessage = "DEV"
+		} else if checkResult.status && checkResultStats[i].anomalous {
+			statusColor = color.New(color.FgMagenta)
+			statusMessage = "ANOM"
+		} else if !checkResult.status && options.acked[i] {
+			statusColor = color.New(color.FgCyan)
+			statusMessage = "ACK"
+		}
+		if i == options.selected {
+			statusColor.Add(color.ReverseVideo)
+		}
 
-		var statusHistory string
-		for _, status := range checkResultStats[i].last50Statuses {
-			if status {
-				statusHistory += "."
-			} else {
-				statusHistory += "F"
+		if options.showSite {
+			if _, err := statusColor.Printf("%-10s ", checkResult.check.site); err != nil {
+				return err
 			}
 		}
-
 		_, err := statusColor.Printf(
-			"%-14s %-4s   %-4s %6v | %7v | %8v | %4dx | %-50s\n",
+			"%-14s %-4s   %-4s %s ",
 			checkResult.check.Name,
 			checkResult.check.CheckType,
 			statusMessage,
-			formatDuration(checkResult.duration),
-			formatDuration(averageDuration(checkResultStats[i].last10Durations)),
-			formatDuration(averageDuration(checkResultStats[i].last100Durations)),
-			checkResult.execCount,
-			statusHistory,
+			joinColumns(options.display.latencyValues(checkResult, checkResultStats[i]), latencyWidths),
 		)
+		if err == nil {
+			trendSymbol, trendColor := trend(checkResultStats[i])
+			_, err = trendColor.Print(trendSymbol)
+		}
+		if err == nil && options.histogram.Enabled {
+			_, err = statusColor.Printf(" | %s", histogram(checkResultStats[i].longDurations, options.histogram.Buckets))
+		}
+		if err == nil && options.showTraffic {
+			_, err = statusColor.Printf(" | %8s", formatBytes(checkResultStats[i].bytes))
+		}
+		if err == nil {
+			_, err = statusColor.Printf(" | %6s | %-12s | %4dx | ", formatAge(checkResultStats[i].stateSince, now),
+				formatMoment(checkResultStats[i].lastFailure, now), checkResult.execCount)
+		}
+		if err == nil {
+			strip := options.display.historyStrip(checkResultStats[i].history, historyLength, checkResult.check.severity(), i == options.selected)
+			_, err = fmt.Println(strip)
+		}
+		if detail := checkResult.check.problemDetail(checkResult.detail); err == nil && isProblem(checkResult) && detail != "" {
+			_, err = statusColor.Printf("%14s %s\n", "", detail)
+		}
+		if note, ok := options.notes[i]; err == nil && ok {
+			_, err = color.New(color.FgCyan).Printf("%14s %s\n", "", note)
+		}
+		if err == nil && checkResultStats[i].deviation != "" {
+			_, err = statusColor.Printf("%14s %s\n", "", checkResultStats[i].deviation)
+		}
+		if err == nil && options.showGeo && checkResult.check.geo != nil {
+			_, err = statusColor.Printf("%14s %s, %s (%s)\n", "", checkResult.check.geo.Country, checkResult.check.geo.Isp, checkResult.check.geo.Asn)
+		}
 		if err != nil {
-			*drawLock = true
 			return err
 		}
 	}
-	*drawLock = false
+
+	if options.traffic != "" {
+		trafficColor := color.New(color.FgWhite)
+		if options.overBudget {
+			trafficColor = color.New(color.FgYellow)
+		}
+		if _, err := trafficColor.Printf("\n%s\n", options.traffic); err != nil {
+			return err
+		}
+	}
+	if options.problemsOnly {
+		if _, err := color.New(color.FgGreen).Printf("\n%d checks OK\n", len(checkResults)-len(order)); err != nil {
+			return err
+		}
+	}
+	for _, line := range options.footer {
+		if _, err := color.New(color.FgRed, color.Bold).Printf("\n%s\n", line); err != nil {
+			return err
+		}
+	}
+	for _, line := range options.silences {
+		if _, err := color.New(color.FgCyan).Printf("\n%s\n", line); err != nil {
+			return err
+		}
+	}
+	if options.prompt != "" {
+		if _, err := color.New(color.Bold).Printf("\n%s\n", options.prompt); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func main() {
-	checks, err := loadChecksFromYaml("checks.yml")
+// loadConfig loads the checks of the profile and prepares them for running at
+// the given site
+func loadConfig(path string, site string, profile string) (Checks, error) {
+	checks, err := loadChecksFromYaml(path)
 	if err != nil {
-		fmt.Println("Error loading config:", err)
+		return Checks{}, err
+	}
+	if err := applyProfile(&checks, profile); err != nil {
+		return Checks{}, err
+	}
+	if err := validateRepeats(checks.Checks); err != nil {
+		return Checks{}, err
+	}
+	if err := validateSeverities(checks.Checks); err != nil {
+		return Checks{}, err
+	}
+	if err := validateAdaptive(checks.Checks); err != nil {
+		return Checks{}, err
+	}
+	if err := validateBackoffs(checks.Checks); err != nil {
+		return Checks{}, err
+	}
+	if err := validateBursts(checks.Checks); err != nil {
+		return Checks{}, err
+	}
+	if err := checks.Display.validate(); err != nil {
+		return Checks{}, err
+	}
+	if err := checks.Retention.validate(); err != nil {
+		return Checks{}, err
+	}
+	if err := checks.RRD.validate(checks.Checks); err != nil {
+		return Checks{}, err
+	}
+	if err := validateSilences(checks.Silences); err != nil {
+		return Checks{}, err
+	}
+	for i := range checks.Checks {
+		checks.Checks[i].site = site
+	}
+	if checks.GeoIP.Enabled {
+		enrichGeoInfo(checks.GeoIP, checks.Checks)
+	}
+	return checks, nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "baseline":
+			os.Exit(runBaseline(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "status-page":
+			os.Exit(runStatusPage(os.Args[2:]))
+		case "discover":
+			os.Exit(runDiscover(os.Args[2:]))
+		case "compare":
+			os.Exit(runCompare(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "heatmap":
+			os.Exit(runHeatmap(os.Args[2:]))
+		case "schema":
+			os.Exit(runSchema(os.Args[2:]))
+		case "init":
+			os.Exit(runInit(os.Args[2:]))
+		case "keygen":
+			os.Exit(runKeygen(os.Args[2:]))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		}
+	}
+
+	configPath := flag.String("config", "checks.yml", "path to the checks configuration, an https://, s3:// or git+ URL, or configmap:<namespace>/<name>[/<key>]")
+	configRefresh := flag.Duration("config-refresh", 0, "check a remote configuration for changes at this interval and reload it")
+	site := flag.String("site", "local", "name of the site this instance runs at")
+	profile := flag.String("profile", profileAuto, "profile of the configuration to use, auto detects it by the network, none runs all checks")
+	agentUrl := flag.String("agent", "", "run as an agent reporting results to the central instance at this URL")
+	listen := flag.String("listen", "", "serve the API (agent results, health, metrics) on this address, e.g. :8443")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate for the API listener")
+	tlsKey := flag.String("tls-key", "", "TLS key for the API listener")
+	token := flag.String("token", "", "shared token authenticating agents")
+	enablePprof := flag.Bool("pprof", false, "expose runtime profiling under /debug/pprof/ on the API listener")
+	noDnsCache := flag.Bool("no-dns-cache", false, "resolve the dest of every http and icmp check run instead of caching addresses for their TTL")
+	queueSize := flag.Int("queue-size", 1000, "capacity of the results queue")
+	daemon := flag.Bool("daemon", false, "run without the terminal display, controlled via the control socket")
+	socket := flag.String("socket", "", "path of the control socket (default "+defaultSocketPath+" in daemon mode)")
+	recordPath := flag.String("record", "", "append every result to this file")
+	signKey := flag.String("sign-key", "", "sign the results of the -record with this ed25519 key written by keygen, checked with verify")
+	snapshotPath := flag.String("snapshot", "", "save the latest results and statistics to this file periodically and on exit, and restore them on start")
+	snapshotInterval := flag.Duration("snapshot-interval", defaultSnapshotInterval, "interval of saving the -snapshot")
+	replayPath := flag.String("replay", "", "replay results recorded with -record instead of running checks")
+	replaySpeed := flag.Float64("replay-speed", 1, "replay speed multiplier, 0 replays as fast as possible")
+	baselinePath := flag.String("baseline", "", "highlight checks deviating from this baseline")
+	baselineFactor := flag.Float64("baseline-factor", 3, "how many times worse than the baseline a check may get")
+	statusTitle := flag.String("status-title", "Status", "title of the status page served with -record and -listen")
+	repeat := flag.Duration("repeat", defaultAdHocRepeat, "repeat of a check given on the command line")
+	timeout := flag.Duration("timeout", 0, "timeout of a check given on the command line")
+	problems := flag.Bool("problems", false, "list only failing and degraded checks, toggled with p")
+	runFor := flag.Duration("for", 0, "stop after this long, print a summary and exit with 2 if a critical check failed and 1 if a warning one did")
+	iterations := flag.Int("iterations", 0, "stop once every check ran this many times, print a summary and exit with 2 if a critical check failed and 1 if a warning one did")
+	once := flag.Bool("once", false, "run every check once, same as -iterations 1")
+	format := flag.String("format", formatText, "format of the summary of a bounded run: text, or openmetrics printed alone on stdout for the textfile collector of the node_exporter")
+	var only, exclude checkFilters
+	flag.Var(&only, "only", "run only checks matching a filter like tag=wan or name~camera (regular expression), repeatable")
+	flag.Var(&exclude, "exclude", "skip checks matching a filter like tag=wan or name~camera (regular expression), repeatable")
+	var settings settingsFlag
+	flag.Var(&settings, "set", "setting (key=value) of a check given on the command line, repeatable")
+	adHocType, adHocDest, args, adHoc := splitAdHocArgs(os.Args[1:])
+	flag.CommandLine.Parse(args)
+	dnsCache.disabled = *noDnsCache
+	if *once && *iterations == 0 {
+		*iterations = 1
+	}
+	switch *format {
+	case formatText:
+	case formatOpenMetrics:
+		if *runFor == 0 && *iterations == 0 {
+			logMessage(logErr, "Error: -format openmetrics needs a bounded run, e.g. -once")
+			os.Exit(1)
+		}
+		// Only the metrics go to stdout, without the table and the keyboard
+		logOutput = os.Stderr
+	default:
+		logMessage(logErr, "Error: unknown -format", *format+", expected text or openmetrics")
 		os.Exit(1)
 	}
+	// Without the terminal display
+	headless := *daemon || *format == formatOpenMetrics
 
-	c := make(chan CheckResult)
-	checkResults := make([]CheckResult, len(checks.Checks))
-	checkResultStats := make([]CheckResultStat, len(checks.Checks))
-	var drawLock bool = false
+	load := func() (Checks, error) {
+		if adHoc {
+			checks, err := adHocChecks(adHocType, adHocDest, *repeat, *timeout, settings)
+			if err == nil {
+				err = validateRepeats(checks.Checks)
+			}
+			if err == nil {
+				err = validateChecks(checks.Checks)
+			}
+			if err == nil {
+				err = validateSeverities(checks.Checks)
+			}
+			if err == nil {
+				err = validateBursts(checks.Checks)
+			}
+			for i := range checks.Checks {
+				checks.Checks[i].site = *site
+			}
+			return checks, err
+		}
+		checks, err := loadConfig(*configPath, *site, *profile)
+		checks.Checks = filterChecks(checks.Checks, only, exclude)
+		return checks, err
+	}
+	var checks Checks
+	if *replayPath == "" {
+		var err error
+		checks, err = load()
+		if err != nil {
+			logMessage(logErr, "Error loading config:", err)
+			os.Exit(1)
+		}
+	}
 
-	for _, check := range checks.Checks {
-		switch check.CheckType {
-		case "http":
-			go runHttpCheck(check, c)
-		case "icmp":
-			go runIcmpCheck(check, c)
-		default:
-			fmt.Println("Unknown check type:", check.CheckType)
+	c := make(chan CheckResult, *queueSize)
+	monitor := newMonitor(checks, c)
+	monitor.tui.problemsOnly = *problems
+	// Bounded runs report on their own runs only
+	if *snapshotPath != "" && *replayPath == "" && *runFor == 0 && *iterations == 0 {
+		restored, err := monitor.restoreSnapshot(*snapshotPath)
+		if err != nil {
+			logMessage(logWarning, "Error restoring stats snapshot:", err)
+		} else if restored > 0 {
+			logMessage(logInfo, "Restored the stats of", restored, "checks from", *snapshotPath)
+		}
+		startSnapshots(monitor, *snapshotPath, *snapshotInterval)
+	}
+	if *baselinePath != "" {
+		baseline, err := loadBaseline(*baselinePath)
+		if err != nil {
+			logMessage(logErr, "Error loading baseline:", err)
+			os.Exit(1)
 		}
+		monitor.baseline = baseline
+		monitor.baselineFactor = *baselineFactor
 	}
 
-	for cR := range c {
-		go func(checkResult CheckResult) {
-			checkResult.execCount = checkResults[checkResult.check.id].execCount + 1
-			checkResults[checkResult.check.id] = checkResult
+	var peers *cluster
+	if len(checks.Cluster.Peers) > 0 {
+		if *listen == "" {
+			logMessage(logErr, "Error configuring the cluster: peers reach this instance through the API, start it with -listen")
+			os.Exit(1)
+		}
+		peers = newCluster(checks.Cluster, *token, monitor)
+		monitor.cluster = peers
+	}
 
-			checkResultStats[checkResult.check.id].last10Durations = limitSlice(prependSlice(checkResult.duration, checkResultStats[checkResult.check.id].last10Durations).([]time.Duration), 10).([]time.Duration)
-			checkResultStats[checkResult.check.id].last100Durations = limitSlice(prependSlice(checkResult.duration, checkResultStats[checkResult.check.id].last100Durations).([]time.Duration), 100).([]time.Duration)
-			checkResultStats[checkResult.check.id].last50Statuses = limitSlice(prependSlice(checkResult.status, checkResultStats[checkResult.check.id].last50Statuses).([]bool), 50).([]bool)
+	// A redraw always shows the latest state, so a single pending one is enough
+	if !headless {
+		monitor.addConsumer("render", 1, monitor.throttledDraw(checks.Display.Refresh))
+	}
+	if *agentUrl != "" {
+		monitor.addConsumer("agent", 1000, agentForwarder(*agentUrl, *token))
+	}
+	var consul *consulClient
+	if checks.Consul.Enabled {
+		consul = newConsulClient(checks.Consul, checks.Checks)
+		if err := consul.register(); err != nil {
+			logMessage(logErr, "Error registering Consul checks:", err)
+			os.Exit(1)
+		}
+		monitor.addConsumer("consul", 1000, consul.update)
+	}
+	if checks.RRD.Enabled {
+		monitor.addConsumer("rrd", 1000, newRRDWriter(checks.RRD).add)
+	}
+	if checks.Syslog.Enabled {
+		syslog, err := newSyslogWriter(checks.Syslog)
+		if err != nil {
+			logMessage(logErr, "Error configuring syslog:", err)
+			os.Exit(1)
+		}
+		monitor.addConsumer("syslog", 1000, monitor.afterGrace(monitor.unlessMuted(peers.leaderOnly(onStateChange(syslog.update)))))
+	}
+	if checks.SNMP.Enabled {
+		snmp, err := newSNMPTrapSender(checks.SNMP)
+		if err != nil {
+			logMessage(logErr, "Error configuring SNMP traps:", err)
+			os.Exit(1)
+		}
+		monitor.addConsumer("snmp", 1000, monitor.afterGrace(monitor.unlessMuted(peers.leaderOnly(onStateChange(snmp.send)))))
+	}
+	if checks.Capture.Enabled {
+		monitor.addConsumer("capture", 1000, monitor.afterGrace(onStateChange(newPacketCapture(checks.Capture, *recordPath).start)))
+	}
+	if *recordPath != "" {
+		var signer *resultSigner
+		if *signKey != "" {
+			var err error
+			if signer, err = loadSigningKey(*signKey); err != nil {
+				logMessage(logErr, "Error loading signing key:", err)
+				os.Exit(1)
+			}
+		}
+		notes, err := openNotesFile(*recordPath, signer)
+		if err != nil {
+			logMessage(logErr, "Error opening notes:", err)
+			os.Exit(1)
+		}
+		recorder, err := resultRecorder(*recordPath, checks.Retention, signer, notes)
+		if err != nil {
+			logMessage(logErr, "Error opening recording:", err)
+			os.Exit(1)
+		}
+		monitor.addConsumer("record", 10000, recorder)
+		monitor.recording = *recordPath
+		monitor.notesFile = notes
+		if err := monitor.loadNotes(); err != nil {
+			logMessage(logWarning, "Error reading notes:", err)
+		}
+	}
+	var mux *http.ServeMux
+	if *listen != "" {
+		mux = startApi(*listen, *tlsCert, *tlsKey, *token, *enablePprof, monitor, c)
+		if *recordPath != "" {
+			mux.HandleFunc("/status", statusPageHandler(*statusTitle, monitor, *recordPath))
+		}
+	}
+	if *daemon && *socket == "" {
+		*socket = defaultSocketPath
+	}
+	reload := func() error {
+		checks, err := load()
+		if err != nil {
+			return err
+		}
+		monitor.reload(checks)
+		return nil
+	}
+	if mux != nil {
+		results := &resultStream{}
+		monitor.addConsumer("grpc", 1000, results.publish)
+		mux.Handle(grpcServicePath, &grpcServer{token: *token, monitor: monitor, reload: reload, recording: *recordPath, results: results})
+		if peers != nil {
+			mux.HandleFunc(clusterPath, peers.handler())
+			peers.start()
+		}
+	}
+	if *configRefresh > 0 && isRemoteConfig(*configPath) && !adHoc && *replayPath == "" {
+		go watchRemoteConfig(*configPath, *configRefresh, reload)
+	}
+	if *socket != "" {
+		if err := startControlSocket(*socket, monitor, reload); err != nil {
+			logMessage(logErr, "Error starting control socket:", err)
+			os.Exit(1)
+		}
+	}
 
-			if drawLock == false {
-				displayResults(checkResults, checkResultStats, &drawLock)
+	var restoreTerminal func()
+	startedAt := time.Now()
+	var exitOnce sync.Once
+	exit := func() {
+		exitOnce.Do(func() {
+			sdNotify("STOPPING=1")
+			if *socket != "" {
+				os.Remove(*socket)
+			}
+			if restoreTerminal != nil {
+				restoreTerminal()
+			}
+			if consul != nil {
+				consul.deregister()
+			}
+			if *snapshotPath != "" && *replayPath == "" && *runFor == 0 && *iterations == 0 {
+				if err := writeSnapshot(*snapshotPath, monitor.snapshot()); err != nil {
+					logMessage(logErr, "Error writing stats snapshot:", err)
+				}
 			}
+			// Bounded runs end with a summary and report failures in the exit code
+			if *runFor == 0 && *iterations == 0 {
+				os.Exit(0)
+			}
+			summary, failed := monitor.summary(time.Since(startedAt))
+			if *format == formatOpenMetrics {
+				fmt.Print(monitor.openMetrics())
+			} else {
+				fmt.Print("\n" + summary)
+			}
+			os.Exit(severityExitCode(failed))
+		})
+	}
+	if *runFor > 0 {
+		time.AfterFunc(*runFor, exit)
+	}
+	if *iterations > 0 {
+		monitor.addConsumer("iterations", 1, func(CheckResult) {
+			if monitor.iterationsDone(*iterations) {
+				exit()
+			}
+		})
+	}
+	if !headless {
+		restoreTerminal = startKeyboard(monitor, exit)
+		monitor.interactive = restoreTerminal != nil
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		exit()
+	}()
 
-			time.Sleep(checkResult.check.Repeat)
-			switch checkResult.check.CheckType {
-			case "http":
-				runHttpCheck(checkResult.check, c)
-			case "icmp":
-				go runIcmpCheck(checkResult.check, c)
-			default:
-				fmt.Println("Unknown check type:", checkResult.check.CheckType)
+	if *replayPath != "" {
+		go func() {
+			if err := replayResults(*replayPath, *replaySpeed, monitor, c); err != nil {
+				logMessage(logErr, "Error replaying results:", err)
 			}
-		}(cR)
+		}()
+	} else {
+		monitor.start()
+	}
+	if *replayPath != "" {
+		monitor.endWarmup()
+	}
+	startWatchdog(monitor)
+	startHeartbeat(checks.Heartbeat, monitor)
+	if err := startHealthPublishing(checks.MQTT, monitor); err != nil {
+		logMessage(logErr, "Error configuring MQTT:", err)
+		os.Exit(1)
+	}
+	go func() {
+		<-monitor.warmed
+		sdNotify("READY=1")
+	}()
+	for checkResult := range c {
+		monitor.handleResult(checkResult)
 	}
 }
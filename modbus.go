@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const modbusReadHoldingRegisters = 0x03
+
+var modbusExceptions = map[byte]string{
+	1:  "illegal function",
+	2:  "illegal data address",
+	3:  "illegal data value",
+	4:  "server device failure",
+	6:  "server device busy",
+	10: "gateway path unavailable",
+	11: "gateway target device failed to respond",
+}
+
+// modbusReadRegister reads one holding register over Modbus TCP
+func modbusReadRegister(conn net.Conn, unit byte, register uint16) (uint16, error) {
+	transaction := uint16(rand.Intn(0x10000))
+	request := binary.BigEndian.AppendUint16(nil, transaction)
+	request = append(request, 0, 0, 0, 6, unit, modbusReadHoldingRegisters)
+	request = binary.BigEndian.AppendUint16(request, register)
+	request = binary.BigEndian.AppendUint16(request, 1)
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+	// The unit identifier followed by at least the function and exception codes
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if binary.BigEndian.Uint16(header) != transaction || length < 3 || length > 254 {
+		return 0, fmt.Errorf("invalid Modbus response")
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(conn, pdu); err != nil {
+		return 0, err
+	}
+	if pdu[0] == modbusReadHoldingRegisters|0x80 {
+		if reason, ok := modbusExceptions[pdu[1]]; ok {
+			return 0, fmt.Errorf("Modbus exception: %s", reason)
+		}
+		return 0, fmt.Errorf("Modbus exception %d", pdu[1])
+	}
+	if pdu[0] != modbusReadHoldingRegisters || len(pdu) < 4 || pdu[1] != 2 {
+		return 0, fmt.Errorf("invalid Modbus response")
+	}
+	return binary.BigEndian.Uint16(pdu[2:]), nil
+}
+
+// runModbusCheck reads a holding register of a Modbus TCP device
+// (host[:port]) and, with min and/or max, requires its value to be in range
+func runModbusCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	addr := check.Dest
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "502")
+	}
+
+	var value uint16
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		value, err = modbusReadRegister(conn, byte(check.Unit), uint16(check.Register))
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+
+	switch {
+	case err != nil:
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+	case check.Min != nil && float64(value) < *check.Min, check.Max != nil && float64(value) > *check.Max:
+		checkResult.detail = fmt.Sprintf("register %d is %d, expected %s", check.Register, value, valueRange(check.Min, check.Max))
+		checkResult.cause = causeUnexpected
+	default:
+		checkResult.status = true
+	}
+	c <- checkResult
+}
+
+func valueRange(min, max *float64) string {
+	switch {
+	case min != nil && max != nil:
+		return fmt.Sprintf("%g to %g", *min, *max)
+	case min != nil:
+		return fmt.Sprintf("at least %g", *min)
+	default:
+		return fmt.Sprintf("at most %g", *max)
+	}
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Monitor holds the latest results and statistics of all checks and runs
+// every check at its fixed rate.
+type Monitor struct {
+	mu      sync.Mutex
+	checks  Checks
+	results []CheckResult
+	stats   []CheckResultStat
+	paused  map[string]bool
+	// Acknowledged failing checks by incidentKey
+	acks map[string]checkAck
+	// Notes attached to checks, oldest first
+	notes []checkNote
+	// Silences added at runtime, those of the configuration are in checks
+	silences   []Silence
+	silenceIds int
+	// Last change of the acks and the runtime silences, which the cluster
+	// shares
+	sharedChanged time.Time
+	remoteIds     map[string]int
+	inflight      map[int]*inflightRun
+	generation    int
+	lastResult    time.Time
+	startedAt     time.Time
+	// Closed once the warmup ended, right away without one
+	warmed   chan struct{}
+	warmOnce sync.Once
+
+	c         chan CheckResult
+	consumers []*consumer
+
+	drawMu      sync.Mutex
+	interactive bool
+	tui         tuiState
+
+	baseline       *Baseline
+	baselineFactor float64
+	// Results recorded with -record, the history of the heatmaps
+	recording string
+	notesFile *notesFile
+	incidents incidentTracker
+	traffic   trafficBudget
+	limiter   *hostLimiter
+	sharing   probeSharing
+	// Instances sharing the alerting, nil without a cluster
+	cluster *cluster
+
+	// Internal metrics exposed by the API
+	schedulerLag   time.Duration
+	queueOverflows int
+}
+
+func newMonitor(checks Checks, c chan CheckResult) *Monitor {
+	m := &Monitor{
+		checks:    checks,
+		results:   make([]CheckResult, len(checks.Checks)),
+		stats:     make([]CheckResultStat, len(checks.Checks)),
+		paused:    make(map[string]bool),
+		acks:      make(map[string]checkAck),
+		remoteIds: make(map[string]int),
+		inflight:  make(map[int]*inflightRun),
+		c:         c,
+		incidents: incidentTracker{window: checks.IncidentWindow},
+		tui:       tuiState{view: viewTable, window: defaultChartWindow},
+		traffic:   newTrafficBudget(checks.Budget),
+		limiter:   newHostLimiter(checks.RateLimit),
+		startedAt: time.Now(),
+		warmed:    make(chan struct{}),
+	}
+	if !checks.Warmup {
+		m.endWarmup()
+	}
+	return m
+}
+
+// addConsumer registers a consumer receiving every recorded result through
+// its own queue of the given size.
+func (m *Monitor) addConsumer(name string, size int, handle func(CheckResult)) {
+	m.consumers = append(m.consumers, newConsumer(name, size, handle))
+}
+
+// queueOverflow accounts for a result that didn't fit into the results queue
+func (m *Monitor) queueOverflow() {
+	m.mu.Lock()
+	m.queueOverflows++
+	m.mu.Unlock()
+}
+
+// start runs all configured checks of the current generation and schedules
+// their further runs
+func (m *Monitor) start() {
+	m.mu.Lock()
+	checks := m.checks.Checks
+	generation := m.generation
+	m.mu.Unlock()
+
+	if !m.warmedUp() {
+		m.startWarmup()
+	}
+	for _, check := range checks {
+		check.generation = generation
+		m.runCheck(check)
+		if check.Repeat <= 0 {
+			logMessage(logWarning, "Check", check.Name, "has no repeat interval, running it only once")
+			continue
+		}
+		go m.schedule(check)
+	}
+}
+
+// nextRun returns the next time a check repeating at the given interval is
+// due. Runs are anchored to wall-clock multiples of the interval, so checks
+// with the same interval fire together regardless of when they were started.
+func nextRun(now time.Time, repeat time.Duration) time.Time {
+	return now.Truncate(repeat).Add(repeat)
+}
+
+// schedule runs the check at a fixed rate, independently of how long the
+// previous runs took, until the configuration is reloaded. The wall-clock
+// time of the next run is turned into a monotonic deadline, so clock jumps
+// (NTP, DST) never make the lag negative or huge.
+func (m *Monitor) schedule(check Check) {
+	for {
+		now := time.Now()
+		due := now.Add(nextRun(now, check.tick()).Sub(now))
+		time.Sleep(time.Until(due))
+		if !m.isCurrent(check) {
+			return
+		}
+
+		m.mu.Lock()
+		m.schedulerLag = time.Since(due)
+		throttled := check.generation == m.generation &&
+			(m.traffic.throttled(check, m.results[check.id].runAt, time.Now()) ||
+				m.stats[check.id].skipped(check, m.results[check.id].runAt, time.Now()))
+		m.mu.Unlock()
+
+		if m.isPaused(check) || throttled {
+			continue
+		}
+		m.runCheck(check)
+	}
+}
+
+// inflightRun is a running execution of a check
+type inflightRun struct {
+	cancel context.CancelFunc
+}
+
+// checkProbe returns the probe running checks of the type, nil for unknown
+// types
+func checkProbe(checkType string) probe {
+	switch checkType {
+	case "http":
+		return runHttpCheck
+	case "icmp":
+		return runIcmpCheck
+	case "dnssec":
+		return runDnssecCheck
+	case "ptr":
+		return runPtrCheck
+	case "rdap":
+		return runRdapCheck
+	case "tls":
+		return runTlsCheck
+	case "rtsp":
+		return runRtspCheck
+	case "hls":
+		return runHlsCheck
+	case "sip":
+		return runSipCheck
+	case "ldap":
+		return runLdapCheck
+	case "kafka":
+		return runKafkaCheck
+	case "elasticsearch", "opensearch":
+		return runElasticsearchCheck
+	case "smb":
+		return runSmbCheck
+	case "ftp":
+		return runFtpCheck
+	case "rdp":
+		return runRdpCheck
+	case "modbus":
+		return runModbusCheck
+	case "coap":
+		return runCoapCheck
+	case "dhcp":
+		return runDhcpCheck
+	case "ports":
+		return runPortsCheck
+	case "path":
+		return runPathCheck
+	}
+	return nil
+}
+
+// runCheck starts a run of the check limited by its deadline. A check never
+// overlaps with itself: when the previous run is still in flight, it's counted
+// as a timeout and depending on the overlap policy either the new run is
+// skipped (default) or the previous one is cancelled.
+func (m *Monitor) runCheck(check Check) {
+	run := checkProbe(check.CheckType)
+	if run == nil {
+		logMessage(logErr, "Unknown check type:", check.CheckType)
+		return
+	}
+
+	m.mu.Lock()
+	if check.generation != m.generation {
+		m.mu.Unlock()
+		return
+	}
+	if previous, ok := m.inflight[check.id]; ok {
+		m.stats[check.id].timeouts++
+		if check.Overlap != "cancel" {
+			m.mu.Unlock()
+			return
+		}
+		previous.cancel()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), check.deadline())
+	current := &inflightRun{cancel: cancel}
+	m.inflight[check.id] = current
+	share := m.checks.ShareProbes
+	m.mu.Unlock()
+
+	limited := func(ctx context.Context, check Check, c chan CheckResult) {
+		// Waiting for other checks of the host counts against the timeout
+		if m.limiter.wait(ctx, destHost(check.Dest)) {
+			run(ctx, check, c)
+		} else {
+			c <- CheckResult{check: check, runAt: time.Now(), duration: check.deadline()}
+		}
+	}
+	if share {
+		limited = m.sharing.wrap(limited)
+	}
+
+	go func() {
+		limited(ctx, check, m.c)
+		cancel()
+
+		m.mu.Lock()
+		if m.inflight[check.id] == current {
+			delete(m.inflight, check.id)
+		}
+		m.mu.Unlock()
+	}()
+}
+
+// handleResult records a result and hands it over to the consumers
+func (m *Monitor) handleResult(checkResult CheckResult) {
+	id := checkResult.check.id
+
+	m.mu.Lock()
+	// Results of checks from before a reload are dropped
+	if !checkResult.check.remote && checkResult.check.generation != m.generation {
+		m.mu.Unlock()
+		return
+	}
+	if !checkResult.check.remote {
+		applyLatencyThreshold(&checkResult)
+	}
+	checkResult.cause = failureCause(checkResult)
+	for len(m.results) <= id {
+		m.results = append(m.results, CheckResult{})
+		m.stats = append(m.stats, CheckResultStat{})
+	}
+	m.lastResult = time.Now()
+	if previous := m.results[id]; previous.execCount == 0 || previous.status != checkResult.status {
+		// The configuration holds the tags and dependencies of local checks
+		check := checkResult.check
+		if !check.remote && id < len(m.checks.Checks) {
+			check = m.checks.Checks[id]
+			check.site = checkResult.check.site
+		}
+		if previous.execCount > 0 || !checkResult.status {
+			m.incidents.update(check, !checkResult.status, checkResult.runAt)
+		}
+	}
+	if checkResult.status {
+		m.clearAck(checkResult.check)
+	}
+	checkResult.execCount = m.results[id].execCount + 1
+	m.stats[id].updateState(m.results[id], checkResult)
+	m.results[id] = checkResult
+	if !m.warmedUp() {
+		m.warmupProgress()
+	}
+
+	display := m.checks.Display
+	m.stats[id].shortDurations = limitSlice(prependSlice(checkResult.duration, m.stats[id].shortDurations).([]time.Duration), display.shortWindow()).([]time.Duration)
+	m.stats[id].longDurations = limitSlice(prependSlice(checkResult.duration, m.stats[id].longDurations).([]time.Duration), display.longWindow()).([]time.Duration)
+	m.stats[id].recentStatuses = limitSlice(prependSlice(checkResult.status, m.stats[id].recentStatuses).([]bool), baselineLossWindow).([]bool)
+	m.stats[id].history = append(m.stats[id].history, historySample{
+		runAt:    checkResult.runAt,
+		duration: checkResult.duration,
+		status:   checkResult.status,
+		degraded: checkResult.degraded,
+		cause:    checkResult.cause,
+	})
+	if len(m.stats[id].history) > chartHistory {
+		m.stats[id].history = m.stats[id].history[1:]
+	}
+	m.stats[id].bytes += checkResult.bytes
+	if checkResult.setup > 0 {
+		m.stats[id].lastSetup = checkResult.setup
+	}
+	if checkResult.dns != nil {
+		if checkResult.dns.cached {
+			m.stats[id].dnsHits++
+		} else {
+			m.stats[id].dnsMisses++
+		}
+		m.stats[id].lastDns = checkResult.dns
+	}
+	m.stats[id].addTotals(checkResult)
+	if !checkResult.check.remote && id < len(m.checks.Checks) {
+		m.stats[id].updateBackoff(m.checks.Checks[id], checkResult)
+		m.stats[id].updateAdaptive(m.checks.Checks[id], checkResult)
+	}
+	if m.traffic.add(checkResult.bytes, time.Now()) {
+		logMessage(logWarning, fmt.Sprintf("Traffic budget of %s per %v exceeded, slowing down checks %dx",
+			formatBytes(int64(m.traffic.config.Limit)), m.traffic.config.Period, m.traffic.config.Slowdown))
+	}
+
+	if m.baseline != nil {
+		deviation := m.baseline.deviation(checkResult.check.site, checkResult.check.Name, m.stats[id], m.baselineFactor)
+		if deviation != "" && m.stats[id].deviation == "" {
+			logMessage(logWarning, "Check", checkResult.check.Name, "deviates from baseline:", deviation)
+		} else if deviation == "" && m.stats[id].deviation != "" {
+			logMessage(logInfo, "Check", checkResult.check.Name, "is back within baseline")
+		}
+		m.stats[id].deviation = deviation
+	}
+
+	if m.checks.Anomaly.Enabled && checkResult.status {
+		anomalous := m.stats[id].detector.observe(checkResult.duration, m.checks.Anomaly)
+		if anomalous && !m.stats[id].anomalous {
+			logMessage(logWarning, "Check", checkResult.check.Name, "shows unusual latency:", formatDuration(checkResult.duration))
+		} else if !anomalous && m.stats[id].anomalous {
+			logMessage(logInfo, "Check", checkResult.check.Name, "latency is back to normal")
+		}
+		m.stats[id].anomalous = anomalous
+	}
+
+	m.mu.Unlock()
+
+	for _, consumer := range m.consumers {
+		consumer.offer(checkResult)
+	}
+}
+
+func (m *Monitor) isCurrent(check Check) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return check.generation == m.generation
+}
+
+func (m *Monitor) isPaused(check Check) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return check.generation == m.generation && m.paused[check.Name]
+}
+
+// setPaused pauses or resumes scheduling of the named check
+func (m *Monitor) setPaused(name string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, check := range m.checks.Checks {
+		if check.Name == name {
+			if paused {
+				m.paused[name] = true
+			} else {
+				delete(m.paused, name)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown check %q", name)
+}
+
+// reload replaces the configured checks. Checks of the previous configuration
+// finish their current run and are not rescheduled. The results and
+// statistics of checks with the same identity are kept, wherever they moved
+// in the configuration.
+func (m *Monitor) reload(checks Checks) {
+	m.mu.Lock()
+	rows := make(map[string]int)
+	for i, check := range m.checks.Checks {
+		rows[check.identity()] = i
+	}
+	results := make([]CheckResult, len(checks.Checks), len(checks.Checks)+len(m.remoteIds))
+	stats := make([]CheckResultStat, len(checks.Checks), len(checks.Checks)+len(m.remoteIds))
+	for i, check := range checks.Checks {
+		if row, ok := rows[check.identity()]; ok && row < len(m.results) {
+			results[i], stats[i] = m.results[row], m.stats[row]
+			results[i].check = check
+		}
+	}
+	remoteIds := make(map[string]int)
+	for key, row := range m.remoteIds {
+		if row < len(m.results) {
+			remoteIds[key] = len(results)
+			m.results[row].check.id = len(results)
+			results = append(results, m.results[row])
+			stats = append(stats, m.stats[row])
+		}
+	}
+	m.checks = checks
+	m.results = results
+	m.stats = stats
+	m.remoteIds = remoteIds
+	m.inflight = make(map[int]*inflightRun)
+	m.incidents = incidentTracker{window: checks.IncidentWindow}
+	// The traffic of the current period still counts against the new budget
+	m.traffic.config = newTrafficBudget(checks.Budget).config
+	m.limiter.setConfig(checks.RateLimit)
+	m.generation++
+	m.mu.Unlock()
+
+	m.start()
+}
+
+// remoteId assigns a row id to a check reported by an agent, keyed by site
+// and check identity. Remote rows follow the local ones.
+func (m *Monitor) remoteId(site string, identity string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := site + "/" + identity
+	id, ok := m.remoteIds[key]
+	if !ok {
+		id = len(m.checks.Checks) + len(m.remoteIds)
+		m.remoteIds[key] = id
+	}
+	return id
+}
+
+// status returns a plain text summary of all checks for the control socket
+func (m *Monitor) status() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+	fmt.Fprintf(&b, "%-14s %-4s %-6s %7s %6s %8s %8s %6s %-12s %s\n", "TARGET", "TYPE", "RES", "LAST", "COUNT", "TIMEOUTS", "TRAFFIC", "SINCE", "LAST FAILURE", "STATE")
+	for i, checkResult := range m.results {
+		name := checkResult.check.Name
+		checkType := checkResult.check.CheckType
+		if i < len(m.checks.Checks) {
+			name = m.checks.Checks[i].Name
+			checkType = m.checks.Checks[i].CheckType
+		}
+		if checkResult.mode != "" {
+			checkType += "/" + checkResult.mode
+		}
+
+		res := "-"
+		if checkResult.execCount > 0 {
+			res = "FAIL"
+			if checkResult.status {
+				res = "OK"
+			}
+			if checkResult.degraded {
+				res = "DEGR"
+			}
+		}
+		ack, acked := m.ackOf(checkResult.check)
+		if acked {
+			res = "ACK"
+		}
+		state := "running"
+		if m.paused[name] {
+			state = "paused"
+		} else if m.stats[i].backoff > 0 {
+			state = "backing off, every " + m.stats[i].backoff.String()
+		} else if i < len(m.checks.Checks) && m.checks.Checks[i].adaptive() {
+			state = "adaptive, every " + m.stats[i].interval(m.checks.Checks[i]).String()
+		}
+		if checkResult.check.remote {
+			state = "remote " + checkResult.check.site
+		}
+		if _, silenced := m.silenceOf(checkResult.check); silenced {
+			state += ", silenced"
+		}
+		fmt.Fprintf(&b, "%-14s %-4s %-6s %7s %5dx %8d %8s %6s %-12s %s\n", name, checkType, res,
+			formatDuration(checkResult.duration), checkResult.execCount, m.stats[i].timeouts,
+			formatBytes(m.stats[i].bytes), formatAge(m.stats[i].stateSince, now),
+			formatMoment(m.stats[i].lastFailure, now), state)
+		if detail := checkResult.check.problemDetail(checkResult.detail); isProblem(checkResult) && detail != "" {
+			fmt.Fprintf(&b, "%14s %s\n", "", detail)
+		}
+		if acked {
+			fmt.Fprintf(&b, "%14s %s\n", "", ack.describe())
+		}
+		if note, ok := m.currentNote(i); ok {
+			fmt.Fprintf(&b, "%14s %s\n", "", note.describe(now))
+		}
+	}
+	fmt.Fprintf(&b, "\n%s\n", m.traffic.summary(time.Now()))
+	if health, ok := m.health(); ok {
+		fmt.Fprintln(&b, "Health:", health)
+	}
+	for _, silence := range m.currentSilences() {
+		fmt.Fprintln(&b, "Silence", silence.describe())
+	}
+	if m.cluster != nil {
+		fmt.Fprintln(&b, m.cluster.summary())
+	}
+	return b.String()
+}
+
+// healthy reports whether the monitor still makes progress: its state can be
+// locked within the timeout (i.e. rendering isn't blocked) and results keep
+// coming in at least as often as the slowest check is scheduled.
+func (m *Monitor) healthy(timeout time.Duration) error {
+	// Polled rather than waited for in a goroutine, which would keep the lock
+	// once it got it after the timeout
+	deadline := time.Now().Add(timeout)
+	for !m.mu.TryLock() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("monitor state locked for more than %v", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer m.mu.Unlock()
+
+	if m.lastResult.IsZero() {
+		return nil
+	}
+	var maxRepeat time.Duration
+	running := false
+	for i, check := range m.checks.Checks {
+		if m.paused[check.Name] {
+			continue
+		}
+		running = true
+		if interval := m.stats[i].interval(check); interval > maxRepeat {
+			maxRepeat = interval
+		}
+	}
+	if !running {
+		return nil
+	}
+	// Allow for the slowest check plus its own timeout
+	if since := time.Since(m.lastResult); since > 2*maxRepeat+time.Minute {
+		return fmt.Errorf("no results for %v", since.Round(time.Second))
+	}
+	return nil
+}
+
+// draw renders a snapshot of the current results, so that a slow terminal
+// doesn't hold the monitor lock.
+func (m *Monitor) draw() {
+	// Key presses and new results both redraw the screen
+	m.drawMu.Lock()
+	defer m.drawMu.Unlock()
+
+	m.mu.Lock()
+	if !m.warmedUp() {
+		ran, total := m.warmupProgress()
+		m.mu.Unlock()
+		displayWarmup(ran, total)
+		return
+	}
+	if m.tui.view == viewHeatmap && m.tui.selected < len(m.results) {
+		name := m.results[m.tui.selected].check.Name
+		metric := m.tui.metric
+		m.mu.Unlock()
+
+		heatmaps, err := m.tuiHeatmaps()
+		h := heatmaps[name]
+		if err == nil && h == nil {
+			h = newHeatmap(heatmapDays, time.Now())
+		}
+		displayHeatmap(name, h, metric, err)
+		return
+	}
+	if m.tui.view == viewMap {
+		lines := m.topology().lines(true)
+		m.mu.Unlock()
+
+		displayMap(lines)
+		return
+	}
+	if m.tui.view == viewCompare {
+		lines := m.compareVantages().lines(true)
+		m.mu.Unlock()
+
+		displayComparison(lines)
+		return
+	}
+	if m.tui.view == viewChart && m.tui.selected < len(m.results) {
+		name := m.results[m.tui.selected].check.Name
+		if m.showSite() {
+			name = m.results[m.tui.selected].check.site + " " + name
+		}
+		ownership := m.results[m.tui.selected].check.formatOwnership()
+		history := append([]historySample(nil), m.stats[m.tui.selected].history...)
+		window := m.tui.window
+		m.mu.Unlock()
+
+		displayChart(name, ownership, history, window)
+		return
+	}
+
+	results := append([]CheckResult(nil), m.results...)
+	stats := append([]CheckResultStat(nil), m.stats...)
+	acked := make(map[int]bool)
+	for i, checkResult := range results {
+		if _, ok := m.ackOf(checkResult.check); ok {
+			acked[i] = true
+		}
+	}
+	notes := make(map[int]string)
+	for i := range results {
+		if note, ok := m.currentNote(i); ok {
+			notes[i] = note.describe(time.Now())
+		}
+	}
+	options := displayOptions{
+		acked:        acked,
+		notes:        notes,
+		showGeo:      m.checks.GeoIP.Enabled,
+		histogram:    m.checks.Histogram,
+		display:      m.checks.Display,
+		footer:       m.incidentFooter(),
+		silences:     m.silenceFooter(),
+		selected:     -1,
+		problemsOnly: m.tui.problemsOnly,
+	}
+	if m.checks.Budget.Limit > 0 {
+		options.showTraffic = true
+		options.traffic = m.traffic.summary(time.Now())
+		options.overBudget = m.traffic.over(time.Now())
+	}
+	if m.interactive {
+		options.selected = m.tui.selected
+		if m.tui.noting && m.tui.selected < len(results) {
+			options.prompt = fmt.Sprintf("Note on %s: %s_ (Enter adds it, Esc cancels)", results[m.tui.selected].check.Name, m.tui.note)
+		}
+	}
+	options.showSite = m.showSite()
+	options.width, _ = terminalSize()
+	options.health, options.showHealth = m.health()
+	m.mu.Unlock()
+
+	displayResults(results, stats, options)
+}
+
+// failingCriticalChecks returns the names of critical checks which failed or
+// didn't report yet
+func (m *Monitor) failingCriticalChecks() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var failing []string
+	for i, check := range m.checks.Checks {
+		if check.severity() == severityCritical && (m.results[i].execCount == 0 || !m.results[i].status) {
+			failing = append(failing, check.Name)
+		}
+	}
+	return failing
+}
+
+// showSite reports whether results come from more than one site, only then
+// the site needs to be shown. The caller must hold the lock.
+func (m *Monitor) showSite() bool {
+	sites := make(map[string]bool)
+	for _, checkResult := range m.results {
+		if checkResult.check.site != "" {
+			sites[checkResult.check.site] = true
+		}
+	}
+	return len(sites) > 1
+}
+
+// consumer decouples a possibly slow consumer of results (rendering,
+// forwarding) from check execution with a bounded queue. Results that don't
+// fit into the queue are dropped and counted.
+type consumer struct {
+	name    string
+	queue   chan CheckResult
+	dropped atomic.Int64
+}
+
+func newConsumer(name string, size int, handle func(CheckResult)) *consumer {
+	c := &consumer{
+		name:  name,
+		queue: make(chan CheckResult, size),
+	}
+	go func() {
+		for checkResult := range c.queue {
+			handle(checkResult)
+		}
+	}()
+	return c
+}
+
+func (c *consumer) offer(checkResult CheckResult) {
+	select {
+	case c.queue <- checkResult:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// onStateChange wraps a consumer so that it only sees results changing the
+// status of a check. The first result of a check only passes when it fails.
+func onStateChange(handle func(CheckResult)) func(CheckResult) {
+	statuses := make(map[string]bool)
+	return func(checkResult CheckResult) {
+		key := incidentKey(checkResult.check)
+		previous, seen := statuses[key]
+		statuses[key] = checkResult.status
+		if (seen && previous == checkResult.status) || (!seen && checkResult.status) {
+			return
+		}
+		handle(checkResult)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// MQTTConfig publishes the health score to an MQTT broker, e.g. for Home
+// Assistant. The score is published retained to the topic, and the score
+// of every site to <topic>/<site> with agents.
+type MQTTConfig struct {
+	// mqtt://host:port or mqtts://host:port
+	Address  string        `yaml:"address"`
+	Topic    string        `yaml:"topic"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	ClientID string        `yaml:"client_id"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+const (
+	defaultMQTTTopic    = "network-checks/health"
+	defaultMQTTInterval = 30 * time.Second
+)
+
+// MQTT 3.1.1 control packet types, shifted into the first byte
+const (
+	mqttConnect = 1 << 4
+	mqttConnack = 2 << 4
+	mqttPublish = 3 << 4
+	mqttPingreq = 12 << 4
+)
+
+// mqttPacket builds a control packet with its remaining length
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// mqttString encodes a length-prefixed string
+func mqttString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(s))), s...)
+}
+
+// mqttPublisher keeps a connection to the broker, reconnecting when it
+// breaks
+type mqttPublisher struct {
+	config MQTTConfig
+	conn   net.Conn
+}
+
+func newMQTTPublisher(config MQTTConfig) (*mqttPublisher, error) {
+	u, err := url.Parse(config.Address)
+	if err != nil || (u.Scheme != "mqtt" && u.Scheme != "mqtts") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid MQTT address %q, expected mqtt://host:port or mqtts://host:port", config.Address)
+	}
+	// MQTT 3.1.1 has no password without a user name
+	if config.Password != "" && config.Username == "" {
+		return nil, fmt.Errorf("mqtt: password needs a username")
+	}
+	if config.Topic == "" {
+		config.Topic = defaultMQTTTopic
+	}
+	if config.ClientID == "" {
+		hostname, _ := os.Hostname()
+		config.ClientID = "network-checks-" + hostname
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultMQTTInterval
+	}
+	return &mqttPublisher{config: config}, nil
+}
+
+// connect opens the connection and waits for the broker to accept it
+func (p *mqttPublisher) connect() error {
+	u, _ := url.Parse(p.config.Address)
+	host := u.Host
+	if u.Port() == "" {
+		port := "1883"
+		if u.Scheme == "mqtts" {
+			port = "8883"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "mqtts" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Clean session; publishing or pinging at the interval keeps the
+	// connection alive
+	flags := byte(0x02)
+	if p.config.Username != "" {
+		flags |= 0x80
+	}
+	if p.config.Password != "" {
+		flags |= 0x40
+	}
+	keepAlive := min(2*p.config.Interval/time.Second, 65535)
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(keepAlive))
+	body = mqttString(body, p.config.ClientID)
+	if p.config.Username != "" {
+		body = mqttString(body, p.config.Username)
+	}
+	if p.config.Password != "" {
+		body = mqttString(body, p.config.Password)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(mqttPacket(mqttConnect, body)); err != nil {
+		conn.Close()
+		return err
+	}
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return fmt.Errorf("reading CONNACK: %v", err)
+	}
+	if connack[0] != mqttConnack || connack[3] != 0 {
+		conn.Close()
+		return fmt.Errorf("broker refused the connection, return code %d", connack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	p.conn = conn
+	// Nothing else the broker sends matters, e.g. PINGRESP; reading it keeps
+	// the socket buffer from filling up
+	go io.Copy(io.Discard, conn)
+	return nil
+}
+
+// publish sends a retained message with QoS 0, connecting first if needed
+func (p *mqttPublisher) publish(topic string, payload string) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	body := append(mqttString(nil, topic), payload...)
+	return p.write(mqttPacket(mqttPublish|0x01, body))
+}
+
+// ping keeps an open connection alive while there's nothing to publish
+func (p *mqttPublisher) ping() error {
+	if p.conn == nil {
+		return nil
+	}
+	return p.write(mqttPacket(mqttPingreq, nil))
+}
+
+// write sends a packet, dropping the connection when that fails
+func (p *mqttPublisher) write(packet []byte) error {
+	p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := p.conn.Write(packet); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+// startHealthPublishing publishes the health score at the interval
+func startHealthPublishing(config MQTTConfig, monitor *Monitor) error {
+	if config.Address == "" {
+		return nil
+	}
+	publisher, err := newMQTTPublisher(config)
+	if err != nil {
+		return err
+	}
+	publish := func() {
+		monitor.mu.Lock()
+		health, ok := monitor.health()
+		monitor.mu.Unlock()
+		if !ok {
+			if err := publisher.ping(); err != nil {
+				logMessage(logWarning, "Error pinging the MQTT broker:", err)
+			}
+			return
+		}
+		err := publisher.publish(publisher.config.Topic, strconv.Itoa(health.Score))
+		for site, score := range health.Sites {
+			// Started with -site "", local checks have no site name
+			if site == "" {
+				site = "local"
+			}
+			if err == nil {
+				err = publisher.publish(publisher.config.Topic+"/"+site, strconv.Itoa(score))
+			}
+		}
+		if err != nil {
+			logMessage(logWarning, "Error publishing the health score to MQTT:", err)
+		}
+	}
+	go func() {
+		for range time.Tick(publisher.config.Interval) {
+			publish()
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notes kept in memory, the oldest are dropped first
+const maxNotes = 1000
+
+// checkNote is a timestamped note someone attached to a check, e.g. "ISP
+// ticket #12345 opened", to correlate what people did with the results
+type checkNote struct {
+	At time.Time `json:"at"`
+	// Identity of the check, its id or name
+	Check string `json:"check"`
+	// Where it was added: tui, ctl or grpc
+	By   string `json:"by"`
+	Text string `json:"text"`
+	// Set with -sign-key, chaining each note to the previous one like the
+	// results of the recording
+	Signature []byte `json:"sig,omitempty"`
+}
+
+// describe renders the note below its check
+func (note checkNote) describe(now time.Time) string {
+	return fmt.Sprintf("note %s by %s: %s", formatMoment(note.At.Local(), now), note.By, note.Text)
+}
+
+// notesPath returns the file the notes are stored in next to a recording,
+// e.g. results.notes.jsonl for results.jsonl
+func notesPath(recording string) string {
+	ext := filepath.Ext(recording)
+	return strings.TrimSuffix(recording, ext) + ".notes" + ext
+}
+
+// readNotes reads the notes stored next to a recording, oldest first. A
+// recording without notes has no notes file.
+func readNotes(recording string) ([]checkNote, error) {
+	file, err := os.Open(notesPath(recording))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var notes []checkNote
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var note checkNote
+		if err := json.Unmarshal(scanner.Bytes(), &note); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", notesPath(recording), line, err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, scanner.Err()
+}
+
+// notesFile is the file the notes are stored in next to a recording. The
+// monitor appends to it and the recorder compacts it with the recording.
+type notesFile struct {
+	mu        sync.Mutex
+	recording string
+	// Signs the notes with -sign-key, in a chain of their own
+	signer *resultSigner
+}
+
+// openNotesFile prepares the notes file of the recording, with a signer
+// continuing the chain of the notes stored so far
+func openNotesFile(recording string, signer *resultSigner) (*notesFile, error) {
+	f := &notesFile{recording: recording}
+	if signer != nil {
+		f.signer = &resultSigner{key: signer.key}
+		notes, err := readNotes(recording)
+		if err != nil {
+			return nil, err
+		}
+		if len(notes) > 0 {
+			f.signer.prev = notes[len(notes)-1].Signature
+		}
+	}
+	return f, nil
+}
+
+// append stores a note
+func (f *notesFile) append(note checkNote) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.signer != nil {
+		var err error
+		if note, err = f.signer.signNote(note); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(notesPath(f.recording), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(note)
+}
+
+// compact drops the notes the retention no longer keeps any results of,
+// signing the rest anew like compactRecording does
+func (f *notesFile) compact(retention RetentionConfig, now time.Time) (before, after int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	notes, err := readNotes(f.recording)
+	if err != nil {
+		return 0, 0, err
+	}
+	var kept []checkNote
+	for _, note := range notes {
+		if retention.period(note.At, now) >= 0 {
+			kept = append(kept, note)
+		}
+	}
+	if len(kept) == len(notes) {
+		return len(notes), len(kept), nil
+	}
+
+	path := notesPath(f.recording)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return len(notes), 0, err
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	jsonEncoder := json.NewEncoder(writer)
+	var chain *resultSigner
+	if f.signer != nil {
+		chain = &resultSigner{key: f.signer.key}
+	}
+	for _, note := range kept {
+		if chain != nil {
+			if note, err = chain.signNote(note); err != nil {
+				break
+			}
+		}
+		if err = jsonEncoder.Encode(note); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return len(notes), 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return len(notes), 0, err
+	}
+	if f.signer != nil {
+		f.signer.prev = chain.prev
+	}
+	return len(notes), len(kept), nil
+}
+
+// addNote attaches a note to the named check
+func (m *Monitor) addNote(name string, by string, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.noteLocked(name, by, text)
+}
+
+// noteLocked attaches a note to the named check and stores it with the
+// recording, if any. Callers hold m.mu.
+func (m *Monitor) noteLocked(name string, by string, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("the note is empty")
+	}
+	identity := m.identityOf(name)
+	if identity == "" {
+		return fmt.Errorf("unknown check %q", name)
+	}
+	note := checkNote{At: time.Now().UTC(), Check: identity, By: by, Text: text}
+	m.notes = append(m.notes, note)
+	if len(m.notes) > maxNotes {
+		m.notes = m.notes[len(m.notes)-maxNotes:]
+	}
+	logMessage(logInfo, "Note on check", name, "by", by+":", text)
+	if m.notesFile != nil {
+		if err := m.notesFile.append(note); err != nil {
+			logMessage(logErr, "Error storing note:", err)
+		}
+	}
+	return nil
+}
+
+// loadNotes restores the notes stored with the recording
+func (m *Monitor) loadNotes() error {
+	notes, err := readNotes(m.recording)
+	if err != nil || len(notes) == 0 {
+		return err
+	}
+	if len(notes) > maxNotes {
+		notes = notes[len(notes)-maxNotes:]
+	}
+	m.mu.Lock()
+	m.notes = notes
+	m.mu.Unlock()
+	return nil
+}
+
+// notesOf returns the notes of the check added since the time, oldest
+// first. Callers hold m.mu.
+func (m *Monitor) notesOf(check Check, since time.Time) []checkNote {
+	var notes []checkNote
+	for _, note := range m.notes {
+		if note.Check == check.identity() && !note.At.Before(since) {
+			notes = append(notes, note)
+		}
+	}
+	return notes
+}
+
+// listNotes renders the notes of the check with the given name or id, or
+// of all checks, oldest first
+func (m *Monitor) listNotes(name string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lines []string
+	for _, note := range m.notes {
+		if name == "" || note.Check == name || m.identityOf(name) == note.Check {
+			lines = append(lines, fmt.Sprintf("%s %-14s %-4s %s", note.At.Local().Format(time.DateTime), note.Check, note.By, note.Text))
+		}
+	}
+	return lines
+}
+
+// identityOf returns the identity of the check with the given name or id,
+// empty when there's none. Local checks are looked up in the configuration,
+// as they have no result before their first run, checks of agents by their
+// results. Callers hold m.mu.
+func (m *Monitor) identityOf(name string) string {
+	matches := func(check Check) bool {
+		return name != "" && (check.Name == name || (check.ID != "" && check.ID == name))
+	}
+	for _, check := range m.checks.Checks {
+		if matches(check) {
+			return check.identity()
+		}
+	}
+	for _, checkResult := range m.results {
+		if checkResult.check.remote && matches(checkResult.check) {
+			return checkResult.check.identity()
+		}
+	}
+	return ""
+}
+
+// incidentFooter describes the open incidents below the table, each with
+// the notes added to its checks since it started. Callers hold m.mu.
+func (m *Monitor) incidentFooter() []string {
+	now := time.Now()
+	var lines []string
+	for _, incident := range m.incidents.open() {
+		line := incident.describe()
+		for _, check := range incident.members {
+			for _, note := range m.notesOf(check, incident.start) {
+				line += fmt.Sprintf("\n  %s: %s", check.Name, note.describe(now))
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// currentNote returns the latest note added to the check since it's in its
+// state, e.g. about the outage it's in. Callers hold m.mu.
+func (m *Monitor) currentNote(id int) (checkNote, bool) {
+	if id >= len(m.results) || id >= len(m.stats) {
+		return checkNote{}, false
+	}
+	notes := m.notesOf(m.results[id].check, m.stats[id].stateSince)
+	if len(notes) == 0 {
+		return checkNote{}, false
+	}
+	return notes[len(notes)-1], true
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Minimal OCSP client (RFC 6960) to check the revocation status of server
+// certificates and the responses stapled by servers
+
+var (
+	oidSHA1       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidMustStaple = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+)
+
+var ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw         asn1.RawContent
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag     `asn1:"tag:0,optional"`
+	Revoked    asn1.RawValue `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag     `asn1:"tag:2,optional"`
+	ThisUpdate time.Time     `asn1:"generalized"`
+	NextUpdate time.Time     `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+// newCertID identifies a certificate towards its issuer's OCSP responder
+func newCertID(cert, issuer *x509.Certificate) (ocspCertID, error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return ocspCertID{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(publicKeyInfo.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   cert.SerialNumber,
+	}, nil
+}
+
+// parseOCSPResponse verifies an OCSP response for the certificate and returns
+// an error unless the certificate is reported good
+func parseOCSPResponse(der []byte, cert, issuer *x509.Certificate, now time.Time) error {
+	var response ocspResponse
+	if _, err := asn1.Unmarshal(der, &response); err != nil {
+		return fmt.Errorf("invalid OCSP response: %v", err)
+	}
+	if response.Status != 0 {
+		return fmt.Errorf("OCSP responder returned status %d", response.Status)
+	}
+	if !response.ResponseBytes.ResponseType.Equal(oidOCSPBasic) {
+		return fmt.Errorf("unsupported OCSP response type %v", response.ResponseBytes.ResponseType)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(response.ResponseBytes.Response, &basic); err != nil {
+		return fmt.Errorf("invalid OCSP response: %v", err)
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return fmt.Errorf("invalid OCSP response data: %v", err)
+	}
+
+	// The issuer signs the response itself or delegates it to a responder
+	// certificate it issued
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return fmt.Errorf("invalid OCSP responder certificate: %v", err)
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("OCSP responder certificate not issued by the issuer: %v", err)
+			}
+			delegated := false
+			for _, usage := range responder.ExtKeyUsage {
+				delegated = delegated || usage == x509.ExtKeyUsageOCSPSigning
+			}
+			if !delegated {
+				return fmt.Errorf("OCSP responder certificate lacks the OCSP signing usage")
+			}
+			signer = responder
+		}
+	}
+	algorithm, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported OCSP signature algorithm %v", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(algorithm, data.Raw, basic.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("bad OCSP response signature: %v", err)
+	}
+
+	for _, single := range data.Responses {
+		if single.CertID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		switch {
+		case now.Before(single.ThisUpdate.Add(-5 * time.Minute)):
+			return fmt.Errorf("OCSP response not valid before %s", single.ThisUpdate.UTC().Format(time.RFC3339))
+		case !single.NextUpdate.IsZero() && now.After(single.NextUpdate):
+			return fmt.Errorf("OCSP response expired %s", single.NextUpdate.UTC().Format(time.RFC3339))
+		case single.Revoked.FullBytes != nil:
+			return fmt.Errorf("certificate %x is revoked", cert.SerialNumber)
+		case bool(single.Unknown):
+			return fmt.Errorf("certificate %x is unknown to the OCSP responder", cert.SerialNumber)
+		}
+		return nil
+	}
+	return fmt.Errorf("OCSP response doesn't cover certificate %x", cert.SerialNumber)
+}
+
+// queryOCSP asks the responder of the certificate for its status
+func queryOCSP(ctx context.Context, server string, cert, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newCertID(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	var request ocspRequest
+	request.TBSRequest.RequestList = []struct{ Cert ocspCertID }{{Cert: id}}
+	der, err := asn1.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(der))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// checkRevocation verifies the response stapled by the server, if any, and
+// queries the OCSP responder of the server certificate. A missing staple
+// fails when required by the check or by the certificate (must-staple).
+func checkRevocation(ctx context.Context, state *tls.ConnectionState, requireStaple bool) error {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) < 2 {
+		return fmt.Errorf("no verified certificate chain")
+	}
+	cert, issuer := state.VerifiedChains[0][0], state.VerifiedChains[0][1]
+	now := time.Now()
+
+	mustStaple := false
+	for _, extension := range cert.Extensions {
+		mustStaple = mustStaple || extension.Id.Equal(oidMustStaple)
+	}
+	if len(state.OCSPResponse) > 0 {
+		if err := parseOCSPResponse(state.OCSPResponse, cert, issuer, now); err != nil {
+			return fmt.Errorf("stapled: %v", err)
+		}
+	} else if requireStaple || mustStaple {
+		return fmt.Errorf("no stapled OCSP response")
+	}
+
+	if len(cert.OCSPServer) == 0 {
+		return nil
+	}
+	response, err := queryOCSP(ctx, cert.OCSPServer[0], cert, issuer)
+	if err != nil {
+		return err
+	}
+	return parseOCSPResponse(response, cert, issuer, now)
+}
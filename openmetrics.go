@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Formats of the summary of a bounded run
+const (
+	formatText        = "text"
+	formatOpenMetrics = "openmetrics"
+)
+
+// openMetrics returns the results of a bounded run in the OpenMetrics text
+// format, e.g. for the textfile collector of the node_exporter. All metrics
+// are gauges of this run, which the textfile collector reads as well, and
+// carry no timestamps, which it rejects.
+func (m *Monitor) openMetrics() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	family := func(name string, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	ran := func(each func(checkResult CheckResult, stat CheckResultStat)) {
+		for i, checkResult := range m.results {
+			if checkResult.execCount > 0 && i < len(m.stats) {
+				each(checkResult, m.stats[i])
+			}
+		}
+	}
+
+	family("network_checks_check_up", "Whether the latest run of the check succeeded.")
+	ran(func(checkResult CheckResult, _ CheckResultStat) {
+		up := 0
+		if checkResult.status {
+			up = 1
+		}
+		fmt.Fprintf(&b, "network_checks_check_up%s %d\n", promLabels(checkLabels(checkResult.check)...), up)
+	})
+	family("network_checks_check_status", "State of the latest run of the check: 0 down, 1 degraded, 2 ok.")
+	ran(func(checkResult CheckResult, _ CheckResultStat) {
+		fmt.Fprintf(&b, "network_checks_check_status%s %d\n", promLabels(checkLabels(checkResult.check)...), checkResult.stateValue())
+	})
+	family("network_checks_check_duration_seconds", "Latency of the latest run of the check.")
+	ran(func(checkResult CheckResult, _ CheckResultStat) {
+		fmt.Fprintf(&b, "network_checks_check_duration_seconds%s %f\n", promLabels(checkLabels(checkResult.check)...), checkResult.duration.Seconds())
+	})
+	family("network_checks_check_last_run_timestamp_seconds", "Time the latest run of the check started.")
+	ran(func(checkResult CheckResult, _ CheckResultStat) {
+		fmt.Fprintf(&b, "network_checks_check_last_run_timestamp_seconds%s %d\n", promLabels(checkLabels(checkResult.check)...), checkResult.runAt.Unix())
+	})
+	family("network_checks_check_runs", "Runs of the check in this invocation.")
+	ran(func(checkResult CheckResult, _ CheckResultStat) {
+		fmt.Fprintf(&b, "network_checks_check_runs%s %d\n", promLabels(checkLabels(checkResult.check)...), checkResult.execCount)
+	})
+	family("network_checks_check_failures", "Failed runs of the check in this invocation by cause, e.g. dns_error or timeout.")
+	ran(func(checkResult CheckResult, stat CheckResultStat) {
+		causes := make([]string, 0, len(stat.causes))
+		for cause := range stat.causes {
+			causes = append(causes, cause)
+		}
+		sort.Strings(causes)
+		for _, cause := range causes {
+			fmt.Fprintf(&b, "network_checks_check_failures%s %d\n", promLabels(append(checkLabels(checkResult.check), "cause", cause)...), stat.causes[cause])
+		}
+	})
+	b.WriteString("# EOF\n")
+	return b.String()
+}
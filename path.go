@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Destination of a hop standing for the default gateway
+const pathGateway = "gateway"
+
+// PathHop is a step of a path check, e.g. pinging the gateway
+type PathHop struct {
+	Name string `yaml:"name,omitempty"`
+	Type string `yaml:"type"`
+	Dest string `yaml:"dest"`
+	// The path is degraded when the hop takes longer
+	Budget time.Duration `yaml:"budget,omitempty"`
+}
+
+func (hop PathHop) label() string {
+	if hop.Name != "" {
+		return hop.Name
+	}
+	return hop.Dest
+}
+
+// runPathCheck runs the hops in order, e.g. gateway, ISP router, a public
+// resolver and a web site, and stops at the first failing one, which the
+// check reports with the latency of every hop before it
+func runPathCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{check: check, runAt: time.Now()}
+	var steps, slow []string
+	for _, hop := range check.Hops {
+		run := checkProbe(hop.Type)
+		if run == nil || hop.Type == "path" {
+			checkResult.detail = fmt.Sprintf("%s: unsupported type %s", hop.label(), hop.Type)
+			break
+		}
+		dest := hop.Dest
+		if dest == pathGateway {
+			if dest = defaultGateway(); dest == "" {
+				checkResult.detail = fmt.Sprintf("%s: no default gateway", hop.label())
+				checkResult.cause = causeUnreachable
+				break
+			}
+		}
+		hopCheck := Check{
+			Name:      hop.label(),
+			CheckType: hop.Type,
+			Dest:      dest,
+			Via:       check.Via,
+			Timeout:   check.Timeout,
+			site:      check.site,
+		}
+		hopResults := make(chan CheckResult, 1)
+		go run(ctx, hopCheck, hopResults)
+		var hopResult CheckResult
+		select {
+		case hopResult = <-hopResults:
+		case <-ctx.Done():
+			hopResult.detail = "timed out"
+			hopResult.cause = causeTimeout
+		}
+		checkResult.bytes += hopResult.bytes
+		if !hopResult.status {
+			reason := hopResult.detail
+			if reason == "" {
+				reason = "failed"
+			}
+			checkResult.detail = fmt.Sprintf("%s: %s", hop.label(), reason)
+			checkResult.cause = failureCause(hopResult)
+			break
+		}
+		steps = append(steps, fmt.Sprintf("%s %s", hop.label(), strings.TrimSpace(formatDuration(hopResult.duration))))
+		if hop.Budget > 0 && hopResult.duration > hop.Budget {
+			slow = append(slow, fmt.Sprintf("%s %s over %s budget", hop.label(), strings.TrimSpace(formatDuration(hopResult.duration)), strings.TrimSpace(formatDuration(hop.Budget))))
+		}
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+
+	switch {
+	case checkResult.detail != "" && len(steps) > 0:
+		checkResult.detail = fmt.Sprintf("%s, after %s", checkResult.detail, strings.Join(steps, " → "))
+	case checkResult.detail != "":
+	case len(slow) > 0:
+		checkResult.status = true
+		checkResult.degraded = true
+		checkResult.detail = fmt.Sprintf("%s (%s)", strings.Join(slow, ", "), strings.Join(steps, " → "))
+	default:
+		checkResult.status = true
+		checkResult.detail = strings.Join(steps, " → ")
+	}
+	c <- checkResult
+}
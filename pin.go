@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// spkiHash returns the base64 SHA-256 of the public key of the certificate,
+// the format of HPKP pins
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// normalizeSerial makes serials comparable regardless of separators, case
+// and leading zeros
+func normalizeSerial(serial string) string {
+	serial = strings.NewReplacer(":", "", " ", "", "-", "").Replace(strings.ToLower(serial))
+	return strings.TrimLeft(serial, "0")
+}
+
+// checkPins verifies the certificate of the server against the pinned
+// public keys and serials of the check. Any pinned value matching passes,
+// which allows pinning the next certificate before a rotation.
+func checkPins(state *tls.ConnectionState, check Check) error {
+	if len(check.PinSPKI) == 0 && len(check.PinSerial) == 0 {
+		return nil
+	}
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("no TLS certificate to verify the pin")
+	}
+	cert := state.PeerCertificates[0]
+	spki, serial := spkiHash(cert), cert.SerialNumber.Text(16)
+	for _, pin := range check.PinSPKI {
+		if strings.TrimPrefix(pin, "sha256/") == spki {
+			return nil
+		}
+	}
+	for _, pin := range check.PinSerial {
+		if normalizeSerial(pin) == serial {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate %s changed: spki sha256/%s, serial %s", cert.Subject.CommonName, spki, serial)
+}
+
+// peerCertificates returns the connection state of a TLS handshake with the
+// server, whose certificate is verified by pinning rather than the CAs
+func peerCertificates(ctx context.Context, addr string, serverName string) (*tls.ConnectionState, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	defer client.Close()
+	if err := client.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	state := client.ConnectionState()
+	return &state, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The system ping command is parsed by its reply lines rather than by its
+// summary, whose wording differs between implementations and languages:
+//
+//	iputils:  64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=12.3 ms
+//	BusyBox:  64 bytes from 1.1.1.1: seq=0 ttl=57 time=12.345 ms
+//	macOS:    64 bytes from 1.1.1.1: icmp_seq=0 ttl=57 time=12.345 ms
+//	          16 bytes from ::1, icmp_seq=0 hlim=64 time=0.061 ms
+//	Windows:  Reply from 1.1.1.1: bytes=32 time=12ms TTL=57
+//	          Reply from ::1: time<1ms
+//	          Antwort von 1.1.1.1: Bytes=32 Zeit=12ms TTL=57
+//	          Ответ от 1.1.1.1: число байт=32 время=12мс TTL=57
+//
+// Unix-like systems run it with LC_ALL=C. Windows translates the reply
+// lines regardless, so there the round trip is the last value with a unit
+// before the TTL, or the value of an IPv6 reply line in ms.
+var (
+	// A reply line of any implementation, by its TTL or hop limit
+	pingReplyLine = regexp.MustCompile(`(?i)\b(?:ttl|hlim)=\d+`)
+	// Round-trip time of an English reply line, e.g. time=12.3 ms or time<1ms
+	pingReplyTime = regexp.MustCompile(`(?i)\btime[=<]\s*([0-9]+(?:[.,][0-9]+)?)\s*ms`)
+	// Round-trip time of a translated Windows reply line, before the TTL
+	pingLocalizedTime = regexp.MustCompile(`(?i)[=<]\s*([0-9]+(?:[.,][0-9]+)?)\s*[^\s0-9=<]+\s+TTL=`)
+	// Round-trip time ending a translated Windows IPv6 reply line, the only
+	// value after the address, in whatever unit
+	pingLocalizedV6Time = regexp.MustCompile(`:\s+[^\s:=<]+\s*[=<]\s*([0-9]+(?:[.,][0-9]+)?)\s*[^\s0-9=<]+\s*$`)
+)
+
+// pingCommand builds the command running the system ping with the
+// arguments, in the C locale on Unix-like systems (always the case via SSH)
+func pingCommand(ctx context.Context, via string, goos string, args ...string) (*exec.Cmd, error) {
+	if goos == "windows" {
+		return probeCommand(ctx, via, "ping", args...)
+	}
+	return probeCommand(ctx, via, "env", append([]string{"LC_ALL=C", "LANG=C", "ping"}, args...)...)
+}
+
+// parsePingReplies returns the round-trip times of the replies in the output
+// of ping
+func parsePingReplies(output string) []time.Duration {
+	var rtts []time.Duration
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		match := pingReplyTime.FindStringSubmatch(line)
+		switch {
+		case match != nil:
+		case pingReplyLine.MatchString(line):
+			match = pingLocalizedTime.FindStringSubmatch(line)
+		default:
+			match = pingLocalizedV6Time.FindStringSubmatch(line)
+		}
+		if match == nil {
+			continue
+		}
+		ms, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+		if err == nil {
+			rtts = append(rtts, time.Duration(ms*float64(time.Millisecond)))
+		}
+	}
+	return rtts
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePingReplies(t *testing.T) {
+	ms := func(value float64) time.Duration {
+		return time.Duration(value * float64(time.Millisecond))
+	}
+	tests := []struct {
+		name   string
+		output string
+		want   []time.Duration
+	}{
+		{"iputils", "PING 1.1.1.1 (1.1.1.1) 56(84) bytes of data.\n64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=12.3 ms\n\n--- 1.1.1.1 ping statistics ---\n1 packets transmitted, 1 received, 0% packet loss, time 0ms\nrtt min/avg/max/mdev = 12.300/12.300/12.300/0.000 ms", []time.Duration{ms(12.3)}},
+		{"BusyBox", "PING 1.1.1.1 (1.1.1.1): 56 data bytes\n64 bytes from 1.1.1.1: seq=0 ttl=57 time=12.345 ms\n\n--- 1.1.1.1 ping statistics ---\n1 packets transmitted, 1 packets received, 0% packet loss\nround-trip min/avg/max = 12.345/12.345/12.345 ms", []time.Duration{ms(12.345)}},
+		{"macOS", "PING 1.1.1.1 (1.1.1.1): 56 data bytes\n64 bytes from 1.1.1.1: icmp_seq=0 ttl=57 time=12.345 ms", []time.Duration{ms(12.345)}},
+		{"macOS IPv6", "PING6(56=40+8+8 bytes) ::1 --> ::1\n16 bytes from ::1, icmp_seq=0 hlim=64 time=0.061 ms", []time.Duration{ms(0.061)}},
+		{"Windows", "Pinging 1.1.1.1 with 32 bytes of data:\r\nReply from 1.1.1.1: bytes=32 time=12ms TTL=57\r\nReply from 1.1.1.1: bytes=32 time<1ms TTL=57\r\n\r\nPing statistics for 1.1.1.1:\r\n    Packets: Sent = 2, Received = 2, Lost = 0 (0% loss),\r\nApproximate round trip times in milli-seconds:\r\n    Minimum = 0ms, Maximum = 12ms, Average = 6ms", []time.Duration{ms(12), ms(1)}},
+		{"Windows IPv6", "Reply from ::1: time<1ms", []time.Duration{ms(1)}},
+		{"Windows German", "Antwort von 1.1.1.1: Bytes=32 Zeit=12ms TTL=57\r\n    Pakete: Gesendet = 1, Empfangen = 1, Verloren = 0\r\n    Minimum = 12ms, Maximum = 12ms, Mittelwert = 12ms", []time.Duration{ms(12)}},
+		{"Windows German IPv6", "Antwort von ::1: Zeit<1ms", []time.Duration{ms(1)}},
+		{"Windows Russian", "Ответ от 1.1.1.1: число байт=32 время=12мс TTL=57\r\n    Пакетов: отправлено = 1, получено = 1, потеряно = 0", []time.Duration{ms(12)}},
+		{"Windows Russian IPv6", "Ответ от ::1: время<1мс", []time.Duration{ms(1)}},
+		{"Windows decimal comma", "Réponse de ::1 : temps<1,5ms", []time.Duration{ms(1.5)}},
+		{"no reply", "PING 10.0.0.1 (10.0.0.1) 56(84) bytes of data.\n\n--- 10.0.0.1 ping statistics ---\n1 packets transmitted, 0 received, 100% packet loss, time 0ms", nil},
+		{"Windows timeout", "Request timed out.\r\n    Packets: Sent = 1, Received = 0, Lost = 1 (100% loss),", nil},
+	}
+	for _, test := range tests {
+		if got := parsePingReplies(test.output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
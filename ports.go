@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ports probed at the same time by a ports check
+const portsConcurrency = 32
+
+// The open of a ports check expecting every port to be closed
+const portsNone = "none"
+
+// parsePorts parses a list of ports and ranges like 22,80,8000-8010
+func parsePorts(s string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 1 || to > 65535 || from > to {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		for port := from; port <= to; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// parseOpenPorts parses the ports a ports check expects to be open, none
+// for a host that should expose none of them
+func parseOpenPorts(s string) ([]int, error) {
+	if strings.TrimSpace(s) == portsNone {
+		return nil, nil
+	}
+	return parsePorts(s)
+}
+
+// formatPorts lists ports, collapsing consecutive ones into ranges
+func formatPorts(ports []int) string {
+	var parts []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(ports[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runPortsCheck connects to every port in ports on the destination host and
+// fails when a port expected to be open (open, by default all of them) is
+// closed or, when open is set, any other port is open. With open: none, any
+// open port fails it.
+func runPortsCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	ports, err := parsePorts(check.Ports)
+	expected := ports
+	if err == nil && check.Open != "" {
+		expected, err = parseOpenPorts(check.Open)
+	}
+	if err == nil && len(ports) == 0 {
+		err = fmt.Errorf("no ports to check")
+	}
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+
+	var mu sync.Mutex
+	open := make(map[int]bool)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, portsConcurrency)
+	for _, port := range ports {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(port int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(check.Dest, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+				mu.Lock()
+				open[port] = true
+				mu.Unlock()
+			}
+		}(port)
+	}
+	wg.Wait()
+	checkResult.duration = time.Since(checkResult.runAt)
+
+	var closed, unexpected []int
+	isExpected := make(map[int]bool)
+	for _, port := range expected {
+		isExpected[port] = true
+		if !open[port] {
+			closed = append(closed, port)
+		}
+	}
+	if check.Open != "" {
+		for _, port := range ports {
+			if open[port] && !isExpected[port] {
+				unexpected = append(unexpected, port)
+			}
+		}
+	}
+
+	problems := []string{fmt.Sprintf("%d/%d open", len(expected)-len(closed), len(expected))}
+	if len(expected) == 0 {
+		problems = []string{fmt.Sprintf("%d/%d open", len(unexpected), len(ports))}
+	}
+	if len(closed) > 0 {
+		problems = append(problems, "closed: "+formatPorts(closed))
+	}
+	if len(unexpected) > 0 {
+		problems = append(problems, "unexpectedly open: "+formatPorts(unexpected))
+	}
+	if len(closed) == 0 && len(unexpected) == 0 {
+		checkResult.status = true
+	} else {
+		checkResult.detail = strings.Join(problems, "; ")
+		checkResult.cause = causeUnexpected
+	}
+	c <- checkResult
+}
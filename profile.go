@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+// Profile selects the checks and their defaults for a network or location,
+// e.g. home or office
+type Profile struct {
+	Name string `yaml:"name"`
+	// The profile is detected when all of these that are set match
+	SSID       string `yaml:"ssid,omitempty"`
+	GatewayMAC string `yaml:"gateway_mac,omitempty"`
+	// Filters like those of -only and -exclude, e.g. tag=home
+	Only    []string `yaml:"only,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Settings of checks that don't set them, e.g. repeat
+	Defaults Check `yaml:"defaults,omitempty"`
+}
+
+// Profile names with a special meaning for -profile
+const (
+	profileAuto = "auto"
+	profileNone = "none"
+)
+
+// currentSSID returns the SSID of the Wi-Fi network, if connected and any
+// of the platform tools reports it
+func currentSSID() string {
+	commands := [][]string{
+		{"iwgetid", "-r"},
+		{"nmcli", "-t", "-f", "active,ssid", "dev", "wifi"},
+		{"networksetup", "-getairportnetwork", "en0"},
+		{"netsh", "wlan", "show", "interfaces"},
+	}
+	for _, command := range commands {
+		output, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case command[0] == "iwgetid" && line != "":
+				return line
+			case strings.HasPrefix(line, "yes:"):
+				return strings.TrimPrefix(line, "yes:")
+			case strings.HasPrefix(line, "Current Wi-Fi Network: "):
+				return strings.TrimPrefix(line, "Current Wi-Fi Network: ")
+			case strings.HasPrefix(line, "SSID"):
+				if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "SSID" {
+					return strings.TrimSpace(value)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// gatewayMAC returns the MAC address of the default gateway. It is only
+// available on Linux.
+func gatewayMAC() string {
+	if gateway := defaultGateway(); gateway != "" {
+		return arpTable()[gateway]
+	}
+	return ""
+}
+
+// defaultGateway returns the IP address of the default IPv4 gateway. It is
+// only available on Linux.
+func defaultGateway() string {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		// Iface, Destination, Gateway, ... in little-endian hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(gateway))
+		return ip.String()
+	}
+	return ""
+}
+
+// detectProfile returns the first profile whose SSID and gateway match the
+// current network
+func detectProfile(profiles []Profile) *Profile {
+	var ssid, mac string
+	var ssidRead, macRead bool
+	for i, profile := range profiles {
+		if profile.SSID == "" && profile.GatewayMAC == "" {
+			continue
+		}
+		if profile.SSID != "" && !ssidRead {
+			ssid, ssidRead = currentSSID(), true
+		}
+		if profile.GatewayMAC != "" && !macRead {
+			mac, macRead = gatewayMAC(), true
+		}
+		if (profile.SSID == "" || profile.SSID == ssid) &&
+			(profile.GatewayMAC == "" || strings.EqualFold(profile.GatewayMAC, mac)) {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// applyDefaults sets the settings of the defaults the check doesn't set
+func applyDefaults(check *Check, defaults Check) {
+	target := reflect.ValueOf(check).Elem()
+	source := reflect.ValueOf(defaults)
+	for i := 0; i < target.NumField(); i++ {
+		if _, ok := yamlKey(target.Type().Field(i)); ok && target.Field(i).IsZero() {
+			target.Field(i).Set(source.Field(i))
+		}
+	}
+}
+
+// applyProfile selects the profile by name, or detects it with auto, and
+// keeps the checks it selects with its defaults applied
+func applyProfile(checks *Checks, name string) error {
+	if name == profileNone || (name == profileAuto && len(checks.Profiles) == 0) {
+		return nil
+	}
+	var profile *Profile
+	if name == profileAuto {
+		if profile = detectProfile(checks.Profiles); profile == nil {
+			logMessage(logWarning, "No profile matches the current network, running all checks")
+			return nil
+		}
+	} else {
+		for i := range checks.Profiles {
+			if checks.Profiles[i].Name == name {
+				profile = &checks.Profiles[i]
+			}
+		}
+		if profile == nil {
+			return fmt.Errorf("unknown profile %s", name)
+		}
+	}
+
+	var only, exclude checkFilters
+	for _, filter := range profile.Only {
+		if err := only.Set(filter); err != nil {
+			return fmt.Errorf("profile %s: %v", profile.Name, err)
+		}
+	}
+	for _, filter := range profile.Exclude {
+		if err := exclude.Set(filter); err != nil {
+			return fmt.Errorf("profile %s: %v", profile.Name, err)
+		}
+	}
+	checks.Checks = filterChecks(checks.Checks, only, exclude)
+	for i := range checks.Checks {
+		applyDefaults(&checks.Checks[i], profile.Defaults)
+	}
+	logMessage(logInfo, "Using profile", profile.Name)
+	return nil
+}
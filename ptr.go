@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of an address
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", ip[i]&0xf, ip[i]>>4)
+	}
+	return b.String() + "ip6.arpa."
+}
+
+// runPtrCheck looks up the PTR records of the destination address using the
+// resolver of the check. With expect, one of the names must match it, which
+// may contain wildcards like *.example.com.
+func runPtrCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	ip := net.ParseIP(check.Dest)
+	if ip == nil {
+		checkResult.detail = fmt.Sprintf("%s is not an IP address", check.Dest)
+		c <- checkResult
+		return
+	}
+
+	response, err := dnsExchange(ctx, dnsResolver(check), reverseName(ip), dnsTypePTR, false)
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+	checkResult.bytes = int64(response.size)
+	if response.rcode != dnsRcodeSuccess {
+		checkResult.detail = dnsRcodes[response.rcode]
+		checkResult.cause = causeDNS
+		c <- checkResult
+		return
+	}
+
+	var names []string
+	for _, rr := range response.records(reverseName(ip), dnsTypePTR) {
+		if name, err := rr.target(); err == nil {
+			names = append(names, strings.TrimSuffix(name, "."))
+		}
+	}
+	if len(names) == 0 {
+		checkResult.detail = "no PTR record"
+		checkResult.cause = causeUnexpected
+		c <- checkResult
+		return
+	}
+	if check.Expect == "" {
+		checkResult.status = true
+		c <- checkResult
+		return
+	}
+	expected := strings.ToLower(strings.TrimSuffix(check.Expect, "."))
+	for _, name := range names {
+		if matched, _ := path.Match(expected, name); matched {
+			checkResult.status = true
+			c <- checkResult
+			return
+		}
+	}
+	checkResult.detail = fmt.Sprintf("PTR is %s, expected %s", strings.Join(names, ", "), check.Expect)
+	checkResult.cause = causeUnexpected
+	c <- checkResult
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig spaces the runs of all checks against the same host, so
+// that e.g. an icmp and an http check of a host don't probe it at once
+type RateLimitConfig struct {
+	MinInterval time.Duration `yaml:"min_interval"`
+	// Intervals of individual hosts overriding min_interval
+	Hosts map[string]time.Duration `yaml:"hosts"`
+}
+
+// hostLimiter hands out start times of runs per host, queuing the runs that
+// come too early
+type hostLimiter struct {
+	mu     sync.Mutex
+	config RateLimitConfig
+	next   map[string]time.Time
+}
+
+func newHostLimiter(config RateLimitConfig) *hostLimiter {
+	return &hostLimiter{config: config, next: make(map[string]time.Time)}
+}
+
+func (l *hostLimiter) setConfig(config RateLimitConfig) {
+	l.mu.Lock()
+	l.config = config
+	l.mu.Unlock()
+}
+
+// wait blocks until a run against the host may start. It returns false when
+// the context ends first; the reserved slot is kept so that runs stay spaced.
+func (l *hostLimiter) wait(ctx context.Context, host string) bool {
+	l.mu.Lock()
+	interval, ok := l.config.Hosts[host]
+	if !ok {
+		interval = l.config.MinInterval
+	}
+	if interval <= 0 {
+		l.mu.Unlock()
+		return true
+	}
+	start := time.Now()
+	if next := l.next[host]; next.After(start) {
+		start = next
+	}
+	l.next[host] = start.Add(interval)
+	l.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rdapBootstrapUrl  = "https://data.iana.org/rdap/dns.json"
+	whoisIanaServer   = "whois.iana.org:43"
+	defaultExpiryDays = 30
+)
+
+// domainRegistration is what the rdap check watches of a domain
+type domainRegistration struct {
+	expiration time.Time
+	registrar  string
+	status     []string
+}
+
+var (
+	rdapMu sync.Mutex
+	// RDAP base URLs by TLD, loaded once from the IANA bootstrap registry
+	rdapServers map[string]string
+	// The registration seen by the previous run of every check
+	lastRegistrations = make(map[string]domainRegistration)
+)
+
+// rdapServer returns the RDAP base URL for the TLD of a domain, empty when
+// the registry offers no RDAP
+func rdapServer(ctx context.Context, domain string) (string, error) {
+	rdapMu.Lock()
+	defer rdapMu.Unlock()
+	if rdapServers == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rdapBootstrapUrl, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		var bootstrap struct {
+			Services [][][]string `json:"services"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&bootstrap); err != nil {
+			return "", fmt.Errorf("error decoding RDAP bootstrap: %v", err)
+		}
+		rdapServers = make(map[string]string)
+		for _, service := range bootstrap.Services {
+			if len(service) != 2 || len(service[1]) == 0 {
+				continue
+			}
+			for _, tld := range service[0] {
+				rdapServers[strings.ToLower(tld)] = service[1][0]
+			}
+		}
+	}
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(domain), "."), ".")
+	return rdapServers[labels[len(labels)-1]], nil
+}
+
+func lookupRdap(ctx context.Context, server string, domain string) (domainRegistration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(server, "/")+"/domain/"+domain, nil)
+	if err != nil {
+		return domainRegistration{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return domainRegistration{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return domainRegistration{}, fmt.Errorf("RDAP server returned %s", resp.Status)
+	}
+
+	var body struct {
+		Status []string `json:"status"`
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+		Entities []struct {
+			Roles []string `json:"roles"`
+			// ["vcard", [["fn", {}, "text", "Example Registrar"], ...]]
+			VcardArray []json.RawMessage `json:"vcardArray"`
+		} `json:"entities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return domainRegistration{}, fmt.Errorf("error decoding RDAP response: %v", err)
+	}
+	registration := domainRegistration{status: body.Status}
+	for _, event := range body.Events {
+		if event.Action == "expiration" {
+			registration.expiration = event.Date
+		}
+	}
+	for _, entity := range body.Entities {
+		if !contains(entity.Roles, "registrar") || len(entity.VcardArray) != 2 {
+			continue
+		}
+		var properties [][]interface{}
+		if err := json.Unmarshal(entity.VcardArray[1], &properties); err != nil {
+			continue
+		}
+		for _, property := range properties {
+			if len(property) == 4 && property[0] == "fn" {
+				registration.registrar, _ = property[3].(string)
+			}
+		}
+	}
+	return registration, nil
+}
+
+var whoisFields = map[string]*regexp.Regexp{
+	"refer":      regexp.MustCompile(`(?im)^\s*(?:refer|whois):\s*(\S+)`),
+	"expiration": regexp.MustCompile(`(?im)^\s*(?:registry expiry date|registrar registration expiration date|expiration date|expiry date|expires(?: on)?|paid-till):\s*(\S+)`),
+	"registrar":  regexp.MustCompile(`(?im)^\s*registrar(?: name)?:\s*(.+?)\s*$`),
+	"status":     regexp.MustCompile(`(?im)^\s*(?:domain )?status:\s*(\S+)`),
+}
+
+func queryWhois(ctx context.Context, server string, query string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\r\n", query); err != nil {
+		return "", err
+	}
+	response, err := io.ReadAll(bufio.NewReader(io.LimitReader(conn, 1<<20)))
+	return string(response), err
+}
+
+// lookupWhois is the fallback for registries without RDAP, asking IANA for
+// the WHOIS server of the TLD
+func lookupWhois(ctx context.Context, domain string) (domainRegistration, error) {
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+	response, err := queryWhois(ctx, whoisIanaServer, labels[len(labels)-1])
+	if err != nil {
+		return domainRegistration{}, err
+	}
+	refer := whoisFields["refer"].FindStringSubmatch(response)
+	if refer == nil {
+		return domainRegistration{}, fmt.Errorf("no WHOIS server for %s", domain)
+	}
+	if response, err = queryWhois(ctx, net.JoinHostPort(refer[1], "43"), domain); err != nil {
+		return domainRegistration{}, err
+	}
+
+	var registration domainRegistration
+	if match := whoisFields["expiration"].FindStringSubmatch(response); match != nil {
+		for _, layout := range []string{time.RFC3339, "2006-01-02", "2006.01.02", "02-Jan-2006", "2006-01-02T15:04:05"} {
+			if t, err := time.Parse(layout, match[1]); err == nil {
+				registration.expiration = t
+				break
+			}
+		}
+	}
+	if match := whoisFields["registrar"].FindStringSubmatch(response); match != nil {
+		registration.registrar = match[1]
+	}
+	for _, match := range whoisFields["status"].FindAllStringSubmatch(response, -1) {
+		registration.status = append(registration.status, match[1])
+	}
+	return registration, nil
+}
+
+// runRdapCheck looks up the registration of the destination domain and
+// fails when it expires within expiry_days, when the registrar doesn't match
+// expect or when the registrar or status changed since the previous run
+func runRdapCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	domain := strings.TrimSuffix(strings.ToLower(check.Dest), ".")
+
+	server, err := rdapServer(ctx, domain)
+	var registration domainRegistration
+	if err == nil && server != "" {
+		registration, err = lookupRdap(ctx, server, domain)
+	} else if err == nil {
+		registration, err = lookupWhois(ctx, domain)
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+		return
+	}
+	sort.Strings(registration.status)
+
+	rdapMu.Lock()
+	previous, seen := lastRegistrations[check.Name]
+	lastRegistrations[check.Name] = registration
+	rdapMu.Unlock()
+
+	expiryDays := check.ExpiryDays
+	if expiryDays <= 0 {
+		expiryDays = defaultExpiryDays
+	}
+	switch {
+	case registration.expiration.IsZero():
+		checkResult.detail = "no expiration date in the registration"
+	case time.Until(registration.expiration) < time.Duration(expiryDays)*24*time.Hour:
+		checkResult.detail = fmt.Sprintf("expires %s", registration.expiration.Format("2006-01-02"))
+	case check.Expect != "" && !strings.Contains(strings.ToLower(registration.registrar), strings.ToLower(check.Expect)):
+		checkResult.detail = fmt.Sprintf("registrar is %s, expected %s", registration.registrar, check.Expect)
+	case seen && previous.registrar != registration.registrar:
+		checkResult.detail = fmt.Sprintf("registrar changed from %s to %s", previous.registrar, registration.registrar)
+	case seen && strings.Join(previous.status, ",") != strings.Join(registration.status, ","):
+		checkResult.detail = fmt.Sprintf("status changed from %s to %s",
+			strings.Join(previous.status, ", "), strings.Join(registration.status, ", "))
+	default:
+		checkResult.status = true
+	}
+	if !checkResult.status {
+		checkResult.cause = causeUnexpected
+	}
+	c <- checkResult
+}
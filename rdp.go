@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// RDP security protocols (MS-RDPBCGR 2.2.1.1.1)
+const (
+	rdpProtocolSSL     = 0x1
+	rdpProtocolHybrid  = 0x2
+	rdpProtocolHybridX = 0x8
+)
+
+var rdpNegFailures = map[uint32]string{
+	1: "TLS required by server",
+	2: "TLS not allowed by server",
+	3: "no certificate on server",
+	4: "inconsistent flags",
+	5: "CredSSP required by server",
+	6: "TLS with user authentication required by server",
+}
+
+// rdpNegotiate sends the X.224 Connection Request and returns the security
+// protocol the server selected
+func rdpNegotiate(conn net.Conn, requested uint32) (uint32, error) {
+	cookie := []byte("Cookie: mstshash=network-checks\r\n")
+	negReq := []byte{0x01, 0x00, 0x08, 0x00}
+	negReq = binary.LittleEndian.AppendUint32(negReq, requested)
+	x224 := append([]byte{0, 0xe0, 0, 0, 0, 0, 0}, cookie...)
+	x224 = append(x224, negReq...)
+	x224[0] = byte(len(x224) - 1)
+	tpkt := binary.BigEndian.AppendUint16([]byte{3, 0}, uint16(4+len(x224)))
+	if _, err := conn.Write(append(tpkt, x224...)); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != 3 || length < 11 {
+		return 0, fmt.Errorf("not an RDP server")
+	}
+	response := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return 0, err
+	}
+	if response[1] != 0xd0 {
+		return 0, fmt.Errorf("connection request refused")
+	}
+	// Servers only supporting standard RDP security don't answer the
+	// negotiation
+	if len(response) < 15 {
+		return 0, nil
+	}
+	neg := response[7:15]
+	value := binary.LittleEndian.Uint32(neg[4:])
+	switch neg[0] {
+	case 0x02:
+		return value, nil
+	case 0x03:
+		if reason, ok := rdpNegFailures[value]; ok {
+			return 0, fmt.Errorf("negotiation failed: %s", reason)
+		}
+		return 0, fmt.Errorf("negotiation failed with code %d", value)
+	}
+	return 0, fmt.Errorf("invalid negotiation response")
+}
+
+// credSSPStart sends the NTLM negotiate message in a TSRequest and requires
+// a challenge in return, proving that network level authentication works
+func credSSPStart(conn net.Conn) error {
+	tsRequest := berTLV(berSequence,
+		berTLV(0xa0, berInt(berInteger, 6)),
+		berTLV(0xa1, berTLV(berSequence, berTLV(berSequence,
+			berTLV(0xa0, berTLV(berOctetString, ntlmNegotiateMessage()))))))
+	if _, err := conn.Write(tsRequest); err != nil {
+		return err
+	}
+	response, err := berRead(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("CredSSP: %v", err)
+	}
+	index := bytes.Index(response.value, ntlmSignature)
+	if index < 0 || len(response.value) < index+12 || binary.LittleEndian.Uint32(response.value[index+8:]) != 2 {
+		return fmt.Errorf("CredSSP: no NTLM challenge")
+	}
+	return nil
+}
+
+// runRdpCheck completes the RDP negotiation with a server (host[:port]) and
+// the TLS handshake of the selected protocol. With credssp it also starts
+// network level authentication.
+func runRdpCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(err error) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+	}
+	addr := check.Dest
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "3389")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	requested := uint32(rdpProtocolSSL | rdpProtocolHybrid | rdpProtocolHybridX)
+	selected, err := rdpNegotiate(conn, requested)
+	if err != nil {
+		fail(err)
+		return
+	}
+	hybrid := selected&(rdpProtocolHybrid|rdpProtocolHybridX) != 0
+	if check.CredSSP && !hybrid {
+		fail(fmt.Errorf("server doesn't offer network level authentication"))
+		return
+	}
+	if selected != 0 {
+		host, _, _ := net.SplitHostPort(addr)
+		// RDP hosts usually present self-signed certificates
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			fail(fmt.Errorf("TLS: %v", err))
+			return
+		}
+		if check.CredSSP {
+			if err := credSSPStart(tlsConn); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	checkResult.status = true
+	c <- checkResult
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// resultRecorder returns a consumer appending every result to the file as a
+// JSON line in the same format agents report results in. With a retention,
+// the file is compacted on the first result and hourly after. With a
+// signer, every result is signed continuing the chain of the file. The notes
+// file, if any, is compacted along with it.
+func resultRecorder(path string, retention RetentionConfig, signer *resultSigner, notes *notesFile) (func(CheckResult), error) {
+	if signer != nil {
+		if err := signer.resume(path); err != nil {
+			return nil, err
+		}
+	}
+	open := func() (*os.File, error) {
+		return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	}
+	file, err := open()
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(file)
+	var compacted time.Time
+
+	return func(checkResult CheckResult) {
+		if retention.enabled() && time.Since(compacted) >= compactionInterval {
+			compacted = time.Now()
+			file.Close()
+			before, after, err := compactRecording(path, retention, compacted, signer)
+			if err != nil {
+				logMessage(logErr, "Error compacting recording:", err)
+			} else if after < before {
+				logMessage(logInfo, fmt.Sprintf("Compacted %s from %d to %d results", path, before, after))
+			}
+			if notes != nil {
+				before, after, err := notes.compact(retention, compacted)
+				if err != nil {
+					logMessage(logErr, "Error compacting notes:", err)
+				} else if after < before {
+					logMessage(logInfo, fmt.Sprintf("Compacted %s from %d to %d notes", notesPath(path), before, after))
+				}
+			}
+			if file, err = open(); err != nil {
+				logMessage(logErr, "Error opening recording:", err)
+				return
+			}
+			encoder = json.NewEncoder(file)
+		}
+		result := newAgentResult(checkResult)
+		if signer != nil {
+			var err error
+			if result, err = signer.sign(result); err != nil {
+				logMessage(logErr, "Error signing result:", err)
+				return
+			}
+		}
+		if err := encoder.Encode(result); err != nil {
+			logMessage(logErr, "Error recording result:", err)
+		}
+	}, nil
+}
+
+// readRecording calls fn for every result in a recording made with -record
+func readRecording(path string, fn func(AgentResult) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var result AgentResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// replayResults feeds recorded results into the results channel, keeping the
+// original spacing between them divided by speed. A speed of zero replays
+// everything as fast as possible.
+func replayResults(path string, speed float64, monitor *Monitor, c chan CheckResult) error {
+	var previous time.Time
+	return readRecording(path, func(result AgentResult) error {
+		if speed > 0 && !previous.IsZero() && result.RunAt.After(previous) {
+			time.Sleep(time.Duration(float64(result.RunAt.Sub(previous)) / speed))
+		}
+		previous = result.RunAt
+
+		c <- result.checkResult(monitor.remoteId(result.Site, result.identity()))
+		return nil
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	s3Prefix  = "s3://"
+	gitPrefix = "git+"
+	// Timeout of fetching the configuration from a remote source
+	remoteConfigTimeout = 30 * time.Second
+)
+
+// isRemoteConfig reports whether the configuration is read from an HTTP(S)
+// URL, an S3 object or a Git repository
+func isRemoteConfig(source string) bool {
+	for _, prefix := range []string{"https://", "http://", s3Prefix, gitPrefix} {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteConfigCache returns the directory the last configuration fetched from
+// the source is kept in, for when the source is unreachable
+func remoteConfigCache(source string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(source))
+	dir = filepath.Join(dir, "network-checks", hex.EncodeToString(sum[:8]))
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// readRemoteConfig fetches the configuration from a remote source, falling
+// back to the copy cached by the last successful fetch
+func readRemoteConfig(source string) ([]byte, error) {
+	data, _, err := fetchRemoteConfig(source)
+	return data, err
+}
+
+// fetchRemoteConfig fetches the configuration and reports whether it
+// changed since the last fetch
+func fetchRemoteConfig(source string) ([]byte, bool, error) {
+	cache, err := remoteConfigCache(source)
+	if err != nil {
+		return nil, false, err
+	}
+	if strings.HasPrefix(source, gitPrefix) {
+		return fetchGitConfig(strings.TrimPrefix(source, gitPrefix), cache)
+	}
+
+	dataPath, etagPath := filepath.Join(cache, "config"), filepath.Join(cache, "etag")
+	cached, cacheErr := os.ReadFile(dataPath)
+	etag, _ := os.ReadFile(etagPath)
+	if cacheErr != nil {
+		etag = nil
+	}
+	data, newEtag, err := httpGetConfig(source, string(etag))
+	switch {
+	case err != nil && cacheErr == nil:
+		logMessage(logWarning, "Using the cached configuration,", err)
+		return cached, false, nil
+	case err != nil:
+		return nil, false, err
+	case data == nil:
+		// Not modified
+		return cached, false, nil
+	}
+	if err := os.WriteFile(dataPath, data, 0600); err != nil {
+		return nil, false, err
+	}
+	if err := os.WriteFile(etagPath, []byte(newEtag), 0600); err != nil {
+		return nil, false, err
+	}
+	return data, cacheErr != nil || !bytes.Equal(data, cached), nil
+}
+
+// httpGetConfig fetches the configuration unless it still has the ETag, in
+// which case the data is nil
+func httpGetConfig(source, etag string) ([]byte, string, error) {
+	url := source
+	if strings.HasPrefix(source, s3Prefix) {
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(source, s3Prefix), "/")
+		url = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, awsRegion(), awsEscapePath(key))
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if strings.HasPrefix(source, s3Prefix) {
+		signS3Request(req, time.Now().UTC())
+	}
+	client := &http.Client{Timeout: remoteConfigTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, nil
+	case http.StatusOK:
+		data, err := io.ReadAll(resp.Body)
+		return data, resp.Header.Get("ETag"), err
+	}
+	return nil, "", fmt.Errorf("fetching %s: %s", source, resp.Status)
+}
+
+func awsRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+// awsEscapePath encodes an object key the way AWS signatures expect, keeping
+// only unreserved characters and slashes
+func awsEscapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signS3Request signs a GET of an S3 object with Signature Version 4 using
+// the credentials in the standard AWS environment variables. Without them
+// the request stays anonymous, for public buckets.
+func signS3Request(req *http.Request, now time.Time) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return
+	}
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	headers := []string{"host:" + req.URL.Host, "x-amz-content-sha256:UNSIGNED-PAYLOAD", "x-amz-date:" + amzDate}
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		headers = append(headers, "x-amz-security-token:"+token)
+		signedHeaders += ";x-amz-security-token"
+	}
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		strings.Join(headers, "\n") + "\n", signedHeaders, "UNSIGNED-PAYLOAD"}, "\n")
+	scope := date + "/" + awsRegion() + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, awsRegion(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// fetchGitConfig reads a file of a Git repository, given like
+// https://github.com/org/repo.git//checks.yml?ref=main, from a shallow clone
+// kept in the cache. The clone is used as is when fetching fails.
+func fetchGitConfig(source, cache string) ([]byte, bool, error) {
+	source, ref, _ := strings.Cut(source, "?ref=")
+	schemeEnd := 0
+	if i := strings.Index(source, "://"); i >= 0 {
+		schemeEnd = i + len("://")
+	}
+	repo, file, ok := strings.Cut(source[schemeEnd:], "//")
+	if !ok || file == "" {
+		return nil, false, fmt.Errorf("invalid Git source %s, expected <repository>//<path>[?ref=<branch>]", source)
+	}
+	repo = source[:schemeEnd] + repo
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	clone := filepath.Join(cache, "repo")
+	git := func(args ...string) (string, error) {
+		output, err := exec.Command("git", append([]string{"-C", clone}, args...)...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+	if _, err := os.Stat(filepath.Join(clone, ".git")); err != nil {
+		if err := os.MkdirAll(clone, 0700); err != nil {
+			return nil, false, err
+		}
+		if _, err := git("init", "-q"); err != nil {
+			return nil, false, err
+		}
+	}
+	before, cloneErr := git("rev-parse", "--verify", "-q", "HEAD")
+	_, err := git("fetch", "-q", "--depth", "1", repo, ref)
+	if err == nil {
+		_, err = git("checkout", "-q", "--force", "FETCH_HEAD")
+	}
+	switch {
+	case err != nil && cloneErr == nil:
+		logMessage(logWarning, "Using the cached configuration,", err)
+	case err != nil:
+		return nil, false, err
+	}
+	after, _ := git("rev-parse", "HEAD")
+	data, err := os.ReadFile(filepath.Join(clone, filepath.FromSlash(file)))
+	return data, after != before, err
+}
+
+// watchRemoteConfig fetches the configuration at every interval and calls
+// reload when it changed
+func watchRemoteConfig(source string, interval time.Duration, reload func() error) {
+	for range time.Tick(interval) {
+		_, changed, err := fetchRemoteConfig(source)
+		if err != nil {
+			logMessage(logWarning, "Error refreshing the configuration:", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err := reload(); err != nil {
+			logMessage(logWarning, "Error reloading the configuration:", err)
+			continue
+		}
+		logMessage(logInfo, "Reloaded the changed configuration from", source)
+	}
+}
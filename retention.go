@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// How often a recording with retention is compacted
+const compactionInterval = time.Hour
+
+// RetentionConfig bounds the growth of the recording made with -record:
+// results older than raw are downsampled to one aggregate per minute, those
+// older than minute to one per hour, and those older than hour are dropped.
+// A zero age keeps the level forever.
+type RetentionConfig struct {
+	Raw    time.Duration `yaml:"raw"`
+	Minute time.Duration `yaml:"minute"`
+	Hour   time.Duration `yaml:"hour"`
+}
+
+func (r RetentionConfig) enabled() bool {
+	return r.Raw > 0 || r.Minute > 0 || r.Hour > 0
+}
+
+func (r RetentionConfig) validate() error {
+	if r.Raw > 0 && r.Minute > 0 && r.Minute < r.Raw {
+		return fmt.Errorf("retention: minute (%v) must be at least raw (%v)", r.Minute, r.Raw)
+	}
+	for _, shorter := range []time.Duration{r.Raw, r.Minute} {
+		if shorter > 0 && r.Hour > 0 && r.Hour < shorter {
+			return fmt.Errorf("retention: hour (%v) must be at least raw and minute", r.Hour)
+		}
+	}
+	return nil
+}
+
+// period returns the resolution a result of the given time is kept at, zero
+// for raw and -1 when it's dropped
+func (r RetentionConfig) period(runAt time.Time, now time.Time) time.Duration {
+	age := now.Sub(runAt)
+	switch {
+	case r.Hour > 0 && age > r.Hour:
+		return -1
+	case r.Minute > 0 && age > r.Minute:
+		return time.Hour
+	case r.Raw > 0 && age > r.Raw:
+		return time.Minute
+	}
+	return 0
+}
+
+// counts returns the successful and all runs a recorded result stands for,
+// and the total duration of the successful ones
+func (result AgentResult) counts() (ok, total int, durations time.Duration) {
+	if result.Samples == 0 {
+		if result.Status {
+			return 1, 1, result.Duration
+		}
+		return 0, 1, 0
+	}
+	ok = result.Samples - result.Failures
+	return ok, result.Samples, result.Duration * time.Duration(ok)
+}
+
+// compactRecording downsamples and drops the results of a recording
+// according to the retention. The aggregates replace the results in place
+// of the oldest ones, followed by the results kept as they are. With a
+// signer, the compacted recording is signed anew as the aggregates aren't
+// covered by the original signatures.
+func compactRecording(path string, retention RetentionConfig, now time.Time, signer *resultSigner) (before, after int, err error) {
+	type aggregate struct {
+		AgentResult
+		ok, total int
+		durations time.Duration
+	}
+	buckets := make(map[string]*aggregate)
+	err = readRecording(path, func(result AgentResult) error {
+		before++
+		period := retention.period(result.RunAt, now)
+		// Kept as they are or dropped; aggregates are never split into finer
+		// ones
+		if period <= 0 || period < result.Period {
+			return nil
+		}
+		start := result.RunAt.UTC().Truncate(period)
+		key := fmt.Sprintf("%s\x00%s\x00%d\x00%d", result.Site, result.identity(), start.Unix(), period)
+		bucket, found := buckets[key]
+		if !found {
+			bucket = &aggregate{AgentResult: result}
+			bucket.RunAt = start
+			bucket.Period = period
+			buckets[key] = bucket
+		}
+		ok, total, durations := result.counts()
+		bucket.ok += ok
+		bucket.total += total
+		bucket.durations += durations
+		return nil
+	})
+	if err != nil {
+		return before, 0, err
+	}
+
+	aggregates := make([]AgentResult, 0, len(buckets))
+	for _, bucket := range buckets {
+		result := bucket.AgentResult
+		result.Samples = bucket.total
+		result.Failures = bucket.total - bucket.ok
+		result.Status = bucket.ok == bucket.total
+		result.Duration = 0
+		if bucket.ok > 0 {
+			result.Duration = bucket.durations / time.Duration(bucket.ok)
+		}
+		aggregates = append(aggregates, result)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		if !aggregates[i].RunAt.Equal(aggregates[j].RunAt) {
+			return aggregates[i].RunAt.Before(aggregates[j].RunAt)
+		}
+		return aggregates[i].identity() < aggregates[j].identity()
+	})
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return before, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	jsonEncoder := json.NewEncoder(writer)
+	// The compacted recording starts a new chain, which the signer continues
+	// only once it replaced the recording
+	var chain *resultSigner
+	if signer != nil {
+		chain = &resultSigner{key: signer.key}
+	}
+	encode := func(result AgentResult) error {
+		if chain != nil {
+			var err error
+			if result, err = chain.sign(result); err != nil {
+				return err
+			}
+		}
+		return jsonEncoder.Encode(result)
+	}
+	for _, result := range aggregates {
+		if err := encode(result); err != nil {
+			tmp.Close()
+			return before, 0, err
+		}
+	}
+	after = len(aggregates)
+	err = readRecording(path, func(result AgentResult) error {
+		period := retention.period(result.RunAt, now)
+		if period == 0 || (period > 0 && period < result.Period) {
+			after++
+			return encode(result)
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return before, 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return before, 0, err
+	}
+	if signer != nil {
+		signer.prev = chain.prev
+	}
+	return before, after, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RRDConfig configures writing results into Smokeping compatible RRD files
+// using the rrdtool command
+type RRDConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Dir     string        `yaml:"dir"`
+	Step    time.Duration `yaml:"step"`
+	Pings   int           `yaml:"pings"`
+}
+
+const (
+	defaultRRDStep  = 5 * time.Minute
+	defaultRRDPings = 20
+)
+
+var rrdNameReplacer = regexp.MustCompile(`[^-_0-9a-zA-Z]`)
+
+// rrdWriter collects the results of every check over a step and writes them
+// as one Smokeping sample: loss, median and the sorted round trip times.
+type rrdWriter struct {
+	config  RRDConfig
+	mu      sync.Mutex
+	pending map[string]*rrdSample
+}
+
+type rrdSample struct {
+	check Check
+	rtts  []time.Duration
+	total int
+}
+
+func newRRDWriter(config RRDConfig) *rrdWriter {
+	if config.Step <= 0 {
+		config.Step = defaultRRDStep
+	}
+	if config.Pings <= 0 {
+		config.Pings = defaultRRDPings
+	}
+	w := &rrdWriter{config: config, pending: make(map[string]*rrdSample)}
+	go func() {
+		for {
+			time.Sleep(time.Until(nextRun(time.Now(), config.Step)))
+			w.flush(time.Now().Truncate(config.Step))
+		}
+	}()
+	return w
+}
+
+// path returns the Smokeping layout of the check's RRD file: <dir>/<group>/<name>.rrd
+func (config RRDConfig) path(check Check) string {
+	name := rrdNameReplacer.ReplaceAllString(check.Name, "_")
+	if check.Group == "" {
+		return filepath.Join(config.Dir, name+".rrd")
+	}
+	return filepath.Join(config.Dir, rrdNameReplacer.ReplaceAllString(check.Group, "_"), name+".rrd")
+}
+
+// validate rejects checks whose names only differ in the characters replaced
+// in the file names, or in case for case-insensitive filesystems, as they
+// would write into the same RRD file
+func (config RRDConfig) validate(checks []Check) error {
+	if !config.Enabled {
+		return nil
+	}
+	names := make(map[string]string)
+	for _, check := range checks {
+		path := config.path(check)
+		if other, ok := names[strings.ToLower(path)]; ok {
+			return fmt.Errorf("rrd: checks %s and %s would write into the same file %s, rename one", other, check.Name, path)
+		}
+		names[strings.ToLower(path)] = check.Name
+	}
+	return nil
+}
+
+func (w *rrdWriter) add(checkResult CheckResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := w.config.path(checkResult.check)
+	sample, ok := w.pending[key]
+	if !ok {
+		sample = &rrdSample{check: checkResult.check}
+		w.pending[key] = sample
+	}
+	sample.total++
+	if checkResult.status {
+		sample.rtts = append(sample.rtts, checkResult.duration)
+	}
+}
+
+func (w *rrdWriter) flush(at time.Time) {
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*rrdSample)
+	w.mu.Unlock()
+
+	for path, sample := range pending {
+		if err := w.update(path, sample, at); err != nil {
+			logMessage(logErr, "Error writing RRD", path+":", err)
+		}
+	}
+}
+
+func (w *rrdWriter) update(path string, sample *rrdSample, at time.Time) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := w.create(path, at); err != nil {
+			return err
+		}
+	}
+
+	// Smokeping keeps a fixed number of pings per step, scale to it
+	pings := w.config.Pings
+	rtts := sample.rtts
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	loss := float64(sample.total-len(rtts)) * float64(pings) / float64(sample.total)
+
+	values := []string{strconv.FormatInt(at.Unix(), 10), "U", strconv.FormatFloat(loss, 'f', 2, 64)}
+	if len(rtts) > 0 {
+		values = append(values, rrdSeconds(rtts[len(rtts)/2]))
+	} else {
+		values = append(values, "U")
+	}
+	// Smokeping stores the sorted pings followed by the lost ones as unknown
+	received := len(rtts) * pings / sample.total
+	for i := 0; i < pings; i++ {
+		if i < received {
+			values = append(values, rrdSeconds(rtts[i*len(rtts)/received]))
+		} else {
+			values = append(values, "U")
+		}
+	}
+
+	output, err := exec.Command("rrdtool", "update", path, strings.Join(values, ":")).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// create creates the RRD file with the data sources and archives Smokeping uses
+func (w *rrdWriter) create(path string, at time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	step := int(w.config.Step.Seconds())
+	heartbeat := strconv.Itoa(2 * step)
+	args := []string{"create", path,
+		"--step", strconv.Itoa(step),
+		"--start", strconv.FormatInt(at.Add(-w.config.Step).Unix(), 10),
+		"DS:uptime:GAUGE:" + heartbeat + ":0:U",
+		"DS:loss:GAUGE:" + heartbeat + ":0:" + strconv.Itoa(w.config.Pings),
+		"DS:median:GAUGE:" + heartbeat + ":0:180",
+	}
+	for i := 1; i <= w.config.Pings; i++ {
+		args = append(args, fmt.Sprintf("DS:ping%d:GAUGE:%s:0:180", i, heartbeat))
+	}
+	args = append(args,
+		"RRA:AVERAGE:0.5:1:1008",
+		"RRA:AVERAGE:0.5:12:4320",
+		"RRA:MIN:0.5:12:4320",
+		"RRA:MAX:0.5:12:4320",
+		"RRA:AVERAGE:0.5:144:720",
+		"RRA:MAX:0.5:144:720",
+		"RRA:MIN:0.5:144:720",
+	)
+
+	output, err := exec.Command("rrdtool", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func rrdSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'e', 10, 64)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Unknown field errors of yaml.UnmarshalStrict
+var unknownFieldPattern = regexp.MustCompile(`^(line \d+: )?field (\S+) not found in type (\S+)$`)
+
+// yamlKey returns the key of a struct field in the configuration, false for
+// fields that can't be configured
+func yamlKey(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	switch key {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	}
+	return key, true
+}
+
+// configStructs finds the structs of the configuration, by the type name used
+// in yaml errors (e.g. main.Check), with the path they are configured at
+func configStructs(t reflect.Type, path string, found map[string]string) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		configStructs(t.Elem(), path, found)
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		found[t.String()] = path
+		for i := 0; i < t.NumField(); i++ {
+			if key, ok := yamlKey(t.Field(i)); ok {
+				configStructs(t.Field(i).Type, strings.TrimPrefix(path+"."+key, "."), found)
+			}
+		}
+	}
+}
+
+func structByName(t reflect.Type, name string) reflect.Type {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		return structByName(t.Elem(), name)
+	case reflect.Struct:
+		if t.String() == name {
+			return t
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if _, ok := yamlKey(t.Field(i)); ok {
+				if found := structByName(t.Field(i).Type, name); found != nil {
+					return found
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// editDistance is the Levenshtein distance of two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// closestKey returns the key of the struct most similar to a misspelled one,
+// or an empty string when none is close
+func closestKey(t reflect.Type, key string) string {
+	best, bestDistance := "", max(2, len(key)/3)+1
+	for i := 0; i < t.NumField(); i++ {
+		if candidate, ok := yamlKey(t.Field(i)); ok {
+			if distance := editDistance(key, candidate); distance < bestDistance {
+				best, bestDistance = candidate, distance
+			}
+		}
+	}
+	return best
+}
+
+// explainConfigError rewrites unknown field errors of the configuration to
+// name where the field is and suggest the closest known field
+func explainConfigError(err error, root reflect.Type) error {
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return err
+	}
+	paths := make(map[string]string)
+	configStructs(root, "", paths)
+	var messages []string
+	for _, message := range typeErr.Errors {
+		if match := unknownFieldPattern.FindStringSubmatch(message); match != nil {
+			if path, ok := paths[match[3]]; ok {
+				where := ""
+				if path != "" {
+					where = " in " + path
+				}
+				message = fmt.Sprintf("%sunknown field %s%s", match[1], match[2], where)
+				if suggestion := closestKey(structByName(root, match[3]), match[2]); suggestion != "" {
+					message += fmt.Sprintf(", did you mean %s?", suggestion)
+				}
+			}
+		}
+		messages = append(messages, message)
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "\n"))
+}
+
+// jsonSchema describes the configuration of a type as JSON Schema
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]interface{}{
+			"type":        "string",
+			"pattern":     `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+			"description": "duration, e.g. 30s or 1m30s",
+		}
+	case reflect.TypeOf(byteSize(0)):
+		return map[string]interface{}{"type": "string", "description": "size, e.g. 500MB or 2GiB"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			if key, ok := yamlKey(t.Field(i)); ok {
+				properties[key] = jsonSchema(t.Field(i).Type)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	return map[string]interface{}{}
+}
+
+// configSchema returns the JSON Schema of the checks configuration
+func configSchema() map[string]interface{} {
+	schema := jsonSchema(reflect.TypeOf(Checks{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "network-checks configuration"
+	schema["required"] = []string{"checks"}
+	check := schema["properties"].(map[string]interface{})["checks"].(map[string]interface{})["items"].(map[string]interface{})
+	check["required"] = []string{"name", "type", "dest"}
+	return schema
+}
+
+// runSchema implements the schema subcommand printing the JSON Schema of the
+// configuration, e.g. for editor completion and validation. It returns the
+// process exit code.
+func runSchema(args []string) int {
+	flags := flag.NewFlagSet("schema", flag.ExitOnError)
+	output := flags.String("o", "-", "file to write the schema to, - for stdout")
+	flags.Parse(args)
+
+	data, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding schema:", err)
+		return 1
+	}
+	data = append(data, '\n')
+	if *output == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Println("Error writing schema:", err)
+		return 1
+	}
+	return 0
+}
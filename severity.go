@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+)
+
+// Severities of checks, from the most to the least important
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+// severityRanks orders the severities, the most important first
+var severityRanks = map[string]int{severityCritical: 0, severityWarning: 1, severityInfo: 2}
+
+// severity returns the severity of the check, warning unless configured
+func (c Check) severity() string {
+	if c.Severity == "" {
+		return severityWarning
+	}
+	return c.Severity
+}
+
+// validateSeverities rejects unknown severities and turns the deprecated
+// critical: true into severity: critical
+func validateSeverities(checks []Check) error {
+	for i, check := range checks {
+		if _, ok := severityRanks[check.Severity]; check.Severity != "" && !ok {
+			return fmt.Errorf("check %s: unknown severity %q, expected critical, warning or info", check.Name, check.Severity)
+		}
+		if check.Critical {
+			if check.Severity != "" && check.Severity != severityCritical {
+				return fmt.Errorf("check %s: critical: true contradicts severity %s", check.Name, check.Severity)
+			}
+			checks[i].Severity = severityCritical
+		}
+	}
+	return nil
+}
+
+// atLeast reports whether the severity is the given one or more important.
+// An empty minimum accepts all.
+func atLeast(severity string, minimum string) bool {
+	return minimum == "" || severityRanks[severity] <= severityRanks[minimum]
+}
+
+// validateMinSeverity checks the min_severity of an alerting configuration
+func validateMinSeverity(minimum string) error {
+	if _, ok := severityRanks[minimum]; minimum != "" && !ok {
+		return fmt.Errorf("unknown min_severity %q, expected critical, warning or info", minimum)
+	}
+	return nil
+}
+
+// failureColor returns the color of a failing check of the severity
+func failureColor(severity string) *color.Color {
+	switch severity {
+	case severityCritical:
+		return color.New(color.FgRed, color.Bold)
+	case severityInfo:
+		return color.New(color.FgBlue)
+	}
+	return color.New(color.FgRed)
+}
+
+// severityExitCode returns the exit code of a bounded run whose most severe
+// failing check has the severity: 2 for critical, 1 for warning and 0 when
+// only info checks or none failed
+func severityExitCode(severity string) int {
+	switch severity {
+	case severityCritical:
+		return 2
+	case severityWarning:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// resultSigner chains the results of a recording: each one is signed with
+// ed25519 over the signature of the previous one and its JSON encoding, so
+// modifying, reordering or removing a result breaks the chain from there.
+type resultSigner struct {
+	key  ed25519.PrivateKey
+	prev []byte
+}
+
+// signedData returns what the signature of a result covers
+func signedData(result AgentResult, prev []byte) ([]byte, error) {
+	result.Signature = nil
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), prev...), data...), nil
+}
+
+// sign signs the result as the next one of the chain
+func (s *resultSigner) sign(result AgentResult) (AgentResult, error) {
+	data, err := signedData(result, s.prev)
+	if err != nil {
+		return result, err
+	}
+	result.Signature = ed25519.Sign(s.key, data)
+	s.prev = result.Signature
+	return result, nil
+}
+
+// signedNoteData returns what the signature of a note covers
+func signedNoteData(note checkNote, prev []byte) ([]byte, error) {
+	note.Signature = nil
+	data, err := json.Marshal(note)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), prev...), data...), nil
+}
+
+// signNote signs the note as the next one of the chain
+func (s *resultSigner) signNote(note checkNote) (checkNote, error) {
+	data, err := signedNoteData(note, s.prev)
+	if err != nil {
+		return note, err
+	}
+	note.Signature = ed25519.Sign(s.key, data)
+	s.prev = note.Signature
+	return note, nil
+}
+
+// resume continues the chain of an existing recording
+func (s *resultSigner) resume(path string) error {
+	s.prev = nil
+	err := readRecording(path, func(result AgentResult) error {
+		s.prev = result.Signature
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// loadSigningKey reads a private key written by the keygen subcommand
+func loadSigningKey(path string) (*resultSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return &resultSigner{key: privateKey}, nil
+}
+
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return publicKey, nil
+}
+
+// verifyRecording checks the signature chain of a recording and returns
+// the number of results verified
+func verifyRecording(path string, key ed25519.PublicKey) (int, error) {
+	var prev []byte
+	verified := 0
+	err := readRecording(path, func(result AgentResult) error {
+		line := verified + 1
+		if len(result.Signature) == 0 {
+			return fmt.Errorf("line %d isn't signed", line)
+		}
+		data, err := signedData(result, prev)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, data, result.Signature) {
+			return fmt.Errorf("line %d: invalid signature, the result was modified or results before it were modified, removed or reordered", line)
+		}
+		prev = result.Signature
+		verified++
+		return nil
+	})
+	return verified, err
+}
+
+// verifyNotes checks the signature chain of the notes stored next to a
+// recording and returns the number of notes verified
+func verifyNotes(recording string, key ed25519.PublicKey) (int, error) {
+	notes, err := readNotes(recording)
+	if err != nil {
+		return 0, err
+	}
+	var prev []byte
+	for i, note := range notes {
+		if len(note.Signature) == 0 {
+			return i, fmt.Errorf("%s line %d isn't signed", notesPath(recording), i+1)
+		}
+		data, err := signedNoteData(note, prev)
+		if err != nil {
+			return i, err
+		}
+		if !ed25519.Verify(key, data, note.Signature) {
+			return i, fmt.Errorf("%s line %d: invalid signature, the note was modified or notes before it were modified, removed or reordered", notesPath(recording), i+1)
+		}
+		prev = note.Signature
+	}
+	return len(notes), nil
+}
+
+// runKeygen implements the keygen subcommand, writing a key pair for
+// signing recordings. It returns the process exit code.
+func runKeygen(args []string) int {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	output := flags.String("o", "signing", "base name of the key files, written as <name>.key and <name>.pub")
+	flags.Parse(args)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Println("Error generating key:", err)
+		return 1
+	}
+	private, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	public, _ := x509.MarshalPKIXPublicKey(publicKey)
+	if err := os.WriteFile(*output+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}), 0600); err != nil {
+		fmt.Println("Error writing key:", err)
+		return 1
+	}
+	if err := os.WriteFile(*output+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0644); err != nil {
+		fmt.Println("Error writing key:", err)
+		return 1
+	}
+	fmt.Printf("Wrote %s.key (keep it on the recording host) and %s.pub (for verify)\n", *output, *output)
+	return 0
+}
+
+// runVerify implements the verify subcommand, checking the signatures of a
+// recording made with -record and -sign-key. It returns the process exit
+// code.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	from := flags.String("from", "", "recording made with -record and -sign-key")
+	keyPath := flags.String("key", "signing.pub", "public key written by keygen")
+	flags.Parse(args)
+	if *from == "" {
+		fmt.Println("Usage: network-checks verify -from results.jsonl [-key signing.pub]")
+		return 2
+	}
+
+	key, err := loadVerifyKey(*keyPath)
+	if err != nil {
+		fmt.Println("Error loading key:", err)
+		return 1
+	}
+	verified, err := verifyRecording(*from, key)
+	if err != nil {
+		fmt.Printf("Verification failed after %d valid results: %v\n", verified, err)
+		return 1
+	}
+	notes, err := verifyNotes(*from, key)
+	if err != nil {
+		fmt.Printf("Verification failed after %d valid notes: %v\n", notes, err)
+		return 1
+	}
+	if notes > 0 {
+		fmt.Printf("%d results and %d notes verified, the recording is intact\n", verified, notes)
+	} else {
+		fmt.Printf("%d results verified, the recording is intact\n", verified)
+	}
+	return 0
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Silence mutes the alerts of the checks matching all its filters for a
+// time range, e.g. everything tagged lab over a weekend. The checks keep
+// running and recording, only syslog messages and SNMP traps are held back.
+type Silence struct {
+	// Filters like -only, e.g. tag=lab or name~camera
+	Match []string `yaml:"match"`
+	// Start and end as 2006-01-02T15:04 in the local time zone or with an
+	// offset (RFC 3339). Without a start the silence applies right away.
+	Start   string `yaml:"start,omitempty"`
+	End     string `yaml:"end"`
+	Comment string `yaml:"comment,omitempty"`
+	filters checkFilters
+	from    time.Time
+	until   time.Time
+	// Silences added at runtime are numbered from 1 and survive reloads
+	id int
+}
+
+// parseSilenceTime parses a time of a silence, in the local time zone unless
+// it has an offset
+func parseSilenceTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 2006-01-02T15:04 or RFC 3339", value)
+}
+
+// prepare parses the filters and the time range of the silence
+func (s *Silence) prepare() error {
+	if len(s.Match) == 0 {
+		return fmt.Errorf("silence: match is required, e.g. tag=lab")
+	}
+	s.filters = nil
+	for _, match := range s.Match {
+		if err := s.filters.Set(match); err != nil {
+			return fmt.Errorf("silence: match %s: %v", match, err)
+		}
+	}
+	var err error
+	if s.Start != "" {
+		if s.from, err = parseSilenceTime(s.Start); err != nil {
+			return fmt.Errorf("silence: start: %v", err)
+		}
+	}
+	if s.End == "" {
+		return fmt.Errorf("silence: end is required")
+	}
+	if s.until, err = parseSilenceTime(s.End); err != nil {
+		return fmt.Errorf("silence: end: %v", err)
+	}
+	if !s.until.After(s.from) {
+		return fmt.Errorf("silence: end %s isn't after start %s", s.End, s.Start)
+	}
+	return nil
+}
+
+func (s Silence) active(now time.Time) bool {
+	return !now.Before(s.from) && now.Before(s.until)
+}
+
+// matches reports whether the silence applies to the check
+func (s Silence) matches(check Check) bool {
+	for _, filter := range s.filters {
+		if !filter.matches(check) {
+			return false
+		}
+	}
+	return true
+}
+
+// describe renders the silence for the status and the terminal UI
+func (s Silence) describe() string {
+	text := strings.Join(s.Match, ",")
+	if s.id > 0 {
+		text = fmt.Sprintf("#%d %s", s.id, text)
+	}
+	if !s.from.IsZero() && time.Now().Before(s.from) {
+		text += " from " + s.from.Format("2006-01-02 15:04")
+	}
+	text += " until " + s.until.Format("2006-01-02 15:04")
+	if s.Comment != "" {
+		text += ": " + s.Comment
+	}
+	return text
+}
+
+// validateSilences prepares the silences of the configuration
+func validateSilences(silences []Silence) error {
+	for i := range silences {
+		if err := silences[i].prepare(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSilence adds a silence at runtime and returns its id
+func (m *Monitor) addSilence(silence Silence) (int, error) {
+	if err := silence.prepare(); err != nil {
+		return 0, err
+	}
+	if !silence.until.After(time.Now()) {
+		return 0, fmt.Errorf("silence: end %s is in the past", silence.End)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.silenceIds++
+	silence.id = m.silenceIds
+	m.silences = append(m.silences, silence)
+	m.sharedChanged = time.Now()
+	logMessage(logInfo, "Added silence", silence.describe())
+	return silence.id, nil
+}
+
+// deleteSilence removes a silence added at runtime
+func (m *Monitor) deleteSilence(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, silence := range m.silences {
+		if silence.id == id {
+			m.silences = append(m.silences[:i], m.silences[i+1:]...)
+			m.sharedChanged = time.Now()
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown silence #%d", id)
+}
+
+// currentSilences returns the silences from the configuration and those added
+// at runtime which didn't end yet, the active ones first. Expired runtime
+// silences are dropped. Callers hold m.mu.
+func (m *Monitor) currentSilences() []Silence {
+	now := time.Now()
+	kept := m.silences[:0]
+	for _, silence := range m.silences {
+		if now.Before(silence.until) {
+			kept = append(kept, silence)
+		}
+	}
+	m.silences = kept
+	var silences []Silence
+	for _, silence := range append(append([]Silence(nil), m.checks.Silences...), m.silences...) {
+		if now.Before(silence.until) {
+			silences = append(silences, silence)
+		}
+	}
+	sort.SliceStable(silences, func(i, j int) bool {
+		return silences[i].active(now) && !silences[j].active(now)
+	})
+	return silences
+}
+
+// silenceOf returns the active silence applying to the check, if any.
+// Callers hold m.mu.
+func (m *Monitor) silenceOf(check Check) (Silence, bool) {
+	// Silences match the configured tags and group of local checks
+	if !check.remote && check.id < len(m.checks.Checks) && m.checks.Checks[check.id].Name == check.Name {
+		check = m.checks.Checks[check.id]
+	}
+	for _, silence := range m.currentSilences() {
+		if silence.active(time.Now()) && silence.matches(check) {
+			return silence, true
+		}
+	}
+	return Silence{}, false
+}
+
+// silenceFooter lists the active silences below the table. Callers hold
+// m.mu.
+func (m *Monitor) silenceFooter() []string {
+	var lines []string
+	for _, silence := range m.currentSilences() {
+		if silence.active(time.Now()) {
+			lines = append(lines, "SILENCED "+silence.describe())
+		}
+	}
+	return lines
+}
+
+// isSilenced reports whether the alerts of the check are silenced
+func (m *Monitor) isSilenced(check Check) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.silenceOf(check)
+	return ok
+}
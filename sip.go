@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SIP retransmission interval over UDP (T1 of RFC 3261)
+const sipT1 = 500 * time.Millisecond
+
+func sipToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseSipUri splits sip:host[:port][;transport=udp|tcp|tls] or
+// sips:host[:port] into the transport and the address
+func parseSipUri(uri string) (transport string, host string, addr string, err error) {
+	scheme, rest, ok := strings.Cut(uri, ":")
+	if !ok || (scheme != "sip" && scheme != "sips") {
+		return "", "", "", fmt.Errorf("invalid SIP URI %s", uri)
+	}
+	hostport, params, _ := strings.Cut(rest, ";")
+	if _, h, ok := strings.Cut(hostport, "@"); ok {
+		hostport = h
+	}
+	transport, port := "udp", "5060"
+	if scheme == "sips" {
+		transport, port = "tls", "5061"
+	}
+	for _, param := range strings.Split(params, ";") {
+		if value, ok := strings.CutPrefix(strings.ToLower(param), "transport="); ok {
+			transport = value
+			if transport == "tls" {
+				port = "5061"
+			}
+		}
+	}
+	if transport != "udp" && transport != "tcp" && transport != "tls" {
+		return "", "", "", fmt.Errorf("unsupported SIP transport %s", transport)
+	}
+	host, p, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, p = hostport, port
+	}
+	return transport, host, net.JoinHostPort(host, p), nil
+}
+
+// sipStatus reads responses until a final one and returns its status code
+func sipStatus(reader *bufio.Reader) (int, error) {
+	tp := textproto.NewReader(reader)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return 0, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "SIP/2.0" {
+			return 0, fmt.Errorf("invalid SIP response %q", line)
+		}
+		code, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid SIP response %q", line)
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return 0, err
+		}
+		if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+			if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+				return 0, err
+			}
+		}
+		// Skip provisional responses like 100 Trying
+		if code >= 200 {
+			return code, nil
+		}
+	}
+}
+
+// runSipCheck sends a SIP OPTIONS request and validates the status code of
+// the final response against expect_status (200 by default)
+func runSipCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(format string, a ...interface{}) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = fmt.Sprintf(format, a...)
+		checkResult.cause = argumentCause(a)
+		c <- checkResult
+	}
+
+	transport, host, addr, err := parseSipUri(check.Dest)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	var dialer net.Dialer
+	network := transport
+	if transport == "tls" {
+		network = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	defer conn.Close()
+	if transport == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			fail("%v", err)
+			return
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	local := conn.LocalAddr().String()
+	localHost, _, _ := net.SplitHostPort(local)
+	request := fmt.Sprintf("OPTIONS %s SIP/2.0\r\n"+
+		"Via: SIP/2.0/%s %s;branch=z9hG4bK%s;rport\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"From: <sip:network-checks@%s>;tag=%s\r\n"+
+		"To: <%s>\r\n"+
+		"Call-ID: %s@%s\r\n"+
+		"CSeq: 1 OPTIONS\r\n"+
+		"Contact: <sip:network-checks@%s>\r\n"+
+		"User-Agent: network-checks\r\n"+
+		"Accept: application/sdp\r\n"+
+		"Content-Length: 0\r\n\r\n",
+		check.Dest, strings.ToUpper(transport), local, sipToken(), localHost, sipToken(),
+		check.Dest, sipToken(), localHost, local)
+
+	var code int
+	if transport == "udp" {
+		code, err = sipOverUdp(ctx, conn, request)
+	} else {
+		if _, err = io.WriteString(conn, request); err == nil {
+			code, err = sipStatus(bufio.NewReader(conn))
+		}
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+
+	expected := check.ExpectStatus
+	if len(expected) == 0 {
+		expected = []int{200}
+	}
+	for _, status := range expected {
+		if code == status {
+			checkResult.status = true
+			c <- checkResult
+			return
+		}
+	}
+	checkResult.detail = fmt.Sprintf("SIP status %d", code)
+	checkResult.cause = causeUnexpected
+	c <- checkResult
+}
+
+// sipOverUdp retransmits the request until a final response arrives
+func sipOverUdp(ctx context.Context, conn net.Conn, request string) (int, error) {
+	responses := make(chan int, 1)
+	errs := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			code, err := sipStatus(bufio.NewReader(strings.NewReader(string(buf[:n]))))
+			if err == nil {
+				responses <- code
+				return
+			}
+		}
+	}()
+	for interval := sipT1; ; interval *= 2 {
+		if _, err := io.WriteString(conn, request); err != nil {
+			return 0, err
+		}
+		select {
+		case code := <-responses:
+			return code, nil
+		case err := <-errs:
+			return 0, err
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Minimal SMB2 client (MS-SMB2) authenticating with NTLMv2 (MS-NLMP) to
+// connect to a share. Only the 2.x dialects are offered, so signing is
+// plain HMAC-SHA256.
+
+const (
+	smbNegotiate    = 0x0000
+	smbSessionSetup = 0x0001
+	smbTreeConnect  = 0x0003
+	smbFlagSigned   = 0x00000008
+	smbSigningReq   = 0x0002
+	smbSessionGuest = 0x0001
+	smbSessionNull  = 0x0002
+)
+
+// NT status codes
+const (
+	statusSuccess         = 0x00000000
+	statusMoreProcessing  = 0xc0000016
+	statusAccessDenied    = 0xc0000022
+	statusLogonFailure    = 0xc000006d
+	statusBadNetworkName  = 0xc00000cc
+	statusAccountDisabled = 0xc0000072
+	statusPasswordExpired = 0xc0000071
+)
+
+var smbStatusNames = map[uint32]string{
+	statusAccessDenied:    "access denied",
+	statusLogonFailure:    "logon failure",
+	statusBadNetworkName:  "share not found",
+	statusAccountDisabled: "account disabled",
+	statusPasswordExpired: "password expired",
+}
+
+func smbStatusError(operation string, status uint32) error {
+	if name, ok := smbStatusNames[status]; ok {
+		return fmt.Errorf("%s failed: %s", operation, name)
+	}
+	return fmt.Errorf("%s failed with status %#08x", operation, status)
+}
+
+// NTLM negotiate flags
+const (
+	ntlmUnicode         = 0x00000001
+	ntlmRequestTarget   = 0x00000004
+	ntlmSign            = 0x00000010
+	ntlmNTLM            = 0x00000200
+	ntlmAnonymous       = 0x00000800
+	ntlmAlwaysSign      = 0x00008000
+	ntlmExtendedSession = 0x00080000
+	ntlmTargetInfo      = 0x00800000
+	ntlm128             = 0x20000000
+	ntlm56              = 0x80000000
+	ntlmFlags           = ntlmUnicode | ntlmRequestTarget | ntlmSign | ntlmNTLM | ntlmAlwaysSign |
+		ntlmExtendedSession | ntlmTargetInfo | ntlm128 | ntlm56
+)
+
+var (
+	ntlmSignature = []byte("NTLMSSP\x00")
+	oidSpnego     = "1.3.6.1.5.5.2"
+	oidNtlmssp    = "1.3.6.1.4.1.311.2.2.10"
+)
+
+// md4 of RFC 1320, which NTLM hashes passwords with
+func md4(data []byte) []byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+	length := uint64(len(data)) * 8
+	msg := append(append([]byte{}, data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, length)
+
+	var x [16]uint32
+	for block := 0; block < len(msg); block += 64 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[block+i*4:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		for _, i := range []int{0, 4, 8, 12} {
+			a = bits.RotateLeft32(a+(b&c|^b&d)+x[i], 3)
+			d = bits.RotateLeft32(d+(a&b|^a&c)+x[i+1], 7)
+			c = bits.RotateLeft32(c+(d&a|^d&b)+x[i+2], 11)
+			b = bits.RotateLeft32(b+(c&d|^c&a)+x[i+3], 19)
+		}
+		for _, i := range []int{0, 1, 2, 3} {
+			a = bits.RotateLeft32(a+(b&c|b&d|c&d)+x[i]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+(a&b|a&c|b&c)+x[i+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+(d&a|d&b|a&b)+x[i+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+(c&d|c&a|d&a)+x[i+12]+0x5a827999, 13)
+		}
+		for _, i := range []int{0, 2, 1, 3} {
+			a = bits.RotateLeft32(a+(b^c^d)+x[i]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+(a^b^c)+x[i+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+(d^a^b)+x[i+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+(c^d^a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+	var sum []byte
+	for _, v := range []uint32{a, b, c, d} {
+		sum = binary.LittleEndian.AppendUint32(sum, v)
+	}
+	return sum
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, r)
+	}
+	return b
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// ntowfv2 derives the NTLMv2 key of a user
+func ntowfv2(user, password, domain string) []byte {
+	return hmacMD5(md4(utf16le(password)), utf16le(strings.ToUpper(user)+domain))
+}
+
+// ntlmv2Response computes the NT and LM responses to a server challenge and
+// the session base key
+func ntlmv2Response(key, serverChallenge, clientChallenge, targetInfo []byte, timestamp uint64) (nt, lm, sessionKey []byte) {
+	var temp []byte
+	temp = append(temp, 1, 1, 0, 0, 0, 0, 0, 0)
+	temp = binary.LittleEndian.AppendUint64(temp, timestamp)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	proof := hmacMD5(key, serverChallenge, temp)
+	nt = append(proof, temp...)
+	lm = append(hmacMD5(key, serverChallenge, clientChallenge), clientChallenge...)
+	return nt, lm, hmacMD5(key, proof)
+}
+
+// fileTime converts a time to Windows FILETIME, 100ns since 1601
+func fileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func ntlmNegotiateMessage() []byte {
+	b := append([]byte{}, ntlmSignature...)
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, ntlmFlags)
+	return append(b, make([]byte, 16)...)
+}
+
+// ntlmAuthenticateMessage answers the challenge message of the server. An
+// empty user authenticates anonymously.
+func ntlmAuthenticateMessage(challenge []byte, user, password, domain string) ([]byte, []byte, error) {
+	if len(challenge) < 48 || !bytes.HasPrefix(challenge, ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, nil, fmt.Errorf("invalid NTLM challenge")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & ntlmFlags
+	serverChallenge := challenge[24:32]
+	infoLength := int(binary.LittleEndian.Uint16(challenge[40:]))
+	infoOffset := int(binary.LittleEndian.Uint32(challenge[44:]))
+	if infoOffset+infoLength > len(challenge) {
+		return nil, nil, fmt.Errorf("invalid NTLM challenge")
+	}
+	targetInfo := challenge[infoOffset : infoOffset+infoLength]
+
+	var nt, lm, sessionKey []byte
+	if user == "" {
+		flags |= ntlmAnonymous
+		lm = []byte{0}
+	} else {
+		// The server's time avoids failures due to clock skew
+		timestamp := fileTime(time.Now())
+		for i := 0; i+4 <= len(targetInfo); {
+			id := binary.LittleEndian.Uint16(targetInfo[i:])
+			length := int(binary.LittleEndian.Uint16(targetInfo[i+2:]))
+			if id == 7 && length == 8 && i+12 <= len(targetInfo) {
+				timestamp = binary.LittleEndian.Uint64(targetInfo[i+4:])
+			}
+			if id == 0 {
+				break
+			}
+			i += 4 + length
+		}
+		clientChallenge := make([]byte, 8)
+		rand.Read(clientChallenge)
+		nt, lm, sessionKey = ntlmv2Response(ntowfv2(user, password, domain), serverChallenge, clientChallenge, targetInfo, timestamp)
+	}
+
+	fields := [][]byte{lm, nt, utf16le(domain), utf16le(user), utf16le("network-checks"), nil}
+	b := append([]byte{}, ntlmSignature...)
+	b = binary.LittleEndian.AppendUint32(b, 3)
+	offset := 12 + len(fields)*8 + 4
+	var payload []byte
+	for _, field := range fields {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(field)))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(field)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(offset+len(payload)))
+		payload = append(payload, field...)
+	}
+	b = binary.LittleEndian.AppendUint32(b, flags)
+	return append(b, payload...), sessionKey, nil
+}
+
+// spnegoInit wraps the first NTLM token in a SPNEGO NegTokenInit
+func spnegoInit(token []byte) []byte {
+	return berTLV(0x60, berOID(oidSpnego),
+		berTLV(0xa0, berTLV(berSequence,
+			berTLV(0xa0, berTLV(berSequence, berOID(oidNtlmssp))),
+			berTLV(0xa2, berTLV(berOctetString, token)))))
+}
+
+// spnegoResponse wraps a further NTLM token in a SPNEGO NegTokenResp
+func spnegoResponse(token []byte) []byte {
+	return berTLV(0xa1, berTLV(berSequence, berTLV(0xa2, berTLV(berOctetString, token))))
+}
+
+// smbConn exchanges SMB2 messages over direct TCP
+type smbConn struct {
+	conn       net.Conn
+	messageId  uint64
+	sessionId  uint64
+	signingKey []byte
+}
+
+type smbResponse struct {
+	status    uint32
+	sessionId uint64
+	body      []byte
+}
+
+func (s *smbConn) request(command uint16, body []byte) (smbResponse, error) {
+	header := make([]byte, 64)
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], 64)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	binary.LittleEndian.PutUint16(header[14:], 1)
+	binary.LittleEndian.PutUint64(header[24:], s.messageId)
+	binary.LittleEndian.PutUint64(header[40:], s.sessionId)
+	s.messageId++
+	message := append(header, body...)
+	if s.signingKey != nil {
+		binary.LittleEndian.PutUint32(message[16:], smbFlagSigned)
+		mac := hmac.New(sha256.New, s.signingKey)
+		mac.Write(message)
+		copy(message[48:64], mac.Sum(nil))
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(message)))
+	if _, err := s.conn.Write(append(frame, message...)); err != nil {
+		return smbResponse{}, err
+	}
+	for {
+		var length uint32
+		if err := binary.Read(s.conn, binary.BigEndian, &length); err != nil {
+			return smbResponse{}, err
+		}
+		length &= 0xffffff
+		if length < 64 {
+			return smbResponse{}, fmt.Errorf("invalid SMB response")
+		}
+		response := make([]byte, length)
+		if _, err := io.ReadFull(s.conn, response); err != nil {
+			return smbResponse{}, err
+		}
+		if !bytes.HasPrefix(response, []byte("\xfeSMB")) {
+			return smbResponse{}, fmt.Errorf("not an SMB2 response")
+		}
+		status := binary.LittleEndian.Uint32(response[8:])
+		// Interim response of an asynchronous operation
+		if status == 0x00000103 {
+			continue
+		}
+		return smbResponse{
+			status:    status,
+			sessionId: binary.LittleEndian.Uint64(response[40:]),
+			body:      response[64:],
+		}, nil
+	}
+}
+
+func (s *smbConn) sessionSetup(token []byte) (smbResponse, error) {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body, 25)
+	body[3] = 1 // signing enabled
+	binary.LittleEndian.PutUint16(body[12:], 64+24)
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(token)))
+	return s.request(smbSessionSetup, append(body, token...))
+}
+
+// connectShare negotiates SMB2, authenticates and connects to the share
+func (s *smbConn) connectShare(host, share, user, password string) error {
+	negotiate := make([]byte, 36)
+	binary.LittleEndian.PutUint16(negotiate, 36)
+	binary.LittleEndian.PutUint16(negotiate[2:], 2)
+	binary.LittleEndian.PutUint16(negotiate[4:], 1)
+	rand.Read(negotiate[12:28])
+	negotiate = binary.LittleEndian.AppendUint16(negotiate, 0x0202)
+	negotiate = binary.LittleEndian.AppendUint16(negotiate, 0x0210)
+	response, err := s.request(smbNegotiate, negotiate)
+	if err != nil {
+		return err
+	}
+	if response.status != statusSuccess {
+		return smbStatusError("negotiate", response.status)
+	}
+	if len(response.body) < 4 {
+		return fmt.Errorf("invalid SMB negotiate response")
+	}
+	signingRequired := binary.LittleEndian.Uint16(response.body[2:])&smbSigningReq != 0
+
+	response, err = s.sessionSetup(spnegoInit(ntlmNegotiateMessage()))
+	if err != nil {
+		return err
+	}
+	if response.status != statusMoreProcessing {
+		return smbStatusError("session setup", response.status)
+	}
+	s.sessionId = response.sessionId
+	index := bytes.Index(response.body, ntlmSignature)
+	if index < 0 {
+		return fmt.Errorf("no NTLM challenge in the session setup response")
+	}
+	domain := ""
+	if d, u, ok := strings.Cut(user, `\`); ok {
+		domain, user = d, u
+	}
+	authenticate, sessionKey, err := ntlmAuthenticateMessage(response.body[index:], user, password, domain)
+	if err != nil {
+		return err
+	}
+	response, err = s.sessionSetup(spnegoResponse(authenticate))
+	if err != nil {
+		return err
+	}
+	if response.status != statusSuccess {
+		return smbStatusError("session setup", response.status)
+	}
+	var sessionFlags uint16
+	if len(response.body) >= 2 {
+		sessionFlags = binary.LittleEndian.Uint16(response.body)
+	}
+	// A server mapping unknown users to guest, e.g. Samba with map to guest
+	// = bad user, accepts wrong credentials with a guest session
+	if user != "" && sessionFlags&(smbSessionGuest|smbSessionNull) != 0 {
+		return unexpectedf("the server accepted %s only as a guest, the credentials were rejected", user)
+	}
+	// Guest and anonymous sessions can't sign
+	if sessionFlags&(smbSessionGuest|smbSessionNull) == 0 && signingRequired && sessionKey != nil {
+		s.signingKey = sessionKey
+	}
+
+	path := utf16le(`\\` + host + `\` + share)
+	treeConnect := make([]byte, 8)
+	binary.LittleEndian.PutUint16(treeConnect, 9)
+	binary.LittleEndian.PutUint16(treeConnect[4:], 64+8)
+	binary.LittleEndian.PutUint16(treeConnect[6:], uint16(len(path)))
+	response, err = s.request(smbTreeConnect, append(treeConnect, path...))
+	if err != nil {
+		return err
+	}
+	if response.status != statusSuccess {
+		return smbStatusError("connecting to "+share, response.status)
+	}
+	return nil
+}
+
+// runSmbCheck connects to a share (smb://host[:port]/share) as username
+// (optionally DOMAIN\user) with password, anonymously without username
+func runSmbCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	u, err := url.Parse(check.Dest)
+	if err != nil || u.Scheme != "smb" || strings.Trim(u.Path, "/") == "" {
+		checkResult.detail = fmt.Sprintf("invalid SMB URL %s", check.Dest)
+		c <- checkResult
+		return
+	}
+	share := strings.Trim(u.Path, "/")
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "445")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		s := &smbConn{conn: conn}
+		err = s.connectShare(u.Hostname(), share, check.Username, check.Password)
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+	if err != nil {
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+	} else {
+		checkResult.status = true
+	}
+	c <- checkResult
+}
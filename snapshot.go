@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Default interval of writing the stats snapshot
+const defaultSnapshotInterval = time.Minute
+
+// statsSnapshot is the in-memory state of the local checks saved with
+// -snapshot, keyed by check identity
+type statsSnapshot struct {
+	SavedAt time.Time                `json:"saved_at"`
+	Checks  map[string]checkSnapshot `json:"checks"`
+	// Notes of all checks, also stored with the recording when there's one
+	Notes []checkNote `json:"notes,omitempty"`
+}
+
+// checkSnapshot holds the latest result of a check and the statistics the
+// display is built from
+type checkSnapshot struct {
+	Status    bool             `json:"status"`
+	RunAt     time.Time        `json:"run_at"`
+	Duration  time.Duration    `json:"duration"`
+	Detail    string           `json:"detail,omitempty"`
+	Degraded  bool             `json:"degraded,omitempty"`
+	ExecCount int              `json:"exec_count"`
+	Timeouts  int              `json:"timeouts"`
+	Bytes     int64            `json:"bytes"`
+	Last10    []time.Duration  `json:"last10"`
+	Last100   []time.Duration  `json:"last100"`
+	Last50    []bool           `json:"last50"`
+	History   []snapshotSample `json:"history"`
+	// Since when the check is in its state and when it last failed
+	StateSince  time.Time `json:"state_since"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+type snapshotSample struct {
+	RunAt    time.Time     `json:"run_at"`
+	Duration time.Duration `json:"duration"`
+	Status   bool          `json:"status"`
+	Degraded bool          `json:"degraded,omitempty"`
+	Cause    string        `json:"cause,omitempty"`
+}
+
+// snapshot captures the state of the local checks that ran
+func (m *Monitor) snapshot() statsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := statsSnapshot{SavedAt: time.Now().UTC(), Checks: make(map[string]checkSnapshot), Notes: append([]checkNote(nil), m.notes...)}
+	for i, check := range m.checks.Checks {
+		if i >= len(m.results) || m.results[i].execCount == 0 {
+			continue
+		}
+		result, stat := m.results[i], m.stats[i]
+		entry := checkSnapshot{
+			Status:      result.status,
+			RunAt:       result.runAt.UTC(),
+			Duration:    result.duration,
+			Detail:      result.detail,
+			Degraded:    result.degraded,
+			ExecCount:   result.execCount,
+			Timeouts:    stat.timeouts,
+			Bytes:       stat.bytes,
+			Last10:      stat.shortDurations,
+			Last100:     stat.longDurations,
+			Last50:      stat.recentStatuses,
+			StateSince:  stat.stateSince.UTC(),
+			LastFailure: stat.lastFailure.UTC(),
+		}
+		for _, sample := range stat.history {
+			entry.History = append(entry.History, snapshotSample{RunAt: sample.runAt.UTC(), Duration: sample.duration, Status: sample.status,
+				Degraded: sample.degraded, Cause: sample.cause})
+		}
+		snapshot.Checks[check.identity()] = entry
+	}
+	return snapshot
+}
+
+// writeSnapshot saves the snapshot atomically, so a crash while writing
+// leaves the previous one
+func writeSnapshot(path string, snapshot statsSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreSnapshot loads the state of the checks still configured from a
+// snapshot. A missing snapshot isn't an error, the first start has none.
+func (m *Monitor) restoreSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var snapshot statsSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notes = snapshot.Notes
+	restored := 0
+	for i, check := range m.checks.Checks {
+		entry, ok := snapshot.Checks[check.identity()]
+		if !ok || i >= len(m.results) {
+			continue
+		}
+		m.results[i] = CheckResult{
+			check:     check,
+			status:    entry.Status,
+			runAt:     entry.RunAt.Local(),
+			duration:  entry.Duration,
+			detail:    entry.Detail,
+			degraded:  entry.Degraded,
+			execCount: entry.ExecCount,
+		}
+		stat := &m.stats[i]
+		stat.timeouts = entry.Timeouts
+		stat.bytes = entry.Bytes
+		stat.shortDurations = entry.Last10
+		stat.longDurations = entry.Last100
+		stat.recentStatuses = entry.Last50
+		if !entry.StateSince.IsZero() {
+			stat.stateSince = entry.StateSince.Local()
+		}
+		if !entry.LastFailure.IsZero() {
+			stat.lastFailure = entry.LastFailure.Local()
+		}
+		stat.history = nil
+		for _, sample := range entry.History {
+			stat.history = append(stat.history, historySample{runAt: sample.RunAt.Local(), duration: sample.Duration, status: sample.Status,
+				degraded: sample.Degraded, cause: sample.Cause})
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// startSnapshots writes a snapshot at the interval
+func startSnapshots(monitor *Monitor, path string, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := writeSnapshot(path, monitor.snapshot()); err != nil {
+				logMessage(logErr, "Error writing stats snapshot:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNMPConfig configures sending SNMP traps when checks fail or recover. The
+// traps are described by NETWORK-CHECKS-MIB.txt.
+type SNMPConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Version string `yaml:"version"`
+	// Only checks of this severity or a more important one are sent
+	MinSeverity string `yaml:"min_severity,omitempty"`
+
+	// v2c
+	Community string `yaml:"community"`
+
+	// v3 user based security model
+	User         string `yaml:"user"`
+	AuthProtocol string `yaml:"auth_protocol"`
+	AuthPassword string `yaml:"auth_password"`
+	PrivProtocol string `yaml:"priv_protocol"`
+	PrivPassword string `yaml:"priv_password"`
+	EngineId     string `yaml:"engine_id"`
+}
+
+const (
+	defaultSNMPPort      = "162"
+	defaultSNMPCommunity = "public"
+)
+
+// OIDs of NETWORK-CHECKS-MIB
+const (
+	oidNetworkChecks  = "1.3.6.1.4.1.32473.1"
+	oidCheckFailed    = oidNetworkChecks + ".0.1"
+	oidCheckRecovered = oidNetworkChecks + ".0.2"
+	oidCheckName      = oidNetworkChecks + ".1.1"
+	oidCheckType      = oidNetworkChecks + ".1.2"
+	oidCheckDest      = oidNetworkChecks + ".1.3"
+	oidCheckSite      = oidNetworkChecks + ".1.4"
+	oidCheckDuration  = oidNetworkChecks + ".1.5"
+	oidCheckLabels    = oidNetworkChecks + ".1.6"
+	oidCheckOwner     = oidNetworkChecks + ".1.7"
+	oidCheckContact   = oidNetworkChecks + ".1.8"
+	oidCheckRunbook   = oidNetworkChecks + ".1.9"
+	oidCheckSeverity  = oidNetworkChecks + ".1.10"
+	oidSysUpTime      = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID    = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// Engine id of enterprise 32473 in text format
+var defaultSNMPEngineId = append([]byte{0x80, 0x00, 0x7e, 0xd9, 0x04}, "network-checks"...)
+
+// BER tags
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berOid         = 0x06
+	berSequence    = 0x30
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+	berTrapV2      = 0xa7
+)
+
+// snmpTrapSender sends a trap for every state change of a check
+type snmpTrapSender struct {
+	config    SNMPConfig
+	address   string
+	started   time.Time
+	requestId uint32
+
+	// v3
+	engineId []byte
+	authHash func() hash.Hash
+	authKey  []byte
+	privKey  []byte
+}
+
+func newSNMPTrapSender(config SNMPConfig) (*snmpTrapSender, error) {
+	if err := validateMinSeverity(config.MinSeverity); err != nil {
+		return nil, err
+	}
+	s := &snmpTrapSender{config: config, address: config.Address, started: time.Now()}
+	if _, _, err := net.SplitHostPort(s.address); err != nil {
+		s.address = net.JoinHostPort(s.address, defaultSNMPPort)
+	}
+	switch config.Version {
+	case "", "2c":
+		if s.config.Community == "" {
+			s.config.Community = defaultSNMPCommunity
+		}
+	case "3":
+		if config.User == "" {
+			return nil, fmt.Errorf("SNMPv3 requires a user")
+		}
+		s.engineId = defaultSNMPEngineId
+		if config.EngineId != "" {
+			engineId, err := hex.DecodeString(strings.TrimPrefix(config.EngineId, "0x"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid SNMP engine id: %v", err)
+			}
+			s.engineId = engineId
+		}
+		switch strings.ToLower(config.AuthProtocol) {
+		case "":
+		case "md5":
+			s.authHash = md5.New
+		case "sha":
+			s.authHash = sha1.New
+		default:
+			return nil, fmt.Errorf("unsupported SNMP auth protocol %q, use md5 or sha", config.AuthProtocol)
+		}
+		if s.authHash != nil {
+			s.authKey = localizeKey(s.authHash, config.AuthPassword, s.engineId)
+		}
+		switch strings.ToLower(config.PrivProtocol) {
+		case "":
+		case "aes":
+			if s.authHash == nil {
+				return nil, fmt.Errorf("SNMP privacy requires authentication")
+			}
+			s.privKey = localizeKey(s.authHash, config.PrivPassword, s.engineId)[:16]
+		default:
+			return nil, fmt.Errorf("unsupported SNMP priv protocol %q, use aes", config.PrivProtocol)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q, use 2c or 3", config.Version)
+	}
+	return s, nil
+}
+
+// localizeKey derives the key of a user for an engine (RFC 3414 A.2)
+func localizeKey(newHash func() hash.Hash, password string, engineId []byte) []byte {
+	h := newHash()
+	if password != "" {
+		// Hash one megabyte of the repeated password
+		chunk := make([]byte, 64)
+		for i := 0; i < 1048576; i += len(chunk) {
+			for j := range chunk {
+				chunk[j] = password[(i+j)%len(password)]
+			}
+			h.Write(chunk)
+		}
+	}
+	ku := h.Sum(nil)
+	h = newHash()
+	h.Write(ku)
+	h.Write(engineId)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berTLV(tag byte, value ...[]byte) []byte {
+	var content []byte
+	for _, v := range value {
+		content = append(content, v...)
+	}
+	return append(append([]byte{tag}, berLength(len(content))...), content...)
+}
+
+func berInt(tag byte, n int64) []byte {
+	// Minimal two's complement, which keeps unsigned values positive
+	b := []byte{byte(n)}
+	for rest := n >> 8; !(rest == 0 && b[0]&0x80 == 0) && !(rest == -1 && b[0]&0x80 != 0); rest >>= 8 {
+		b = append([]byte{byte(rest)}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berOID(oid string) []byte {
+	var parts []uint64
+	for _, part := range strings.Split(oid, ".") {
+		n, _ := strconv.ParseUint(part, 10, 32)
+		parts = append(parts, n)
+	}
+	b := []byte{byte(parts[0]*40 + parts[1])}
+	for _, n := range parts[2:] {
+		enc := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			enc = append([]byte{byte(n&0x7f) | 0x80}, enc...)
+		}
+		b = append(b, enc...)
+	}
+	return berTLV(berOid, b)
+}
+
+func berVarBind(oid string, value []byte) []byte {
+	return berTLV(berSequence, berOID(oid), value)
+}
+
+// pdu builds the trap PDU for a result
+func (s *snmpTrapSender) pdu(checkResult CheckResult) []byte {
+	s.requestId++
+	trap := oidCheckFailed
+	if checkResult.status {
+		trap = oidCheckRecovered
+	}
+	check := checkResult.check
+	return berTLV(berTrapV2,
+		berInt(berInteger, int64(s.requestId&0x7fffffff)),
+		berInt(berInteger, 0),
+		berInt(berInteger, 0),
+		berTLV(berSequence,
+			berVarBind(oidSysUpTime, berInt(berTimeTicks, int64(time.Since(s.started)/(10*time.Millisecond)))),
+			berVarBind(oidSnmpTrapOID, berOID(trap)),
+			berVarBind(oidCheckName, berTLV(berOctetString, []byte(check.Name))),
+			berVarBind(oidCheckType, berTLV(berOctetString, []byte(check.CheckType))),
+			berVarBind(oidCheckDest, berTLV(berOctetString, []byte(check.Dest))),
+			berVarBind(oidCheckSite, berTLV(berOctetString, []byte(check.site))),
+			berVarBind(oidCheckDuration, berInt(berGauge32, checkResult.duration.Milliseconds())),
+			berVarBind(oidCheckLabels, berTLV(berOctetString, []byte(check.formatLabels()))),
+			berVarBind(oidCheckOwner, berTLV(berOctetString, []byte(check.Owner))),
+			berVarBind(oidCheckContact, berTLV(berOctetString, []byte(check.Contact))),
+			berVarBind(oidCheckRunbook, berTLV(berOctetString, []byte(check.Runbook))),
+			berVarBind(oidCheckSeverity, berTLV(berOctetString, []byte(check.severity()))),
+		),
+	)
+}
+
+// messageV2c wraps a PDU into a community based message
+func (s *snmpTrapSender) messageV2c(pdu []byte) []byte {
+	return berTLV(berSequence,
+		berInt(berInteger, 1),
+		berTLV(berOctetString, []byte(s.config.Community)),
+		pdu,
+	)
+}
+
+// messageV3 wraps a PDU into a user based security model message, the sender
+// of a trap being the authoritative engine (RFC 3414)
+func (s *snmpTrapSender) messageV3(pdu []byte) ([]byte, error) {
+	boots := int64(1)
+	engineTime := int64(time.Since(s.started).Seconds())
+	flags := byte(0)
+	scopedPdu := berTLV(berSequence,
+		berTLV(berOctetString, s.engineId),
+		berTLV(berOctetString, nil),
+		pdu,
+	)
+
+	var authParams, privParams []byte
+	if s.authKey != nil {
+		flags |= 0x01
+		authParams = make([]byte, 12)
+	}
+	if s.privKey != nil {
+		flags |= 0x02
+		privParams = make([]byte, 8)
+		if _, err := rand.Read(privParams); err != nil {
+			return nil, err
+		}
+		// AES-128 in CFB mode (RFC 3826)
+		iv := make([]byte, 16)
+		binary.BigEndian.PutUint32(iv[0:], uint32(boots))
+		binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+		copy(iv[8:], privParams)
+		block, err := aes.NewCipher(s.privKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(scopedPdu))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scopedPdu)
+		scopedPdu = berTLV(berOctetString, encrypted)
+	}
+
+	beforeAuth := bytes.Join([][]byte{
+		berTLV(berOctetString, s.engineId),
+		berInt(berInteger, boots),
+		berInt(berInteger, engineTime),
+		berTLV(berOctetString, []byte(s.config.User)),
+	}, nil)
+	securityParams := berTLV(berSequence,
+		beforeAuth,
+		berTLV(berOctetString, authParams),
+		berTLV(berOctetString, privParams),
+	)
+	message := berTLV(berSequence,
+		berInt(berInteger, 3),
+		berTLV(berSequence,
+			berInt(berInteger, int64(s.requestId&0x7fffffff)),
+			berInt(berInteger, 65507),
+			berTLV(berOctetString, []byte{flags}),
+			berInt(berInteger, 3),
+		),
+		berTLV(berOctetString, securityParams),
+		scopedPdu,
+	)
+
+	if s.authKey != nil {
+		// The digest is computed over the message with zeroed auth params and
+		// then put in their place
+		mac := hmac.New(s.authHash, s.authKey)
+		mac.Write(message)
+		digest := mac.Sum(nil)[:12]
+		header := len(securityParams) - len(beforeAuth) - (2 + len(authParams)) - (2 + len(privParams))
+		offset := bytes.Index(message, securityParams) + header + len(beforeAuth) + 2
+		copy(message[offset:], digest)
+	}
+	return message, nil
+}
+
+// send is a consumer sending a trap for a state change, see onStateChange
+func (s *snmpTrapSender) send(checkResult CheckResult) {
+	if !atLeast(checkResult.check.severity(), s.config.MinSeverity) {
+		return
+	}
+	pdu := s.pdu(checkResult)
+	message := s.messageV2c(pdu)
+	if s.config.Version == "3" {
+		var err error
+		if message, err = s.messageV3(pdu); err != nil {
+			logMessage(logWarning, "Error building SNMP trap:", err)
+			return
+		}
+	}
+	conn, err := net.DialTimeout("udp", s.address, 5*time.Second)
+	if err != nil {
+		logMessage(logWarning, "Error sending SNMP trap:", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write(message); err != nil {
+		logMessage(logWarning, "Error sending SNMP trap:", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeCommand builds the command running a probe either locally or, when via
+// is set to a ssh://user@host[:port] URL, on the remote host using the system
+// ssh client. Authentication relies on the user's ssh agent/keys.
+func probeCommand(ctx context.Context, via string, name string, args ...string) (*exec.Cmd, error) {
+	if via == "" {
+		return exec.CommandContext(ctx, name, args...), nil
+	}
+
+	u, err := url.Parse(via)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("unsupported via %q, expected ssh://user@host", via)
+	}
+
+	sshArgs := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=5"}
+	if u.Port() != "" {
+		sshArgs = append(sshArgs, "-p", u.Port())
+	}
+	target := u.Hostname()
+	if u.User != nil {
+		target = u.User.Username() + "@" + target
+	}
+	sshArgs = append(sshArgs, target, "--", shellQuote(name))
+	for _, arg := range args {
+		sshArgs = append(sshArgs, shellQuote(arg))
+	}
+	return exec.CommandContext(ctx, "ssh", sshArgs...), nil
+}
+
+// shellQuote quotes an argument for the remote shell
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Check types run on the remote host with via, any other would silently
+// probe from here
+var viaTypes = map[string]bool{"http": true, "icmp": true, "path": true}
+
+// validateVia rejects via on checks that can't run on the remote host
+func validateVia(check Check) error {
+	if check.Via == "" {
+		return nil
+	}
+	if !viaTypes[check.CheckType] {
+		return fmt.Errorf("check %s: via isn't supported by %s checks, only by http, icmp and path checks", check.Name, check.CheckType)
+	}
+	switch {
+	case check.CheckType == "icmp" && check.ICMPMode != "" && check.ICMPMode != icmpAuto && check.ICMPMode != icmpExec:
+		return fmt.Errorf("check %s: icmp_mode %s doesn't run via SSH, which runs the ping command", check.Name, check.ICMPMode)
+	case len(check.Steps) > 0:
+		return fmt.Errorf("check %s: steps aren't supported with via", check.Name)
+	}
+	if check.CheckType == "http" {
+		if options := localHttpOptions(check); len(options) > 0 {
+			return fmt.Errorf("check %s: with via, curl on the remote host only checks the status, not %s", check.Name, strings.Join(options, ", "))
+		}
+	}
+	for _, hop := range check.Hops {
+		if hop.Type != "http" && hop.Type != "icmp" {
+			return fmt.Errorf("check %s: hop %s: via isn't supported by %s checks", check.Name, hop.label(), hop.Type)
+		}
+		if hop.Dest == pathGateway {
+			return fmt.Errorf("check %s: hop %s: the gateway of the remote host isn't known, give its address", check.Name, hop.label())
+		}
+	}
+	return nil
+}
+
+// localHttpOptions returns the options of an http check that only a local
+// check honors
+func localHttpOptions(check Check) []string {
+	var options []string
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"expect_sha256", check.ExpectSHA256 != ""},
+		{"expect_status", len(check.ExpectStatus) > 0},
+		{"min_size", check.MinSize > 0},
+		{"min_throughput", check.MinThroughput > 0},
+		{"pin_spki", len(check.PinSPKI) > 0},
+		{"pin_serial", len(check.PinSerial) > 0},
+		{"ocsp", check.OCSP},
+		{"ocsp_staple", check.OCSPStaple},
+		{"connection", check.Connection != ""},
+	} {
+		if option.set {
+			options = append(options, option.name)
+		}
+	}
+	return options
+}
+
+// runRemoteHttpCheck performs the http check with curl on the remote host.
+// Like a local check, it passes on a 200 status.
+func runRemoteHttpCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+
+	cmd, err := probeCommand(ctx, check.Via, "curl", "-s", "-o", "/dev/null", "--max-time", "30",
+		"-w", "%{http_code} %{time_total}", check.Dest)
+	if err != nil {
+		c <- checkResult
+		return
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	err = cmd.Run()
+	checkResult.duration = time.Since(checkResult.runAt)
+
+	// curl exits non-zero on connection errors, the output is still "000 <time>"
+	fields := strings.Fields(output.String())
+	if err == nil && len(fields) == 2 {
+		secs, parseErr := strconv.ParseFloat(fields[1], 64)
+		if parseErr == nil {
+			checkResult.duration = time.Duration(secs * float64(time.Second))
+		}
+		checkResult.status = fields[0] == "200"
+		if code, _ := strconv.Atoi(fields[0]); code > 0 && !checkResult.status {
+			checkResult.detail = fmt.Sprintf("status %d", code)
+			checkResult.cause = httpStatusCause(code)
+		}
+	}
+
+	c <- checkResult
+}
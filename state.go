@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// States of a check exporters report, the same the terminal UI shows
+const (
+	stateOk       = "ok"
+	stateDegraded = "degraded"
+	stateDown     = "down"
+)
+
+// state returns whether the run was ok, degraded or down
+func (checkResult CheckResult) state() string {
+	switch {
+	case !checkResult.status:
+		return stateDown
+	case checkResult.degraded:
+		return stateDegraded
+	}
+	return stateOk
+}
+
+// stateValue returns the state as the value of a metric: 0 down, 1 degraded
+// and 2 ok
+func (checkResult CheckResult) stateValue() int {
+	switch checkResult.state() {
+	case stateDown:
+		return 0
+	case stateDegraded:
+		return 1
+	}
+	return 2
+}
+
+// updateState tracks since when the check is in the state of its latest run
+// and when it last failed
+func (s *CheckResultStat) updateState(previous CheckResult, checkResult CheckResult) {
+	if previous.execCount == 0 || previous.state() != checkResult.state() || s.stateSince.IsZero() {
+		s.stateSince = checkResult.runAt
+	}
+	if !checkResult.status {
+		s.lastFailure = checkResult.runAt
+	}
+}
+
+// formatAge renders how long ago something happened in its two largest
+// units, like 45s, 12m, 5h12m or 3d4h, and - when it never did
+func formatAge(since time.Time, now time.Time) string {
+	if since.IsZero() {
+		return "-"
+	}
+	age := now.Sub(since)
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", max(int(age.Seconds()), 0))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh%dm", int(age.Hours()), int(age.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%dh", int(age.Hours())/24, int(age.Hours())%24)
+}
+
+// formatMoment renders a time of today by its time of day and an earlier
+// one with its date, and - for the zero time
+func formatMoment(t time.Time, now time.Time) string {
+	switch {
+	case t.IsZero():
+		return "-"
+	case t.Year() == now.Year() && t.YearDay() == now.YearDay():
+		return t.Format("15:04:05")
+	}
+	return t.Format("Jan 2 15:04")
+}
+
+// applyLatencyThreshold marks a successful run slower than the
+// degraded_above of its check as degraded
+func applyLatencyThreshold(checkResult *CheckResult) {
+	threshold := checkResult.check.DegradedAbove
+	if threshold <= 0 || !checkResult.status || checkResult.duration <= threshold {
+		return
+	}
+	checkResult.degraded = true
+	if checkResult.detail == "" {
+		checkResult.detail = fmt.Sprintf("took %v, above the threshold of %v", checkResult.duration.Truncate(time.Millisecond), threshold)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const statusPageDays = 90
+
+type statusPageDay struct {
+	Date    string
+	Samples int
+	Uptime  float64
+	Class   string
+}
+
+type statusPageCheck struct {
+	Name     string
+	Known    bool
+	Up       bool
+	Degraded bool
+	Uptime   float64
+	Days     []statusPageDay
+	// Notes of the period, the latest first
+	Notes []statusPageNote
+}
+
+type statusPageNote struct {
+	At   string
+	Text string
+}
+
+type statusPageGroup struct {
+	Name   string
+	Checks []*statusPageCheck
+}
+
+type statusPage struct {
+	Title     string
+	Generated time.Time
+	AllUp     bool
+	Groups    []*statusPageGroup
+}
+
+// uptimeClass maps an uptime ratio to the color of its bar
+func uptimeClass(samples int, uptime float64) string {
+	switch {
+	case samples == 0:
+		return "none"
+	case uptime >= 0.999:
+		return "up"
+	case uptime >= 0.99:
+		return "minor"
+	case uptime >= 0.95:
+		return "major"
+	default:
+		return "down"
+	}
+}
+
+// buildStatusPage summarizes the configured checks from a recording made
+// with -record: the current state, the daily uptime of the last 90 days and
+// the notes of the period.
+func buildStatusPage(title string, checks []Check, recording string, now time.Time) (statusPage, error) {
+	page := statusPage{Title: title, Generated: now, AllUp: true}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	first := today.AddDate(0, 0, -(statusPageDays - 1))
+
+	type counts struct {
+		ok, total int
+	}
+	// Results are kept by site and identity, agents may report checks of
+	// the same name
+	key := func(site string, identity string) string {
+		return site + "\x00" + identity
+	}
+	daily := make(map[string][]counts)
+	latest := make(map[string]AgentResult)
+	for _, check := range checks {
+		daily[key(check.site, check.identity())] = make([]counts, statusPageDays)
+	}
+
+	err := readRecording(recording, func(result AgentResult) error {
+		days, ok := daily[key(result.Site, result.identity())]
+		if !ok || result.RunAt.Before(first) {
+			return nil
+		}
+		runAt := result.RunAt.In(now.Location())
+		day := int(time.Date(runAt.Year(), runAt.Month(), runAt.Day(), 0, 0, 0, 0, now.Location()).Sub(first).Hours()/24 + 0.5)
+		if day < 0 || day >= statusPageDays {
+			return nil
+		}
+		successful, total, _ := result.counts()
+		days[day].total += total
+		days[day].ok += successful
+		if result.RunAt.After(latest[key(result.Site, result.identity())].RunAt) {
+			latest[key(result.Site, result.identity())] = result
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return page, err
+	}
+	notes, err := readNotes(recording)
+	if err != nil {
+		return page, err
+	}
+
+	groups := make(map[string]*statusPageGroup)
+	for _, check := range checks {
+		groupName := check.Group
+		if groupName == "" {
+			groupName = "Services"
+		}
+		group, found := groups[groupName]
+		if !found {
+			group = &statusPageGroup{Name: groupName}
+			groups[groupName] = group
+			page.Groups = append(page.Groups, group)
+		}
+
+		pageCheck := &statusPageCheck{Name: check.Name}
+		var ok, total int
+		for i, c := range daily[key(check.site, check.identity())] {
+			uptime := 0.0
+			if c.total > 0 {
+				uptime = float64(c.ok) / float64(c.total)
+			}
+			pageCheck.Days = append(pageCheck.Days, statusPageDay{
+				Date:    first.AddDate(0, 0, i).Format(time.DateOnly),
+				Samples: c.total,
+				Uptime:  uptime * 100,
+				Class:   uptimeClass(c.total, uptime),
+			})
+			ok += c.ok
+			total += c.total
+		}
+		if total > 0 {
+			pageCheck.Uptime = float64(ok) / float64(total) * 100
+		}
+		for i := len(notes) - 1; i >= 0; i-- {
+			if notes[i].Check == check.identity() && !notes[i].At.Before(first) {
+				pageCheck.Notes = append(pageCheck.Notes, statusPageNote{
+					At:   notes[i].At.In(now.Location()).Format("2006-01-02 15:04"),
+					Text: notes[i].Text,
+				})
+			}
+		}
+		if result, found := latest[key(check.site, check.identity())]; found {
+			pageCheck.Known = true
+			pageCheck.Up = result.Status
+			pageCheck.Degraded = result.Status && result.Degraded
+		}
+		if !pageCheck.Up {
+			page.AllUp = false
+		}
+		group.Checks = append(group.Checks, pageCheck)
+	}
+	return page, nil
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; color: #222; }
+.banner { padding: 1em; border-radius: 4px; color: #fff; font-weight: bold; }
+.banner.up { background: #2e9d4f; } .banner.down { background: #d64541; }
+.check { margin: 1em 0; }
+.check .name { display: flex; justify-content: space-between; }
+.state.up { color: #2e9d4f; } .state.degraded { color: #f08a24; } .state.down { color: #d64541; } .state.unknown { color: #888; }
+.bars { display: flex; gap: 2px; height: 28px; margin-top: 4px; }
+.bars span { flex: 1; border-radius: 2px; }
+.up { background: #2e9d4f; } .minor { background: #e3c04d; } .major { background: #f08a24; }
+.down { background: #d64541; } .none { background: #ddd; }
+.state.up, .state.degraded, .state.down, .state.unknown { background: none; }
+.notes { margin: 4px 0; padding-left: 1.2em; font-size: small; } .notes time { color: #888; }
+footer { color: #888; font-size: small; margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .AllUp}}<div class="banner up">All systems operational</div>{{else}}<div class="banner down">Some systems are not operational</div>{{end}}
+{{range .Groups}}
+<h2>{{.Name}}</h2>
+{{range .Checks}}
+<div class="check">
+<div class="name"><span>{{.Name}}</span>
+{{if not .Known}}<span class="state unknown">No data</span>{{else if .Degraded}}<span class="state degraded">Degraded performance</span>{{else if .Up}}<span class="state up">Operational</span>{{else}}<span class="state down">Down</span>{{end}}</div>
+<div class="bars">{{range .Days}}<span class="{{.Class}}" title="{{.Date}}: {{if .Samples}}{{printf "%.2f" .Uptime}}%{{else}}no data{{end}}"></span>{{end}}</div>
+<small>{{printf "%.2f" .Uptime}}% uptime over the last 90 days</small>
+{{if .Notes}}<ul class="notes">{{range .Notes}}<li><time>{{.At}}</time> {{.Text}}</li>{{end}}</ul>{{end}}
+</div>
+{{end}}
+{{end}}
+<footer>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}, days in {{.Generated.Format "MST"}}</footer>
+</body>
+</html>
+`))
+
+func writeStatusPage(w io.Writer, page statusPage) error {
+	return statusPageTemplate.Execute(w, page)
+}
+
+// statusPageHandler serves a status page generated from the recording on
+// every request
+func statusPageHandler(title string, monitor *Monitor, recording string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		monitor.mu.Lock()
+		checks := monitor.checks.Checks
+		monitor.mu.Unlock()
+
+		page, err := buildStatusPage(title, checks, recording, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writeStatusPage(w, page)
+	}
+}
+
+// runStatusPage implements the status-page subcommand generating a static
+// page, e.g. from cron for publishing to any static hosting. It returns the
+// process exit code.
+func runStatusPage(args []string) int {
+	flags := flag.NewFlagSet("status-page", flag.ExitOnError)
+	configPath := flags.String("config", "checks.yml", "path to the checks configuration")
+	from := flags.String("from", "", "recording made with -record")
+	output := flags.String("o", "status.html", "file to write the page to")
+	title := flags.String("title", "Status", "title of the page")
+	tz := flags.String("tz", "Local", "time zone the days start in, e.g. UTC or Europe/Prague")
+	site := flags.String("site", "local", "site the recording instance ran at, results of other sites are left out")
+	flags.Parse(args)
+	if *from == "" {
+		fmt.Println("Usage: network-checks status-page -from results.jsonl [-config checks.yml] [-o status.html] [-title Status]")
+		return 2
+	}
+
+	checks, err := loadChecksFromYaml(*configPath)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		return 1
+	}
+	for i := range checks.Checks {
+		checks.Checks[i].site = *site
+	}
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+	page, err := buildStatusPage(*title, checks.Checks, *from, time.Now().In(location))
+	if err != nil {
+		fmt.Println("Error reading recording:", err)
+		return 1
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fmt.Println("Error writing status page:", err)
+		return 1
+	}
+	defer file.Close()
+	if err := writeStatusPage(file, page); err != nil {
+		fmt.Println("Error writing status page:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rtspRequest sends an RTSP request and reads the response. It returns the
+// time the first byte of the response arrived.
+func rtspRequest(conn net.Conn, reader *bufio.Reader, method string, target string, cseq int, headers map[string]string) (int, textproto.MIMEHeader, []byte, time.Time, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: network-checks\r\n", method, target, cseq)
+	for name, value := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	b.WriteString("\r\n")
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		return 0, nil, nil, time.Time{}, err
+	}
+
+	if _, err := reader.Peek(1); err != nil {
+		return 0, nil, nil, time.Time{}, err
+	}
+	firstByte := time.Now()
+	tp := textproto.NewReader(reader)
+	statusLine, err := tp.ReadLine()
+	if err != nil {
+		return 0, nil, nil, firstByte, err
+	}
+	fields := strings.Fields(statusLine)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "RTSP/") {
+		return 0, nil, nil, firstByte, fmt.Errorf("invalid RTSP response %q", statusLine)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, nil, nil, firstByte, fmt.Errorf("invalid RTSP response %q", statusLine)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return 0, nil, nil, firstByte, err
+	}
+	var body []byte
+	if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+		body = make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return 0, nil, nil, firstByte, err
+		}
+	}
+	return code, header, body, firstByte, nil
+}
+
+// rtspAuthorization answers a Basic or Digest challenge
+func rtspAuthorization(challenge string, user *url.Userinfo, method string, target string) string {
+	password, _ := user.Password()
+	if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password))
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Digest "), ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := md5hex(user.Username() + ":" + params["realm"] + ":" + password)
+	ha2 := md5hex(method + ":" + target)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		user.Username(), params["realm"], params["nonce"], target, md5hex(ha1+":"+params["nonce"]+":"+ha2))
+}
+
+// runRtspCheck asks an RTSP server (rtsp://[user:password@]host[:port]/path)
+// for the description of the stream and requires it to contain media. The
+// latency is the time to the first byte of the description.
+func runRtspCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(format string, a ...interface{}) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = fmt.Sprintf(format, a...)
+		checkResult.cause = argumentCause(a)
+		c <- checkResult
+	}
+
+	u, err := url.Parse(check.Dest)
+	if err != nil || u.Scheme != "rtsp" {
+		fail("invalid RTSP URL %s", check.Dest)
+		return
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "554")
+	}
+	user := u.User
+	u.User = nil
+	target := u.String()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+
+	code, _, _, _, err := rtspRequest(conn, reader, "OPTIONS", target, 1, nil)
+	if err != nil {
+		fail("OPTIONS: %v", err)
+		return
+	}
+	if code != 200 && code != 401 {
+		fail("%v", unexpectedf("OPTIONS returned %d", code))
+		return
+	}
+
+	headers := map[string]string{"Accept": "application/sdp"}
+	code, header, body, firstByte, err := rtspRequest(conn, reader, "DESCRIBE", target, 2, headers)
+	if err == nil && code == 401 && user != nil {
+		headers["Authorization"] = rtspAuthorization(header.Get("WWW-Authenticate"), user, "DESCRIBE", target)
+		code, _, body, firstByte, err = rtspRequest(conn, reader, "DESCRIBE", target, 3, headers)
+	}
+	if err != nil {
+		fail("DESCRIBE: %v", err)
+		return
+	}
+	if code != 200 {
+		fail("%v", unexpectedf("DESCRIBE returned %d", code))
+		return
+	}
+	if !strings.Contains(string(body), "\nm=") {
+		fail("%v", unexpectedf("stream description has no media"))
+		return
+	}
+	checkResult.duration = firstByte.Sub(checkResult.runAt)
+	checkResult.status = true
+	c <- checkResult
+}
+
+// hlsGet fetches a URL and returns the response with the time its first
+// byte arrived
+func hlsGet(ctx context.Context, target string) (*http.Response, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, time.Time{}, fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return resp, time.Now(), nil
+}
+
+// hlsPlaylist reads the URIs of a playlist, resolved against its URL, and
+// whether it's a master playlist listing variant streams
+func hlsPlaylist(ctx context.Context, target string) ([]string, bool, error) {
+	resp, _, err := hlsGet(ctx, target)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	base, _ := url.Parse(target)
+
+	var uris []string
+	master := false
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1<<20))
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first && line != "#EXTM3U" {
+			return nil, false, fmt.Errorf("%s is not an HLS playlist", target)
+		}
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			master = true
+		case line != "" && !strings.HasPrefix(line, "#"):
+			if u, err := base.Parse(line); err == nil {
+				uris = append(uris, u.String())
+			}
+		}
+	}
+	return uris, master, scanner.Err()
+}
+
+// runHlsCheck fetches an HLS playlist, following the first variant of a
+// master playlist, and the start of its newest segment. The latency is the
+// time to the first byte of the segment.
+func runHlsCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	fail := func(err error) {
+		checkResult.duration = time.Since(checkResult.runAt)
+		checkResult.detail = err.Error()
+		checkResult.cause = errorCause(err)
+		c <- checkResult
+	}
+
+	uris, master, err := hlsPlaylist(ctx, check.Dest)
+	if err == nil && master && len(uris) > 0 {
+		uris, _, err = hlsPlaylist(ctx, uris[0])
+	}
+	if err != nil {
+		fail(err)
+		return
+	}
+	if len(uris) == 0 {
+		fail(unexpectedf("playlist has no segments"))
+		return
+	}
+
+	segmentStart := time.Now()
+	resp, firstByte, err := hlsGet(ctx, uris[len(uris)-1])
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer resp.Body.Close()
+	// A few kilobytes prove that media is served
+	if n, err := io.CopyN(io.Discard, resp.Body, 64*1024); err != nil && (err != io.EOF || n == 0) {
+		fail(fmt.Errorf("reading segment: %w", err))
+		return
+	}
+	checkResult.duration = firstByte.Sub(segmentStart)
+	checkResult.status = true
+	c <- checkResult
+}
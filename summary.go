@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// addTotals accounts for a result in the totals since the start
+func (stat *CheckResultStat) addTotals(checkResult CheckResult) {
+	if !checkResult.status {
+		stat.failures++
+		if stat.causes == nil {
+			stat.causes = make(map[string]int)
+		}
+		stat.causes[checkResult.cause]++
+	}
+	if stat.minDuration == 0 || checkResult.duration < stat.minDuration {
+		stat.minDuration = checkResult.duration
+	}
+	if checkResult.duration > stat.maxDuration {
+		stat.maxDuration = checkResult.duration
+	}
+	stat.totalDuration += checkResult.duration
+}
+
+// iterationsDone returns how many times every local check has run at least.
+// Checks without a repeat interval run only once and count as done then.
+func (m *Monitor) iterationsDone(iterations int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, check := range m.checks.Checks {
+		runs := 0
+		if i < len(m.results) {
+			runs = m.results[i].execCount
+		}
+		if runs < iterations && !(check.Repeat <= 0 && runs > 0) && !m.paused[check.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// summary returns the totals of every check since the start and the most
+// important severity of the checks with failed runs, empty when none failed
+func (m *Monitor) summary(elapsed time.Duration) (string, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failed := ""
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary of %v\n", elapsed.Round(time.Second))
+	fmt.Fprintf(&b, "%-14s %-4s %6s %6s %6s %7s %7s %7s %s\n", "TARGET", "TYPE", "RUNS", "FAILED", "LOSS", "MIN", "AVG", "MAX", "CAUSES")
+	for i, checkResult := range m.results {
+		name := checkResult.check.Name
+		checkType := checkResult.check.CheckType
+		severity := checkResult.check.severity()
+		if i < len(m.checks.Checks) {
+			name = m.checks.Checks[i].Name
+			checkType = m.checks.Checks[i].CheckType
+			severity = m.checks.Checks[i].severity()
+		}
+		stat := m.stats[i]
+		runs := checkResult.execCount
+		if runs == 0 {
+			fmt.Fprintf(&b, "%-14s %-4s %6d %6s %6s %7s %7s %7s\n", name, checkType, 0, "-", "-", "-", "-", "-")
+			continue
+		}
+		if stat.failures > 0 && (failed == "" || atLeast(severity, failed)) {
+			failed = severity
+		}
+		fmt.Fprintf(&b, "%-14s %-4s %6d %6d %5.1f%% %7s %7s %7s %s\n", name, checkType, runs, stat.failures,
+			float64(stat.failures)*100/float64(runs), formatDuration(stat.minDuration),
+			formatDuration(stat.totalDuration/time.Duration(runs)), formatDuration(stat.maxDuration),
+			formatCauses(stat.causes))
+	}
+	return b.String(), failed
+}
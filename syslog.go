@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SyslogConfig configures RFC 5424 syslog messages for check state changes
+type SyslogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address is udp://host:port or tcp://host:port, empty for the local
+	// syslog socket
+	Address  string `yaml:"address"`
+	Facility string `yaml:"facility"`
+	AppName  string `yaml:"app_name"`
+	// Only checks of this severity or a more important one are sent
+	MinSeverity string `yaml:"min_severity,omitempty"`
+}
+
+const (
+	defaultSyslogFacility = "daemon"
+	defaultSyslogAppName  = "network-checks"
+	// Private enterprise number used for the structured data element
+	syslogEnterpriseId = 32473
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+const (
+	syslogSeverityCrit    = 2
+	syslogSeverityErr     = 3
+	syslogSeverityWarning = 4
+	syslogSeverityNotice  = 5
+)
+
+// Syslog severities of failures by the severity of the check
+var syslogFailureSeverities = map[string]int{
+	severityCritical: syslogSeverityCrit,
+	severityWarning:  syslogSeverityErr,
+	severityInfo:     syslogSeverityWarning,
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogWriter sends a message for every change of a check's status
+type syslogWriter struct {
+	config   SyslogConfig
+	facility int
+	hostname string
+	network  string
+	address  string
+	conn     net.Conn
+}
+
+func newSyslogWriter(config SyslogConfig) (*syslogWriter, error) {
+	if config.Facility == "" {
+		config.Facility = defaultSyslogFacility
+	}
+	if config.AppName == "" {
+		config.AppName = defaultSyslogAppName
+	}
+	facility, ok := syslogFacilities[config.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", config.Facility)
+	}
+	if err := validateMinSeverity(config.MinSeverity); err != nil {
+		return nil, err
+	}
+	w := &syslogWriter{
+		config:   config,
+		facility: facility,
+		network:  "unixgram",
+		address:  "/dev/log",
+	}
+	if config.Address != "" {
+		u, err := url.Parse(config.Address)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q, expected udp://host:port or tcp://host:port", config.Address)
+		}
+		w.network, w.address = u.Scheme, u.Host
+	}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "-"
+	}
+	return w, nil
+}
+
+// format renders an RFC 5424 message with the result as structured data
+func (w *syslogWriter) format(checkResult CheckResult) string {
+	check := checkResult.check
+	severity, status := syslogFailureSeverities[check.severity()], "FAIL"
+	msg := fmt.Sprintf("Check %s of %s failed", check.Name, check.Dest)
+	if checkResult.status {
+		severity, status = syslogSeverityNotice, "OK"
+		msg = fmt.Sprintf("Check %s of %s recovered", check.Name, check.Dest)
+	} else if ownership := check.formatOwnership(); ownership != "" {
+		msg += " (" + ownership + ")"
+	}
+	params := []string{
+		"name", check.Name,
+		"type", check.CheckType,
+		"dest", check.Dest,
+		"site", check.site,
+		"group", check.Group,
+		"severity", check.severity(),
+		"status", status,
+		"state", checkResult.state(),
+		"duration_ms", fmt.Sprintf("%d", checkResult.duration.Milliseconds()),
+	}
+	params = append(params, check.ownershipPairs()...)
+	params = append(params, check.labelPairs()...)
+	var sd strings.Builder
+	fmt.Fprintf(&sd, "[check@%d", syslogEnterpriseId)
+	for i := 0; i < len(params); i += 2 {
+		if params[i+1] == "" {
+			continue
+		}
+		fmt.Fprintf(&sd, ` %s="%s"`, params[i], syslogParamEscaper.Replace(params[i+1]))
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		w.facility*8+severity,
+		// RFC 5424 allows at most microseconds
+		checkResult.runAt.Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname,
+		w.config.AppName,
+		os.Getpid(),
+		strings.ToLower(status),
+		sd.String(),
+		msg)
+}
+
+// send writes a message, reconnecting once if the connection went away
+func (w *syslogWriter) send(msg string) error {
+	// TCP needs octet counting framing (RFC 6587)
+	if w.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			w.conn, err = net.DialTimeout(w.network, w.address, 5*time.Second)
+			if err != nil {
+				return err
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = w.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// update sends a message for a state change, see onStateChange
+func (w *syslogWriter) update(checkResult CheckResult) {
+	if !atLeast(checkResult.check.severity(), w.config.MinSeverity) {
+		return
+	}
+	if err := w.send(w.format(checkResult)); err != nil {
+		logMessage(logWarning, "Error sending syslog message:", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Syslog priorities understood by journald as line prefixes
+const (
+	logErr     = 3
+	logWarning = 4
+	logInfo    = 6
+)
+
+// Log lines go to stdout unless it's reserved for the output of the run
+var logOutput io.Writer = os.Stdout
+
+// logMessage prints a log line. When stdout is connected to journald the
+// line is prefixed with its priority so that it's classified correctly.
+func logMessage(priority int, a ...interface{}) {
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		fmt.Fprintf(logOutput, "<%d>", priority)
+	}
+	fmt.Fprintln(logOutput, a...)
+}
+
+// sdNotify sends a state update to systemd. It does nothing when not started
+// by systemd with Type=notify.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	// Abstract namespace sockets are announced with a leading @
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns how often the watchdog has to be pinged, or zero
+// when the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	// Ping at half the timeout as recommended by sd_watchdog_enabled(3)
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// startWatchdog pings the systemd watchdog for as long as the monitor is
+// healthy, so that a wedged process gets restarted.
+func startWatchdog(monitor *Monitor) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := monitor.healthy(interval); err != nil {
+				logMessage(logWarning, "Skipping watchdog ping:", err)
+				continue
+			}
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// CheckTemplate expands into a check for every set of variables, e.g. a
+// dest of https://{{.host}}/healthz for a list of hosts
+type CheckTemplate struct {
+	Check Check               `yaml:"check"`
+	Vars  []map[string]string `yaml:"vars"`
+}
+
+// expandString executes a setting of a template with the variables
+func expandString(text string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	// Misspelled variables fail instead of expanding to <no value>
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// expand returns the check of a template for one set of variables. All
+// settings holding text are expanded, including those of steps, hops and
+// labels.
+func (t CheckTemplate) expand(vars map[string]string) (Check, error) {
+	check := t.Check
+	if err := expandValue(reflect.ValueOf(&check).Elem(), vars); err != nil {
+		return Check{}, err
+	}
+	return check, nil
+}
+
+// expandValue expands the text in a setting. Slices and maps are copied
+// first, the checks of a template would share them otherwise.
+func expandValue(value reflect.Value, vars map[string]string) error {
+	switch value.Kind() {
+	case reflect.String:
+		expanded, err := expandString(value.String(), vars)
+		if err != nil {
+			return err
+		}
+		value.SetString(expanded)
+	case reflect.Slice:
+		if value.IsNil() {
+			return nil
+		}
+		clone := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(clone, value)
+		value.Set(clone)
+		for j := 0; j < clone.Len(); j++ {
+			if err := expandValue(clone.Index(j), vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.IsNil() || value.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		clone := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			expanded, err := expandString(iter.Value().String(), vars)
+			if err != nil {
+				return fmt.Errorf("%v: %v", iter.Key(), err)
+			}
+			clone.SetMapIndex(iter.Key(), reflect.ValueOf(expanded).Convert(value.Type().Elem()))
+		}
+		value.Set(clone)
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			key, ok := yamlKey(value.Type().Field(i))
+			if !ok {
+				continue
+			}
+			if err := expandValue(value.Field(i), vars); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expandTemplates returns the checks of all templates
+func expandTemplates(templates []CheckTemplate) ([]Check, error) {
+	var checks []Check
+	for i, t := range templates {
+		names := make(map[string]bool)
+		for _, vars := range t.Vars {
+			check, err := t.expand(vars)
+			if err != nil {
+				return nil, fmt.Errorf("template %d (%s): %v", i+1, t.Check.Name, err)
+			}
+			if names[check.Name] {
+				return nil, fmt.Errorf("template %d (%s): expands to the name %s more than once, use a variable in the name",
+					i+1, t.Check.Name, check.Name)
+			}
+			names[check.Name] = true
+			checks = append(checks, check)
+		}
+	}
+	return checks, nil
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+func enableKeyboardInput() (func(), error) {
+	return nil, errors.New("keyboard input is not supported on this platform")
+}
+
+func terminalSize() (int, int) {
+	return 80, 24
+}
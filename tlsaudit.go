@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var tlsVersions = []struct {
+	name    string
+	version uint16
+}{
+	{"1.0", tls.VersionTLS10},
+	{"1.1", tls.VersionTLS11},
+	{"1.2", tls.VersionTLS12},
+	{"1.3", tls.VersionTLS13},
+}
+
+const defaultMinTLS = "1.2"
+
+// tlsHandshake reports whether the server accepts a handshake limited to
+// the version and, for versions before 1.3, the cipher suite
+func tlsHandshake(ctx context.Context, addr string, serverName string, version uint16, suite uint16) (bool, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	config := &tls.Config{
+		ServerName: serverName,
+		// Only the protocol is audited, the certificate is of no interest
+		InsecureSkipVerify: true,
+		MinVersion:         version,
+		MaxVersion:         version,
+	}
+	if suite != 0 {
+		config.CipherSuites = []uint16{suite}
+	}
+	client := tls.Client(conn, config)
+	defer client.Close()
+	return client.HandshakeContext(ctx) == nil, nil
+}
+
+// runTlsCheck enumerates the TLS versions and cipher suites the destination
+// (host:port) accepts. It fails when versions below min_tls or insecure
+// cipher suites are accepted, or the certificate doesn't match a pin.
+func runTlsCheck(ctx context.Context, check Check, c chan CheckResult) {
+	checkResult := CheckResult{
+		check: check,
+		runAt: time.Now(),
+	}
+	addr := check.Dest
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+
+	minTLS := check.MinTLS
+	if minTLS == "" {
+		minTLS = defaultMinTLS
+	}
+	var minVersion uint16
+	for _, v := range tlsVersions {
+		if v.name == minTLS {
+			minVersion = v.version
+		}
+	}
+	if minVersion == 0 {
+		checkResult.detail = fmt.Sprintf("unknown min_tls %s", minTLS)
+		c <- checkResult
+		return
+	}
+
+	var oldVersions, weakSuites []string
+	accepted := 0
+	for _, v := range tlsVersions {
+		ok, err := tlsHandshake(ctx, addr, host, v.version, 0)
+		if err != nil {
+			checkResult.duration = time.Since(checkResult.runAt)
+			checkResult.detail = err.Error()
+			checkResult.cause = errorCause(err)
+			c <- checkResult
+			return
+		}
+		if !ok {
+			continue
+		}
+		accepted++
+		if v.version < minVersion {
+			oldVersions = append(oldVersions, "TLS "+v.name)
+		}
+		// TLS 1.3 suites are all secure and can't be limited
+		if v.version == tls.VersionTLS13 {
+			continue
+		}
+		for _, suite := range tls.InsecureCipherSuites() {
+			if !supportsVersion(suite, v.version) || contains(weakSuites, suite.Name) {
+				continue
+			}
+			if ok, err := tlsHandshake(ctx, addr, host, v.version, suite.ID); err == nil && ok {
+				weakSuites = append(weakSuites, suite.Name)
+			}
+		}
+	}
+	checkResult.duration = time.Since(checkResult.runAt)
+
+	var problems []string
+	if accepted == 0 {
+		problems = append(problems, "no TLS handshake succeeded")
+	}
+	if len(oldVersions) > 0 {
+		problems = append(problems, "accepts "+strings.Join(oldVersions, ", "))
+	}
+	if len(weakSuites) > 0 {
+		problems = append(problems, "insecure ciphers "+strings.Join(weakSuites, ", "))
+	}
+	if accepted > 0 && (len(check.PinSPKI) > 0 || len(check.PinSerial) > 0) {
+		state, err := peerCertificates(ctx, addr, host)
+		if err == nil {
+			err = checkPins(state, check)
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		checkResult.detail = strings.Join(problems, "; ")
+		checkResult.cause = causeTLS
+	} else {
+		checkResult.status = true
+	}
+	c <- checkResult
+}
+
+func supportsVersion(suite *tls.CipherSuite, version uint16) bool {
+	for _, v := range suite.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// topologyNode is a configured check on the map with the status of its
+// latest run: OK, FAIL, DEGR or - before the first run
+type topologyNode struct {
+	check    Check
+	status   string
+	children []int
+}
+
+// topology is the map of the checks: a check is drawn behind the checks it
+// depends on, in its segment (e.g. a VLAN)
+type topology struct {
+	nodes []topologyNode
+	roots []int
+}
+
+// topology builds the map of the local checks. Callers hold m.mu.
+func (m *Monitor) topology() topology {
+	var t topology
+	index := make(map[string]int)
+	for i, check := range m.checks.Checks {
+		status := "-"
+		if i < len(m.results) && m.results[i].execCount > 0 {
+			checkResult := m.results[i]
+			switch {
+			case !checkResult.status:
+				status = "FAIL"
+			case checkResult.degraded:
+				status = "DEGR"
+			default:
+				status = "OK"
+			}
+		}
+		index[check.Name] = i
+		t.nodes = append(t.nodes, topologyNode{check: check, status: status})
+	}
+	for i, node := range t.nodes {
+		// Drawn behind the first known check it depends on
+		parent := -1
+		for _, dependency := range node.check.DependsOn {
+			if j, ok := index[dependency]; ok && j != i {
+				parent = j
+				break
+			}
+		}
+		if parent >= 0 {
+			t.nodes[parent].children = append(t.nodes[parent].children, i)
+		} else {
+			t.roots = append(t.roots, i)
+		}
+	}
+	// A dependency cycle leaves checks unreachable from the roots, they are
+	// drawn as roots then
+	reached := make(map[int]bool)
+	var reach func(i int)
+	reach = func(i int) {
+		if reached[i] {
+			return
+		}
+		reached[i] = true
+		for _, child := range t.nodes[i].children {
+			reach(child)
+		}
+	}
+	for _, root := range t.roots {
+		reach(root)
+	}
+	for i := range t.nodes {
+		if !reached[i] {
+			t.roots = append(t.roots, i)
+			reach(i)
+		}
+	}
+	return t
+}
+
+// label describes a node on the map
+func (node topologyNode) label() string {
+	text := fmt.Sprintf("%s [%s]", node.check.Name, node.status)
+	if node.check.Segment != "" {
+		text += " (" + node.check.Segment + ")"
+	}
+	if len(node.check.DependsOn) > 1 {
+		text += " also behind " + strings.Join(node.check.DependsOn[1:], ", ")
+	}
+	return text
+}
+
+// lines renders the map as an ASCII tree, coloring every node by its status
+// when colored
+func (t topology) lines(colored bool) []string {
+	var lines []string
+	drawn := make(map[int]bool)
+	var draw func(i int, prefix string, connector string, childPrefix string)
+	draw = func(i int, prefix string, connector string, childPrefix string) {
+		node := t.nodes[i]
+		label := node.label()
+		if drawn[i] {
+			label = node.check.Name + " (see above)"
+		} else if colored {
+			label = topologyColor(node.status).Sprint(label)
+		}
+		lines = append(lines, prefix+connector+label)
+		if drawn[i] {
+			return
+		}
+		drawn[i] = true
+		for k, child := range node.children {
+			if k == len(node.children)-1 {
+				draw(child, prefix+childPrefix, "└── ", "    ")
+			} else {
+				draw(child, prefix+childPrefix, "├── ", "│   ")
+			}
+		}
+	}
+	for _, root := range t.roots {
+		draw(root, "", "", "")
+	}
+	return lines
+}
+
+func topologyColor(status string) *color.Color {
+	switch status {
+	case "OK":
+		return color.New(color.FgGreen)
+	case "FAIL":
+		return color.New(color.FgRed)
+	case "DEGR":
+		return color.New(color.FgYellow)
+	}
+	return color.New(color.FgWhite)
+}
+
+// Fill colors of the graphviz nodes by status
+var topologyFillColors = map[string]string{
+	"OK": "palegreen", "FAIL": "salmon", "DEGR": "gold", "-": "lightgray",
+}
+
+// dot renders the map in the graphviz format, with the checks of a segment
+// grouped in a cluster and an edge from every dependency to its dependents
+func (t topology) dot() string {
+	var b strings.Builder
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	quote := func(s string) string {
+		return `"` + escaper.Replace(s) + `"`
+	}
+	b.WriteString("digraph network {\n  rankdir=LR;\n  node [shape=box, style=filled];\n")
+	segments := make(map[string][]int)
+	for i, node := range t.nodes {
+		segments[node.check.Segment] = append(segments[node.check.Segment], i)
+	}
+	names := make([]string, 0, len(segments))
+	for segment := range segments {
+		names = append(names, segment)
+	}
+	sort.Strings(names)
+	for k, segment := range names {
+		indent := "  "
+		if segment != "" {
+			fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%s;\n", k, quote(segment))
+			indent = "    "
+		}
+		for _, i := range segments[segment] {
+			node := t.nodes[i]
+			fmt.Fprintf(&b, "%s%s [label=\"%s\\n%s\", fillcolor=%s];\n", indent, quote(node.check.Name),
+				escaper.Replace(node.check.Name), node.status, topologyFillColors[node.status])
+		}
+		if segment != "" {
+			b.WriteString("  }\n")
+		}
+	}
+	known := make(map[string]bool)
+	for _, node := range t.nodes {
+		known[node.check.Name] = true
+	}
+	for _, node := range t.nodes {
+		for _, dependency := range node.check.DependsOn {
+			if known[dependency] {
+				fmt.Fprintf(&b, "  %s -> %s;\n", quote(dependency), quote(node.check.Name))
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// displayMap draws the map view of the terminal UI
+func displayMap(lines []string) {
+	fmt.Print("\033[H\033[2J") // Clear terminal screen
+	fmt.Print("Topology - checks behind the checks they depend on (q back)\n\n")
+	if len(lines) == 0 {
+		fmt.Println("No checks")
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// BudgetConfig caps the traffic generated by the checks per period. Over
+// budget, checks run only every slowdown-th time until the period ends.
+type BudgetConfig struct {
+	Limit    byteSize      `yaml:"limit"`
+	Period   time.Duration `yaml:"period"`
+	Slowdown int           `yaml:"slowdown"`
+}
+
+const (
+	defaultBudgetPeriod   = time.Hour
+	defaultBudgetSlowdown = 10
+)
+
+// Size of an echo request or reply with the default payload of ping,
+// including the IP header
+const (
+	icmpProbeBytes        = 84
+	icmpProbeBytesWindows = 60
+)
+
+// byteSize is a number of bytes, configured like 500kB, 50MB or 1GiB
+type byteSize int64
+
+var byteUnits = map[string]int64{
+	"": 1, "b": 1,
+	"kb": 1000, "mb": 1000 * 1000, "gb": 1000 * 1000 * 1000,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30,
+}
+
+func parseByteSize(s string) (byteSize, error) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[len(number):]))]
+	value, err := strconv.ParseFloat(number, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return byteSize(value * float64(unit)), nil
+}
+
+func (b *byteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	size, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1000*1000*1000:
+		return fmt.Sprintf("%.1fGB", float64(n)/1e9)
+	case n >= 1000*1000:
+		return fmt.Sprintf("%.1fMB", float64(n)/1e6)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fkB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// trafficBudget accounts for the traffic of all checks in fixed periods
+// anchored to the wall clock
+type trafficBudget struct {
+	config      BudgetConfig
+	periodStart time.Time
+	used        int64
+	total       int64
+}
+
+func newTrafficBudget(config BudgetConfig) trafficBudget {
+	if config.Period <= 0 {
+		config.Period = defaultBudgetPeriod
+	}
+	if config.Slowdown <= 0 {
+		config.Slowdown = defaultBudgetSlowdown
+	}
+	return trafficBudget{config: config}
+}
+
+// add accounts for traffic and reports whether it exceeded the budget
+func (b *trafficBudget) add(n int64, now time.Time) (exceeded bool) {
+	if start := now.Truncate(b.config.Period); !start.Equal(b.periodStart) {
+		b.periodStart = start
+		b.used = 0
+	}
+	wasOver := b.over(now)
+	b.used += n
+	b.total += n
+	return !wasOver && b.over(now)
+}
+
+func (b *trafficBudget) over(now time.Time) bool {
+	return b.config.Limit > 0 && now.Truncate(b.config.Period).Equal(b.periodStart) && b.used >= int64(b.config.Limit)
+}
+
+// throttled reports whether a run of a check is skipped because the budget
+// is exhausted
+func (b *trafficBudget) throttled(check Check, lastRun time.Time, now time.Time) bool {
+	return b.over(now) && now.Sub(lastRun) < check.Repeat*time.Duration(b.config.Slowdown)
+}
+
+func (b *trafficBudget) summary(now time.Time) string {
+	summary := "Traffic: " + formatBytes(b.total)
+	if b.config.Limit > 0 {
+		used := b.used
+		if !now.Truncate(b.config.Period).Equal(b.periodStart) {
+			used = 0
+		}
+		summary += fmt.Sprintf(", %s of %s per %v", formatBytes(used), formatBytes(int64(b.config.Limit)), b.config.Period)
+		if b.over(now) {
+			summary += fmt.Sprintf(", over budget: checks slowed down %dx", b.config.Slowdown)
+		}
+	}
+	return summary
+}
+
+// meteredConn counts the bytes transferred over a connection
+type meteredConn struct {
+	net.Conn
+	transferred atomic.Int64
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.transferred.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.transferred.Add(int64(n))
+	return n, err
+}
+
+// httpClient runs the local HTTP checks over metered connections
+var httpClient = &http.Client{Transport: meteredTransport()}
+
+// freshHttpClient runs the local HTTP checks that set up a new connection
+// every run
+var freshHttpClient = &http.Client{Transport: freshTransport()}
+
+func freshTransport() *http.Transport {
+	transport := meteredTransport()
+	transport.DisableKeepAlives = true
+	return transport
+}
+
+func meteredTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialCached(ctx, dialer, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &meteredConn{Conn: conn}, nil
+	}
+	return transport
+}
+
+// withSetupTrace returns a context recording how long setting up a new
+// connection (DNS, TCP, TLS) took for a request, and a function returning it.
+// It is zero when a pooled connection was reused.
+func withSetupTrace(ctx context.Context) (context.Context, func() time.Duration) {
+	var start time.Time
+	var setup atomic.Int64
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			start = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				setup.Store(int64(time.Since(start)))
+			}
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), func() time.Duration {
+		return time.Duration(setup.Load())
+	}
+}
+
+// withTrafficTrace returns a context recording the connection a request
+// uses and a function returning the bytes transferred for it since. A new
+// connection is counted including its handshakes.
+func withTrafficTrace(ctx context.Context) (context.Context, func() int64) {
+	var conn *meteredConn
+	var start int64
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c := info.Conn
+			if tlsConn, ok := c.(*tls.Conn); ok {
+				c = tlsConn.NetConn()
+			}
+			if metered, ok := c.(*meteredConn); ok {
+				conn = metered
+				if info.Reused {
+					start = metered.transferred.Load()
+				}
+			}
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), func() int64 {
+		if conn == nil {
+			return 0
+		}
+		return conn.transferred.Load() - start
+	}
+}
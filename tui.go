@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Views of the interactive terminal UI
+const (
+	viewTable = iota
+	viewChart
+	viewHeatmap
+	viewMap
+	viewCompare
+)
+
+// tuiState is the state of the interactive terminal UI
+type tuiState struct {
+	view     int
+	selected int
+	// Number of results shown in the chart
+	window int
+	// Only failing and degraded checks are listed in the table
+	problemsOnly bool
+	// A note on the selected check is being typed
+	noting bool
+	note   []byte
+	// Metric of the heatmap view and the heatmaps last read from the recording
+	metric     int
+	heatmaps   map[string]*heatmap
+	heatmapErr error
+	heatmapAt  time.Time
+}
+
+const (
+	minChartWindow     = 10
+	defaultChartWindow = 100
+)
+
+// startKeyboard reads key presses from the terminal: up/down (or k/j) select
+// a check, enter opens its latency chart, h its heatmap, +/- zoom the chart,
+// m switches the heatmap between loss and latency, p toggles listing only
+// the problems, a acknowledges the selected failing check (or removes its
+// ack), n types a note on it, t shows the topology map, v compares the
+// vantage points and q leaves the chart, heatmap, map or comparison or
+// quits. It returns a function restoring the terminal, or nil when stdin is
+// not a terminal.
+func startKeyboard(monitor *Monitor, quit func()) func() {
+	restore, err := enableKeyboardInput()
+	if err != nil {
+		return nil
+	}
+
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			key, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			// Arrow keys are sent as ESC [ A/B
+			if key == 0x1b && reader.Buffered() >= 2 {
+				if next, _ := reader.Peek(1); next[0] == '[' {
+					reader.ReadByte()
+					arrow, _ := reader.ReadByte()
+					switch arrow {
+					case 'A':
+						key = 'k'
+					case 'B':
+						key = 'j'
+					default:
+						continue
+					}
+				}
+			}
+			if !monitor.handleKey(key) {
+				quit()
+				return
+			}
+			monitor.draw()
+		}
+	}()
+	return restore
+}
+
+// handleKey updates the UI state for a key press. It returns false when the
+// user asked to quit.
+func (m *Monitor) handleKey(key byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tui.noting {
+		m.typeNote(key)
+		return true
+	}
+	switch key {
+	case 'j', 'k':
+		order := displayOrder(m.results, m.showSite(), m.tui.problemsOnly)
+		pos := 0
+		for i, id := range order {
+			if id == m.tui.selected {
+				pos = i
+			}
+		}
+		if key == 'j' && pos < len(order)-1 {
+			pos++
+		} else if key == 'k' && pos > 0 {
+			pos--
+		}
+		if len(order) > 0 {
+			m.tui.selected = order[pos]
+		}
+	case '\r', '\n', 'c':
+		m.tui.view = viewChart
+	case 'h':
+		m.tui.view = viewHeatmap
+	case 't':
+		m.tui.view = viewMap
+	case 'v':
+		m.tui.view = viewCompare
+	case 'm':
+		m.tui.metric = heatmapLatency - m.tui.metric
+	case 'p':
+		m.tui.problemsOnly = !m.tui.problemsOnly
+	case 'a':
+		if m.tui.view == viewTable {
+			m.toggleAck(m.tui.selected)
+		}
+	case 'n':
+		if m.tui.view == viewTable && m.tui.selected < len(m.results) {
+			m.tui.noting, m.tui.note = true, nil
+		}
+	case '+':
+		if m.tui.window/2 >= minChartWindow {
+			m.tui.window /= 2
+		}
+	case '-':
+		if m.tui.window*2 <= chartHistory {
+			m.tui.window *= 2
+		}
+	case 'q', 0x1b:
+		if m.tui.view == viewTable {
+			return key != 'q'
+		}
+		m.tui.view = viewTable
+	}
+	return true
+}
+
+// typeNote edits the note being typed: Enter adds it to the selected check,
+// Esc cancels it and backspace deletes the last character. Callers hold
+// m.mu.
+func (m *Monitor) typeNote(key byte) {
+	switch {
+	case key == '\r' || key == '\n':
+		if len(m.tui.note) > 0 {
+			if err := m.noteLocked(m.results[m.tui.selected].check.Name, "tui", string(m.tui.note)); err != nil {
+				logMessage(logErr, "Error adding note:", err)
+			}
+		}
+		m.tui.noting = false
+	case key == 0x1b:
+		m.tui.noting = false
+	case key == 0x7f || key == 0x08:
+		// Drop the last character, with the continuation bytes of UTF-8
+		for len(m.tui.note) > 0 {
+			last := m.tui.note[len(m.tui.note)-1]
+			m.tui.note = m.tui.note[:len(m.tui.note)-1]
+			if last < 0x80 || last >= 0xc0 {
+				break
+			}
+		}
+	case key >= 0x20:
+		m.tui.note = append(m.tui.note, key)
+	}
+}
+
+// tuiHeatmaps returns the heatmaps of all checks, read from the recording at
+// most once per heatmapRefresh
+func (m *Monitor) tuiHeatmaps() (map[string]*heatmap, error) {
+	m.mu.Lock()
+	recording := m.recording
+	heatmaps, err := m.tui.heatmaps, m.tui.heatmapErr
+	fresh := heatmaps != nil && time.Since(m.tui.heatmapAt) < heatmapRefresh
+	m.mu.Unlock()
+	if recording == "" {
+		return nil, fmt.Errorf("heatmaps are read from the recording, start with -record")
+	}
+	if fresh {
+		return heatmaps, err
+	}
+
+	// Reading the recording may take a while, results keep coming meanwhile
+	heatmaps, err = readHeatmaps(recording, heatmapDays, time.Now())
+	m.mu.Lock()
+	m.tui.heatmaps, m.tui.heatmapErr, m.tui.heatmapAt = heatmaps, err, time.Now()
+	m.mu.Unlock()
+	return heatmaps, err
+}
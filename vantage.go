@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// A check runs slower at a vantage point when its latency is more than
+// vantageSlowFactor times the one of the fastest vantage point and at
+// least vantageSlowMargin above it
+const (
+	vantageSlowFactor = 2
+	vantageSlowMargin = 20 * time.Millisecond
+)
+
+// vantageCell is the latest status (OK, FAIL, DEGR or - without results)
+// and the short window average latency of a check at a vantage point
+type vantageCell struct {
+	status  string
+	latency time.Duration
+}
+
+// vantageRow compares a check by name across the vantage points
+type vantageRow struct {
+	name  string
+	cells map[string]vantageCell
+	// Down everywhere, down or slow at some vantage points, or the same
+	// everywhere
+	verdict   string
+	divergent bool
+}
+
+// vantageComparison lines up the checks running at more than one vantage
+// point, i.e. the sites of the agents reporting to an aggregator
+type vantageComparison struct {
+	sites []string
+	rows  []vantageRow
+}
+
+// compareVantages builds the comparison of the checks reported by more than
+// one site. Callers hold m.mu.
+func (m *Monitor) compareVantages() vantageComparison {
+	var comparison vantageComparison
+	bySite := make(map[string]bool)
+	byName := make(map[string]map[string]vantageCell)
+	for i, checkResult := range m.results {
+		check := checkResult.check
+		if i < len(m.checks.Checks) && m.paused[check.Name] {
+			continue
+		}
+		cell := vantageCell{status: "-"}
+		if checkResult.execCount > 0 {
+			switch {
+			case !checkResult.status:
+				cell.status = "FAIL"
+			case checkResult.degraded:
+				cell.status = "DEGR"
+			default:
+				cell.status = "OK"
+			}
+			cell.latency = averageDuration(m.stats[i].shortDurations)
+		}
+		if byName[check.Name] == nil {
+			byName[check.Name] = make(map[string]vantageCell)
+		}
+		byName[check.Name][check.site] = cell
+		bySite[check.site] = true
+	}
+	for site := range bySite {
+		comparison.sites = append(comparison.sites, site)
+	}
+	sort.Strings(comparison.sites)
+
+	for name, cells := range byName {
+		if len(cells) < 2 {
+			continue
+		}
+		row := vantageRow{name: name, cells: cells}
+		row.verdict, row.divergent = vantageVerdict(cells)
+		comparison.rows = append(comparison.rows, row)
+	}
+	// Divergent checks first, they answer whether it's down for everyone
+	sort.Slice(comparison.rows, func(i, j int) bool {
+		if comparison.rows[i].divergent != comparison.rows[j].divergent {
+			return comparison.rows[i].divergent
+		}
+		return comparison.rows[i].name < comparison.rows[j].name
+	})
+	return comparison
+}
+
+// vantageVerdict tells whether a check is down everywhere, down or slow at
+// some vantage points only, or the same everywhere
+func vantageVerdict(cells map[string]vantageCell) (string, bool) {
+	var down, up []string
+	fastest := time.Duration(-1)
+	for site, cell := range cells {
+		switch cell.status {
+		case "FAIL":
+			down = append(down, site)
+		case "OK", "DEGR":
+			up = append(up, site)
+			if fastest < 0 || cell.latency < fastest {
+				fastest = cell.latency
+			}
+		}
+	}
+	sort.Strings(down)
+	switch {
+	case len(up) == 0 && len(down) == 0:
+		return "no results yet", false
+	case len(up) == 0:
+		return "down everywhere", false
+	case len(down) > 0:
+		return "down at " + strings.Join(down, ", "), true
+	}
+	var slow []string
+	for _, site := range up {
+		latency := cells[site].latency
+		if latency > vantageSlowFactor*fastest && latency-fastest >= vantageSlowMargin {
+			slow = append(slow, site)
+		}
+	}
+	if len(slow) > 0 {
+		sort.Strings(slow)
+		return "slow at " + strings.Join(slow, ", "), true
+	}
+	return "same everywhere", false
+}
+
+// lines renders the comparison as a table with a column per vantage point,
+// coloring the cells by status and the divergent verdicts when colored
+func (c vantageComparison) lines(colored bool) []string {
+	if len(c.rows) == 0 {
+		return nil
+	}
+	nameWidth := len("TARGET")
+	for _, row := range c.rows {
+		nameWidth = max(nameWidth, len(row.name))
+	}
+	widths := make([]int, len(c.sites))
+	header := fmt.Sprintf("%-*s ", nameWidth, "TARGET")
+	for k, site := range c.sites {
+		widths[k] = max(len(site), len("FAIL 1000ms"))
+		header += fmt.Sprintf("| %-*s ", widths[k], site)
+	}
+	lines := []string{header + "| VERDICT"}
+
+	for _, row := range c.rows {
+		line := fmt.Sprintf("%-*s ", nameWidth, row.name)
+		for k, site := range c.sites {
+			cell, ok := row.cells[site]
+			text := ""
+			if ok {
+				text = cell.status
+				if cell.status != "-" && cell.status != "FAIL" {
+					text += " " + strings.TrimSpace(formatDuration(cell.latency))
+				}
+			}
+			text = fmt.Sprintf("%-*s", widths[k], text)
+			if colored && ok {
+				text = topologyColor(cell.status).Sprint(text)
+			}
+			line += "| " + text + " "
+		}
+		verdict := row.verdict
+		if colored && row.divergent {
+			verdict = color.New(color.FgYellow, color.Bold).Sprint(verdict)
+		}
+		lines = append(lines, line+"| "+verdict)
+	}
+	return lines
+}
+
+// displayComparison draws the vantage comparison view of the terminal UI
+func displayComparison(lines []string) {
+	fmt.Print("\033[H\033[2J") // Clear terminal screen
+	fmt.Print("Vantage points - the checks of the sites side by side (q back)\n\n")
+	if len(lines) == 0 {
+		fmt.Println("No check runs at more than one site")
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// endWarmup lets the display, alerts and readiness see the results
+func (m *Monitor) endWarmup() {
+	m.warmOnce.Do(func() { close(m.warmed) })
+}
+
+// warmedUp reports whether every check ran once since the start, or the
+// warmup timed out
+func (m *Monitor) warmedUp() bool {
+	select {
+	case <-m.warmed:
+		return true
+	default:
+		return false
+	}
+}
+
+// startWarmup ends the warmup at the latest when the slowest check timed
+// out, so a hanging check can't hold it forever
+func (m *Monitor) startWarmup() {
+	var longest time.Duration
+	for _, check := range m.checks.Checks {
+		longest = max(longest, check.deadline())
+	}
+	time.AfterFunc(longest+time.Second, m.endWarmup)
+}
+
+// warmupProgress returns how many of the checks ran since the start, to be
+// called with the lock held. It ends the warmup once all did.
+func (m *Monitor) warmupProgress() (ran, total int) {
+	for i := range m.checks.Checks {
+		if i < len(m.results) && m.results[i].execCount > 0 && !m.results[i].runAt.Before(m.startedAt) {
+			ran++
+		}
+	}
+	if ran == len(m.checks.Checks) {
+		m.endWarmup()
+	}
+	return ran, len(m.checks.Checks)
+}
+
+// displayWarmup replaces the table until the first results of all checks
+// are in
+func displayWarmup(ran, total int) {
+	fmt.Print("\033[H\033[2J") // Clear terminal screen
+	fmt.Printf("Warming up: %d of %d checks ran\n", ran, total)
+}
+
+// afterGrace wraps an alerting consumer so that it doesn't see results
+// before the warmup ended and the startup grace period passed. Checks still
+// failing afterwards alert with their next result, see onStateChange.
+func (m *Monitor) afterGrace(handle func(CheckResult)) func(CheckResult) {
+	grace := m.checks.StartupGrace
+	return func(checkResult CheckResult) {
+		if !m.warmedUp() || time.Since(m.startedAt) < grace {
+			return
+		}
+		handle(checkResult)
+	}
+}